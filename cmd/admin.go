@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"itisadb/config"
+	"itisadb/pkg/api/cluster"
+
+	api "github.com/egorgasay/itisadb-shared-proto/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// _clusterCommand prints the servers of a balancer as JSON:
+	//	itisadb [-config path] cluster [-addr host:port] [-login l] [-password p]
+	_clusterCommand = "cluster"
+	// _drainCommand moves the data off a server of a balancer and disconnects it:
+	//	itisadb [-config path] drain [-addr host:port] [-login l] [-password p] [-wait] <server>
+	_drainCommand = "drain"
+	// _maintenanceCommand keeps new data off a server of a balancer, or lets it take new data again:
+	//	itisadb [-config path] maintenance [-addr host:port] [-login l] [-password p] <server> on|off
+	_maintenanceCommand = "maintenance"
+)
+
+// adminFlags are the flags of the commands that call a running node.
+type adminFlags struct {
+	addr, login, password *string
+}
+
+func newAdminFlags(fs *flag.FlagSet, cfg *config.Config) adminFlags {
+	return adminFlags{
+		addr:     fs.String("addr", cfg.Network.GRPC, "address of the node"),
+		login:    fs.String("login", "itisadb", "login of an admin user"),
+		password: fs.String("password", "itisadb", "password of the admin user"),
+	}
+}
+
+// dial connects to the Cluster service of the node and returns the context with the token of the admin.
+func (f adminFlags) dial(ctx context.Context) (cluster.ClusterClient, context.Context, func(), error) {
+	conn, err := grpc.DialContext(ctx, *f.addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("can't dial %s: %w", *f.addr, err)
+	}
+
+	resp, err := api.NewItisaDBClient(conn).Authenticate(ctx, &api.AuthRequest{Login: *f.login, Password: *f.password})
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("can't authenticate: %w", err)
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "token", resp.Token)
+
+	return cluster.NewClusterClient(conn), ctx, func() { conn.Close() }, nil
+}
+
+func parseServer(s string) (int32, error) {
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid server number %q", s)
+	}
+
+	return int32(n), nil
+}
+
+func runDrain(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet(_drainCommand, flag.ExitOnError)
+	af := newAdminFlags(fs, cfg)
+	wait := fs.Bool("wait", false, "wait until the server is empty and disconnected")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: itisadb drain [-addr host:port] [-login l] [-password p] [-wait] <server>")
+	}
+
+	server, err := parseServer(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	client, ctx, closeConn, err := af.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	if _, err := client.Drain(ctx, &cluster.DrainRequest{Server: server}); err != nil {
+		return err
+	}
+
+	fmt.Printf("s#%d is draining\n", server)
+
+	for *wait {
+		time.Sleep(time.Second)
+
+		st, err := client.RebalanceStatus(ctx, &cluster.RebalanceStatusRequest{})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("moved %d, failed %d, scanned %d\n", st.Moved, st.Failed, st.Scanned)
+
+		if st.Running {
+			continue
+		}
+
+		for _, n := range st.Leaving {
+			if n == server {
+				return fmt.Errorf("s#%d still has data, %d entries failed to move: %s", server, st.Failed, st.Error)
+			}
+		}
+
+		fmt.Printf("s#%d is disconnected\n", server)
+		return nil
+	}
+
+	return nil
+}
+
+func runMaintenance(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet(_maintenanceCommand, flag.ExitOnError)
+	af := newAdminFlags(fs, cfg)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 || (fs.Arg(1) != "on" && fs.Arg(1) != "off") {
+		return errors.New("usage: itisadb maintenance [-addr host:port] [-login l] [-password p] <server> on|off")
+	}
+
+	server, err := parseServer(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	client, ctx, closeConn, err := af.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	_, err = client.SetMaintenance(ctx, &cluster.MaintenanceRequest{Server: server, On: fs.Arg(1) == "on"})
+	return err
+}
+
+func runCluster(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet(_clusterCommand, flag.ExitOnError)
+	af := newAdminFlags(fs, cfg)
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		return errors.New("usage: itisadb cluster [-addr host:port] [-login l] [-password p]")
+	}
+
+	client, ctx, closeConn, err := af.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	info, err := client.ClusterInfo(ctx, &cluster.ClusterInfoRequest{})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(info)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+
+	"itisadb/config"
+	"itisadb/internal/domains"
+	"itisadb/internal/service/backup"
+	"itisadb/pkg/api/cluster"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// _backupCommand downloads a backup archive from a running node:
+	//	itisadb [-config path] backup [-addr host:port] [-login l] [-password p] <archive>
+	_backupCommand = "backup"
+	// _restoreCommand loads an archive and starts the node with its data:
+	//	itisadb [-config path] restore <archive>
+	_restoreCommand = "restore"
+)
+
+func runBackup(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet(_backupCommand, flag.ExitOnError)
+	af := newAdminFlags(fs, cfg)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: itisadb backup [-addr host:port] [-login l] [-password p] <archive>")
+	}
+	path := fs.Arg(0)
+
+	client, ctx, closeConn, err := af.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	stream, err := client.Backup(ctx, &cluster.BackupRequest{})
+	if err != nil {
+		return err
+	}
+
+	// the archive appears under its name only when it is complete
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tmp.Write(chunk.Data); err != nil {
+			return err
+		}
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func restoreArchive(cfg config.Config, args []string, restorer domains.Restorer, security domains.SecurityService, lg *zap.Logger) error {
+	if len(args) != 1 {
+		return errors.New("usage: itisadb restore <archive>")
+	}
+
+	if cfg.Replication.IsReplica() {
+		return errors.New("a replica gets its data from the primary, restore the primary instead")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	m, err := backup.Restore(f, cfg, restorer, security)
+	if err != nil {
+		return err
+	}
+
+	lg.Info("Backup restored",
+		zap.String("archive", args[0]),
+		zap.Time("created_at", m.CreatedAt),
+		zap.Uint64("seq", m.Seq),
+		zap.Uint64("values", m.Values),
+		zap.Uint64("objects", m.Objects),
+		zap.Uint64("users", m.Users),
+	)
+
+	return nil
+}
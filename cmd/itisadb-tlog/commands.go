@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	transactionlogger "itisadb/internal/service/transaction-logger"
+)
+
+type jsonEvent struct {
+	Seq       uint64 `json:"seq"`
+	File      int32  `json:"file"`
+	Line      int    `json:"line"`
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	Value     string `json:"value,omitempty"`
+	Metadata  string `json:"metadata,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+}
+
+func toJSONEvent(opts options, pos transactionlogger.Position, e transactionlogger.Event) (jsonEvent, error) {
+	je := jsonEvent{
+		Seq:      pos.Seq,
+		File:     pos.File,
+		Line:     pos.Line,
+		Type:     e.EventType.String(),
+		Name:     e.Name,
+		Value:    e.Value,
+		Metadata: e.Metadata,
+	}
+
+	if !e.Encrypted() {
+		return je, nil
+	}
+
+	if opts.decrypter == nil {
+		// the ciphertext is binary, keep it readable
+		je.Value = base64.StdEncoding.EncodeToString([]byte(e.Value))
+		je.Encrypted = true
+		return je, nil
+	}
+
+	value, err := opts.decrypter.Decrypt(e.Value)
+	if err != nil {
+		return je, fmt.Errorf("can't decrypt value of %s (seq %d): %w", e.Name, pos.Seq, err)
+	}
+
+	je.Value = value
+	return je, nil
+}
+
+func printEvents(w io.Writer, opts options, match func(transactionlogger.Event) bool) error {
+	enc := json.NewEncoder(w)
+
+	return transactionlogger.ScanDir(opts.dir, func(pos transactionlogger.Position, e transactionlogger.Event) (bool, error) {
+		if match != nil && !match(e) {
+			return true, nil
+		}
+
+		je, err := toJSONEvent(opts, pos, e)
+		if err != nil {
+			return false, err
+		}
+
+		return true, enc.Encode(je)
+	})
+}
+
+func dump(w io.Writer, opts options, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	return printEvents(w, opts, nil)
+}
+
+func grep(w io.Writer, opts options, args []string) error {
+	fs := flag.NewFlagSet("grep", flag.ContinueOnError)
+	prefix := fs.Bool("prefix", false, "match keys that start with the pattern")
+	isRegexp := fs.Bool("regexp", false, "treat the pattern as a regular expression")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New("grep: exactly one key pattern is required")
+	}
+
+	pattern := fs.Arg(0)
+
+	var match func(transactionlogger.Event) bool
+
+	switch {
+	case *isRegexp:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("grep: invalid pattern: %w", err)
+		}
+		match = func(e transactionlogger.Event) bool { return re.MatchString(e.Name) }
+	case *prefix:
+		match = func(e transactionlogger.Event) bool { return strings.HasPrefix(e.Name, pattern) }
+	default:
+		match = func(e transactionlogger.Event) bool { return e.Name == pattern }
+	}
+
+	return printEvents(w, opts, match)
+}
+
+func stats(w io.Writer, opts options, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	sep := fs.String("sep", ".:/", "characters that end a key prefix")
+	top := fs.Int("top", 20, "number of prefixes to show, 0 shows all of them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var total uint64
+	byType := make(map[transactionlogger.EventType]uint64)
+	byPrefix := make(map[string]uint64)
+
+	err := transactionlogger.ScanDir(opts.dir, func(_ transactionlogger.Position, e transactionlogger.Event) (bool, error) {
+		total++
+		byType[e.EventType]++
+		byPrefix[keyPrefix(e.Name, *sep)]++
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "events\t%d\n\n", total)
+
+	fmt.Fprintln(tw, "TYPE\tCOUNT")
+	types := make([]transactionlogger.EventType, 0, len(byType))
+	for t := range byType {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, t := range types {
+		fmt.Fprintf(tw, "%s\t%d\n", t, byType[t])
+	}
+
+	fmt.Fprintln(tw, "\nPREFIX\tCOUNT")
+	prefixes := make([]string, 0, len(byPrefix))
+	for p := range byPrefix {
+		prefixes = append(prefixes, p)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if byPrefix[prefixes[i]] != byPrefix[prefixes[j]] {
+			return byPrefix[prefixes[i]] > byPrefix[prefixes[j]]
+		}
+		return prefixes[i] < prefixes[j]
+	})
+	if *top > 0 && len(prefixes) > *top {
+		prefixes = prefixes[:*top]
+	}
+	for _, p := range prefixes {
+		fmt.Fprintf(tw, "%s\t%d\n", p, byPrefix[p])
+	}
+
+	return tw.Flush()
+}
+
+func keyPrefix(key, sep string) string {
+	if i := strings.IndexAny(key, sep); i >= 0 {
+		return key[:i]
+	}
+
+	return key
+}
+
+func truncate(w io.Writer, opts options, args []string) error {
+	fs := flag.NewFlagSet("truncate", flag.ContinueOnError)
+	force := fs.Bool("force", false, "modify the files, otherwise only report what would be cut")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var valid uint64
+	err := transactionlogger.ScanDir(opts.dir, func(pos transactionlogger.Position, _ transactionlogger.Event) (bool, error) {
+		valid = pos.Seq
+		return true, nil
+	})
+
+	var corruption *transactionlogger.CorruptionError
+	if err == nil {
+		fmt.Fprintf(w, "no corruption found, %d events\n", valid)
+		return nil
+	} else if !errors.As(err, &corruption) {
+		return err
+	}
+
+	pos := corruption.Position
+
+	files, err := transactionlogger.Files(opts.dir)
+	if err != nil {
+		return err
+	}
+
+	var later []int32
+	for _, n := range files {
+		if n > pos.File {
+			later = append(later, n)
+		}
+	}
+
+	fmt.Fprintf(w, "%v\n", corruption)
+	fmt.Fprintf(w, "%d valid events are kept, file %d is cut at byte %d", valid, pos.File, pos.Offset)
+	if len(later) > 0 {
+		fmt.Fprintf(w, ", files %v are removed", later)
+	}
+	fmt.Fprintln(w)
+
+	if !*force {
+		fmt.Fprintln(w, "dry run, use -force to apply")
+		return nil
+	}
+
+	if err := os.Truncate(fmt.Sprintf("%s/%d", opts.dir, pos.File), pos.Offset); err != nil {
+		return fmt.Errorf("can't truncate file %d: %w", pos.File, err)
+	}
+
+	for _, n := range later {
+		if err := os.Remove(fmt.Sprintf("%s/%d", opts.dir, n)); err != nil {
+			return fmt.Errorf("can't remove file %d: %w", n, err)
+		}
+	}
+
+	fmt.Fprintln(w, "done")
+	return nil
+}
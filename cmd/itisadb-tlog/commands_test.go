@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"itisadb/config"
+	"itisadb/internal/service/security"
+	transactionlogger "itisadb/internal/service/transaction-logger"
+)
+
+func writeLog(t *testing.T, dir, name string, lines ...string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(lines, "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func set(key, value, metadata string) string {
+	return transactionlogger.EncodeEvent(transactionlogger.Event{
+		EventType: transactionlogger.Set, Name: key, Value: value, Metadata: metadata,
+	})
+}
+
+func TestGrep(t *testing.T) {
+	dir := t.TempDir()
+	writeLog(t, dir, "1", set("user:1", "a", "0;0"), set("order:1", "b", "0;0"), set("user:2", "c", "0;0"))
+
+	var out bytes.Buffer
+	if err := grep(&out, options{dir: dir}, []string{"-prefix", "user:"}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 events, got %d: %s", len(lines), out.String())
+	}
+
+	var e jsonEvent
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Name != "user:2" || e.Seq != 3 || e.Type != "Set" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
+
+func TestDumpDecrypts(t *testing.T) {
+	sec := security.NewSecurityService(config.SecurityConfig{}, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+
+	encrypted, err := sec.Encrypt("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeLog(t, dir, "1", set("secret", encrypted, "0;2;E"))
+
+	var out bytes.Buffer
+	if err := dump(&out, options{dir: dir, decrypter: sec}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var e jsonEvent
+	if err := json.Unmarshal(out.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Value != "password" || e.Encrypted {
+		t.Errorf("value was not decrypted: %+v", e)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	dir := t.TempDir()
+	writeLog(t, dir, "1", set("a", "1", "0;0"), set("b", "2", "0;0"), "broken line\n", set("c", "3", "0;0"))
+	writeLog(t, dir, "2", set("d", "4", "0;0"))
+
+	var out bytes.Buffer
+	if err := truncate(&out, options{dir: dir}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := transactionlogger.Files(dir); len(files) != 2 {
+		t.Fatalf("dry run must not modify the log, files: %v", files)
+	}
+
+	if err := truncate(&out, options{dir: dir}, []string{"-force"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	err := transactionlogger.ScanDir(dir, func(_ transactionlogger.Position, e transactionlogger.Event) (bool, error) {
+		names = append(names, e.Name)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("log is still corrupted: %v", err)
+	}
+
+	if strings.Join(names, ",") != "a,b" {
+		t.Errorf("want events a,b after truncate, got %v", names)
+	}
+
+	if files, _ := transactionlogger.Files(dir); len(files) != 1 {
+		t.Errorf("files after the corrupted one must be removed, got %v", files)
+	}
+}
@@ -0,0 +1,114 @@
+// Command itisadb-tlog inspects, filters and repairs transaction-log segments offline.
+//
+// Usage:
+//
+//	itisadb-tlog [-dir path] [-config path] [-key key] <command> [args]
+//
+// Commands:
+//
+//	dump                   print every event as a JSON line
+//	stats [-sep chars]     count events per type and per key prefix
+//	grep [-prefix|-regexp] <key>
+//	                       print the events whose key matches
+//	truncate [-force]      cut the log at the first corrupted event
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"itisadb/config"
+	"itisadb/internal/service/security"
+	transactionlogger "itisadb/internal/service/transaction-logger"
+
+	"github.com/BurntSushi/toml"
+)
+
+type options struct {
+	dir       string
+	decrypter decrypter
+}
+
+type decrypter interface {
+	Decrypt(val string) (string, error)
+}
+
+// the config package registers the server flags on flag.CommandLine, so the tool uses its own set
+var fs = flag.NewFlagSet("itisadb-tlog", flag.ExitOnError)
+
+func main() {
+	dir := fs.String("dir", "", "transaction log directory (default: BackupDirectory from -config or "+transactionlogger.DefaultPath+")")
+	configPath := fs.String("config", "", "path to the itisadb config file")
+	key := fs.String("key", "", "encryption key used to decrypt Secret values (default: Encryption.Key from -config)")
+	fs.Usage = usage
+	_ = fs.Parse(os.Args[1:])
+
+	if fs.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	opts, err := loadOptions(*dir, *configPath, *key)
+	if err != nil {
+		fatal(err)
+	}
+
+	args := fs.Args()[1:]
+
+	switch fs.Arg(0) {
+	case "dump":
+		err = dump(os.Stdout, opts, args)
+	case "stats":
+		err = stats(os.Stdout, opts, args)
+	case "grep":
+		err = grep(os.Stdout, opts, args)
+	case "truncate":
+		err = truncate(os.Stdout, opts, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func loadOptions(dir, configPath, key string) (options, error) {
+	var cfg config.Config
+	if configPath != "" {
+		if _, err := toml.DecodeFile(configPath, &cfg); err != nil {
+			return options{}, fmt.Errorf("failed to decode config: %w", err)
+		}
+	}
+
+	if dir == "" {
+		dir = cfg.TransactionLogger.BackupDirectory
+	}
+
+	if dir == "" {
+		dir = transactionlogger.DefaultPath
+	}
+
+	if key == "" {
+		key = cfg.Encryption.Key
+	}
+
+	opts := options{dir: dir}
+	if key != "" {
+		opts.decrypter = security.NewSecurityService(cfg.Security, config.EncryptionConfig{Key: key})
+	}
+
+	return opts, nil
+}
+
+func usage() {
+	fmt.Fprintf(fs.Output(), "Usage: %s [flags] dump|stats|grep|truncate [args]\n", os.Args[0])
+	fs.PrintDefaults()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "itisadb-tlog:", err)
+	os.Exit(1)
+}
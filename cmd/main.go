@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
@@ -11,15 +12,28 @@ import (
 
 	"itisadb/config"
 	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/antientropy"
+	"itisadb/internal/service/backup"
 	"itisadb/internal/service/balancer"
+	"itisadb/internal/service/catalog"
+	"itisadb/internal/service/coordinator"
 	"itisadb/internal/service/generator"
+	"itisadb/internal/service/gossip"
 	"itisadb/internal/service/logic"
+	"itisadb/internal/service/metadata"
+	"itisadb/internal/service/peers"
+	"itisadb/internal/service/raft"
+	"itisadb/internal/service/rebalancer"
+	"itisadb/internal/service/replication"
 	"itisadb/internal/service/security"
 	"itisadb/internal/service/servers"
+	"itisadb/internal/service/servers/pool"
 	"itisadb/internal/service/session"
 	"itisadb/internal/service/syncer"
 	transactionlogger "itisadb/internal/service/transaction-logger"
 	"itisadb/internal/storage"
+	"itisadb/pkg/api/cluster"
 
 	"github.com/egorgasay/gost"
 	"go.uber.org/zap"
@@ -43,6 +57,38 @@ func main() {
 		log.Fatalf("failed to inizialise config: %v", err)
 	}
 
+	command, args := "", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "":
+	case _backupCommand:
+		if err := runBackup(cfg, args); err != nil {
+			log.Fatalf("backup failed: %v", err)
+		}
+		return
+	case _clusterCommand:
+		if err := runCluster(cfg, args); err != nil {
+			log.Fatalf("cluster failed: %v", err)
+		}
+		return
+	case _drainCommand:
+		if err := runDrain(cfg, args); err != nil {
+			log.Fatalf("drain failed: %v", err)
+		}
+		return
+	case _maintenanceCommand:
+		if err := runMaintenance(cfg, args); err != nil {
+			log.Fatalf("maintenance failed: %v", err)
+		}
+		return
+	case _restoreCommand:
+	default:
+		log.Fatalf("unknown command %q", command)
+	}
+
 	var lg *zap.Logger
 	var level zap.AtomicLevel
 	switch cfg.Logging.Level {
@@ -71,13 +117,41 @@ func main() {
 
 	sec := security.NewSecurityService(cfg.Security, cfg.Encryption)
 
+	if command == _restoreCommand {
+		if err := restoreArchive(*cfg, args, store, sec, lg); err != nil {
+			lg.Fatal("failed to restore backup", zap.Error(err))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var r *replication.Replica
+	if cfg.Replication.IsReplica() {
+		r = replication.NewReplica(cfg.Replication, store, sec, cfg.Encryption, lg, pool.DialOptions(cfg.Balancer.Connections)...)
+
+		if cfg.TransactionLogger.On {
+			dir := cfg.TransactionLogger.BackupDirectory
+			if dir == "" {
+				dir = transactionlogger.DefaultPath
+			}
+
+			lg.Info("Bootstrapping the replica", zap.String("primary", cfg.Replication.Primary))
+			if err := r.Bootstrap(ctx, dir); err != nil {
+				lg.Fatal("failed to bootstrap the replica", zap.Error(err))
+			}
+		}
+	}
+
 	var tl domains.TransactionLogger
+	var source = gost.None[domains.ReplicationSource]()
+	var checker = gost.None[domains.HealthChecker]()
 
 	if cfg.TransactionLogger.On {
-		tl, err = transactionlogger.New(cfg.TransactionLogger, lg, sec)
+		logger, err := transactionlogger.New(cfg.TransactionLogger, lg, sec)
 		if err != nil {
 			lg.Fatal("failed to inizialise transaction logger: %v", zap.Error(err))
 		}
+		tl = logger
 
 		lg.Info("Transaction logger enabled")
 
@@ -87,14 +161,25 @@ func main() {
 		}
 		lg.Info("Transaction logger recovery completed")
 
+		if r != nil {
+			// the replica logs what it applies and continues after the restored events
+			r.Resume(logger)
+		}
+
 		tl.Run()
+		source = source.Some(tl)
+		checker = checker.Some(tl)
 
 		lg.Info("Transaction logger started")
 	} else {
 		lg.Info("Transaction logger disabled")
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	var replica = gost.None[domains.Replica]()
+	if r != nil {
+		go r.Start(ctx)
+		replica = replica.Some(r)
+	}
 
 	appCFG := *cfg
 
@@ -109,25 +194,125 @@ func main() {
 		local = local.Some(ls)
 	}
 
-	s, err := servers.New(cfg.Balancer.Servers, local, lg)
+	cat, err := catalog.New(cfg.Balancer, lg)
+	if err != nil {
+		lg.Fatal("failed to open catalog: %v", zap.Error(err))
+	}
+	defer cat.Close()
+
+	var routes domains.Catalog = cat
+	var meta = gost.None[domains.Metadata]()
+	var raftServer = gost.None[cluster.RaftServer]()
+
+	if cfg.Balancer.Raft.On {
+		if local.IsSome() {
+			lg.Fatal("Raft needs BalancerOnly, the local servers of the balancers would share a number")
+		}
+
+		raftCFG := cfg.Balancer.Raft
+		if raftCFG.ID == "" {
+			raftCFG.ID = cfg.Network.GRPC
+		}
+
+		conns := pool.WithDefaults(cfg.Balancer.Connections)
+		transport := raft.NewTransport(peers.Authenticate(conns.Login, conns.Password), pool.DialOptions(conns)...)
+		defer transport.Close()
+
+		ms, err := metadata.New(raftCFG, cat, transport, lg)
+		if err != nil {
+			lg.Fatal("failed to open metadata: %v", zap.Error(err))
+		}
+		defer ms.Close()
+
+		ms.Start()
+		lg.Info("Metadata is shared with the other balancers", zap.String("id", raftCFG.ID), zap.Strings("peers", raftCFG.Peers))
+
+		routes = ms
+		meta = meta.Some(ms)
+		raftServer = raftServer.Some(ms.Server())
+	}
+
+	s, err := servers.New(cfg.Balancer, local, ses, meta, lg)
 	if err != nil {
 		lg.Fatal("failed to inizialise balancer: %v", zap.Error(err))
 	}
 
 	// TODO: make it configurable
-	syncer, err := syncer.NewSyncer(s, lg, store)
+	syncer, err := syncer.NewSyncer(s, lg, store, meta)
 	if err != nil {
 		lg.Fatal("failed to inizialise syncer: %v", zap.Error(err))
 	}
 
 	go syncer.Start()
 
-	b, err := balancer.New(ctx, appCFG, lg, store, tl, s, ses, sec, uc)
+	rb := rebalancer.New(cfg.Balancer, s, routes, lg)
+
+	var txs = gost.None[*coordinator.Coordinator]()
+	if cfg.Balancer.On {
+		txCFG := cfg.Balancer.Transactions
+		if txCFG.ID == "" {
+			txCFG.ID = cfg.Network.GRPC
+		}
+
+		co, err := coordinator.New(txCFG, s, lg)
+		if err != nil {
+			lg.Fatal("failed to inizialise transactions: %v", zap.Error(err))
+		}
+
+		co.Start()
+		defer co.Close()
+
+		txs = txs.Some(co)
+
+		ae := antientropy.New(cfg.Balancer, s, lg)
+		ae.Start()
+		defer ae.Close()
+	}
+
+	b, err := balancer.New(ctx, appCFG, lg, store, tl, s, routes, rb, ses, sec, uc, txs)
 	if err != nil {
 		lg.Fatal("failed to inizialise logic layer: %v", zap.String("error", err.Error()))
 	}
 
-	go runGRPC(ctx, lg, b, appCFG.Security, appCFG.Network, ses)
+	bk := backup.New(store, source, sec, cfg.Encryption, lg)
+	defer bk.Close()
+
+	var gossipServer = gost.None[cluster.GossipServer]()
+	if cfg.Gossip.On {
+		gossipCFG := cfg.Gossip
+		if gossipCFG.Address == "" {
+			gossipCFG.Address = cfg.Network.GRPC
+		}
+
+		role := models.MemberServer
+		switch {
+		case cfg.Balancer.On:
+			role = models.MemberBalancer
+		case cfg.Replication.IsReplica():
+			role = models.MemberReplica
+		}
+
+		conns := pool.WithDefaults(cfg.Balancer.Connections)
+		transport := gossip.NewTransport(peers.Authenticate(conns.Login, conns.Password), pool.DialOptions(conns)...)
+		defer transport.Close()
+
+		g := gossip.New(gossipCFG, role, transport, lg)
+		g.Start()
+		defer g.Leave()
+
+		if cfg.Balancer.On {
+			s.Discover(g)
+		}
+
+		gossipServer = gossipServer.Some(g.Server())
+		lg.Info("Gossip started", zap.String("address", gossipCFG.Address), zap.String("role", role), zap.Strings("seeds", gossipCFG.Seeds))
+	}
+
+	go runGRPC(ctx, lg, b, appCFG.Security, appCFG.Network, appCFG.Balancer.Connections, ses, sec, source, replica, bk, uc, rb, checker, raftServer, gossipServer)
+
+	if cfg.Network.Metrics != "" {
+		go runMetrics(ctx, lg, cfg.Network, checker)
+	}
 
 	// TODO: do check before connect
 	time.Sleep(2 * time.Second)
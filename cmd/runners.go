@@ -3,11 +3,13 @@ package main
 import (
 	"context"
 	"errors"
+	"expvar"
 	"fmt"
 	"html/template"
 	"io"
 	"net"
 	"net/http"
+	"time"
 
 	"itisadb/config"
 	"itisadb/internal/cli/handler"
@@ -18,6 +20,8 @@ import (
 	grpchandler "itisadb/internal/handler/grpc"
 	resthandler "itisadb/internal/handler/rest"
 	"itisadb/internal/service/balancer"
+	"itisadb/internal/service/servers/pool"
+	"itisadb/pkg/api/cluster"
 
 	"github.com/brpaz/echozap"
 	"github.com/egorgasay/gost"
@@ -27,6 +31,8 @@ import (
 	"github.com/valyala/fasthttp"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func runGRPC(
@@ -35,20 +41,44 @@ func runGRPC(
 	logic domains.Balancer,
 	securityCFG config.SecurityConfig,
 	networkCFG config.NetworkConfig,
+	connCFG config.ConnectionsConfig,
 	session domains.Session,
+	security domains.SecurityService,
+	source gost.Option[domains.ReplicationSource],
+	replica gost.Option[domains.Replica],
+	backuper domains.Backuper,
+	scanner domains.Scanner,
+	rebalancer domains.Rebalancer,
+	checker gost.Option[domains.HealthChecker],
+	raftServer gost.Option[cluster.RaftServer],
+	gossipServer gost.Option[cluster.GossipServer],
 ) {
 	converterr := converterr.New(l)
 
 	h := grpchandler.New(logic, l, session, securityCFG, converterr)
-	grpcServer := grpc.NewServer(
+	// the balancers connect to the server with the same connection options
+	opts := append(pool.ServerOptions(connCFG),
 		grpc.UnaryInterceptor(h.AuthMiddleware),
+		grpc.StreamInterceptor(h.AuthStreamMiddleware),
 	)
+	grpcServer := grpc.NewServer(opts...)
 
 	lis, err := net.Listen("tcp", networkCFG.GRPC)
 	if err != nil {
 		l.Fatal("failed to listen: %v", zap.Error(err))
 	}
 	api.RegisterItisaDBServer(grpcServer, h)
+	cluster.RegisterClusterServer(grpcServer, grpchandler.NewCluster(source, replica, backuper, scanner, rebalancer, logic, security, l, converterr))
+	if raftServer.IsSome() {
+		cluster.RegisterRaftServer(grpcServer, raftServer.Unwrap())
+	}
+	if gossipServer.IsSome() {
+		cluster.RegisterGossipServer(grpcServer, gossipServer.Unwrap())
+	}
+
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, hs)
+	go watchHealth(ctx, l, hs, checker)
 
 	err = gost.WithContextPool(ctx, func() error {
 		l.Info("Starting GRPC", zap.String("address", networkCFG.GRPC))
@@ -99,6 +129,73 @@ func runREST(
 	}
 }
 
+// watchHealth keeps the status reported by the gRPC health service up to date.
+func watchHealth(ctx context.Context, l *zap.Logger, hs *health.Server, checker gost.Option[domains.HealthChecker]) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_SERVING
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		if checker.IsSome() {
+			if err := checker.Unwrap().Healthy(); err != nil {
+				status = healthpb.HealthCheckResponse_NOT_SERVING
+			}
+		}
+
+		if status != last {
+			l.Warn("Health status changed", zap.String("status", status.String()))
+			last = status
+		}
+		hs.SetServingStatus("", status)
+
+		select {
+		case <-ctx.Done():
+			hs.Shutdown()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runMetrics(ctx context.Context, l *zap.Logger, cfg config.NetworkConfig, checker gost.Option[domains.HealthChecker]) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
+		if checker.IsSome() {
+			if err := checker.Unwrap().Healthy(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		fmt.Fprintln(w, "ok")
+	})
+
+	lis, err := net.Listen("tcp", cfg.Metrics)
+	if err != nil {
+		l.Fatal("failed to listen: %v", zap.Error(err))
+	}
+
+	err = gost.WithContextPool(ctx, func() error {
+		l.Info("Starting metrics HTTP server", zap.String("address", cfg.Metrics))
+		if err := http.Serve(lis, mux); err != nil {
+			return fmt.Errorf("error in metrics Serve: %w", err)
+		}
+
+		return nil
+	}, make(chan struct{}, 1), func() {
+		if err := lis.Close(); err != nil {
+			l.Warn("Failed to close listener", zap.Error(err))
+		}
+	})
+	l.Info("Shutdown metrics ...")
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		l.Error("Error in metrics server", zap.Error(err))
+	}
+}
+
 type Template struct {
 	templates *template.Template
 }
@@ -17,17 +17,29 @@ type Config struct {
 	Balancer          BalancerConfig          `toml:"Balancer"`
 	Security          SecurityConfig          `toml:"Security"`
 	Logging           LoggingConfig           `toml:"Logging"`
+	Replication       ReplicationConfig       `toml:"Replication"`
+	Gossip            GossipConfig            `toml:"Gossip"`
 }
 
 type TransactionLoggerConfig struct {
 	On              bool          `toml:"On"`
 	BackupDirectory string        `toml:"BackupDirectory"`
 	SyncBufferTime  time.Duration `toml:"SyncBufferTime"`
+	QueueSize       int           `toml:"QueueSize"`
+	QueueFullPolicy string        `toml:"QueueFullPolicy"`
+	QueueTimeout    time.Duration `toml:"QueueTimeout"`
 }
 
+const (
+	BlockPolicy    = "block"
+	RejectPolicy   = "reject"
+	ReadOnlyPolicy = "readonly"
+)
+
 type NetworkConfig struct {
-	GRPC string `toml:"GRPC"`
-	REST string `toml:"FastHTTP"`
+	GRPC    string `toml:"GRPC"`
+	REST    string `toml:"FastHTTP"`
+	Metrics string `toml:"Metrics"`
 }
 
 type EncryptionConfig struct {
@@ -44,6 +56,151 @@ type BalancerConfig struct {
 	On           bool     `toml:"On"`
 	BalancerOnly bool     `toml:"BalancerOnly"`
 	Servers      []string `toml:"Servers"`
+	Placement    string   `toml:"Placement"`
+	VirtualNodes int      `toml:"VirtualNodes"`
+	Strategy     string   `toml:"Strategy"`
+
+	// ServerOptions come from the [[Server]] tables of the servers config.
+	ServerOptions []ServerOptions `toml:"Server"`
+
+	CatalogDirectory string `toml:"CatalogDirectory"`
+	CatalogCacheSize int    `toml:"CatalogCacheSize"`
+
+	ReplicationFactor int `toml:"ReplicationFactor"`
+	ReadQuorum        int `toml:"ReadQuorum"`
+	WriteQuorum       int `toml:"WriteQuorum"`
+
+	RebalanceRate int `toml:"RebalanceRate"`
+
+	Health   HealthConfig   `toml:"Health"`
+	Timeouts TimeoutsConfig `toml:"Timeouts"`
+	Hedge    HedgeConfig    `toml:"Hedge"`
+
+	NearCache NearCacheConfig `toml:"NearCache"`
+
+	Connections ConnectionsConfig `toml:"Connections"`
+
+	Raft RaftConfig `toml:"Raft"`
+
+	Transactions TransactionsConfig `toml:"Transactions"`
+
+	AntiEntropy AntiEntropyConfig `toml:"AntiEntropy"`
+}
+
+// AntiEntropyConfig sets the background job that brings the replicas of the keys back in line.
+type AntiEntropyConfig struct {
+	// Interval is how often the replicas are compared, a negative one turns the job off.
+	Interval time.Duration `toml:"Interval"`
+	// Depth sets the size of the Merkle trees the replicas are compared with, a tree has 2^Depth leaves.
+	Depth int `toml:"Depth"`
+}
+
+// TransactionsConfig sets the transactions the balancer commits across the servers.
+type TransactionsConfig struct {
+	// ID starts the IDs of the transactions of this balancer, Network.GRPC by default.
+	// The balancers sharing the servers need different IDs.
+	ID string `toml:"ID"`
+	// Directory keeps the commit decisions until the servers apply them.
+	Directory string `toml:"Directory"`
+	// RecoverInterval is how often the in-doubt transactions are resolved.
+	RecoverInterval time.Duration `toml:"RecoverInterval"`
+}
+
+// RaftConfig sets the metadata replicated between the balancer instances.
+type RaftConfig struct {
+	On bool `toml:"On"`
+	// ID is the gRPC address the other instances reach this one at, Network.GRPC by default.
+	ID string `toml:"ID"`
+	// Peers are the IDs of all the instances, this one too.
+	Peers []string `toml:"Peers"`
+	// Directory keeps the log and the snapshot of the metadata.
+	Directory string `toml:"Directory"`
+
+	HeartbeatInterval time.Duration `toml:"HeartbeatInterval"`
+	// ElectionTimeout is how long a follower waits for the leader before it starts an election,
+	// the actual wait is random, between the timeout and twice of it.
+	ElectionTimeout time.Duration `toml:"ElectionTimeout"`
+	// SnapshotThreshold is the number of the applied entries after which the log is compacted.
+	SnapshotThreshold int `toml:"SnapshotThreshold"`
+}
+
+const GzipCompression = "gzip"
+
+// ConnectionsConfig sets the connections of the balancer to each remote server.
+type ConnectionsConfig struct {
+	// PoolSize is the number of the connections to each server.
+	PoolSize int `toml:"PoolSize"`
+	// Keepalive is how long a connection is idle before it is pinged, a negative one turns the pings off.
+	Keepalive time.Duration `toml:"Keepalive"`
+	// KeepaliveTimeout is how long a ping may go unanswered before the connection is closed.
+	KeepaliveTimeout time.Duration `toml:"KeepaliveTimeout"`
+	// MaxMessageSize bounds the messages in bytes, both ways.
+	MaxMessageSize int `toml:"MaxMessageSize"`
+	// Compression is "gzip" or "" for none.
+	Compression string `toml:"Compression"`
+	// Login and Password authenticate the balancer on the servers and the nodes on each other,
+	// the default user when Login is empty.
+	Login    string `toml:"Login"`
+	Password string `toml:"Password"`
+}
+
+// TimeoutsConfig bounds the operations of the balancer by their type, a negative timeout turns it off.
+type TimeoutsConfig struct {
+	Read   time.Duration `toml:"Read"`
+	Write  time.Duration `toml:"Write"`
+	Object time.Duration `toml:"Object"`
+	Users  time.Duration `toml:"Users"`
+}
+
+// NearCacheConfig sets the cache of the hot values the balancer read from the remote servers.
+type NearCacheConfig struct {
+	// Size is the number of the cached values, 0 turns the cache off.
+	Size int `toml:"Size"`
+	// MaxStaleness bounds how long a value is served from the cache.
+	MaxStaleness time.Duration `toml:"MaxStaleness"`
+}
+
+// HedgeConfig sets the hedged reads of the replicated keys.
+type HedgeConfig struct {
+	On bool `toml:"On"`
+	// MinDelay is the least wait before the read is sent to the other replicas.
+	MinDelay time.Duration `toml:"MinDelay"`
+}
+
+// HealthConfig sets how the balancer checks the remote servers.
+type HealthConfig struct {
+	Interval         time.Duration `toml:"Interval"`
+	Timeout          time.Duration `toml:"Timeout"`
+	FailureThreshold int           `toml:"FailureThreshold"`
+	SuccessThreshold int           `toml:"SuccessThreshold"`
+	MinBackoff       time.Duration `toml:"MinBackoff"`
+	MaxBackoff       time.Duration `toml:"MaxBackoff"`
+	// KeyFilterInterval is how often the key filters of the servers are pulled, a negative one turns them off.
+	KeyFilterInterval time.Duration `toml:"KeyFilterInterval"`
+}
+
+const (
+	RAMPlacement  = "ram"
+	HashPlacement = "hash"
+)
+
+// Strategies choose a server for new data in the RAM placement mode.
+const (
+	MostFreeRAMStrategy = "most-free-ram"
+	RoundRobinStrategy  = "round-robin"
+	FewestKeysStrategy  = "fewest-keys"
+	WeightedStrategy    = "weighted"
+	LabelsStrategy      = "labels"
+)
+
+// LocalServerAddress names the balancer's own storage in the [[Server]] tables.
+const LocalServerAddress = "local"
+
+// ServerOptions describe a server to the placement strategies.
+type ServerOptions struct {
+	Address string            `toml:"Address"`
+	Weight  int               `toml:"Weight"`
+	Labels  map[string]string `toml:"Labels"`
 }
 
 type SecurityConfig struct {
@@ -54,6 +211,42 @@ type LoggingConfig struct {
 	Level string `toml:"Level"`
 }
 
+const (
+	PrimaryRole = "primary"
+	ReplicaRole = "replica"
+)
+
+type ReplicationConfig struct {
+	Role              string        `toml:"Role"`
+	Primary           string        `toml:"Primary"`
+	Login             string        `toml:"Login"`
+	Password          string        `toml:"Password"`
+	ReconnectInterval time.Duration `toml:"ReconnectInterval"`
+}
+
+func (c ReplicationConfig) IsReplica() bool {
+	return c.Role == ReplicaRole
+}
+
+// GossipConfig sets the membership protocol the nodes find each other and detect the failures with.
+type GossipConfig struct {
+	On bool `toml:"On"`
+	// Address is the gRPC address the other nodes reach this one at, Network.GRPC by default.
+	Address string `toml:"Address"`
+	// Seeds are the addresses of the nodes to join the cluster through.
+	Seeds []string `toml:"Seeds"`
+
+	// Interval is how often a node probes another one.
+	Interval time.Duration `toml:"Interval"`
+	Timeout  time.Duration `toml:"Timeout"`
+	// IndirectChecks is the number of the nodes asked to probe a node that didn't answer.
+	IndirectChecks int `toml:"IndirectChecks"`
+	// SuspectTimeout is how long a suspected node has to refute the suspicion before it is declared dead.
+	SuspectTimeout time.Duration `toml:"SuspectTimeout"`
+	// ReapTimeout is how long a dead or left node is remembered, so the older news don't bring it back.
+	ReapTimeout time.Duration `toml:"ReapTimeout"`
+}
+
 var _configFlag = flag.String("config", "", "Specify the path to the config file")
 var _configServersFlag = flag.String("config-servers", "", "Specify the path to the config file")
 
@@ -97,17 +290,25 @@ func New() (*Config, error) {
 }
 
 type ServersConfig struct {
-	Servers []string `toml:"Servers"`
+	Servers []string        `toml:"Servers"`
+	Server  []ServerOptions `toml:"Server,omitempty"`
 }
 
+// UpdateServers saves the addresses of the servers, their [[Server]] tables are kept.
 func UpdateServers(servers []string) error {
+	var cfg ServersConfig
+	if _, err := toml.DecodeFile(getPathToServers(), &cfg); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("can't read servers file: %w", err)
+	}
+	cfg.Servers = servers
+
 	f, err := os.OpenFile(getPathToServers(), os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0644)
 	if err != nil {
 		return fmt.Errorf("can't open servers file to insert new")
 	}
 	defer f.Close()
 
-	if err := toml.NewEncoder(f).Encode(&ServersConfig{Servers: servers}); err != nil {
+	if err := toml.NewEncoder(f).Encode(&cfg); err != nil {
 		return fmt.Errorf("failed to decode config: %w", err)
 	}
 
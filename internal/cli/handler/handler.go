@@ -150,6 +150,21 @@ func (h *Handler) Servers(c echo.Context) error {
 	return nil
 }
 
+// Cluster returns the servers of the balancer as JSON, for the scripts.
+func (h *Handler) Cluster(c echo.Context) error {
+	cookie, err := c.Cookie("session")
+	if cookie == nil || err != nil {
+		return c.Redirect(http.StatusMovedPermanently, "/auth")
+	}
+
+	info, err := h.logic.ClusterInfo(c.Request().Context(), cookie.Value)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, schema.Response{Text: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, info)
+}
+
 func (h *Handler) Authenticate(c echo.Context) error {
 	cookie, err := c.Cookie("session")
 	if cookie != nil && err == nil {
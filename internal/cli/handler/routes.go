@@ -12,5 +12,6 @@ func (h *Handler) PublicRoutes(e *echo.Echo) {
 	e.GET("/act", h.Action)
 	e.GET("/history", h.History)
 	e.GET("/servers", h.Servers)
+	e.GET("/cluster", h.Cluster)
 	e.HEAD("/", h.MainPage)
 }
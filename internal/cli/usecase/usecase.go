@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/egorgasay/itisadb-go-sdk"
 	"go.uber.org/zap"
@@ -15,6 +16,7 @@ import (
 	"itisadb/config"
 
 	"itisadb/internal/cli/commands"
+	"itisadb/pkg/api/cluster"
 
 	api "github.com/egorgasay/itisadb-shared-proto/go"
 
@@ -26,6 +28,7 @@ type UseCase struct {
 
 	sdk       *itisadb.Client
 	conn      api.ItisaDBClient
+	cluster   cluster.ClusterClient
 	mainToken string
 
 	cmds   *commands.Commands
@@ -57,7 +60,7 @@ func New(cfg config.WebAppConfig, storage *storage.Storage, balancer string, lg
 	cmds := commands.New(r.Unwrap())
 
 	return &UseCase{
-		conn: b, storage: storage, cmds: cmds,
+		conn: b, cluster: cluster.NewClusterClient(conn), storage: storage, cmds: cmds,
 		tokens: map[string]string{"itisadb": "itisadb"}, // TODO: ??
 		logger: lg, mainToken: resp.Token,
 	}
@@ -108,12 +111,48 @@ func withAuth(ctx context.Context, token string) context.Context {
 		metadata.New(map[string]string{"token": token}))
 }
 
+// Servers describes the servers of the balancer, one line per server.
 func (uc *UseCase) Servers(ctx context.Context, token string) (string, error) {
-	servers, err := uc.conn.Servers(withAuth(ctx, token), &api.ServersRequest{})
+	info, err := uc.ClusterInfo(ctx, token)
 	if err != nil {
-		return "", errors.Join(err, fmt.Errorf("failed to get servers"))
+		return "", err
 	}
-	return servers.ServersInfo, err
+
+	lines := make([]string, 0, len(info.Servers))
+	for _, s := range info.Servers {
+		name := s.Address
+		if s.Local {
+			name = "local"
+		}
+
+		line := fmt.Sprintf("s#%d %s %s, RAM %d/%d MB free", s.Number, name, s.State, s.RAMAvailable, s.RAMTotal)
+		if s.StatsError == "" {
+			line += fmt.Sprintf(", %d keys, %d objects, %s", s.Keys, s.Objects, s.Role)
+		}
+
+		if !s.Local {
+			line += fmt.Sprintf(", latency p50 %s p90 %s p99 %s",
+				time.Duration(s.LatencyP50), time.Duration(s.LatencyP90), time.Duration(s.LatencyP99))
+
+			if s.Failures > 0 {
+				line += fmt.Sprintf(", %d failures", s.Failures)
+			}
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// ClusterInfo returns the servers of the balancer as the Cluster service reports them.
+func (uc *UseCase) ClusterInfo(ctx context.Context, token string) (*cluster.ClusterInfoResponse, error) {
+	info, err := uc.cluster.ClusterInfo(withAuth(ctx, token), &cluster.ClusterInfoRequest{})
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("failed to get servers"))
+	}
+
+	return info, nil
 }
 
 func (uc *UseCase) Authenticate(ctx context.Context, username, password string) (string, error) {
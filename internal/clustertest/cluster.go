@@ -0,0 +1,319 @@
+package clustertest
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/egorgasay/gost"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/servers/ring"
+)
+
+// Cluster is the part of servers.Servers the services use. It implements the placement
+// of the keys on a hash ring when it has one, the other methods panic.
+// Without a ring the keys have no owner and are placed by the catalog.
+type Cluster struct {
+	domains.Servers
+
+	mu          sync.Mutex
+	servers     map[int32]domains.Server
+	leaving     map[int32]bool
+	maintenance map[int32]bool
+	ring        *ring.Ring
+}
+
+// NewCluster returns a cluster without a hash ring, the keys are placed by the catalog.
+func NewCluster(servers ...domains.Server) *Cluster {
+	c := &Cluster{servers: make(map[int32]domains.Server), leaving: make(map[int32]bool), maintenance: make(map[int32]bool)}
+	for _, s := range servers {
+		c.Add(s)
+	}
+
+	return c
+}
+
+// NewHashCluster returns a cluster that places the keys on a hash ring.
+func NewHashCluster(servers ...domains.Server) *Cluster {
+	c := &Cluster{servers: make(map[int32]domains.Server), leaving: make(map[int32]bool), maintenance: make(map[int32]bool), ring: ring.New(0)}
+	for _, s := range servers {
+		c.Add(s)
+	}
+
+	return c
+}
+
+func (c *Cluster) Add(s domains.Server) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.servers[s.Number()] = s
+	if c.ring != nil {
+		c.ring.Add(s.Number())
+	}
+}
+
+// Owners returns the numbers of the n servers the ring places the key on.
+func (c *Cluster) Owners(key string, n int) []int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ring == nil {
+		return nil
+	}
+
+	return c.ring.Owners(key, n, nil)
+}
+
+func (c *Cluster) Owner(key string) int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ring == nil {
+		return 0
+	}
+
+	n, _ := c.ring.Owner(key, nil)
+	return n
+}
+
+func (c *Cluster) KeyReplicas(key string, n int) []domains.Server {
+	var replicas []domains.Server
+	for _, number := range c.Owners(key, n) {
+		s, _ := c.GetServer(number)
+		replicas = append(replicas, s)
+	}
+
+	return replicas
+}
+
+func (c *Cluster) KeyOwner(key string) (res gost.Option[domains.Server]) {
+	if replicas := c.KeyReplicas(key, 1); len(replicas) > 0 {
+		return res.Some(replicas[0])
+	}
+
+	return res.None()
+}
+
+func (c *Cluster) Segments(n int) []models.Segment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ring == nil {
+		return nil
+	}
+
+	var segments []models.Segment
+	for _, seg := range c.ring.Segments(n, nil) {
+		segments = append(segments, models.Segment{From: seg.From, To: seg.To, Servers: seg.Servers})
+	}
+
+	return segments
+}
+
+// GetServer picks the placeable server with the smallest number for the automatic placement.
+func (c *Cluster) GetServer(number int32) (domains.Server, bool) {
+	if number != constants.AutoServerNumber {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		s, ok := c.servers[number]
+		return s, ok
+	}
+
+	placed := c.PlaceN("", 1)
+	if placed.IsErr() {
+		return nil, false
+	}
+
+	return placed.Unwrap()[0], true
+}
+
+// Place picks the placeable server with the smallest number, the hint is ignored.
+func (c *Cluster) Place(hint string) (res gost.Result[domains.Server]) {
+	placed := c.PlaceN(hint, 1)
+	if placed.IsErr() {
+		return res.Err(placed.Error())
+	}
+
+	return res.Ok(placed.Unwrap()[0])
+}
+
+// PlaceN picks the placeable servers with the smallest numbers.
+func (c *Cluster) PlaceN(_ string, n int) (res gost.Result[[]domains.Server]) {
+	var placed []domains.Server
+	for _, number := range c.placeable() {
+		if len(placed) == n {
+			break
+		}
+
+		s, _ := c.GetServer(number)
+		placed = append(placed, s)
+	}
+
+	if len(placed) == 0 {
+		return res.Err(constants.ErrNoPlacement)
+	}
+
+	return res.Ok(placed)
+}
+
+// DeepSearch asks the online servers for the key in the order of their numbers.
+func (c *Cluster) DeepSearch(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.GetOptions) (res gost.Result[gost.Pair[int32, models.Value]]) {
+	for _, s := range c.online() {
+		if r := s.GetOne(ctx, claims, key, opts); r.IsOk() {
+			return res.Ok(gost.Pair[int32, models.Value]{Left: s.Number(), Right: r.Unwrap()})
+		}
+	}
+
+	return res.Err(constants.ErrNotFound)
+}
+
+// SetToAll sets the key on the online servers and returns the numbers of the ones that failed.
+func (c *Cluster) SetToAll(ctx context.Context, claims gost.Option[models.UserClaims], key, val string, opts models.SetOptions) []int32 {
+	failed := make([]int32, 0)
+	for _, s := range c.online() {
+		if r := s.SetOne(ctx, claims, key, val, opts); r.IsErr() {
+			failed = append(failed, s.Number())
+		}
+	}
+
+	return failed
+}
+
+// DelFromAll deletes the key from the online servers.
+func (c *Cluster) DelFromAll(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.DeleteOptions) (atLeastOnce bool) {
+	for _, s := range c.online() {
+		if r := s.DelOne(ctx, claims, key, opts); r.IsOk() {
+			atLeastOnce = true
+		}
+	}
+
+	return atLeastOnce
+}
+
+// Iter calls f on the servers in the order of their numbers.
+func (c *Cluster) Iter(f func(domains.Server) error) error {
+	for _, number := range c.numbers() {
+		s, ok := c.GetServer(number)
+		if !ok {
+			continue
+		}
+
+		if err := f(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Cluster) Exists(number int32) bool {
+	_, ok := c.GetServer(number)
+	return ok
+}
+
+func (c *Cluster) Len() int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int32(len(c.servers))
+}
+
+func (c *Cluster) Leave(number int32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.servers[number]; !ok {
+		return false
+	}
+
+	c.leaving[number] = true
+	if c.ring != nil {
+		c.ring.Remove(number)
+	}
+
+	return true
+}
+
+func (c *Cluster) Leaving() (leaving []int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for n := range c.leaving {
+		leaving = append(leaving, n)
+	}
+	slices.Sort(leaving)
+
+	return leaving
+}
+
+// SetMaintenance keeps the server out of the placement, the ring still owns the keys with it.
+func (c *Cluster) SetMaintenance(number int32, on bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.servers[number]; !ok {
+		return false
+	}
+
+	if on {
+		c.maintenance[number] = true
+	} else {
+		delete(c.maintenance, number)
+	}
+
+	return true
+}
+
+func (c *Cluster) Disconnect(number int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.servers, number)
+	delete(c.leaving, number)
+	delete(c.maintenance, number)
+	if c.ring != nil {
+		c.ring.Remove(number)
+	}
+}
+
+// Connected reports whether the server is still in the cluster.
+func (c *Cluster) Connected(number int32) bool {
+	return c.Exists(number)
+}
+
+func (c *Cluster) numbers() []int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	numbers := make([]int32, 0, len(c.servers))
+	for n := range c.servers {
+		numbers = append(numbers, n)
+	}
+	slices.Sort(numbers)
+
+	return numbers
+}
+
+func (c *Cluster) online() (online []domains.Server) {
+	c.Iter(func(s domains.Server) error {
+		if !s.IsOffline() {
+			online = append(online, s)
+		}
+		return nil
+	})
+
+	return online
+}
+
+// placeable returns the servers new data may be put on, like servers.Servers does.
+func (c *Cluster) placeable() []int32 {
+	numbers := c.numbers()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.DeleteFunc(numbers, func(n int32) bool { return c.leaving[n] || c.maintenance[n] })
+}
@@ -0,0 +1,4 @@
+// Package clustertest holds the fixtures the tests of the cluster services share:
+// in-memory servers, a cluster of them that stands for servers.Servers,
+// and the helpers that run several nodes on localhost.
+package clustertest
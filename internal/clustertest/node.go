@@ -0,0 +1,65 @@
+package clustertest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Timeout bounds the waits of Eventually.
+const Timeout = 10 * time.Second
+
+// Addresses returns n free addresses on localhost, the nodes listen on them after a restart too.
+func Addresses(t *testing.T, n int) []string {
+	t.Helper()
+
+	addresses := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lis.Close()
+
+		addresses = append(addresses, lis.Addr().String())
+	}
+
+	return addresses
+}
+
+// Serve serves the services register adds on the address, until the server is stopped.
+func Serve(t *testing.T, address string, register func(*grpc.Server)) *grpc.Server {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer()
+	register(srv)
+	go srv.Serve(lis)
+
+	return srv
+}
+
+// Insecure is the dial option of the nodes, they talk without TLS.
+func Insecure() grpc.DialOption {
+	return grpc.WithTransportCredentials(insecure.NewCredentials())
+}
+
+// Eventually waits until cond holds, the test fails after Timeout.
+func Eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(Timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,526 @@
+package clustertest
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/egorgasay/gost"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/servers/ring"
+	"itisadb/pkg/merkle"
+)
+
+// ErrDown is the error of every call to a server that is down.
+var ErrDown = gost.NewErrX(0, "server is down")
+
+// Server keeps its values and objects in maps. It implements the part of domains.Server
+// the services use, the other methods panic.
+type Server struct {
+	domains.Server
+
+	number int32
+
+	mu       sync.Mutex
+	offline  bool
+	down     bool
+	rejected int
+	reads    int
+	calls    map[string]int
+	// delay holds the reads back, like a slow server does
+	delay   time.Duration
+	written func(name string)
+	// filter answers MayHave, every key may be on the server without it
+	filter func(key string) bool
+
+	values  map[string]models.Value
+	objects map[string]models.Level
+	fields  map[string]map[string]string
+}
+
+func NewServer(number int32) *Server {
+	return &Server{
+		number:  number,
+		calls:   make(map[string]int),
+		values:  make(map[string]models.Value),
+		objects: make(map[string]models.Level),
+		fields:  make(map[string]map[string]string),
+	}
+}
+
+func (s *Server) Number() int32 { return s.number }
+
+func (s *Server) IsOffline() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offline
+}
+
+// SetOffline marks the server offline, the calls still reach it.
+func (s *Server) SetOffline(offline bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offline = offline
+}
+
+// SetDown fails the reads and the writes of keys with ErrDown, the server is not marked offline.
+func (s *Server) SetDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+// Rejected returns the number of the calls that failed while the server was down.
+func (s *Server) Rejected() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rejected
+}
+
+// Reads returns the number of the reads of keys.
+func (s *Server) Reads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reads
+}
+
+// Calls returns the number of the calls of the method, one of GetOne, SetOne, MGet and MSet.
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+// SetKeyFilter makes MayHave answer with f, like the key filter the server was last asked for.
+func (s *Server) SetKeyFilter(f func(key string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter = f
+}
+
+func (s *Server) MayHave(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filter == nil || s.filter(key)
+}
+
+// RefreshRAM and RefreshKeyFilter let the health checks of servers.Servers run, the fake has nothing to pull.
+func (s *Server) RefreshRAM(context.Context) (res gost.ResultN) { return res.Ok() }
+
+func (s *Server) RefreshKeyFilter(context.Context) (res gost.ResultN) { return res.Ok() }
+
+func (s *Server) SetDelay(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = delay
+}
+
+// OnWrite sets f to be called with the key or the object after a value is written.
+// The server is still locked, so f must not call it.
+func (s *Server) OnWrite(f func(name string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = f
+}
+
+// Put writes the value as it is, even while the server is down.
+func (s *Server) Put(key string, val models.Value) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = val
+}
+
+// Remove deletes the key, even while the server is down.
+func (s *Server) Remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+func (s *Server) Value(key string) (models.Value, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.values[key]
+	return v, ok
+}
+
+func (s *Server) Has(key string) bool {
+	_, ok := s.Value(key)
+	return ok
+}
+
+// Keys returns the keys of the values, sorted.
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
+
+func (s *Server) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.values)
+}
+
+// Objects returns the names of the objects, the nested ones too, sorted.
+func (s *Server) Objects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.objects))
+	for name := range s.objects {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func (s *Server) ObjectLevel(name string) (models.Level, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	level, ok := s.objects[name]
+	return level, ok
+}
+
+// Fields returns a copy of the values of the object, nil when there is no such object.
+func (s *Server) Fields(object string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fields, ok := s.fields[object]
+	if !ok {
+		return nil
+	}
+
+	cp := make(map[string]string, len(fields))
+	for k, v := range fields {
+		cp[k] = v
+	}
+
+	return cp
+}
+
+// reject counts the call when the server is down. The caller holds the lock.
+func (s *Server) reject() bool {
+	if s.down {
+		s.rejected++
+	}
+	return s.down
+}
+
+// notify calls the written hook. The caller holds the lock.
+func (s *Server) notify(name string) {
+	if s.written != nil {
+		s.written(name)
+	}
+}
+
+func (s *Server) GetOne(ctx context.Context, _ gost.Option[models.UserClaims], key string, _ models.GetOptions) (res gost.Result[models.Value]) {
+	s.mu.Lock()
+	s.reads++
+	s.calls["GetOne"]++
+	delay := s.delay
+	s.mu.Unlock()
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return res.Err(ErrDown)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reject() {
+		return res.Err(ErrDown)
+	}
+
+	v, ok := s.values[key]
+	if !ok {
+		return res.Err(constants.ErrNotFound)
+	}
+
+	return res.Ok(v)
+}
+
+func (s *Server) SetOne(_ context.Context, _ gost.Option[models.UserClaims], key, val string, opts models.SetOptions) (res gost.Result[int32]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls["SetOne"]++
+
+	if s.reject() {
+		return res.Err(ErrDown)
+	}
+
+	if _, ok := s.values[key]; ok && opts.Unique {
+		return res.Err(constants.ErrAlreadyExists)
+	}
+
+	s.values[key] = models.Value{Value: val, Level: opts.Level, ReadOnly: opts.ReadOnly}
+	s.notify(key)
+
+	return res.Ok(s.number)
+}
+
+func (s *Server) DelOne(_ context.Context, _ gost.Option[models.UserClaims], key string, _ models.DeleteOptions) (res gost.ResultN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reject() {
+		return res.Err(ErrDown)
+	}
+
+	if _, ok := s.values[key]; !ok {
+		return res.Err(constants.ErrNotFound)
+	}
+
+	delete(s.values, key)
+	return res.Ok()
+}
+
+// MGet reads the keys like GetOne, without the delay.
+func (s *Server) MGet(_ context.Context, _ gost.Option[models.UserClaims], keys []string, _ models.GetOptions) (res gost.Result[[]models.GetResult]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls["MGet"]++
+
+	if s.reject() {
+		return res.Err(ErrDown)
+	}
+
+	results := make([]models.GetResult, len(keys))
+	for i, key := range keys {
+		results[i].Key = key
+		s.reads++
+
+		if v, ok := s.values[key]; ok {
+			results[i].Value = v
+		} else {
+			results[i].Err = constants.ErrNotFound
+		}
+	}
+
+	return res.Ok(results)
+}
+
+func (s *Server) MSet(_ context.Context, _ gost.Option[models.UserClaims], values map[string]string, opts models.SetOptions) (res gost.Result[[]models.SetResult]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls["MSet"]++
+
+	if s.reject() {
+		return res.Err(ErrDown)
+	}
+
+	results := make([]models.SetResult, 0, len(values))
+	for key, val := range values {
+		s.values[key] = models.Value{Value: val, Level: opts.Level, ReadOnly: opts.ReadOnly}
+		s.notify(key)
+
+		results = append(results, models.SetResult{Key: key, Server: s.number})
+	}
+
+	return res.Ok(results)
+}
+
+func (s *Server) NewObject(_ context.Context, _ gost.Option[models.UserClaims], name string, opts models.ObjectOptions) (res gost.ResultN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reject() {
+		return res.Err(ErrDown)
+	}
+
+	s.objects[name] = opts.Level
+	if s.fields[name] == nil {
+		s.fields[name] = make(map[string]string)
+	}
+
+	return res.Ok()
+}
+
+func (s *Server) IsObject(_ context.Context, _ gost.Option[models.UserClaims], name string, _ models.IsObjectOptions) (res gost.Result[bool]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reject() {
+		return res.Err(ErrDown)
+	}
+
+	_, ok := s.objects[name]
+	return res.Ok(ok)
+}
+
+func (s *Server) SetToObject(_ context.Context, _ gost.Option[models.UserClaims], object, key, value string, _ models.SetToObjectOptions) (res gost.ResultN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[object]; !ok {
+		return res.Err(constants.ErrObjectNotFound)
+	}
+
+	s.fields[object][key] = value
+	s.notify(object)
+
+	return res.Ok()
+}
+
+func (s *Server) GetFromObject(_ context.Context, _ gost.Option[models.UserClaims], object, key string, _ models.GetFromObjectOptions) (res gost.Result[string]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[object]; !ok {
+		return res.Err(constants.ErrObjectNotFound)
+	}
+
+	v, ok := s.fields[object][key]
+	if !ok {
+		return res.Err(constants.ErrNotFound)
+	}
+
+	return res.Ok(v)
+}
+
+func (s *Server) ObjectDeleteKey(_ context.Context, _ gost.Option[models.UserClaims], object, key string, _ models.DeleteAttrOptions) (res gost.ResultN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.fields[object][key]; !ok {
+		return res.Err(constants.ErrNotFound)
+	}
+
+	delete(s.fields[object], key)
+	return res.Ok()
+}
+
+// ObjectToJSON encodes the object the way the storage does.
+func (s *Server) ObjectToJSON(_ context.Context, _ gost.Option[models.UserClaims], name string, _ models.ObjectToJSONOptions) (res gost.Result[string]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[name]; !ok {
+		return res.Err(constants.ErrObjectNotFound)
+	}
+
+	data, err := json.Marshal(s.objectJSON(name))
+	if err != nil {
+		return res.ErrNewUnknown(err.Error())
+	}
+
+	return res.Ok(string(data))
+}
+
+func (s *Server) objectJSON(name string) map[string]any {
+	values := make([]any, 0)
+	for key, val := range s.fields[name] {
+		values = append(values, map[string]any{"key": key, "value": val, "read_only": false})
+	}
+
+	for child := range s.objects {
+		if i := strings.LastIndex(child, constants.ObjectSeparator); i > 0 && child[:i] == name {
+			values = append(values, s.objectJSON(child))
+		}
+	}
+
+	short := name[strings.LastIndex(name, constants.ObjectSeparator)+1:]
+
+	return map[string]any{"name": short, "level": s.objects[name].String(), "attached_to": nil, "values": values}
+}
+
+func (s *Server) DeleteObject(_ context.Context, _ gost.Option[models.UserClaims], object string, _ models.DeleteObjectOptions) (res gost.ResultN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[object]; !ok {
+		return res.Err(constants.ErrNotFound)
+	}
+
+	for name := range s.objects {
+		if name == object || strings.HasPrefix(name, object+constants.ObjectSeparator) {
+			delete(s.objects, name)
+			delete(s.fields, name)
+		}
+	}
+
+	return res.Ok()
+}
+
+// Scan passes the values first, then the objects sorted by name, so the parents come before their children.
+func (s *Server) Scan(_ context.Context, f func(models.Entry) error) (res gost.ResultN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, val := range s.values {
+		if err := f(models.Entry{Kind: models.ValueEntry, Key: key, Value: val}); err != nil {
+			return res.ErrNewUnknown(err.Error())
+		}
+	}
+
+	names := make([]string, 0, len(s.objects))
+	for name := range s.objects {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if err := f(models.Entry{Kind: models.ObjectEntry, Object: name, Value: models.Value{Level: s.objects[name]}}); err != nil {
+			return res.ErrNewUnknown(err.Error())
+		}
+
+		for key, val := range s.fields[name] {
+			if err := f(models.Entry{Kind: models.ObjectValueEntry, Object: name, Key: key, Value: models.Value{Value: val}}); err != nil {
+				return res.ErrNewUnknown(err.Error())
+			}
+		}
+	}
+
+	return res.Ok()
+}
+
+func (s *Server) MerkleTree(_ context.Context, ranges merkle.Ranges, depth int) (res gost.Result[*merkle.Tree]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tree := merkle.New(depth)
+	for key, val := range s.values {
+		if h := ring.Hash(key); ranges.Contains(h) {
+			tree.Add(h, key, val.Value)
+		}
+	}
+
+	return res.Ok(tree)
+}
+
+func (s *Server) MerkleLeaves(_ context.Context, ranges merkle.Ranges, depth int, leaves []int) (res gost.Result[[]models.Entry]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tree := merkle.New(depth)
+
+	var entries []models.Entry
+	for key, val := range s.values {
+		if h := ring.Hash(key); ranges.Contains(h) && slices.Contains(leaves, tree.Leaf(h)) {
+			entries = append(entries, models.Entry{Kind: models.ValueEntry, Key: key, Value: val})
+		}
+	}
+
+	return res.Ok(entries)
+}
@@ -18,3 +18,10 @@ const (
 const NoUser = 0
 
 const UserKey = "user-claims"
+
+// CallerKey is the metadata key of the caller a balancer forwards to a remote server.
+// The value is a token signed by the balancer, or AnonymousCaller for a caller without one.
+const (
+	CallerKey       = "caller"
+	AnonymousCaller = "anonymous"
+)
@@ -43,4 +43,58 @@ var (
 	ErrInvalidPassword = errors.New("invalid password")
 
 	ErrForbidden = gost.NewErrX(0, "forbidden")
+
+	/*
+		Replication Errors
+	*/
+
+	ErrReadOnlyReplica = gost.NewErrX(0, "node is a read-only replica")
+
+	/*
+		Transaction Logger Errors
+	*/
+
+	ErrLogQueueFull = gost.NewErrX(0, "transaction log queue is full")
+	ErrLogReadOnly  = gost.NewErrX(0, "node is read-only until the transaction log queue drains")
+	ErrLogFailed    = gost.NewErrX(0, "transaction logger failed")
+
+	/*
+		Quorum Errors
+	*/
+
+	ErrQuorum = gost.NewErrX(0, "not enough replicas answered")
+
+	/*
+		Membership Errors
+	*/
+
+	ErrNoTargetServer = gost.NewErrX(0, "no other server can take the data")
+	ErrNoPlacement    = gost.NewErrX(0, "no server can take new data")
+	ErrInvalidHint    = gost.NewErrX(0, "invalid placement hint")
+
+	/*
+		Sharding Errors
+	*/
+
+	ErrNestedShards = gost.NewErrX(0, "only a top-level object can be sharded")
+
+	/*
+		Value Errors
+	*/
+
+	ErrReservedValue = gost.NewErrX(0, "the value starts with a prefix reserved for references or versions")
+
+	/*
+		Metadata Errors
+	*/
+
+	ErrNoMetadataLeader = gost.NewErrX(0, "no balancer leads the metadata")
+
+	/*
+		Transaction Errors
+	*/
+
+	ErrTxConflict    = gost.NewErrX(0, "the key is locked by another transaction")
+	ErrTxReplicated  = gost.NewErrX(0, "transactions don't support replicated keys")
+	ErrNoCoordinator = gost.NewErrX(0, "transactions are coordinated by balancers only")
 )
@@ -0,0 +1,20 @@
+package domains
+
+import (
+	"context"
+	"io"
+
+	"itisadb/internal/models"
+)
+
+type Backuper interface {
+	Backup(ctx context.Context, w io.Writer) (models.BackupManifest, error)
+}
+
+// SnapshotVisitor receives the whole state of a storage, parent objects come before their children.
+type SnapshotVisitor interface {
+	Value(key string, v models.Value) error
+	Object(name string, info models.ObjectInfo) error
+	ObjectValue(object string, key string, v models.OValue) error
+	User(user models.User) error
+}
@@ -5,12 +5,20 @@ import (
 
 	"github.com/egorgasay/gost"
 	"itisadb/internal/models"
+	"itisadb/pkg/bloom"
 )
 
 type Balancer interface {
 	Get(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.GetOptions) (models.Value, error)
 	Set(ctx context.Context, claims gost.Option[models.UserClaims], key, val string, opts models.SetOptions) (int32, error)
 	Delete(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.DeleteOptions) error
+	// MGet and MSet report the error of each key in its result, a failed key doesn't fail the others.
+	MGet(ctx context.Context, claims gost.Option[models.UserClaims], keys []string, opts models.GetOptions) ([]models.GetResult, error)
+	MSet(ctx context.Context, claims gost.Option[models.UserClaims], values map[string]string, opts models.SetOptions) ([]models.SetResult, error)
+	// Transact applies the writes all at once with a two-phase commit across their servers, it returns the ID of the transaction.
+	Transact(ctx context.Context, claims gost.Option[models.UserClaims], ops []models.TxOp, opts models.SetOptions) (string, error)
+	// The transactions coordinated by the balancers are prepared and resolved on this node through txLogic.
+	txLogic
 
 	Object(ctx context.Context, claims gost.Option[models.UserClaims], name string, opts models.ObjectOptions) (int32, error)
 	ObjectToJSON(ctx context.Context, claims gost.Option[models.UserClaims], name string, opts models.ObjectToJSONOptions) (string, error)
@@ -25,7 +33,17 @@ type Balancer interface {
 
 	Connect(ctx context.Context, address string) (int32, error)
 	Disconnect(ctx context.Context, number int32) error
+	// Drain moves the data off the server and disconnects it when it is empty.
+	Drain(ctx context.Context, number int32) error
+	// SetMaintenance keeps new data off the server without moving what it holds.
+	SetMaintenance(ctx context.Context, number int32, on bool) error
 	Servers() []string
+	// ClusterInfo describes every server of the balancer, the storage stats are asked from the servers.
+	ClusterInfo(ctx context.Context) ([]models.ServerInfo, error)
+	// NodeStats describes the storage of this node.
+	NodeStats(ctx context.Context) gost.Result[models.NodeStats]
+	// KeyFilter returns the Bloom filter over the keys of this node.
+	KeyFilter(ctx context.Context) gost.Result[*bloom.Filter]
 
 	Authenticate(ctx context.Context, login, password string) (string, error)
 	NewUser(ctx context.Context, claims gost.Option[models.UserClaims], user models.User) error
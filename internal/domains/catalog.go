@@ -0,0 +1,19 @@
+package domains
+
+import "github.com/egorgasay/gost"
+
+// Catalog remembers which server holds a key or an object.
+type Catalog interface {
+	KeyServer(key string) gost.Option[int32]
+	SetKeyServer(key string, server int32) gost.ResultN
+	DelKeyServer(key string) gost.ResultN
+
+	ObjectServer(object string) gost.Option[int32]
+	SetObjectServer(object string, server int32) gost.ResultN
+	DelObjectServer(object string) gost.ResultN
+
+	// ObjectShards returns the servers of the shards of a sharded object.
+	ObjectShards(object string) gost.Option[[]int32]
+	SetObjectShards(object string, servers []int32) gost.ResultN
+	DelObjectShards(object string) gost.ResultN
+}
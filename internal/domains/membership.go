@@ -0,0 +1,11 @@
+package domains
+
+import "itisadb/internal/models"
+
+// Membership is the view of the cluster the nodes gossip about.
+type Membership interface {
+	// Members returns the nodes of the cluster, this one too.
+	Members() []models.Member
+	// Watch calls f with the members now and after they change.
+	Watch(f func(members []models.Member))
+}
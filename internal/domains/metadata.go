@@ -0,0 +1,28 @@
+package domains
+
+import (
+	"context"
+
+	"github.com/egorgasay/gost"
+)
+
+// Metadata is what the balancer instances share: the servers with their numbers,
+// the routes and the synced user change ID. Any instance reads it, the changes go through the leader.
+type Metadata interface {
+	Catalog
+
+	// AddMember gives the server at the address a number, a member keeps its number.
+	AddMember(ctx context.Context, address string) gost.Result[int32]
+	RemoveMember(ctx context.Context, number int32) gost.ResultN
+	// Members returns the addresses of the servers by their numbers.
+	Members() map[int32]string
+	// Watch calls f with the members now and after they change.
+	Watch(f func(members map[int32]string))
+
+	UserChangeID() uint64
+	// SetUserChangeID saves the change ID, unless a greater one is saved.
+	SetUserChangeID(ctx context.Context, id uint64) gost.ResultN
+
+	// IsLeader reports whether this instance leads the others.
+	IsLeader() bool
+}
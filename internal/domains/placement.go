@@ -0,0 +1,12 @@
+package domains
+
+import (
+	"github.com/egorgasay/gost"
+	"itisadb/internal/models"
+)
+
+// PlacementStrategy chooses the server for new data.
+// Pick is called concurrently, the candidates are never empty.
+type PlacementStrategy interface {
+	Pick(candidates []models.PlacementCandidate) gost.Option[int32]
+}
@@ -0,0 +1,27 @@
+package domains
+
+import (
+	"context"
+
+	"github.com/egorgasay/gost"
+	"itisadb/internal/models"
+	"itisadb/pkg/merkle"
+)
+
+// Scanner streams the keys and objects stored on a server.
+type Scanner interface {
+	Scan(ctx context.Context, f func(models.Entry) error) gost.ResultN
+	// MerkleTree digests the values whose ring hashes fall in ranges, the replicas compare the trees.
+	MerkleTree(ctx context.Context, ranges merkle.Ranges, depth int) gost.Result[*merkle.Tree]
+	// MerkleLeaves returns the values under the leaves of the tree MerkleTree builds with the same arguments.
+	MerkleLeaves(ctx context.Context, ranges merkle.Ranges, depth int, leaves []int) gost.Result[[]models.Entry]
+}
+
+// Rebalancer moves the data between the servers when they join or leave.
+type Rebalancer interface {
+	// Rebalance moves every key and object to the servers that own it now.
+	Rebalance(reason string)
+	// Remove moves the data off the server and disconnects it.
+	Remove(server int32)
+	Status() models.RebalanceStatus
+}
@@ -0,0 +1,18 @@
+package domains
+
+import (
+	"context"
+
+	"itisadb/internal/models"
+)
+
+// ReplicationSource is a node whose transaction log can be tailed by replicas.
+type ReplicationSource interface {
+	Head() uint64
+	Stream(ctx context.Context, from uint64, send func(models.LogEvent) error) error
+}
+
+type Replica interface {
+	Start(ctx context.Context)
+	Status() models.ReplicationStatus
+}
@@ -14,9 +14,17 @@ type Server interface {
 	IsOffline() bool
 	Reconnect(ctx context.Context) (res gost.ResultN)
 	Address() string
+	// NodeStats reports the size of the storage and the replication role of the server.
+	NodeStats(ctx context.Context) (res gost.Result[models.NodeStats])
+	// MayHave reports false only when the server is known not to have the key.
+	MayHave(key string) bool
+	// RefreshKeyFilter pulls the filter MayHave answers with.
+	RefreshKeyFilter(ctx context.Context) (res gost.ResultN)
 
+	Scanner
 	appLogic
 	userLogic
+	txLogic
 }
 
 type appLogic interface {
@@ -24,6 +32,11 @@ type appLogic interface {
 	DelOne(ctx context.Context, claims gost.Option[models.UserClaims], key string, opt models.DeleteOptions) gost.ResultN
 	SetOne(ctx context.Context, claims gost.Option[models.UserClaims], key string, val string, opt models.SetOptions) (res gost.Result[int32])
 
+	// MGet and MSet serve a batch of keys in one request, each key gets its own result.
+	// The error is returned only when the whole batch failed.
+	MGet(ctx context.Context, claims gost.Option[models.UserClaims], keys []string, opt models.GetOptions) (res gost.Result[[]models.GetResult])
+	MSet(ctx context.Context, claims gost.Option[models.UserClaims], values map[string]string, opt models.SetOptions) (res gost.Result[[]models.SetResult])
+
 	NewObject(ctx context.Context, claims gost.Option[models.UserClaims], name string, opts models.ObjectOptions) (res gost.ResultN)
 	SetToObject(ctx context.Context, claims gost.Option[models.UserClaims], object, key, value string, opts models.SetToObjectOptions) (res gost.ResultN)
 	GetFromObject(ctx context.Context, claims gost.Option[models.UserClaims], object, key string, opts models.GetFromObjectOptions) (res gost.Result[string])
@@ -44,3 +57,14 @@ type userLogic interface {
 	GetLastUserChangeID(ctx context.Context) (r gost.Result[uint64])
 	Sync(todo context.Context, syncID uint64, users []models.User) gost.ResultN
 }
+
+// txLogic is the part the server plays in the transactions coordinated by a balancer.
+type txLogic interface {
+	// Prepare locks the keys of tx and logs it, the server commits tx whenever it's asked to after that.
+	Prepare(ctx context.Context, claims gost.Option[models.UserClaims], tx models.Transaction) gost.ResultN
+	// CommitPrepared and AbortPrepared resolve a prepared transaction, the ones already resolved are ignored.
+	CommitPrepared(ctx context.Context, id string) gost.ResultN
+	AbortPrepared(ctx context.Context, id string) gost.ResultN
+	// InDoubt returns the IDs of the prepared transactions.
+	InDoubt(ctx context.Context) gost.Result[[]string]
+}
@@ -12,10 +12,28 @@ type Servers interface {
 	Len() int32
 	AddServer(ctx context.Context, address string, force bool) (int32, error)
 	Disconnect(number int32)
-	GetServersInfo() []string
+	// Info describes the servers from what the balancer knows, without asking them. The servers are sorted by number.
+	Info() []models.ServerInfo
 	GetServer(number int32) (Server, bool)
+	// Place chooses a server for new data, the hint limits the choice to the servers with its labels.
+	Place(hint string) gost.Result[Server]
+	// PlaceN chooses up to n different servers for new data.
+	PlaceN(hint string, n int) gost.Result[[]Server]
 	Exists(number int32) bool
 
+	// KeyOwner returns the server that owns the key, if the placement can compute it.
+	KeyOwner(key string) gost.Option[Server]
+	// KeyReplicas returns up to n servers that keep the copies of the key, the owner first.
+	KeyReplicas(key string, n int) []Server
+	// Segments splits the key hashes between the servers that keep their copies, see ring.Segments.
+	Segments(n int) []models.Segment
+
+	// Leave stops placing new data on the server until it is disconnected.
+	Leave(number int32) bool
+	Leaving() []int32
+	// SetMaintenance keeps new data off the server without moving what it holds.
+	SetMaintenance(number int32, on bool) bool
+
 	// TODO: may be we should use Iter instead, because Servers != buisness logic
 	SetToAll(ctx context.Context, claims gost.Option[models.UserClaims], key string, val string, opts models.SetOptions) []int32
 
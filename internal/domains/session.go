@@ -3,6 +3,7 @@ package domains
 import (
 	"context"
 
+	"github.com/egorgasay/gost"
 	"itisadb/internal/models"
 )
 
@@ -10,4 +11,9 @@ type Session interface {
 	AuthByToken(ctx context.Context, token string) (models.UserClaims, error)
 	AuthByPassword(ctx context.Context, username, password string) (string, error)
 	Create(ctx context.Context, userID string, level models.Level) (string, error)
+
+	// Forward signs the caller of a request a balancer sends to a remote server,
+	// AuthByForwarded checks it on the remote server. An anonymous caller is gost.None.
+	Forward(ctx context.Context, claims gost.Option[models.UserClaims]) (string, error)
+	AuthByForwarded(ctx context.Context, caller string) (gost.Option[models.UserClaims], error)
 }
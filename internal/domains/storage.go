@@ -3,6 +3,7 @@ package domains
 import (
 	"github.com/egorgasay/gost"
 	"itisadb/internal/models"
+	"itisadb/pkg/bloom"
 )
 
 //go:generate mockgen -destination=mocks/storage/mock_storage.go -package=mocks . Storage
@@ -10,12 +11,23 @@ type Storage interface {
 	CommonStorage
 	ObjectsStorage
 	UserStorage
+
+	Snapshot(v SnapshotVisitor) error
 }
 
 type CommonStorage interface {
 	Set(key string, val string, opts models.SetOptions) gost.ResultN
 	Get(key string) (r gost.Option[models.Value])
+	// GetMany and SetMany hold the lock once for the whole batch.
+	GetMany(keys []string) []gost.Option[models.Value]
+	// SetMany writes the values that pass check, check gets the current value of the key.
+	SetMany(values map[string]string, opts models.SetOptions, check func(key string, old gost.Option[models.Value]) error) map[string]error
 	DeleteIfExists(key string)
+	// Count returns the number of keys and the number of objects, nested objects included.
+	Count() (keys, objects int)
+	// KeyFilter returns a copy of the Bloom filter over the keys, MayHave asks the filter itself.
+	KeyFilter() *bloom.Filter
+	MayHave(key string) bool
 	Delete(key string) gost.ResultN
 }
 
@@ -62,4 +74,6 @@ type UserStorage interface {
 	GetUsersFromChangeID(id uint64) gost.Result[[]models.User]
 	GetUserChangeID() uint64
 	SetUserChangeID(id uint64)
+	RestoreUser(user models.User) (r gost.ResultN)
+	RestoreDeleteUser(username string, changeID uint64) (r gost.ResultN)
 }
@@ -10,15 +10,36 @@ type TransactionLogger interface {
 	Err() <-chan error
 	Stop() error
 	Restore(r Restorer) error
-	WriteSet(key string, value string, opts models.SetOptions)
-	WriteDelete(key string)
-	WriteSetToObject(name string, key string, val string, opts models.SetToObjectOptions)
-	WriteCreateObject(name string, info models.ObjectInfo)
-	WriteDeleteObject(name string)
-	WriteAttach(dst string, src string)
-	WriteDeleteAttr(name string, key string)
-	WriteNewUser(user models.User)
-	WriteDeleteUser(login string)
+	// Reserve takes room for n events before the data is changed, Release gives it back once they are written.
+	Reserve(n int) gost.ResultN
+	Release(n int)
+	WriteSet(key string, value string, opts models.SetOptions) gost.ResultN
+	WriteDelete(key string) gost.ResultN
+	WriteSetToObject(name string, key string, val string, opts models.SetToObjectOptions) gost.ResultN
+	WriteCreateObject(name string, info models.ObjectInfo) gost.ResultN
+	WriteDeleteObject(name string) gost.ResultN
+	WriteAttach(dst string, src string) gost.ResultN
+	WriteDeleteAttr(name string, key string) gost.ResultN
+	WriteNewUser(user models.User) gost.ResultN
+	WriteUpdateUser(user models.User) gost.ResultN
+	WriteChangePassword(user models.User) gost.ResultN
+	WriteChangeLevel(user models.User) gost.ResultN
+	WriteDeleteUser(login string, changeID uint64) gost.ResultN
+
+	// WritePrepare returns once the event is in the log file, the other events are written in the background.
+	WritePrepare(tx models.Transaction) gost.ResultN
+	WriteCommit(tx models.Transaction) gost.ResultN
+	WriteAbort(id string) gost.ResultN
+	// InDoubt returns the transactions Restore found prepared but not resolved.
+	InDoubt() []models.Transaction
+
+	ReplicationSource
+	HealthChecker
+}
+
+type HealthChecker interface {
+	// Healthy returns the reason why the node can't work properly, if any.
+	Healthy() error
 }
 
 type Restorer interface {
@@ -28,7 +49,8 @@ type Restorer interface {
 	DeleteObject(name string) gost.ResultN
 	CreateObject(name string, opts models.ObjectOptions) gost.ResultN
 	AttachToObject(dst, src string) gost.ResultN
-	NewUser(user models.User) (r gost.ResultN)
+	RestoreUser(user models.User) (r gost.ResultN)
+	RestoreDeleteUser(login string, changeID uint64) (r gost.ResultN)
 	DeleteUser(login string) (r gost.Result[bool])
 	AddObjectInfo(name string, info models.ObjectInfo)
 	DeleteObjectInfo(name string)
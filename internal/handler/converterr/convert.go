@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"itisadb/internal/constants"
+	"strings"
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
@@ -19,6 +20,10 @@ func New(logger *zap.Logger) ConvertErr {
 }
 
 func (c ConvertErr) ToGRPC(err error) error {
+	if err == nil {
+		return nil
+	}
+
 	c.logger.Info(err.Error())
 
 	baseError, _ := Unwrap(err)
@@ -39,19 +44,45 @@ func (c ConvertErr) ToGRPC(err error) error {
 		return status.Error(codes.Unauthenticated, err.Error())
 	case context.Canceled:
 		return status.Error(codes.Canceled, err.Error())
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
 	case constants.ErrForbidden:
 		return status.Error(codes.PermissionDenied, err.Error())
+	case constants.ErrReadOnlyReplica, constants.ErrNoTargetServer, constants.ErrNoPlacement:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case constants.ErrTxReplicated, constants.ErrNoCoordinator:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case constants.ErrTxConflict:
+		return status.Error(codes.Aborted, err.Error())
+	case constants.ErrInvalidHint, constants.ErrNestedShards, constants.ErrReservedValue:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case constants.ErrLogQueueFull, constants.ErrLogReadOnly, constants.ErrLogFailed, constants.ErrQuorum:
+		return status.Error(codes.Unavailable, err.Error())
 	default:
 		return err
 	}
 }
 
+// _shared lists the errors that ToGRPC gives the code of another error.
+// The message of the status keeps the error, so FromGRPC tells them apart by it.
+var _shared = map[codes.Code][]error{
+	codes.FailedPrecondition: {constants.ErrReadOnlyReplica, constants.ErrNoTargetServer, constants.ErrNoPlacement, constants.ErrTxReplicated, constants.ErrNoCoordinator},
+	codes.InvalidArgument:    {constants.ErrInvalidHint, constants.ErrReservedValue, constants.ErrNestedShards},
+	codes.Unavailable:        {constants.ErrLogQueueFull, constants.ErrLogReadOnly, constants.ErrLogFailed, constants.ErrQuorum},
+}
+
 func FromGRPC(err error) error {
 	st, ok := status.FromError(err)
 	if !ok {
 		return err
 	}
 
+	for _, target := range _shared[st.Code()] {
+		if strings.Contains(st.Message(), target.Error()) {
+			return target
+		}
+	}
+
 	switch st.Code() {
 	case codes.NotFound:
 		return constants.ErrNotFound
@@ -67,6 +98,8 @@ func FromGRPC(err error) error {
 		return constants.ErrCircularAttachment
 	case codes.Unauthenticated:
 		return constants.ErrWrongCredentials
+	case codes.Aborted:
+		return constants.ErrTxConflict
 	default:
 		return err
 	}
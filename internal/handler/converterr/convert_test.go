@@ -7,6 +7,8 @@ import (
 	"google.golang.org/grpc/status"
 	"itisadb/internal/constants"
 	"testing"
+
+	"go.uber.org/zap"
 )
 
 func TestConvertToGRPC(t *testing.T) {
@@ -49,9 +51,47 @@ func TestConvertToGRPC(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := ConvertToGRPC(tt.args.err); !errors.Is(err, tt.wantErr) {
+			if err := New(zap.NewNop()).ToGRPC(tt.args.err); !errors.Is(err, tt.wantErr) {
 				t.Errorf("ConvertToGRPC() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestRoundTrip(t *testing.T) {
+	c := New(zap.NewNop())
+
+	for _, want := range []error{
+		constants.ErrNotFound,
+		constants.ErrObjectNotFound,
+		constants.ErrUnavailable,
+		constants.ErrInvalidName,
+		constants.ErrAlreadyExists,
+		constants.ErrCircularAttachment,
+		constants.ErrWrongCredentials,
+		constants.ErrReadOnlyReplica,
+		constants.ErrLogQueueFull,
+		constants.ErrLogReadOnly,
+		constants.ErrLogFailed,
+		constants.ErrQuorum,
+		constants.ErrNoTargetServer,
+		constants.ErrNoPlacement,
+		constants.ErrInvalidHint,
+		constants.ErrReservedValue,
+		constants.ErrNestedShards,
+		constants.ErrTxReplicated,
+		constants.ErrNoCoordinator,
+		constants.ErrTxConflict,
+	} {
+		t.Run(want.Error(), func(t *testing.T) {
+			if got := FromGRPC(c.ToGRPC(want)); got != want {
+				t.Errorf("got %v", got)
+			}
+
+			// the server adds the details to the error
+			if got := FromGRPC(c.ToGRPC(fmt.Errorf("s#1: %w", want))); got != want {
+				t.Errorf("wrapped: got %v", got)
+			}
+		})
+	}
+}
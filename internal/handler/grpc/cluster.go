@@ -0,0 +1,512 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/handler/converterr"
+	"itisadb/internal/models"
+	"itisadb/pkg/api/cluster"
+	"itisadb/pkg/merkle"
+)
+
+// ClusterHandler serves the internal api.Cluster service used by other itisadb nodes.
+type ClusterHandler struct {
+	cluster.UnimplementedClusterServer
+	source     gost.Option[domains.ReplicationSource]
+	replica    gost.Option[domains.Replica]
+	backuper   domains.Backuper
+	scanner    domains.Scanner
+	rebalancer domains.Rebalancer
+	core       domains.Balancer
+	security   domains.SecurityService
+	logger     *zap.Logger
+	converterr converterr.ConvertErr
+
+	replicas atomic.Int32
+}
+
+func NewCluster(
+	source gost.Option[domains.ReplicationSource],
+	replica gost.Option[domains.Replica],
+	backuper domains.Backuper,
+	scanner domains.Scanner,
+	rebalancer domains.Rebalancer,
+	core domains.Balancer,
+	security domains.SecurityService,
+	l *zap.Logger,
+	converterr converterr.ConvertErr,
+) *ClusterHandler {
+	return &ClusterHandler{
+		source:     source,
+		replica:    replica,
+		backuper:   backuper,
+		scanner:    scanner,
+		rebalancer: rebalancer,
+		core:       core,
+		security:   security,
+		logger:     l,
+		converterr: converterr,
+	}
+}
+
+// claims returns the caller put in the context by the auth interceptor.
+func (h *ClusterHandler) claims(ctx context.Context) (claims gost.Option[models.UserClaims]) {
+	if c, ok := ctx.Value(constants.UserKey).(models.UserClaims); ok {
+		return claims.Some(c)
+	}
+
+	return claims.None()
+}
+
+// isAdmin reports whether the caller may use the cluster API.
+func (h *ClusterHandler) isAdmin(ctx context.Context) bool {
+	return h.security.HasPermission(h.claims(ctx), constants.MaxLevel)
+}
+
+func (h *ClusterHandler) Replicate(r *cluster.ReplicateRequest, stream cluster.Cluster_ReplicateServer) error {
+	ctx := stream.Context()
+
+	if !h.isAdmin(ctx) {
+		return h.converterr.ToGRPC(constants.ErrForbidden)
+	}
+
+	if h.source.IsNone() {
+		return status.Error(codes.FailedPrecondition, "transaction logger is disabled on this node")
+	}
+
+	source := h.source.Unwrap()
+
+	h.replicas.Add(1)
+	defer h.replicas.Add(-1)
+
+	h.logger.Info("replica connected", zap.Uint64("from", r.From))
+
+	err := source.Stream(ctx, r.From, func(e models.LogEvent) error {
+		ev := &cluster.ReplicationEvent{
+			Seq:      e.Seq,
+			Head:     source.Head(),
+			Type:     e.Type,
+			Name:     []byte(e.Name),
+			Value:    []byte(e.Value),
+			Metadata: []byte(e.Metadata),
+		}
+
+		if !e.Time.IsZero() {
+			ev.Time = e.Time.UnixNano()
+		}
+
+		return stream.Send(ev)
+	})
+
+	h.logger.Info("replica disconnected", zap.Error(err))
+
+	return err
+}
+
+func (h *ClusterHandler) ReplicationStatus(ctx context.Context, _ *cluster.ReplicationStatusRequest) (*cluster.ReplicationStatusResponse, error) {
+	if !h.isAdmin(ctx) {
+		return nil, h.converterr.ToGRPC(constants.ErrForbidden)
+	}
+
+	var st models.ReplicationStatus
+
+	switch {
+	case h.replica.IsSome():
+		st = h.replica.Unwrap().Status()
+	case h.source.IsSome():
+		st = models.ReplicationStatus{
+			Role:     config.PrimaryRole,
+			Head:     h.source.Unwrap().Head(),
+			Replicas: int(h.replicas.Load()),
+		}
+	}
+
+	return &cluster.ReplicationStatusResponse{
+		Role:      st.Role,
+		Primary:   st.Primary,
+		Connected: st.Connected,
+		Applied:   st.Applied,
+		Head:      st.Head,
+		Replicas:  st.Replicas,
+		LagEvents: st.LagEvents(),
+		LagSecs:   st.LagTime().Seconds(),
+	}, nil
+}
+
+// _backupChunkSize is the size of the archive parts sent by Backup.
+const _backupChunkSize = 1 << 20
+
+func (h *ClusterHandler) Backup(_ *cluster.BackupRequest, stream cluster.Cluster_BackupServer) error {
+	ctx := stream.Context()
+
+	if !h.isAdmin(ctx) {
+		return h.converterr.ToGRPC(constants.ErrForbidden)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := h.backuper.Backup(ctx, pw)
+		pw.CloseWithError(err)
+	}()
+
+	buf := make([]byte, _backupChunkSize)
+	for {
+		n, err := io.ReadFull(pr, buf)
+		if n > 0 {
+			if sendErr := stream.Send(&cluster.BackupChunk{Data: buf[:n]}); sendErr != nil {
+				pr.CloseWithError(sendErr)
+				return sendErr
+			}
+		}
+
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return nil
+		default:
+			h.logger.Error("backup failed", zap.Error(err))
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+}
+
+// Scan streams the keys and objects of the node, a balancer uses it to move them to other servers.
+func (h *ClusterHandler) Scan(_ *cluster.ScanRequest, stream cluster.Cluster_ScanServer) error {
+	ctx := stream.Context()
+
+	if !h.isAdmin(ctx) {
+		return h.converterr.ToGRPC(constants.ErrForbidden)
+	}
+
+	r := h.scanner.Scan(ctx, func(e models.Entry) error {
+		return stream.Send(&cluster.ScanEntry{
+			Kind:     uint8(e.Kind),
+			Object:   e.Object,
+			Key:      e.Key,
+			Value:    e.Value.Value,
+			Level:    uint8(e.Value.Level),
+			ReadOnly: e.Value.ReadOnly,
+		})
+	})
+	if r.IsErr() {
+		h.logger.Error("scan failed", zap.Error(r.Error()))
+		return status.Error(codes.Internal, r.Error().Error())
+	}
+
+	return nil
+}
+
+// MerkleTree digests the values of the node in the ranges, the balancer compares the trees of the replicas.
+func (h *ClusterHandler) MerkleTree(ctx context.Context, r *cluster.MerkleTreeRequest) (*cluster.MerkleTreeResponse, error) {
+	if !h.isAdmin(ctx) {
+		return nil, h.converterr.ToGRPC(constants.ErrForbidden)
+	}
+
+	res := h.scanner.MerkleTree(ctx, merkleRanges(r.Ranges), r.Depth)
+	if res.IsErr() {
+		return nil, status.Error(codes.Internal, res.Error().Error())
+	}
+
+	data, err := res.Unwrap().MarshalBinary()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &cluster.MerkleTreeResponse{Tree: data}, nil
+}
+
+// MerkleLeaves returns the values under the leaves the trees of the replicas differ at.
+func (h *ClusterHandler) MerkleLeaves(ctx context.Context, r *cluster.MerkleLeavesRequest) (*cluster.MerkleLeavesResponse, error) {
+	if !h.isAdmin(ctx) {
+		return nil, h.converterr.ToGRPC(constants.ErrForbidden)
+	}
+
+	res := h.scanner.MerkleLeaves(ctx, merkleRanges(r.Ranges), r.Depth, r.Leaves)
+	if res.IsErr() {
+		return nil, status.Error(codes.Internal, res.Error().Error())
+	}
+
+	entries := res.Unwrap()
+
+	resp := &cluster.MerkleLeavesResponse{Entries: make([]cluster.ScanEntry, len(entries))}
+	for i, e := range entries {
+		resp.Entries[i] = cluster.ScanEntry{
+			Kind:     uint8(e.Kind),
+			Key:      e.Key,
+			Value:    e.Value.Value,
+			Level:    uint8(e.Value.Level),
+			ReadOnly: e.Value.ReadOnly,
+		}
+	}
+
+	return resp, nil
+}
+
+func (h *ClusterHandler) RebalanceStatus(ctx context.Context, _ *cluster.RebalanceStatusRequest) (*cluster.RebalanceStatusResponse, error) {
+	st := h.rebalancer.Status()
+
+	resp := &cluster.RebalanceStatusResponse{
+		Running: st.Running,
+		Reason:  st.Reason,
+		Scanned: st.Scanned,
+		Moved:   st.Moved,
+		Failed:  st.Failed,
+		Leaving: st.Leaving,
+		Error:   st.Error,
+	}
+
+	if !st.StartedAt.IsZero() {
+		resp.StartedAt = st.StartedAt.UnixNano()
+	}
+
+	if !st.FinishedAt.IsZero() {
+		resp.FinishedAt = st.FinishedAt.UnixNano()
+	}
+
+	return resp, nil
+}
+
+func (h *ClusterHandler) Drain(ctx context.Context, r *cluster.DrainRequest) (*cluster.DrainResponse, error) {
+	if !h.isAdmin(ctx) {
+		return nil, h.converterr.ToGRPC(constants.ErrForbidden)
+	}
+
+	if err := h.core.Drain(ctx, r.Server); err != nil {
+		return nil, h.converterr.ToGRPC(err)
+	}
+
+	return &cluster.DrainResponse{}, nil
+}
+
+func (h *ClusterHandler) SetMaintenance(ctx context.Context, r *cluster.MaintenanceRequest) (*cluster.MaintenanceResponse, error) {
+	if !h.isAdmin(ctx) {
+		return nil, h.converterr.ToGRPC(constants.ErrForbidden)
+	}
+
+	if err := h.core.SetMaintenance(ctx, r.Server, r.On); err != nil {
+		return nil, h.converterr.ToGRPC(err)
+	}
+
+	return &cluster.MaintenanceResponse{}, nil
+}
+
+// MGet reads a batch of keys, unlike the other methods it is open to every user.
+func (h *ClusterHandler) MGet(ctx context.Context, r *cluster.MGetRequest) (*cluster.MGetResponse, error) {
+	results, err := h.core.MGet(ctx, h.claims(ctx), r.Keys, models.GetOptions{Server: r.Server})
+	if err != nil {
+		return nil, h.converterr.ToGRPC(err)
+	}
+
+	resp := &cluster.MGetResponse{Values: make([]cluster.BatchValue, len(results))}
+	for i, res := range results {
+		resp.Values[i] = cluster.BatchValue{
+			Key:      res.Key,
+			Value:    res.Value.Value,
+			Level:    uint8(res.Value.Level),
+			ReadOnly: res.Value.ReadOnly,
+			Error:    errorMessages(res.Err),
+		}
+	}
+
+	return resp, nil
+}
+
+// MSet writes a batch of keys, unlike the other methods it is open to every user.
+func (h *ClusterHandler) MSet(ctx context.Context, r *cluster.MSetRequest) (*cluster.MSetResponse, error) {
+	opts := models.SetOptions{
+		Server:    r.Server,
+		Level:     models.Level(r.Level),
+		ReadOnly:  r.ReadOnly,
+		Unique:    r.Unique,
+		Placement: getPlacement(ctx),
+	}
+
+	results, err := h.core.MSet(ctx, h.claims(ctx), r.Values, opts)
+	if err != nil {
+		return nil, h.converterr.ToGRPC(err)
+	}
+
+	resp := &cluster.MSetResponse{Values: make([]cluster.BatchValue, len(results))}
+	for i, res := range results {
+		resp.Values[i] = cluster.BatchValue{Key: res.Key, Server: res.Server, Error: errorMessages(res.Err)}
+	}
+
+	return resp, nil
+}
+
+// Transact writes the keys all at once, unlike the other methods it is open to every user.
+func (h *ClusterHandler) Transact(ctx context.Context, r *cluster.TransactRequest) (*cluster.TransactResponse, error) {
+	opts := models.SetOptions{
+		Level:     models.Level(r.Level),
+		ReadOnly:  r.ReadOnly,
+		Unique:    r.Unique,
+		Placement: getPlacement(ctx),
+	}
+
+	id, err := h.core.Transact(ctx, h.claims(ctx), txOps(r.Ops), opts)
+	if err != nil {
+		return nil, h.converterr.ToGRPC(err)
+	}
+
+	return &cluster.TransactResponse{ID: id}, nil
+}
+
+// Prepare prepares the part of a transaction, the node checks the levels of the keys like MSet does it.
+// A refusal is an answer, so it comes in the response with its messages.
+func (h *ClusterHandler) Prepare(ctx context.Context, r *cluster.PrepareRequest) (*cluster.PrepareResponse, error) {
+	tx := models.Transaction{
+		ID:  r.ID,
+		Ops: txOps(r.Ops),
+		Opts: models.SetOptions{
+			Level:    models.Level(r.Level),
+			ReadOnly: r.ReadOnly,
+			Unique:   r.Unique,
+		},
+	}
+
+	if res := h.core.Prepare(ctx, h.claims(ctx), tx); res.IsErr() {
+		return &cluster.PrepareResponse{Error: errorMessages(res.Error())}, nil
+	}
+
+	return &cluster.PrepareResponse{}, nil
+}
+
+func (h *ClusterHandler) CommitPrepared(ctx context.Context, r *cluster.ResolveRequest) (*cluster.ResolveResponse, error) {
+	if !h.isAdmin(ctx) {
+		return nil, h.converterr.ToGRPC(constants.ErrForbidden)
+	}
+
+	if res := h.core.CommitPrepared(ctx, r.ID); res.IsErr() {
+		return nil, h.converterr.ToGRPC(res.Error())
+	}
+
+	return &cluster.ResolveResponse{}, nil
+}
+
+func (h *ClusterHandler) AbortPrepared(ctx context.Context, r *cluster.ResolveRequest) (*cluster.ResolveResponse, error) {
+	if !h.isAdmin(ctx) {
+		return nil, h.converterr.ToGRPC(constants.ErrForbidden)
+	}
+
+	if res := h.core.AbortPrepared(ctx, r.ID); res.IsErr() {
+		return nil, h.converterr.ToGRPC(res.Error())
+	}
+
+	return &cluster.ResolveResponse{}, nil
+}
+
+func (h *ClusterHandler) InDoubt(ctx context.Context, _ *cluster.InDoubtRequest) (*cluster.InDoubtResponse, error) {
+	if !h.isAdmin(ctx) {
+		return nil, h.converterr.ToGRPC(constants.ErrForbidden)
+	}
+
+	res := h.core.InDoubt(ctx)
+	if res.IsErr() {
+		return nil, h.converterr.ToGRPC(res.Error())
+	}
+
+	return &cluster.InDoubtResponse{IDs: res.Unwrap()}, nil
+}
+
+func txOps(ops []cluster.TxOp) []models.TxOp {
+	res := make([]models.TxOp, len(ops))
+	for i, op := range ops {
+		res[i] = models.TxOp{Key: op.Key, Value: op.Value, Delete: op.Delete}
+	}
+
+	return res
+}
+
+// merkleRanges sorts the ranges of the request, the tree looks the hashes up in them.
+func merkleRanges(ranges []cluster.Range) merkle.Ranges {
+	res := make([]merkle.Range, len(ranges))
+	for i, r := range ranges {
+		res[i] = merkle.Range{From: r.From, To: r.To}
+	}
+
+	return merkle.Normalize(res)
+}
+
+// errorMessages keeps the root message of the error apart, so the caller can tell "not found" from the rest.
+func errorMessages(err error) []string {
+	if err == nil {
+		return nil
+	}
+
+	var errX *gost.ErrX
+	if errors.As(err, &errX) {
+		return errX.Messages()
+	}
+
+	return []string{err.Error()}
+}
+
+// NodeStats describes the storage of this node, the balancers use it for ClusterInfo.
+func (h *ClusterHandler) NodeStats(ctx context.Context, _ *cluster.NodeStatsRequest) (*cluster.NodeStatsResponse, error) {
+	r := h.core.NodeStats(ctx)
+	if r.IsErr() {
+		return nil, h.converterr.ToGRPC(r.Error())
+	}
+
+	st := r.Unwrap()
+	return &cluster.NodeStatsResponse{Keys: st.Keys, Objects: st.Objects, Role: st.Role}, nil
+}
+
+// KeyFilter returns the Bloom filter over the keys of the node.
+func (h *ClusterHandler) KeyFilter(ctx context.Context, _ *cluster.KeyFilterRequest) (*cluster.KeyFilterResponse, error) {
+	r := h.core.KeyFilter(ctx)
+	if r.IsErr() {
+		return nil, h.converterr.ToGRPC(r.Error())
+	}
+
+	data, err := r.Unwrap().MarshalBinary()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &cluster.KeyFilterResponse{Filter: data}, nil
+}
+
+// ClusterInfo describes the servers of the balancer, like Servers of the public API does it in text.
+func (h *ClusterHandler) ClusterInfo(ctx context.Context, _ *cluster.ClusterInfoRequest) (*cluster.ClusterInfoResponse, error) {
+	infos, err := h.core.ClusterInfo(ctx)
+	if err != nil {
+		return nil, h.converterr.ToGRPC(err)
+	}
+
+	resp := &cluster.ClusterInfoResponse{Servers: make([]cluster.ServerInfo, len(infos))}
+	for i, info := range infos {
+		resp.Servers[i] = cluster.ServerInfo{
+			Number:       info.Number,
+			Address:      info.Address,
+			Local:        info.Local,
+			State:        info.State,
+			Failures:     info.Failures,
+			RAMTotal:     info.RAM.Total,
+			RAMAvailable: info.RAM.Available,
+			LatencyP50:   int64(info.Latency.P50),
+			LatencyP90:   int64(info.Latency.P90),
+			LatencyP99:   int64(info.Latency.P99),
+			Keys:         info.Keys,
+			Objects:      info.Objects,
+			Role:         info.Role,
+			StatsError:   info.StatsError,
+		}
+
+		if !info.LastSeen.IsZero() {
+			resp.Servers[i].LastSeen = info.LastSeen.UnixNano()
+		}
+	}
+
+	return resp, nil
+}
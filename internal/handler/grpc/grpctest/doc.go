@@ -0,0 +1,2 @@
+// Package grpctest tests the gRPC handler together with the remote servers of a balancer that call it.
+package grpctest
@@ -0,0 +1,210 @@
+package grpctest
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/handler/converterr"
+	grpchandler "itisadb/internal/handler/grpc"
+	"itisadb/internal/models"
+	"itisadb/internal/service/generator"
+	"itisadb/internal/service/logic"
+	"itisadb/internal/service/security"
+	"itisadb/internal/service/servers"
+	"itisadb/internal/service/servers/pool"
+	"itisadb/internal/service/session"
+	"itisadb/internal/storage"
+
+	"github.com/egorgasay/gost"
+	api "github.com/egorgasay/itisadb-shared-proto/go"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// core serves the requests the tests send with the logic of a single node.
+type core struct {
+	domains.Balancer
+	logic   *logic.Logic
+	session domains.Session
+}
+
+func (c core) Authenticate(ctx context.Context, login, password string) (string, error) {
+	return c.session.AuthByPassword(ctx, login, password)
+}
+
+func (c core) Get(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.GetOptions) (models.Value, error) {
+	r := c.logic.GetOne(ctx, claims, key, opts)
+	if r.IsErr() {
+		return models.Value{}, r.Error()
+	}
+
+	return r.Unwrap(), nil
+}
+
+type node struct {
+	address string
+	session domains.Session
+}
+
+// users of the node, the balancer connects as the admin.
+var _users = []models.User{
+	{Login: "admin", Password: "admin", Level: constants.MaxLevel, Active: true},
+	{Login: "default", Password: "default", Level: constants.DefaultLevel, Active: true},
+	{Login: "secret", Password: "secret", Level: constants.SecretLevel, Active: true},
+}
+
+// newNode serves the gRPC handler with mandatory authorization and a key of every level.
+func newNode(t *testing.T) node {
+	t.Helper()
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range _users {
+		if r := store.NewUser(u); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+	}
+
+	securityCFG := config.SecurityConfig{MandatoryAuthorization: true}
+	sec := security.NewSecurityService(securityCFG, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+	ses := session.New(config.Config{}, store, generator.New(zap.NewNop()), zap.NewNop())
+	l := logic.NewLogic(store, config.Config{}, nil, zap.NewNop(), sec)
+
+	admin := gost.Some(models.UserClaims{Level: constants.MaxLevel})
+	for _, level := range []models.Level{constants.DefaultLevel, constants.SecretLevel} {
+		if r := l.SetOne(context.Background(), admin, level.String(), "value", models.SetOptions{Level: level}); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+	}
+
+	h := grpchandler.New(core{logic: l, session: ses}, zap.NewNop(), ses, securityCFG, converterr.New(zap.NewNop()))
+	srv := grpc.NewServer(grpc.UnaryInterceptor(h.AuthMiddleware))
+	api.RegisterItisaDBServer(srv, h)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	return node{address: lis.Addr().String(), session: ses}
+}
+
+// newRemote connects to the node like a balancer does.
+func newRemote(t *testing.T, n node) *servers.RemoteServer {
+	t.Helper()
+
+	conns := pool.WithDefaults(config.ConnectionsConfig{Login: "admin", Password: "admin"})
+
+	rs, err := servers.NewRemoteServer(context.Background(), n.address, 2, config.HealthConfig{}, conns, n.session, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs
+}
+
+func TestForward(t *testing.T) {
+	ctx := context.Background()
+
+	n := newNode(t)
+	rs := newRemote(t, n)
+
+	callers := map[string]gost.Option[models.UserClaims]{
+		"anonymous": gost.None[models.UserClaims](),
+		"default":   gost.Some(models.UserClaims{ID: "default", Level: constants.DefaultLevel}),
+		"secret":    gost.Some(models.UserClaims{ID: "secret", Level: constants.SecretLevel}),
+	}
+
+	// the node checks the levels against the caller the balancer forwards, not against the balancer
+	for name, caller := range callers {
+		level := constants.DefaultLevel
+		if caller.IsSome() {
+			level = caller.Unwrap().Level
+		}
+
+		for _, key := range []models.Level{constants.DefaultLevel, constants.SecretLevel} {
+			r := rs.GetOne(ctx, caller, key.String(), models.GetOptions{})
+
+			if key <= level {
+				if r.IsErr() {
+					t.Errorf("%s reads the %s key: %v", name, key, r.Error())
+				}
+				continue
+			}
+
+			// a denied request fails like it does on the local server,
+			// the codes of the errors are all 0, so the messages tell them apart
+			if r.IsOk() || r.Error().Messages()[0] != constants.ErrForbidden.Message() {
+				t.Errorf("%s reads the %s key: got %+v, want %v", name, key, r, constants.ErrForbidden)
+			}
+		}
+	}
+
+	// the balancer itself reads as the admin it connected as
+	if r := rs.GetOne(ctx, gost.Some(models.UserClaims{Level: constants.MaxLevel}), constants.SecretLevel.String(), models.GetOptions{}); r.IsErr() {
+		t.Errorf("the balancer reads the secret key: %v", r.Error())
+	}
+
+	// a user the node doesn't know can't be forwarded
+	stranger := gost.Some(models.UserClaims{ID: "stranger", Level: constants.SecretLevel})
+	if r := rs.GetOne(ctx, stranger, constants.DefaultLevel.String(), models.GetOptions{}); r.IsOk() {
+		t.Error("a stranger reads the default key")
+	}
+}
+
+func TestForgedCaller(t *testing.T) {
+	ctx := context.Background()
+
+	n := newNode(t)
+
+	cc, err := grpc.NewClient(n.address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+
+	client := api.NewItisaDBClient(cc)
+
+	auth, err := client.Authenticate(ctx, &api.AuthRequest{Login: "default", Password: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the caller is signed correctly, but only an admin may act for another user
+	caller, err := n.session.Forward(ctx, gost.Some(models.UserClaims{ID: "secret", Level: constants.SecretLevel}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := metadata.AppendToOutgoingContext(ctx, "token", auth.Token, constants.CallerKey, caller)
+
+	_, err = client.Get(forged, &api.GetRequest{Key: constants.SecretLevel.String(), Options: &api.GetRequest_Options{}})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("a default user forwards a secret caller: got %v, want %v", err, codes.PermissionDenied)
+	}
+
+	// without the header the user reads with its own level
+	own := metadata.AppendToOutgoingContext(ctx, "token", auth.Token)
+
+	if _, err := client.Get(own, &api.GetRequest{Key: constants.DefaultLevel.String(), Options: &api.GetRequest_Options{}}); err != nil {
+		t.Errorf("the default user reads the default key: %v", err)
+	}
+
+	if _, err := client.Get(own, &api.GetRequest{Key: constants.SecretLevel.String(), Options: &api.GetRequest_Options{}}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("the default user reads the secret key: got %v, want %v", err, codes.PermissionDenied)
+	}
+}
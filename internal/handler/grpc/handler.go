@@ -56,6 +56,7 @@ func (h *Handler) Set(ctx context.Context, r *api.SetRequest) (*api.SetResponse,
 	if err := copier.Copy(&opts, gost.SafeDeref(r.Options)); err != nil {
 		return nil, h.converterr.ToGRPC(err)
 	}
+	opts.Placement = getPlacement(ctx)
 
 	setTo, err := h.core.Set(ctx, claims, r.Key, r.Value, opts)
 	if err != nil {
@@ -197,6 +198,11 @@ func (h *Handler) Object(ctx context.Context, r *api.ObjectRequest) (*api.Object
 	if err != nil {
 		return nil, h.converterr.ToGRPC(err)
 	}
+	opts.Placement = getPlacement(ctx)
+
+	if opts.Shards, err = getShards(ctx); err != nil {
+		return nil, err
+	}
 
 	serv, err := h.core.Object(ctx, claims, r.Name, opts)
 	if err != nil {
@@ -5,16 +5,61 @@ import (
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 	"itisadb/internal/constants"
 )
 
+// _publicMethods can be called without a token.
+var _publicMethods = map[string]bool{
+	"/api.ItisaDB/Authenticate":          true,
+	healthpb.Health_Check_FullMethodName: true,
+	healthpb.Health_Watch_FullMethodName: true,
+}
+
 func (h *Handler) AuthMiddleware(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	h.logger.Info("Request", zap.String("method", info.FullMethod))
 
-	if !h.security.MandatoryAuthorization || info.FullMethod == "/api.ItisaDB/Authenticate" {
+	if !h.security.MandatoryAuthorization || _publicMethods[info.FullMethod] {
 		return handler(ctx, req)
 	}
 
+	ctx, err := h.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := handler(ctx, req)
+	if err != nil {
+		h.logger.Error("Failed to perform request", zap.String("method", info.FullMethod), zap.Error(err))
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (h *Handler) AuthStreamMiddleware(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	h.logger.Info("Stream", zap.String("method", info.FullMethod))
+
+	if !h.security.MandatoryAuthorization || _publicMethods[info.FullMethod] {
+		return handler(srv, ss)
+	}
+
+	ctx, err := h.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	if err := handler(srv, authenticatedStream{ServerStream: ss, ctx: ctx}); err != nil {
+		h.logger.Error("Failed to perform stream", zap.String("method", info.FullMethod), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handler) authenticate(ctx context.Context) (context.Context, error) {
 	token, err := getToken(ctx)
 	if err != nil {
 		return nil, err
@@ -25,13 +70,33 @@ func (h *Handler) AuthMiddleware(ctx context.Context, req interface{}, info *grp
 		return nil, err
 	}
 
-	ctx = context.WithValue(ctx, constants.UserKey, claims)
+	caller, ok := getCaller(ctx)
+	if !ok {
+		return context.WithValue(ctx, constants.UserKey, claims), nil
+	}
 
-	res, err := handler(ctx, req)
+	// a balancer connects as an admin and acts for its caller, the levels are checked against the caller
+	if claims.Level < constants.MaxLevel {
+		return nil, status.Error(codes.PermissionDenied, "only an admin can forward a caller")
+	}
+
+	forwarded, err := h.session.AuthByForwarded(ctx, caller)
 	if err != nil {
-		h.logger.Error("Failed to perform request", zap.String("method", info.FullMethod), zap.Error(err))
 		return nil, err
 	}
 
-	return res, nil
+	if forwarded.IsNone() {
+		return context.WithValue(ctx, constants.UserKey, nil), nil
+	}
+
+	return context.WithValue(ctx, constants.UserKey, forwarded.Unwrap()), nil
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authenticatedStream) Context() context.Context {
+	return s.ctx
 }
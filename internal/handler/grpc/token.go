@@ -5,6 +5,8 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"itisadb/internal/constants"
+	"strconv"
 )
 
 func getToken(ctx context.Context) (token string, err error) {
@@ -20,3 +22,55 @@ func getToken(ctx context.Context) (token string, err error) {
 
 	return values[0], nil
 }
+
+// getPlacement returns the placement hint of the request, e.g. "zone=b".
+// The shared proto has no field for it, so it comes in the metadata.
+func getPlacement(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get("placement")
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// getShards returns the number of shards requested for a new object, see models.ObjectOptions.
+// Like the placement hint, it comes in the metadata.
+func getShards(ctx context.Context) (int32, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, nil
+	}
+
+	values := md.Get("shards")
+	if len(values) == 0 {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(values[0], 10, 32)
+	if err != nil || n < 0 {
+		return 0, status.Error(codes.InvalidArgument, "invalid number of shards")
+	}
+
+	return int32(n), nil
+}
+
+// getCaller returns the caller a balancer forwarded with the request, see constants.CallerKey.
+func getCaller(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	values := md.Get(constants.CallerKey)
+	if len(values) == 0 {
+		return "", false
+	}
+
+	return values[0], true
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// BackupManifest describes the content of a backup archive.
+type BackupManifest struct {
+	FormatVersion  int       `json:"format_version"`
+	CreatedAt      time.Time `json:"created_at"`
+	Seq            uint64    `json:"seq"`
+	KeyFingerprint string    `json:"key_fingerprint"`
+	Events         uint64    `json:"events"`
+	Values         uint64    `json:"values"`
+	Objects        uint64    `json:"objects"`
+	Users          uint64    `json:"users"`
+}
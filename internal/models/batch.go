@@ -0,0 +1,15 @@
+package models
+
+// GetResult is the value of one key of a batch read, or the error that kept it from being read.
+type GetResult struct {
+	Key   string
+	Value Value
+	Err   error
+}
+
+// SetResult is the server that took one key of a batch write, or the error that kept it from being written.
+type SetResult struct {
+	Key    string
+	Server int32
+	Err    error
+}
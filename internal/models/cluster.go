@@ -0,0 +1,69 @@
+package models
+
+import "time"
+
+// Server states in ServerInfo.
+const (
+	ServerOnline      = "online"
+	ServerOffline     = "offline"
+	ServerDraining    = "draining"
+	ServerMaintenance = "maintenance"
+)
+
+// Member states in Member.
+const (
+	MemberAlive   = "alive"
+	MemberSuspect = "suspect"
+	MemberDead    = "dead"
+	MemberLeft    = "left"
+)
+
+// Member roles in Member.
+const (
+	MemberServer   = "server"
+	MemberReplica  = "replica"
+	MemberBalancer = "balancer"
+)
+
+// Member is a node of the cluster as the membership protocol sees it.
+type Member struct {
+	Address string
+	// Role is one of MemberServer, MemberReplica and MemberBalancer.
+	Role string
+	// State is one of MemberAlive, MemberSuspect, MemberDead and MemberLeft.
+	State string
+	// Incarnation grows when the node refutes a suspicion, the news of a greater one win.
+	Incarnation uint64
+}
+
+// NodeStats is what a node reports about its own storage.
+type NodeStats struct {
+	Keys    uint64
+	Objects uint64
+	// Role is the replication role of the node, "primary" or "replica".
+	Role string
+}
+
+// Latency holds the percentiles of the durations of the recent requests to a server.
+type Latency struct {
+	P50, P90, P99 time.Duration
+}
+
+// ServerInfo describes a server of the cluster as the balancer sees it.
+type ServerInfo struct {
+	Number  int32
+	Address string
+	Local   bool
+	// State is one of ServerOnline, ServerOffline, ServerDraining and ServerMaintenance.
+	State string
+	// Failures is the number of failed requests in a row.
+	Failures int
+	// LastSeen is when the server answered last, zero if it never did.
+	LastSeen time.Time
+	RAM      RAM
+	Latency  Latency
+
+	NodeStats
+	// StatsError tells why Keys, Objects and Role are unknown.
+	StatsError string
+}
@@ -18,6 +18,9 @@ type SetOptions struct {
 	Unique   bool
 	Level    Level
 	Encrypt  bool
+	// Placement restricts the automatic choice of a server to the servers with the labels, e.g. "zone=b".
+	// The replicated keys are placed by the ring and ignore it.
+	Placement string
 }
 
 func (o SetOptions) ToSDK() itisadb.SetOptions {
@@ -74,6 +77,10 @@ func (l Level) String() string {
 type ObjectOptions struct {
 	Server int32
 	Level  Level
+	// Placement restricts the automatic choice of a server to the servers with the labels, e.g. "zone=b".
+	Placement string
+	// Shards spreads the attributes of a top-level object over this many servers by their keys.
+	Shards int32
 }
 
 func (o ObjectOptions) ToSDK() itisadb.ObjectOptions {
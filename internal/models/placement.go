@@ -0,0 +1,16 @@
+package models
+
+// PlacementCandidate is an online server that may take new data.
+type PlacementCandidate struct {
+	Number int32
+	RAM    RAM
+	Weight int
+	Labels map[string]string
+}
+
+// Segment is a range of the key hashes on the ring, From and To included,
+// and the online servers that keep its keys, the owner first.
+type Segment struct {
+	From, To uint64
+	Servers  []int32
+}
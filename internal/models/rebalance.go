@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+type EntryKind uint8
+
+const (
+	ValueEntry EntryKind = iota + 1
+	ObjectEntry
+	ObjectValueEntry
+)
+
+// Entry is a part of the data of a server, as it is streamed by Scan.
+// ObjectEntry fills Object and Value.Level, ObjectValueEntry fills Object, Key and Value,
+// ValueEntry fills Key and Value.
+type Entry struct {
+	Kind   EntryKind
+	Object string
+	Key    string
+	Value  Value
+}
+
+type RebalanceStatus struct {
+	Running    bool
+	Reason     string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Scanned    uint64
+	Moved      uint64
+	Failed     uint64
+	Leaving    []int32
+	Error      string
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// LogEvent is a transaction-log event together with its position in the log.
+type LogEvent struct {
+	Seq      uint64
+	Time     time.Time
+	Type     uint8
+	Name     string
+	Value    string
+	Metadata string
+}
+
+type ReplicationStatus struct {
+	Role      string
+	Primary   string
+	Connected bool
+	Applied   uint64
+	Head      uint64
+	Replicas  int
+	LastEvent time.Time
+}
+
+// LagEvents returns how many events the replica is behind the primary.
+func (s ReplicationStatus) LagEvents() uint64 {
+	if s.Head <= s.Applied {
+		return 0
+	}
+
+	return s.Head - s.Applied
+}
+
+// LagTime returns how long ago the last applied event was written on the primary.
+func (s ReplicationStatus) LagTime() time.Duration {
+	if s.LastEvent.IsZero() || s.LagEvents() == 0 {
+		return 0
+	}
+
+	return time.Since(s.LastEvent)
+}
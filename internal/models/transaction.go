@@ -0,0 +1,26 @@
+package models
+
+// TxOp is one write of a transaction, it sets the key to the value or deletes it.
+type TxOp struct {
+	Key    string
+	Value  string
+	Delete bool
+}
+
+// Transaction is the part of a multi-key transaction that one server applies.
+// The options apply to every value it sets.
+type Transaction struct {
+	ID   string
+	Ops  []TxOp
+	Opts SetOptions
+}
+
+// Keys returns the keys the transaction writes.
+func (tx Transaction) Keys() []string {
+	keys := make([]string, len(tx.Ops))
+	for i, op := range tx.Ops {
+		keys[i] = op.Key
+	}
+
+	return keys
+}
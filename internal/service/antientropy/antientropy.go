@@ -0,0 +1,335 @@
+// Package antientropy brings the replicas of the keys back in line in the background.
+//
+// Read repair fixes only the keys that are read, so a replica that was offline while
+// the keys were written keeps missing the rest of them. A round walks the segments
+// of the hash ring: the owner of a segment and each of its other replicas digest the keys
+// of the segments they share into Merkle trees, only the keys under the leaves where
+// the trees differ are read, and the newest version of each is copied to the replica
+// that lags behind.
+package antientropy
+
+import (
+	"context"
+	"expvar"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/quorum"
+	"itisadb/pkg/merkle"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+// DefaultInterval is the time between the rounds when the config does not set it.
+const DefaultInterval = 10 * time.Minute
+
+// _batch is the number of the leaves whose values are read with one request.
+const _batch = 64
+
+var (
+	_stats = expvar.NewMap("anti_entropy")
+	// _divergent is the number of the keys the replicas disagreed on in the last round.
+	_divergent = new(expvar.Int)
+)
+
+func init() {
+	_stats.Set("divergent_keys", _divergent)
+}
+
+// _admin is used for the requests of the job, it repairs the keys of any level.
+var _admin = gost.Some(models.UserClaims{Level: constants.MaxLevel})
+
+type AntiEntropy struct {
+	servers  domains.Servers
+	logger   *zap.Logger
+	replicas int
+	depth    int
+	interval time.Duration
+	on       bool
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	started atomic.Bool
+}
+
+// Stats sums up a round.
+type Stats struct {
+	// Pairs is the number of the pairs of the replicas compared.
+	Pairs int
+	// Divergent is the number of the keys the replicas disagreed on.
+	Divergent int
+	Repaired  int
+	Failed    int
+}
+
+// New returns the job, it is on with the hash placement and ReplicationFactor above 1.
+func New(cfg config.BalancerConfig, servers domains.Servers, logger *zap.Logger) *AntiEntropy {
+	interval := cfg.AntiEntropy.Interval
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+
+	depth := cfg.AntiEntropy.Depth
+	if depth <= 0 {
+		depth = merkle.DefaultDepth
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &AntiEntropy{
+		servers:  servers,
+		logger:   logger,
+		replicas: cfg.ReplicationFactor,
+		depth:    min(depth, merkle.MaxDepth),
+		interval: interval,
+		on:       interval > 0 && cfg.Placement == config.HashPlacement && cfg.ReplicationFactor > 1,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start runs a round every interval until Close.
+func (a *AntiEntropy) Start() {
+	if !a.on {
+		return
+	}
+
+	a.started.Store(true)
+
+	go func() {
+		defer close(a.done)
+
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-a.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			a.Round(a.ctx)
+		}
+	}()
+}
+
+// Close stops the job, a running round is cut short.
+func (a *AntiEntropy) Close() {
+	a.cancel()
+	if a.started.Load() {
+		<-a.done
+	}
+}
+
+// pair is the owner of the segments and another replica of them.
+type pair struct {
+	owner, replica int32
+	ranges         merkle.Ranges
+}
+
+// Round compares every pair of the online servers that keep the same segments once.
+func (a *AntiEntropy) Round(ctx context.Context) (st Stats) {
+	for _, p := range a.pairs() {
+		if ctx.Err() != nil {
+			break
+		}
+
+		owner, ok := a.servers.GetServer(p.owner)
+		if !ok || owner.IsOffline() {
+			continue
+		}
+
+		replica, ok := a.servers.GetServer(p.replica)
+		if !ok || replica.IsOffline() {
+			continue
+		}
+
+		st.Pairs++
+		a.compare(ctx, owner, replica, p.ranges, &st)
+	}
+
+	_stats.Add("rounds", 1)
+	_stats.Add("repaired", int64(st.Repaired))
+	_stats.Add("failed", int64(st.Failed))
+	_divergent.Set(int64(st.Divergent))
+
+	if st.Divergent > 0 || st.Failed > 0 {
+		a.logger.Info("replicas reconciled",
+			zap.Int("pairs", st.Pairs),
+			zap.Int("divergent", st.Divergent),
+			zap.Int("repaired", st.Repaired),
+			zap.Int("failed", st.Failed),
+		)
+	}
+
+	return st
+}
+
+func (a *AntiEntropy) pairs() []pair {
+	shared := make(map[[2]int32][]merkle.Range)
+	for _, seg := range a.servers.Segments(a.replicas) {
+		if len(seg.Servers) < 2 {
+			continue
+		}
+
+		for _, replica := range seg.Servers[1:] {
+			key := [2]int32{seg.Servers[0], replica}
+			shared[key] = append(shared[key], merkle.Range{From: seg.From, To: seg.To})
+		}
+	}
+
+	pairs := make([]pair, 0, len(shared))
+	for key, ranges := range shared {
+		pairs = append(pairs, pair{owner: key[0], replica: key[1], ranges: merkle.Normalize(ranges)})
+	}
+
+	slices.SortFunc(pairs, func(x, y pair) int {
+		if x.owner != y.owner {
+			return int(x.owner - y.owner)
+		}
+		return int(x.replica - y.replica)
+	})
+
+	return pairs
+}
+
+// compare reads the keys under the leaves where the trees of x and y differ and repairs them.
+func (a *AntiEntropy) compare(ctx context.Context, x, y domains.Server, ranges merkle.Ranges, st *Stats) {
+	rX := x.MerkleTree(ctx, ranges, a.depth)
+	if rX.IsErr() {
+		a.fail("can't build merkle tree", x, "", rX.Error(), st)
+		return
+	}
+
+	rY := y.MerkleTree(ctx, ranges, a.depth)
+	if rY.IsErr() {
+		a.fail("can't build merkle tree", y, "", rY.Error(), st)
+		return
+	}
+
+	leaves, err := rX.Unwrap().Diff(rY.Unwrap())
+	if err != nil {
+		a.fail("can't compare merkle trees", y, "", err, st)
+		return
+	}
+
+	for len(leaves) > 0 {
+		batch := leaves[:min(len(leaves), _batch)]
+		leaves = leaves[len(batch):]
+
+		rEntriesX := x.MerkleLeaves(ctx, ranges, a.depth, batch)
+		if rEntriesX.IsErr() {
+			a.fail("can't read merkle leaves", x, "", rEntriesX.Error(), st)
+			return
+		}
+
+		rEntriesY := y.MerkleLeaves(ctx, ranges, a.depth, batch)
+		if rEntriesY.IsErr() {
+			a.fail("can't read merkle leaves", y, "", rEntriesY.Error(), st)
+			return
+		}
+
+		a.reconcile(ctx, x, y, rEntriesX.Unwrap(), rEntriesY.Unwrap(), st)
+	}
+}
+
+func (a *AntiEntropy) reconcile(ctx context.Context, x, y domains.Server, entriesX, entriesY []models.Entry, st *Stats) {
+	valuesX, valuesY := values(entriesX), values(entriesY)
+
+	keys := make([]string, 0, len(valuesX)+len(valuesY))
+	for key := range valuesX {
+		keys = append(keys, key)
+	}
+	for key := range valuesY {
+		if _, ok := valuesX[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		valX, inX := valuesX[key]
+		valY, inY := valuesY[key]
+
+		if inX && inY && valX.Value == valY.Value {
+			continue
+		}
+
+		st.Divergent++
+
+		target, val := y, valX
+		if !inX || (inY && newer(valY, valX)) {
+			target, val = x, valY
+		}
+
+		rRepair := a.repair(ctx, target, key, val)
+		switch {
+		case rRepair.IsErr():
+			a.fail("can't repair replica", target, key, rRepair.Error(), st)
+		case rRepair.Unwrap():
+			st.Repaired++
+			a.logger.Debug("replica repaired", zap.Int32("server", target.Number()), zap.String("key", key))
+		}
+	}
+}
+
+// repair writes val to the target, it reports false when the target got the same or a newer value in the meantime.
+func (a *AntiEntropy) repair(ctx context.Context, target domains.Server, key string, val models.Value) (res gost.Result[bool]) {
+	// the values were read a while ago, a write since then is not overwritten
+	rGet := target.GetOne(ctx, _admin, key, models.GetOptions{Server: target.Number()})
+	if rGet.IsErr() && !quorum.IsNotFound(rGet.Error()) {
+		return res.Err(rGet.Error())
+	}
+
+	if rGet.IsOk() {
+		if cur := rGet.Unwrap(); cur.Value == val.Value || newer(cur, val) {
+			return res.Ok(false)
+		}
+	}
+
+	opts := models.SetOptions{Server: target.Number(), ReadOnly: val.ReadOnly, Level: val.Level}
+	if quorum.Decode(val).Tombstone {
+		opts = models.SetOptions{Server: target.Number()}
+	}
+
+	if rSet := target.SetOne(ctx, _admin, key, val.Value, opts); rSet.IsErr() {
+		return res.Err(rSet.Error())
+	}
+
+	return res.Ok(true)
+}
+
+func (a *AntiEntropy) fail(msg string, server domains.Server, key string, err error, st *Stats) {
+	st.Failed++
+	a.logger.Warn(msg, zap.Int32("server", server.Number()), zap.String("key", key), zap.Error(err))
+}
+
+// newer reports whether x is a later version than y. The values of the same version differ
+// only when they were written without replication, the larger one wins on every balancer then,
+// so the replicas still end up with the same value.
+func newer(x, y models.Value) bool {
+	vx, vy := quorum.Decode(x), quorum.Decode(y)
+	if vx.Version != vy.Version {
+		return vx.Version > vy.Version
+	}
+
+	return x.Value > y.Value
+}
+
+func values(entries []models.Entry) map[string]models.Value {
+	m := make(map[string]models.Value, len(entries))
+	for _, e := range entries {
+		m[e.Key] = e.Value
+	}
+
+	return m
+}
@@ -0,0 +1,117 @@
+package antientropy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"itisadb/config"
+	"itisadb/internal/clustertest"
+	"itisadb/internal/models"
+	"itisadb/internal/service/quorum"
+
+	"go.uber.org/zap"
+)
+
+// server returns the server of the cluster by its number.
+func server(c *clustertest.Cluster, number int32) *clustertest.Server {
+	s, _ := c.GetServer(number)
+	return s.(*clustertest.Server)
+}
+
+// write puts the value on every replica of the key, like the quorum coordinator does.
+func write(c *clustertest.Cluster, key string, version uint64, value string) {
+	for _, s := range c.KeyReplicas(key, 2) {
+		s.(*clustertest.Server).Put(key, models.Value{Value: quorum.Encode(version, false, value)})
+	}
+}
+
+func TestRound(t *testing.T) {
+	s1, s2, s3 := clustertest.NewServer(1), clustertest.NewServer(2), clustertest.NewServer(3)
+	c := clustertest.NewHashCluster(s1, s2, s3)
+
+	for i := 0; i < 300; i++ {
+		write(c, fmt.Sprintf("key%d", i), 1, "old")
+	}
+
+	ae := New(config.BalancerConfig{Placement: config.HashPlacement, ReplicationFactor: 2}, c, zap.NewNop())
+
+	if st := ae.Round(context.Background()); st.Divergent != 0 || st.Failed != 0 {
+		t.Fatalf("round over the same replicas: %+v", st)
+	}
+
+	// s2 was offline while these were written, the other replicas got them
+	missed := map[string]string{}
+	for i := 0; i < 300; i += 10 {
+		key := fmt.Sprintf("key%d", i)
+		owners := c.Owners(key, 2)
+		if !slices.Contains(owners, 2) {
+			continue
+		}
+
+		for _, number := range owners {
+			if number != 2 {
+				server(c, number).Put(key, models.Value{Value: quorum.Encode(2, false, "new")})
+			}
+		}
+		missed[key] = quorum.Encode(2, false, "new")
+	}
+
+	deleted := ""
+	for _, key := range s2.Keys() {
+		if _, ok := missed[key]; !ok {
+			deleted = key
+			break
+		}
+	}
+	// s2 missed the delete too, the tombstone wins over its older value
+	for _, number := range c.Owners(deleted, 2) {
+		if number != 2 {
+			server(c, number).Put(deleted, models.Value{Value: quorum.Encode(3, true, "")})
+		}
+	}
+	missed[deleted] = quorum.Encode(3, true, "")
+
+	// s2 missed a new key entirely
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("fresh%d", i)
+		if slices.Contains(c.Owners(key, 2), 2) {
+			write(c, key, 4, "fresh")
+			s2.Remove(key)
+			missed[key] = quorum.Encode(4, false, "fresh")
+			break
+		}
+	}
+
+	st := ae.Round(context.Background())
+	if st.Divergent != len(missed) || st.Repaired != len(missed) || st.Failed != 0 {
+		t.Fatalf("round: %+v, want %d keys repaired", st, len(missed))
+	}
+
+	for key, want := range missed {
+		if v, ok := s2.Value(key); !ok || v.Value != want {
+			t.Errorf("%s on s2 is %q, want %q", key, v.Value, want)
+		}
+	}
+
+	if st := ae.Round(context.Background()); st.Divergent != 0 {
+		t.Errorf("replicas still differ after the repair: %+v", st)
+	}
+}
+
+func TestRepairKeepsNewer(t *testing.T) {
+	s := clustertest.NewServer(1)
+	s.Put("key", models.Value{Value: quorum.Encode(5, false, "newer")})
+
+	ae := New(config.BalancerConfig{}, clustertest.NewHashCluster(s), zap.NewNop())
+
+	r := ae.repair(context.Background(), s, "key", models.Value{Value: quorum.Encode(4, false, "older")})
+	if r.IsErr() || r.Unwrap() {
+		t.Fatalf("older value was written over a newer one: %v", r.Error())
+	}
+
+	if v, _ := s.Value("key"); v.Value != quorum.Encode(5, false, "newer") {
+		t.Errorf("value is %q", v.Value)
+	}
+}
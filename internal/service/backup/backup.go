@@ -0,0 +1,318 @@
+// Package backup makes portable archives of a node's data and restores them.
+//
+// An archive is a gzipped tar file with two entries: manifest.json with
+// models.BackupManifest and snapshot.log with the state of the storage in the
+// transaction log format. Values of the Secret level stay encrypted there,
+// so the archive can only be restored with the same encryption key.
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	transactionlogger "itisadb/internal/service/transaction-logger"
+	"itisadb/internal/storage"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+// FormatVersion is the version of the archives written by this package.
+const FormatVersion = 1
+
+const (
+	_manifestName = "manifest.json"
+	_snapshotName = "snapshot.log"
+)
+
+var (
+	ErrFormatVersion = errors.New("unsupported backup format version")
+	ErrKeyMismatch   = errors.New("backup was made with another encryption key")
+	ErrCorrupted     = errors.New("corrupted backup archive")
+)
+
+// Fingerprint identifies the encryption key without revealing it.
+func Fingerprint(key string) string {
+	sum := sha256.Sum256([]byte("itisadb:" + key))
+	return hex.EncodeToString(sum[:8])
+}
+
+type Service struct {
+	storage  domains.Storage
+	source   gost.Option[domains.ReplicationSource]
+	security domains.SecurityService
+	key      string
+	logger   *zap.Logger
+
+	// mu serialises the backups made from the log, they share the checkpoint.
+	mu         sync.Mutex
+	checkpoint gost.Option[checkpoint]
+}
+
+// checkpoint is the snapshot of the last backup made from the log,
+// the next backup loads it and replays only the events after it.
+type checkpoint struct {
+	path   string
+	seq    uint64
+	events uint64
+}
+
+func New(
+	storage domains.Storage,
+	source gost.Option[domains.ReplicationSource],
+	security domains.SecurityService,
+	encryption config.EncryptionConfig,
+	logger *zap.Logger,
+) *Service {
+	return &Service{storage: storage, source: source, security: security, key: encryption.Key, logger: logger}
+}
+
+// Backup writes an archive with the current state of the node to w.
+//
+// When the transaction logger is on, the log is replayed into a separate storage up
+// to its current head, so the archive is consistent and the writers are never blocked.
+// The snapshot of the previous backup is the starting point, so only the events since
+// then are replayed. Otherwise the live storage is read part by part.
+func (s *Service) Backup(ctx context.Context, w io.Writer) (m models.BackupManifest, err error) {
+	m = models.BackupManifest{
+		FormatVersion:  FormatVersion,
+		CreatedAt:      time.Now().UTC(),
+		KeyFingerprint: Fingerprint(s.key),
+	}
+
+	var snap domains.Storage = s.storage
+	if s.source.IsSome() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		snap, m.Seq, err = s.replay(ctx, s.source.Unwrap())
+		if err != nil {
+			return m, fmt.Errorf("can't replay transaction log: %w", err)
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "itisadb-backup-*")
+	if err != nil {
+		return m, err
+	}
+	keep := false
+	defer func() {
+		tmp.Close()
+		if !keep {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	enc := &encoder{w: bufio.NewWriter(tmp), security: s.security, manifest: &m}
+	if err = snap.Snapshot(enc); err != nil {
+		return m, fmt.Errorf("can't make snapshot: %w", err)
+	}
+
+	if err = enc.w.Flush(); err != nil {
+		return m, err
+	}
+
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return m, err
+	}
+
+	if err = writeArchive(w, m, tmp, enc.size); err != nil {
+		return m, err
+	}
+
+	if s.source.IsSome() {
+		s.replaceCheckpoint(checkpoint{path: tmp.Name(), seq: m.Seq, events: m.Events})
+		keep = true
+	}
+
+	s.logger.Info("backup completed",
+		zap.Uint64("seq", m.Seq),
+		zap.Uint64("values", m.Values),
+		zap.Uint64("objects", m.Objects),
+		zap.Uint64("users", m.Users),
+	)
+
+	return m, nil
+}
+
+var errReplayed = errors.New("replayed")
+
+// replay applies the transaction log up to its current head to a new storage,
+// starting from the checkpoint when there is one.
+func (s *Service) replay(ctx context.Context, src domains.ReplicationSource) (*storage.Storage, uint64, error) {
+	scratch, err := storage.New()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	head := src.Head()
+
+	var from uint64
+	if s.checkpoint.IsSome() {
+		cp := s.checkpoint.Unwrap()
+
+		switch err := s.load(cp, scratch); {
+		case cp.seq > head:
+			// can't happen with the same log, it is only appended to
+			s.logger.Warn("backup checkpoint is ahead of the transaction log", zap.Uint64("seq", cp.seq), zap.Uint64("head", head))
+		case err != nil:
+			s.logger.Warn("can't load backup checkpoint, replaying the whole log", zap.Error(err))
+		default:
+			from = cp.seq
+		}
+
+		if from == 0 {
+			if scratch, err = storage.New(); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	if head == from {
+		return scratch, head, nil
+	}
+
+	err = src.Stream(ctx, from, func(e models.LogEvent) error {
+		if err := transactionlogger.ApplyEvent(scratch, s.security, transactionlogger.Event{
+			EventType: transactionlogger.EventType(e.Type),
+			Name:      e.Name,
+			Value:     e.Value,
+			Metadata:  e.Metadata,
+		}); err != nil {
+			return fmt.Errorf("event %d: %w", e.Seq, err)
+		}
+
+		if e.Seq >= head {
+			return errReplayed
+		}
+
+		return nil
+	})
+	if !errors.Is(err, errReplayed) {
+		return nil, 0, err
+	}
+
+	return scratch, head, nil
+}
+
+// load applies the snapshot of the checkpoint to r.
+func (s *Service) load(cp checkpoint, r domains.Restorer) error {
+	f, err := os.Open(cp.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return readSnapshot(f, models.BackupManifest{Events: cp.events}, func(e transactionlogger.Event) error {
+		return transactionlogger.ApplyEvent(r, s.security, e)
+	})
+}
+
+func (s *Service) replaceCheckpoint(cp checkpoint) {
+	if s.checkpoint.IsSome() {
+		os.Remove(s.checkpoint.Unwrap().path)
+	}
+
+	s.checkpoint = gost.Some(cp)
+}
+
+// Close removes the checkpoint.
+func (s *Service) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.checkpoint.IsSome() {
+		os.Remove(s.checkpoint.Unwrap().path)
+		s.checkpoint = gost.None[checkpoint]()
+	}
+}
+
+func writeArchive(w io.Writer, m models.BackupManifest, snapshot io.Reader, size int64) error {
+	manifest, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	if err := tw.WriteHeader(&tar.Header{Name: _manifestName, Mode: 0644, Size: int64(len(manifest)), ModTime: m.CreatedAt}); err != nil {
+		return err
+	}
+
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: _snapshotName, Mode: 0644, Size: size, ModTime: m.CreatedAt}); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(tw, snapshot); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// encoder writes the snapshot of a storage as transaction log events.
+type encoder struct {
+	w        *bufio.Writer
+	security domains.SecurityService
+	manifest *models.BackupManifest
+	size     int64
+}
+
+func (e *encoder) write(ev transactionlogger.Event) error {
+	n, err := e.w.WriteString(transactionlogger.EncodeEvent(ev))
+	e.size += int64(n)
+	e.manifest.Events++
+	return err
+}
+
+func (e *encoder) Value(key string, v models.Value) error {
+	ev, err := transactionlogger.SetEvent(e.security, key, v.Value, models.SetOptions{
+		ReadOnly: v.ReadOnly,
+		Level:    v.Level,
+		Encrypt:  v.Level == constants.SecretLevel,
+	})
+	if err != nil {
+		return fmt.Errorf("can't encrypt %s: %w", key, err)
+	}
+
+	e.manifest.Values++
+	return e.write(ev)
+}
+
+func (e *encoder) Object(name string, info models.ObjectInfo) error {
+	e.manifest.Objects++
+	return e.write(transactionlogger.CreateObjectEvent(name, info))
+}
+
+func (e *encoder) ObjectValue(object string, key string, v models.OValue) error {
+	return e.write(transactionlogger.SetToObjectEvent(object, key, v.Value, models.SetToObjectOptions{ReadOnly: v.ReadOnly}))
+}
+
+func (e *encoder) User(user models.User) error {
+	e.manifest.Users++
+	return e.write(transactionlogger.UserEvent(transactionlogger.CreateUser, user))
+}
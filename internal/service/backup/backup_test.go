@@ -0,0 +1,246 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/security"
+	transactionlogger "itisadb/internal/service/transaction-logger"
+	"itisadb/internal/storage"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+const _key = "PLEASE CHANGE ME"
+
+func newStorage(t *testing.T) *storage.Storage {
+	t.Helper()
+
+	s, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestBackupRestore(t *testing.T) {
+	sec := security.NewSecurityService(config.SecurityConfig{}, config.EncryptionConfig{Key: _key})
+
+	tl, err := transactionlogger.New(config.TransactionLoggerConfig{BackupDirectory: t.TempDir()}, zap.NewNop(), sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.Run()
+	defer tl.Stop()
+
+	user := models.User{Login: "alice", Password: "pass", Level: constants.RestrictedLevel, Active: true}
+	user.SetChangeID(7)
+
+	tl.WriteSet("plain", "value", models.SetOptions{ReadOnly: true})
+	tl.WriteSet("secret", "password", models.SetOptions{Level: constants.SecretLevel, Encrypt: true})
+	tl.WriteSet("deleted", "value", models.SetOptions{})
+	tl.WriteDelete("deleted")
+	tl.WriteCreateObject("obj", models.ObjectInfo{Server: 1, Level: constants.RestrictedLevel})
+	tl.WriteCreateObject("obj.inner", models.ObjectInfo{Server: 1, Level: constants.RestrictedLevel})
+	tl.WriteSetToObject("obj", "key", "v1", models.SetToObjectOptions{})
+	tl.WriteSetToObject("obj.inner", "key", "v2", models.SetToObjectOptions{ReadOnly: true})
+	tl.WriteSetToObject("obj.inner", "deleted", "v3", models.SetToObjectOptions{})
+	tl.WriteDeleteAttr("obj.inner", "deleted")
+	tl.WriteNewUser(user)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tl.Head() != 11 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the log")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var source gost.Option[domains.ReplicationSource]
+	svc := New(newStorage(t), source.Some(tl), sec, config.EncryptionConfig{Key: _key}, zap.NewNop())
+	defer svc.Close()
+
+	var archive bytes.Buffer
+	m, err := svc.Backup(context.Background(), &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Seq != 11 || m.Values != 2 || m.Objects != 2 || m.Users != 1 {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+
+	t.Run("transaction logger", func(t *testing.T) {
+		cfg := config.Config{
+			Encryption:        config.EncryptionConfig{Key: _key},
+			TransactionLogger: config.TransactionLoggerConfig{On: true, BackupDirectory: t.TempDir()},
+		}
+
+		if _, err := Restore(bytes.NewReader(archive.Bytes()), cfg, nil, sec); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Restore(bytes.NewReader(archive.Bytes()), cfg, nil, sec); !errors.Is(err, transactionlogger.ErrNotEmpty) {
+			t.Fatalf("restore into a used log directory: got %v, want %v", err, transactionlogger.ErrNotEmpty)
+		}
+
+		restoredTL, err := transactionlogger.New(cfg.TransactionLogger, zap.NewNop(), sec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restored := newStorage(t)
+		if err := restoredTL.Restore(restored); err != nil {
+			t.Fatal(err)
+		}
+
+		checkRestored(t, restored)
+	})
+
+	t.Run("storage", func(t *testing.T) {
+		cfg := config.Config{Encryption: config.EncryptionConfig{Key: _key}}
+
+		restored := newStorage(t)
+		if _, err := Restore(bytes.NewReader(archive.Bytes()), cfg, restored, sec); err != nil {
+			t.Fatal(err)
+		}
+
+		checkRestored(t, restored)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		cfg := config.Config{Encryption: config.EncryptionConfig{Key: "ANOTHER KEY 1234"}}
+
+		if _, err := Restore(bytes.NewReader(archive.Bytes()), cfg, newStorage(t), sec); !errors.Is(err, ErrKeyMismatch) {
+			t.Fatalf("got %v, want %v", err, ErrKeyMismatch)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		cfg := config.Config{Encryption: config.EncryptionConfig{Key: _key}}
+
+		truncated := archive.Bytes()[:archive.Len()/2]
+		if _, err := Restore(bytes.NewReader(truncated), cfg, newStorage(t), sec); err == nil {
+			t.Fatal("truncated archive was restored")
+		}
+	})
+
+	t.Run("from checkpoint", func(t *testing.T) {
+		tl.WriteSet("later", "value", models.SetOptions{})
+		tl.WriteDelete("plain")
+		tl.WriteSet("plain", "value", models.SetOptions{ReadOnly: true})
+
+		deadline := time.Now().Add(5 * time.Second)
+		for tl.Head() != 14 {
+			if time.Now().After(deadline) {
+				t.Fatal("timeout waiting for the log")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		var next bytes.Buffer
+		m, err := svc.Backup(context.Background(), &next)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Seq != 14 || m.Values != 3 {
+			t.Fatalf("unexpected manifest: %+v", m)
+		}
+
+		restored := newStorage(t)
+		cfg := config.Config{Encryption: config.EncryptionConfig{Key: _key}}
+		if _, err := Restore(&next, cfg, restored, sec); err != nil {
+			t.Fatal(err)
+		}
+
+		checkRestored(t, restored)
+		if v := restored.Get("later"); v.IsNone() || v.Unwrap().Value != "value" {
+			t.Errorf("later: got %+v", v)
+		}
+	})
+}
+
+func TestBackupWithoutTransactionLogger(t *testing.T) {
+	sec := security.NewSecurityService(config.SecurityConfig{}, config.EncryptionConfig{Key: _key})
+
+	live := newStorage(t)
+	live.Set("key", "value", models.SetOptions{Level: constants.SecretLevel})
+	live.CreateObject("obj", models.ObjectOptions{})
+	live.SetToObject("obj", "key", "value", models.SetToObjectOptions{})
+
+	svc := New(live, gost.None[domains.ReplicationSource](), sec, config.EncryptionConfig{Key: _key}, zap.NewNop())
+
+	var archive bytes.Buffer
+	m, err := svc.Backup(context.Background(), &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Seq != 0 || m.Values != 1 || m.Objects != 1 {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+
+	restored := newStorage(t)
+	if _, err := Restore(&archive, config.Config{Encryption: config.EncryptionConfig{Key: _key}}, restored, sec); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := restored.Get("key"); v.IsNone() || v.Unwrap().Value != "value" || v.Unwrap().Level != constants.SecretLevel {
+		t.Errorf("key: got %+v", v)
+	}
+
+	if v := restored.GetFromObject("obj", "key"); v.IsNone() || v.Unwrap() != "value" {
+		t.Errorf("obj.key: got %+v", v)
+	}
+}
+
+func checkRestored(t *testing.T, s *storage.Storage) {
+	t.Helper()
+
+	if v := s.Get("plain"); v.IsNone() || v.Unwrap().Value != "value" || !v.Unwrap().ReadOnly {
+		t.Errorf("plain: got %+v", v)
+	}
+
+	if v := s.Get("secret"); v.IsNone() || v.Unwrap().Value != "password" {
+		t.Errorf("secret: got %+v", v)
+	}
+
+	if s.Get("deleted").IsSome() {
+		t.Error("deleted key was restored")
+	}
+
+	if v := s.GetFromObject("obj", "key"); v.IsNone() || v.Unwrap() != "v1" {
+		t.Errorf("obj.key: got %+v", v)
+	}
+
+	if v := s.GetFromObject("obj.inner", "key"); v.IsNone() || v.Unwrap() != "v2" {
+		t.Errorf("obj.inner.key: got %+v", v)
+	}
+
+	if v := s.GetFromObject("obj.inner", "deleted"); v.IsSome() {
+		t.Errorf("deleted attribute was restored: %+v", v)
+	}
+
+	if info := s.GetObjectInfo("obj"); info.IsNone() || info.Unwrap().Server != 1 {
+		t.Errorf("obj info: got %+v", info)
+	}
+
+	r := s.GetUserByName("alice")
+	if r.IsErr() {
+		t.Fatalf("alice: %v", r.Error())
+	}
+
+	if u := r.Unwrap(); u.Password != "pass" || u.GetChangeID() != 7 || s.GetUserChangeID() != 7 {
+		t.Errorf("alice: got %+v with change ID %d, users change ID %d", u, u.GetChangeID(), s.GetUserChangeID())
+	}
+}
@@ -0,0 +1,148 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"itisadb/config"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	transactionlogger "itisadb/internal/service/transaction-logger"
+)
+
+// Restore loads the archive read from r. With the transaction logger on, the snapshot
+// becomes the first file of an empty log directory and is applied by the usual recovery,
+// otherwise it is applied to restorer right away.
+func Restore(r io.Reader, cfg config.Config, restorer domains.Restorer, security domains.SecurityService) (m models.BackupManifest, err error) {
+	a, err := Open(r, cfg.Encryption.Key)
+	if err != nil {
+		return m, err
+	}
+	defer a.Close()
+
+	if cfg.TransactionLogger.On {
+		dir := cfg.TransactionLogger.BackupDirectory
+		if dir == "" {
+			dir = transactionlogger.DefaultPath
+		}
+
+		return a.Manifest, transactionlogger.Seed(dir, a.Events)
+	}
+
+	return a.Manifest, a.Events(func(e transactionlogger.Event) error {
+		return transactionlogger.ApplyEvent(restorer, security, e)
+	})
+}
+
+// Archive is an archive opened for reading, its snapshot is read with Events.
+type Archive struct {
+	Manifest models.BackupManifest
+
+	gz *gzip.Reader
+	tr *tar.Reader
+}
+
+// Open reads the manifest of the archive read from r and checks that
+// the archive can be restored with the encryption key.
+func Open(r io.Reader, key string) (*Archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+
+	a := &Archive{gz: gz, tr: tar.NewReader(gz)}
+
+	if err := a.open(key); err != nil {
+		gz.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *Archive) open(key string) (err error) {
+	a.Manifest, err = readManifest(a.tr)
+	if err != nil {
+		return err
+	}
+
+	if m := a.Manifest; m.FormatVersion < 1 || m.FormatVersion > FormatVersion {
+		return fmt.Errorf("%w: %d", ErrFormatVersion, m.FormatVersion)
+	}
+
+	if a.Manifest.KeyFingerprint != Fingerprint(key) {
+		return ErrKeyMismatch
+	}
+
+	return nextEntry(a.tr, _snapshotName)
+}
+
+// Events passes every event of the snapshot to fn.
+func (a *Archive) Events(fn func(transactionlogger.Event) error) error {
+	return readSnapshot(a.tr, a.Manifest, fn)
+}
+
+func (a *Archive) Close() error {
+	return a.gz.Close()
+}
+
+func nextEntry(tr *tar.Reader, name string) error {
+	h, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("%w: %s is missing: %w", ErrCorrupted, name, err)
+	}
+
+	if h.Name != name {
+		return fmt.Errorf("%w: unexpected entry %s instead of %s", ErrCorrupted, h.Name, name)
+	}
+
+	return nil
+}
+
+func readManifest(tr *tar.Reader) (m models.BackupManifest, err error) {
+	if err = nextEntry(tr, _manifestName); err != nil {
+		return m, err
+	}
+
+	if err = json.NewDecoder(tr).Decode(&m); err != nil {
+		return m, fmt.Errorf("%w: invalid manifest: %w", ErrCorrupted, err)
+	}
+
+	return m, nil
+}
+
+// readSnapshot passes every event of the snapshot to fn and checks
+// that the snapshot has as many events as the manifest says.
+func readSnapshot(r io.Reader, m models.BackupManifest, fn func(transactionlogger.Event) error) error {
+	var n uint64
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64*1024*1024)
+
+	for sc.Scan() {
+		n++
+
+		e, err := transactionlogger.DecodeEvent(sc.Text())
+		if err != nil {
+			return fmt.Errorf("%w: event %d: %w", ErrCorrupted, n, err)
+		}
+
+		if err := fn(e); err != nil {
+			return fmt.Errorf("event %d: %w", n, err)
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+
+	if n != m.Events {
+		return fmt.Errorf("%w: %d events instead of %d", ErrCorrupted, n, m.Events)
+	}
+
+	return nil
+}
@@ -3,14 +3,23 @@ package balancer
 import (
 	"context"
 	"errors"
+	"expvar"
+	"fmt"
 	"runtime"
+	"time"
 
 	"github.com/egorgasay/gost"
 	"go.uber.org/zap"
 	"itisadb/config"
 	"itisadb/internal/constants"
 	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/coordinator"
+	"itisadb/internal/service/deadline"
 	"itisadb/internal/service/logic"
+	"itisadb/internal/service/nearcache"
+	"itisadb/internal/service/quorum"
+	"itisadb/pkg/flight"
 )
 
 type Balancer struct {
@@ -23,14 +32,24 @@ type Balancer struct {
 	security domains.SecurityService
 	*logic.Logic
 
-	cfg config.Config
+	cfg      config.Config
+	timeouts config.TimeoutsConfig
 
 	pool chan struct{} // TODO: ADD TO CONFIG
 
-	objectServers gost.RwLock[map[string]int32]
-	keyServers    gost.RwLock[map[string]int32]
+	catalog    domains.Catalog
+	quorum     *quorum.Coordinator
+	rebalancer domains.Rebalancer
+
+	reads     flight.Group[models.Value]
+	nearCache *nearcache.Cache
+
+	coordinator gost.Option[*coordinator.Coordinator]
 }
 
+// _coalesced counts the reads that shared the result of an identical one in flight.
+var _coalesced = expvar.NewInt("coalesced_reads")
+
 func New(
 	ctx context.Context,
 	cfg config.Config,
@@ -38,9 +57,12 @@ func New(
 	storage domains.Storage,
 	tlogger domains.TransactionLogger,
 	servers domains.Servers,
+	catalog domains.Catalog,
+	rebalancer domains.Rebalancer,
 	session domains.Session,
 	security domains.SecurityService,
 	logic *logic.Logic,
+	coordinator gost.Option[*coordinator.Coordinator],
 ) (*Balancer, error) {
 	var err error
 
@@ -48,18 +70,53 @@ func New(
 		return nil, err
 	}
 
-	
+	q, err := quorum.New(cfg.Balancer, logger)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Balancer{
-		logger:        logger,
-		servers:       servers,
-		storage:       storage,
-		tlogger:       tlogger,
-		session:       session,
-		cfg:           cfg,
-		pool:          make(chan struct{}, 20_000*runtime.NumCPU()), // TODO: MOVE TO CONFIG
-		objectServers: gost.NewRwLock(make(map[string]int32)),
-		keyServers:    gost.NewRwLock(make(map[string]int32)),
-		security:      security,
-		Logic:         logic,
+		logger:     logger,
+		servers:    servers,
+		storage:    storage,
+		tlogger:    tlogger,
+		session:    session,
+		cfg:        cfg,
+		timeouts:   deadline.WithDefaults(cfg.Balancer.Timeouts),
+		pool:       make(chan struct{}, 20_000*runtime.NumCPU()), // TODO: MOVE TO CONFIG
+		catalog:    catalog,
+		quorum:     q,
+		rebalancer: rebalancer,
+		nearCache:  nearcache.New(cfg.Balancer.NearCache),
+		security:   security,
+		Logic:      logic,
+
+		coordinator: coordinator,
 	}, nil
 }
+
+// run runs f in the pool with ctx bounded by the timeout of the operation, see deadline.Bound.
+// An operation that ran out of time fails with context.DeadlineExceeded, whatever the servers answered.
+func (c *Balancer) run(ctx context.Context, timeout time.Duration, f func(ctx context.Context) error) error {
+	ctx, cancel := deadline.Bound(ctx, timeout)
+	defer cancel()
+
+	err := gost.WithContextPool(ctx, func() error {
+		return f(ctx)
+	}, c.pool)
+
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+	}
+
+	return err
+}
+
+// writable returns an error when the node is a read-only replica.
+func (c *Balancer) writable() error {
+	if c.cfg.Replication.IsReplica() {
+		return constants.ErrReadOnlyReplica
+	}
+
+	return nil
+}
@@ -0,0 +1,2 @@
+// Package balancertest tests how the balancer routes the keys and the objects to the servers of a cluster.
+package balancertest
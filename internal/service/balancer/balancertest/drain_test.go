@@ -0,0 +1,259 @@
+package balancertest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"itisadb/config"
+	"itisadb/internal/clustertest"
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+	"itisadb/internal/service/balancer"
+	"itisadb/internal/service/catalog"
+	"itisadb/internal/service/coordinator"
+	"itisadb/internal/service/rebalancer"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+// newDrainingBalancer returns a balancer with a rebalancer that moves the data of the cluster.
+func newDrainingBalancer(t *testing.T, c *clustertest.Cluster) (*balancer.Balancer, *rebalancer.Rebalancer) {
+	t.Helper()
+
+	bcfg := config.BalancerConfig{CatalogDirectory: t.TempDir(), RebalanceRate: 1_000_000}
+
+	cat, err := catalog.New(bcfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cat.Close() })
+
+	rb := rebalancer.New(bcfg, c, cat, zap.NewNop())
+	t.Cleanup(rb.Wait)
+
+	return buildBalancer(t, bcfg, c, cat, rb, gost.None[*coordinator.Coordinator]()), rb
+}
+
+// fill writes the keys and an object with an attribute to the server.
+func fill(t *testing.T, b *balancer.Balancer, server int32, keys ...string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	for _, key := range keys {
+		if _, err := b.Set(ctx, _claims, key, "value "+key, models.SetOptions{Server: server}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := b.Object(ctx, _claims, "user", models.ObjectOptions{Server: server}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.SetToObject(ctx, _claims, "user", "name", "Ann", models.SetToObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// served checks that the balancer still finds the data written by fill.
+func served(t *testing.T, b *balancer.Balancer, keys ...string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	for _, key := range keys {
+		if v, err := b.Get(ctx, _claims, key, models.GetOptions{}); err != nil || v.Value != "value "+key {
+			t.Errorf("get %s: %q, %v", key, v.Value, err)
+		}
+	}
+
+	if v, err := b.GetFromObject(ctx, _claims, "user", "name", models.GetFromObjectOptions{}); err != nil || v != "Ann" {
+		t.Errorf("get from object: %q, %v", v, err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2 := clustertest.NewServer(1), clustertest.NewServer(2)
+	c := clustertest.NewCluster(s1, s2)
+	b, rb := newDrainingBalancer(t, c)
+
+	keys := []string{"a", "b", "c"}
+	fill(t, b, 2, keys...)
+
+	if err := b.Drain(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	rb.Wait()
+
+	if st := rb.Status(); st.Failed != 0 || st.Moved == 0 {
+		t.Errorf("status: %+v", st)
+	}
+
+	if s2.Len() != 0 || len(s2.Objects()) != 0 {
+		t.Errorf("the drained server keeps %v and %v", s2.Keys(), s2.Objects())
+	}
+
+	if c.Connected(2) {
+		t.Error("the drained server is still connected")
+	}
+
+	for _, key := range keys {
+		if !s1.Has(key) {
+			t.Errorf("%s didn't reach s#1", key)
+		}
+	}
+
+	if s1.Fields("user")["name"] != "Ann" {
+		t.Errorf("the object didn't reach s#1: %v", s1.Fields("user"))
+	}
+
+	served(t, b, keys...)
+
+	// new data goes to the servers that are left
+	if n, err := b.Set(ctx, _claims, "d", "value", models.SetOptions{}); err != nil || n != 1 {
+		t.Errorf("set after the drain: server %d, %v", n, err)
+	}
+}
+
+func TestDrainRejected(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2 := clustertest.NewServer(1), clustertest.NewServer(2)
+	c := clustertest.NewCluster(s1, s2)
+	b, rb := newDrainingBalancer(t, c)
+
+	if err := b.Drain(ctx, 3); !errors.Is(err, constants.ErrUnknownServer) {
+		t.Errorf("drain an unknown server: %v", err)
+	}
+
+	s2.SetOffline(true)
+	if err := b.Drain(ctx, 2); !errors.Is(err, constants.ErrUnavailable) {
+		t.Errorf("drain an offline server: %v", err)
+	}
+	s2.SetOffline(false)
+
+	if err := b.Drain(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	rb.Wait()
+
+	if err := b.Drain(ctx, 1); !is(err, constants.ErrNoTargetServer) {
+		t.Errorf("drain the last server: %v", err)
+	}
+
+	if !c.Connected(1) {
+		t.Error("the last server was disconnected")
+	}
+}
+
+func TestDrainFails(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2 := clustertest.NewServer(1), clustertest.NewServer(2)
+	c := clustertest.NewCluster(s1, s2)
+	b, rb := newDrainingBalancer(t, c)
+
+	keys := []string{"a", "b"}
+	fill(t, b, 2, keys...)
+
+	// the data can't be copied to the only target
+	s1.SetDown(true)
+
+	if err := b.Drain(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	rb.Wait()
+
+	if st := rb.Status(); st.Failed == 0 {
+		t.Errorf("the copy to a down server didn't fail: %+v", st)
+	}
+
+	// the server keeps what it couldn't give away and stays in the cluster
+	if !c.Connected(2) {
+		t.Fatal("the server was disconnected with its data")
+	}
+
+	for _, key := range keys {
+		if !s2.Has(key) {
+			t.Errorf("%s was lost", key)
+		}
+	}
+
+	s1.SetDown(false)
+	served(t, b, keys...)
+
+	// the server is still leaving, the drain is retried
+	if err := b.Drain(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	rb.Wait()
+
+	if c.Connected(2) || s2.Len() != 0 {
+		t.Errorf("the retried drain left %v on s#2", s2.Keys())
+	}
+
+	served(t, b, keys...)
+}
+
+func TestMaintenance(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2 := clustertest.NewServer(1), clustertest.NewServer(2)
+	c := clustertest.NewCluster(s1, s2)
+	b, _ := newBalancer(t, c, gost.None[*coordinator.Coordinator]())
+
+	if _, err := b.Set(ctx, _claims, "old", "value", models.SetOptions{}); err != nil || !s1.Has("old") {
+		t.Fatalf("set: %v", err)
+	}
+
+	if err := b.SetMaintenance(ctx, 1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// new data skips the server
+	if n, err := b.Set(ctx, _claims, "new", "value", models.SetOptions{}); err != nil || n != 2 {
+		t.Errorf("set during maintenance: server %d, %v", n, err)
+	}
+
+	if n, err := b.Object(ctx, _claims, "user", models.ObjectOptions{}); err != nil || n != 2 {
+		t.Errorf("object during maintenance: server %d, %v", n, err)
+	}
+
+	// what it holds is still served from it
+	if v, err := b.Get(ctx, _claims, "old", models.GetOptions{}); err != nil || v.Value != "value" {
+		t.Errorf("get during maintenance: %q, %v", v.Value, err)
+	}
+
+	if !s1.Has("old") || s2.Has("old") {
+		t.Error("the data was moved off the server in maintenance")
+	}
+
+	// no server can take new data
+	if err := b.SetMaintenance(ctx, 2, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Set(ctx, _claims, "rejected", "value", models.SetOptions{}); !is(err, constants.ErrNoPlacement) {
+		t.Errorf("set with every server in maintenance: %v", err)
+	}
+
+	if s1.Has("rejected") || s2.Has("rejected") {
+		t.Error("the rejected key was stored")
+	}
+
+	if err := b.SetMaintenance(ctx, 1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := b.Set(ctx, _claims, "back", "value", models.SetOptions{}); err != nil || n != 1 {
+		t.Errorf("set after the maintenance: server %d, %v", n, err)
+	}
+
+	if err := b.SetMaintenance(ctx, 3, true); !errors.Is(err, constants.ErrUnknownServer) {
+		t.Errorf("maintenance of an unknown server: %v", err)
+	}
+}
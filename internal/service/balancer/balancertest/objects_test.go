@@ -0,0 +1,282 @@
+package balancertest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"itisadb/internal/clustertest"
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+	"itisadb/internal/service/coordinator"
+	"itisadb/internal/service/reference"
+
+	"github.com/egorgasay/gost"
+)
+
+func TestObjectRoutes(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2 := clustertest.NewServer(1), clustertest.NewServer(2)
+	c := clustertest.NewCluster(s1, s2)
+	b, cat := newBalancer(t, c, gost.None[*coordinator.Coordinator]())
+
+	if n, err := b.Object(ctx, _claims, "user", models.ObjectOptions{Server: 2}); err != nil || n != 2 {
+		t.Fatalf("object: server %d, %v", n, err)
+	}
+
+	// the nested object and the attributes follow the top-level object
+	if n, err := b.Object(ctx, _claims, "user.address", models.ObjectOptions{}); err != nil || n != 2 {
+		t.Fatalf("nested object: server %d, %v", n, err)
+	}
+
+	if n, err := b.SetToObject(ctx, _claims, "user.address", "city", "Paris", models.SetToObjectOptions{}); err != nil || n != 2 {
+		t.Fatalf("set to object: server %d, %v", n, err)
+	}
+
+	if v, err := b.GetFromObject(ctx, _claims, "user.address", "city", models.GetFromObjectOptions{}); err != nil || v != "Paris" {
+		t.Fatalf("get from object: %q, %v", v, err)
+	}
+
+	if len(s1.Objects()) != 0 {
+		t.Errorf("s#1 got %v", s1.Objects())
+	}
+
+	if err := b.DeleteAttr(ctx, _claims, "city", "user.address", models.DeleteAttrOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if fields := s2.Fields("user.address"); len(fields) != 0 {
+		t.Errorf("the attribute is still there: %v", fields)
+	}
+
+	if _, err := b.Object(ctx, _claims, "user", models.ObjectOptions{Server: 1}); !is(err, constants.ErrAlreadyExists) {
+		t.Errorf("object on another server: %v", err)
+	}
+
+	if err := b.DeleteObject(ctx, _claims, "user", models.DeleteObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s2.Objects()) != 0 {
+		t.Errorf("s#2 still has %v", s2.Objects())
+	}
+
+	if r := cat.ObjectServer("user"); r.IsSome() {
+		t.Errorf("the catalog still has s#%d for the deleted object", r.Unwrap())
+	}
+}
+
+func TestObjectSearch(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2 := clustertest.NewServer(1), clustertest.NewServer(2)
+	c := clustertest.NewCluster(s1, s2)
+	b, _ := newBalancer(t, c, gost.None[*coordinator.Coordinator]())
+
+	// the catalog doesn't know the object, the servers are asked about it
+	s2.NewObject(ctx, _claims, "user", models.ObjectOptions{})
+
+	if n, err := b.SetToObject(ctx, _claims, "user", "name", "Ann", models.SetToObjectOptions{}); err != nil || n != 2 {
+		t.Fatalf("set to object: server %d, %v", n, err)
+	}
+
+	if fields := s2.Fields("user"); fields["name"] != "Ann" {
+		t.Errorf("s#2 has %v", fields)
+	}
+}
+
+func TestReservedValue(t *testing.T) {
+	ctx := context.Background()
+
+	s1 := clustertest.NewServer(1)
+	b, _ := newBalancer(t, clustertest.NewCluster(s1), gost.None[*coordinator.Coordinator]())
+
+	if _, err := b.Object(ctx, _claims, "user", models.ObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the balancer would take the value for an attached object
+	if _, err := b.SetToObject(ctx, _claims, "user", "name", reference.Encode("other"), models.SetToObjectOptions{}); !is(err, constants.ErrReservedValue) {
+		t.Fatalf("set a reference: %v", err)
+	}
+
+	if fields := s1.Fields("user"); len(fields) != 0 {
+		t.Errorf("the value reached the server: %v", fields)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2 := clustertest.NewServer(1), clustertest.NewServer(2)
+	c := clustertest.NewCluster(s1, s2)
+	b, _ := newBalancer(t, c, gost.None[*coordinator.Coordinator]())
+
+	if _, err := b.Object(ctx, _claims, "user", models.ObjectOptions{Server: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Object(ctx, _claims, "address", models.ObjectOptions{Server: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.SetToObject(ctx, _claims, "address", "city", "Paris", models.SetToObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the objects are on different servers, s#1 keeps a reference to s#2
+	if err := b.AttachToObject(ctx, _claims, "user", "address", models.AttachToObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s1.Fields("user")[reference.Name("address")]; got != reference.Encode("address") {
+		t.Fatalf("s#1 keeps %q", got)
+	}
+
+	// the path goes through the reference to the server of the attached object
+	path := "user" + constants.ObjectSeparator + reference.Name("address")
+
+	if v, err := b.GetFromObject(ctx, _claims, path, "city", models.GetFromObjectOptions{}); err != nil || v != "Paris" {
+		t.Fatalf("get through the reference: %q, %v", v, err)
+	}
+
+	if n, err := b.SetToObject(ctx, _claims, path, "zip", "75001", models.SetToObjectOptions{}); err != nil || n != 2 {
+		t.Fatalf("set through the reference: server %d, %v", n, err)
+	}
+
+	// the reference is not a value
+	if _, err := b.GetFromObject(ctx, _claims, "user", reference.Name("address"), models.GetFromObjectOptions{}); !is(err, constants.ErrObjectNotFound) {
+		t.Errorf("get the reference: %v", err)
+	}
+
+	data, err := b.ObjectToJSON(ctx, _claims, "user", models.ObjectToJSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := attributes(t, data); got["city"] != "Paris" || got["zip"] != "75001" {
+		t.Errorf("the attached object is not expanded: %s", data)
+	}
+
+	if err := b.AttachToObject(ctx, _claims, "address", "user", models.AttachToObjectOptions{}); !is(err, constants.ErrCircularAttachment) {
+		t.Errorf("attach in a cycle: %v", err)
+	}
+}
+
+func TestShards(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2, s3 := clustertest.NewServer(1), clustertest.NewServer(2), clustertest.NewServer(3)
+	c := clustertest.NewCluster(s1, s2, s3)
+	b, cat := newBalancer(t, c, gost.None[*coordinator.Coordinator]())
+
+	if n, err := b.Object(ctx, _claims, "users", models.ObjectOptions{Shards: 2}); err != nil || n != 1 {
+		t.Fatalf("sharded object: server %d, %v", n, err)
+	}
+
+	if shards := cat.ObjectShards("users"); shards.IsNone() || len(shards.Unwrap()) != 2 {
+		t.Fatalf("the catalog has the shards %v", shards)
+	}
+
+	if len(s3.Objects()) != 0 {
+		t.Errorf("s#3 got a shard")
+	}
+
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for _, key := range keys {
+		if _, err := b.SetToObject(ctx, _claims, "users", key, key, models.SetToObjectOptions{}); err != nil {
+			t.Fatalf("set %s: %v", key, err)
+		}
+	}
+
+	// the attributes are spread between the shards
+	if len(s1.Fields("users")) == 0 || len(s2.Fields("users")) == 0 {
+		t.Errorf("a shard is empty: %v, %v", s1.Fields("users"), s2.Fields("users"))
+	}
+
+	for _, key := range keys {
+		if v, err := b.GetFromObject(ctx, _claims, "users", key, models.GetFromObjectOptions{}); err != nil || v != key {
+			t.Errorf("get %s: %q, %v", key, v, err)
+		}
+	}
+
+	data, err := b.ObjectToJSON(ctx, _claims, "users", models.ObjectToJSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := attributes(t, data); len(got) != len(keys) {
+		t.Errorf("the shards are not merged: %s", data)
+	}
+
+	if _, err := b.Object(ctx, _claims, "users.nested", models.ObjectOptions{Shards: 2}); !is(err, constants.ErrNestedShards) {
+		t.Errorf("nested sharded object: %v", err)
+	}
+
+	if err := b.DeleteObject(ctx, _claims, "users", models.DeleteObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s1.Objects())+len(s2.Objects()) != 0 {
+		t.Errorf("the shards are still there: %v, %v", s1.Objects(), s2.Objects())
+	}
+}
+
+func TestShardsDropped(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2 := clustertest.NewServer(1), clustertest.NewServer(2)
+	c := clustertest.NewCluster(s1, s2)
+	b, cat := newBalancer(t, c, gost.None[*coordinator.Coordinator]())
+
+	// the second shard can't be created, the first one is deleted
+	s2.SetDown(true)
+
+	if _, err := b.Object(ctx, _claims, "users", models.ObjectOptions{Shards: 2}); err == nil {
+		t.Fatal("the object is created without its second shard")
+	}
+
+	if len(s1.Objects()) != 0 {
+		t.Errorf("s#1 keeps the shard %v", s1.Objects())
+	}
+
+	s2.SetDown(false)
+
+	// the shards can't be saved, none of them is left
+	cat.Close()
+
+	if _, err := b.Object(ctx, _claims, "users", models.ObjectOptions{Shards: 2}); err == nil {
+		t.Fatal("the object is created without the catalog")
+	}
+
+	if len(s1.Objects())+len(s2.Objects()) != 0 {
+		t.Errorf("the shards are still there: %v, %v", s1.Objects(), s2.Objects())
+	}
+}
+
+// attributes returns the values of the object JSON and of the objects in it by their keys.
+func attributes(t *testing.T, data string) map[string]string {
+	t.Helper()
+
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(data), &obj); err != nil {
+		t.Fatal(err)
+	}
+
+	attrs := make(map[string]string)
+
+	var walk func(obj map[string]any)
+	walk = func(obj map[string]any) {
+		values, _ := obj["values"].([]any)
+		for _, v := range values {
+			m, _ := v.(map[string]any)
+			if key, ok := m["key"].(string); ok {
+				attrs[key], _ = m["value"].(string)
+			} else {
+				walk(m)
+			}
+		}
+	}
+	walk(obj)
+
+	return attrs
+}
@@ -0,0 +1,203 @@
+package balancertest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"itisadb/config"
+	"itisadb/internal/clustertest"
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+	"itisadb/internal/service/balancer"
+	"itisadb/internal/service/coordinator"
+	"itisadb/internal/service/quorum"
+
+	"github.com/egorgasay/gost"
+)
+
+// newReplicatedBalancer returns a balancer that keeps 3 copies of each key, 2 of them make a quorum.
+func newReplicatedBalancer(t *testing.T, c *clustertest.Cluster) *balancer.Balancer {
+	t.Helper()
+
+	b, _ := newBalancerWith(t, config.BalancerConfig{ReplicationFactor: 3, Placement: config.HashPlacement}, c, gost.None[*coordinator.Coordinator]())
+	return b
+}
+
+// versionOf returns the value of the key on the server with its version.
+func versionOf(s *clustertest.Server, key string) (quorum.Versioned, bool) {
+	v, ok := s.Value(key)
+	if !ok {
+		return quorum.Versioned{}, false
+	}
+
+	return quorum.Decode(v), true
+}
+
+func TestReplicatedRoutes(t *testing.T) {
+	ctx := context.Background()
+
+	c := clustertest.NewHashCluster(clustertest.NewServer(1), clustertest.NewServer(2), clustertest.NewServer(3), clustertest.NewServer(4))
+	b := newReplicatedBalancer(t, c)
+
+	key := "user"
+	owners := c.Owners(key, 3)
+
+	if _, err := b.Set(ctx, _claims, key, "Ann", models.SetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the write returns after the quorum, the last replica gets it in the background
+	for _, number := range owners {
+		s := server(c, number)
+		clustertest.Eventually(t, "the write on the replica", func() bool {
+			v, ok := versionOf(s, key)
+			return ok && v.Value.Value == "Ann" && v.Version > 0
+		})
+	}
+
+	for _, s := range []int32{1, 2, 3, 4} {
+		if !slices.Contains(owners, s) && server(c, s).Has(key) {
+			t.Errorf("s#%d is not a replica of the key, but has it", s)
+		}
+	}
+
+	// a replica that missed the write doesn't hide it
+	server(c, owners[0]).Remove(key)
+
+	if v, err := b.Get(ctx, _claims, key, models.GetOptions{}); err != nil || v.Value != "Ann" {
+		t.Fatalf("get: %q, %v", v.Value, err)
+	}
+
+	if err := b.Delete(ctx, _claims, key, models.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Get(ctx, _claims, key, models.GetOptions{}); !is(err, constants.ErrNotFound) {
+		t.Errorf("get a deleted key: %v", err)
+	}
+}
+
+func TestReservedVersion(t *testing.T) {
+	ctx := context.Background()
+
+	c := clustertest.NewHashCluster(clustertest.NewServer(1), clustertest.NewServer(2), clustertest.NewServer(3))
+	b := newReplicatedBalancer(t, c)
+
+	// the replicas would take the value for a version of the key
+	stamped := quorum.Encode(1, false, "value")
+
+	if _, err := b.Set(ctx, _claims, "key", stamped, models.SetOptions{}); !is(err, constants.ErrReservedValue) {
+		t.Errorf("set: %v", err)
+	}
+
+	if _, err := b.Set(ctx, _claims, "key", stamped, models.SetOptions{Server: 1}); !is(err, constants.ErrReservedValue) {
+		t.Errorf("set to a server: %v", err)
+	}
+
+	results, err := b.MSet(ctx, _claims, map[string]string{"key": stamped, "other": "value"}, models.SetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, res := range results {
+		if want := res.Key == "key"; is(res.Err, constants.ErrReservedValue) != want {
+			t.Errorf("mset %s: %v", res.Key, res.Err)
+		}
+	}
+
+	if _, err := b.Transact(ctx, _claims, []models.TxOp{{Key: "key", Value: stamped}}, models.SetOptions{Server: 1}); !is(err, constants.ErrReservedValue) {
+		t.Errorf("transact: %v", err)
+	}
+
+	for _, s := range []int32{1, 2, 3} {
+		if server(c, s).Has("key") {
+			t.Errorf("the value reached s#%d", s)
+		}
+	}
+}
+
+func TestReplicatedBatch(t *testing.T) {
+	ctx := context.Background()
+
+	c := clustertest.NewHashCluster(clustertest.NewServer(1), clustertest.NewServer(2), clustertest.NewServer(3), clustertest.NewServer(4))
+	b, cat := newBalancerWith(t, config.BalancerConfig{ReplicationFactor: 3, Placement: config.HashPlacement}, c, gost.None[*coordinator.Coordinator]())
+
+	var keys []string
+	values := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys = append(keys, key)
+		values[key] = fmt.Sprintf("value%d", i)
+	}
+
+	results, err := b.MSet(ctx, _claims, values, models.SetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, res := range results {
+		if res.Err != nil || !slices.Contains(c.Owners(res.Key, 3), res.Server) {
+			t.Errorf("mset %s: server %d, %v", res.Key, res.Server, res.Err)
+		}
+	}
+
+	// every server keeps some of the keys and gets them in one request
+	for key := range values {
+		for _, number := range c.Owners(key, 3) {
+			clustertest.Eventually(t, "the write on the replica", func() bool {
+				v, ok := versionOf(server(c, number), key)
+				return ok && v.Value.Value == values[key]
+			})
+		}
+	}
+
+	for _, number := range []int32{1, 2, 3, 4} {
+		if s := server(c, number); s.Calls("MSet") != 1 || s.Calls("SetOne") != 0 {
+			t.Errorf("s#%d: %d batch and %d single writes", number, s.Calls("MSet"), s.Calls("SetOne"))
+		}
+	}
+
+	if err := b.Delete(ctx, _claims, "key0", models.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a key written before the replication was turned on is kept on one server the catalog knows
+	server(c, 1).Put("old", models.Value{Value: "plain"})
+	cat.SetKeyServer("old", 1)
+
+	keys = append(keys, "old", "missing")
+
+	got, err := b.MGet(ctx, _claims, keys, models.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, res := range got {
+		switch key := keys[i]; key {
+		case "key0", "missing":
+			if !is(res.Err, constants.ErrNotFound) {
+				t.Errorf("mget %s: %q, %v", key, res.Value.Value, res.Err)
+			}
+		case "old":
+			if res.Err != nil || res.Value.Value != "plain" {
+				t.Errorf("mget %s: %q, %v", key, res.Value.Value, res.Err)
+			}
+		default:
+			if res.Err != nil || res.Value.Value != values[key] {
+				t.Errorf("mget %s: %q, %v", key, res.Value.Value, res.Err)
+			}
+		}
+	}
+
+	// the keys are read with one request per replica server
+	clustertest.Eventually(t, "the batch reads", func() bool {
+		for _, number := range []int32{1, 2, 3, 4} {
+			if server(c, number).Calls("MGet") != 1 {
+				return false
+			}
+		}
+		return true
+	})
+}
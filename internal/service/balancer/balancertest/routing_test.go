@@ -0,0 +1,403 @@
+package balancertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/clustertest"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/balancer"
+	"itisadb/internal/service/catalog"
+	"itisadb/internal/service/coordinator"
+	"itisadb/internal/service/generator"
+	"itisadb/internal/service/logic"
+	"itisadb/internal/service/security"
+	"itisadb/internal/service/servers"
+	"itisadb/internal/service/session"
+	"itisadb/internal/storage"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+var _claims gost.Option[models.UserClaims]
+
+// newBalancer returns a balancer of the cluster with the catalog in a temporary directory.
+func newBalancer(t *testing.T, c *clustertest.Cluster, co gost.Option[*coordinator.Coordinator]) (*balancer.Balancer, *catalog.Catalog) {
+	t.Helper()
+
+	return newBalancerWith(t, config.BalancerConfig{}, c, co)
+}
+
+// newBalancerWith returns a balancer with the config, see newBalancer.
+func newBalancerWith(t *testing.T, bcfg config.BalancerConfig, c *clustertest.Cluster, co gost.Option[*coordinator.Coordinator]) (*balancer.Balancer, *catalog.Catalog) {
+	t.Helper()
+
+	bcfg.CatalogDirectory = t.TempDir()
+
+	cat, err := catalog.New(bcfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cat.Close() })
+
+	return buildBalancer(t, bcfg, c, cat, nil, co), cat
+}
+
+// buildBalancer returns a balancer of the cluster with the catalog and the rebalancer.
+func buildBalancer(t *testing.T, bcfg config.BalancerConfig, c *clustertest.Cluster, cat *catalog.Catalog, rb domains.Rebalancer, co gost.Option[*coordinator.Coordinator]) *balancer.Balancer {
+	t.Helper()
+
+	cfg := config.Config{Balancer: bcfg}
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec := security.NewSecurityService(config.SecurityConfig{}, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+	ses := session.New(cfg, store, generator.New(zap.NewNop()), zap.NewNop())
+	l := logic.NewLogic(store, cfg, nil, zap.NewNop(), sec)
+
+	b, err := balancer.New(context.Background(), cfg, zap.NewNop(), store, nil, c, cat, rb, ses, sec, l, co)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// server returns the server of the cluster by its number.
+func server(c *clustertest.Cluster, number int32) *clustertest.Server {
+	s, _ := c.GetServer(number)
+	return s.(*clustertest.Server)
+}
+
+// is reports whether err comes from target, the codes of the errors don't tell them apart.
+func is(err error, target *gost.ErrX) bool {
+	var errX *gost.ErrX
+	return errors.As(err, &errX) && slices.Contains(errX.Messages(), target.Message())
+}
+
+// keyOwnedBy returns a key the ring places on the server.
+func keyOwnedBy(c *clustertest.Cluster, number int32) string {
+	for i := 0; ; i++ {
+		if key := fmt.Sprintf("key%d", i); c.Owner(key) == number {
+			return key
+		}
+	}
+}
+
+func TestSetRoutes(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2 := clustertest.NewServer(1), clustertest.NewServer(2)
+	c := clustertest.NewCluster(s1, s2)
+	b, cat := newBalancer(t, c, gost.None[*coordinator.Coordinator]())
+
+	// without a ring the new key goes to the server the cluster places it on
+	if n, err := b.Set(ctx, _claims, "auto", "1", models.SetOptions{}); err != nil || n != 1 {
+		t.Fatalf("set auto: server %d, %v", n, err)
+	}
+
+	if n, err := b.Set(ctx, _claims, "pinned", "2", models.SetOptions{Server: 2}); err != nil || n != 2 {
+		t.Fatalf("set pinned: server %d, %v", n, err)
+	}
+
+	// the catalog keeps the key on its server
+	if n, err := b.Set(ctx, _claims, "pinned", "3", models.SetOptions{}); err != nil || n != 2 {
+		t.Fatalf("set pinned again: server %d, %v", n, err)
+	}
+
+	if s1.Has("pinned") {
+		t.Error("s#1 got the pinned key")
+	}
+
+	if v, _ := s2.Value("pinned"); v.Value != "3" {
+		t.Errorf("s#2 has %q, want the new value", v.Value)
+	}
+
+	if r := cat.KeyServer("auto"); r.IsNone() || r.Unwrap() != 1 {
+		t.Errorf("the catalog has %v for the auto key", r)
+	}
+
+	if _, err := b.Set(ctx, _claims, "lost", "4", models.SetOptions{Server: 3}); !errors.Is(err, constants.ErrUnknownServer) {
+		t.Errorf("set to an unknown server: %v", err)
+	}
+}
+
+func TestSetPlacement(t *testing.T) {
+	ctx := context.Background()
+
+	c := clustertest.NewHashCluster(clustertest.NewServer(1), clustertest.NewServer(2), clustertest.NewServer(3))
+	b, _ := newBalancer(t, c, gost.None[*coordinator.Coordinator]())
+
+	owned := keyOwnedBy(c, 2)
+
+	// the ring places the key without a hint
+	if n, err := b.Set(ctx, _claims, owned, "1", models.SetOptions{}); err != nil || n != 2 {
+		t.Fatalf("set owned: server %d, %v", n, err)
+	}
+
+	// the hint wins over the ring, the catalog finds the key later
+	hinted := keyOwnedBy(c, 3)
+	if n, err := b.Set(ctx, _claims, hinted, "2", models.SetOptions{Placement: "zone=a"}); err != nil || n != 1 {
+		t.Fatalf("set hinted: server %d, %v", n, err)
+	}
+
+	reads := server(c, 3).Reads()
+
+	if v, err := b.Get(ctx, _claims, hinted, models.GetOptions{}); err != nil || v.Value != "2" {
+		t.Fatalf("get hinted: %q, %v", v.Value, err)
+	}
+
+	if got := server(c, 3).Reads(); got != reads {
+		t.Errorf("the owner on the ring was asked %d times", got-reads)
+	}
+}
+
+func TestGetSearches(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2 := clustertest.NewServer(1), clustertest.NewServer(2)
+	c := clustertest.NewCluster(s1, s2)
+	b, cat := newBalancer(t, c, gost.None[*coordinator.Coordinator]())
+
+	// the catalog doesn't know the key, only the search finds it
+	s2.Put("key", models.Value{Value: "1"})
+
+	if v, err := b.Get(ctx, _claims, "key", models.GetOptions{}); err != nil || v.Value != "1" {
+		t.Fatalf("get: %q, %v", v.Value, err)
+	}
+
+	if r := cat.KeyServer("key"); r.IsNone() || r.Unwrap() != 2 {
+		t.Fatalf("the search left %v in the catalog", r)
+	}
+
+	reads := s1.Reads()
+
+	if _, err := b.Get(ctx, _claims, "key", models.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s1.Reads(); got != reads {
+		t.Errorf("s#1 was asked %d times after the search", got-reads)
+	}
+
+	if _, err := b.Get(ctx, _claims, "missing", models.GetOptions{}); !is(err, constants.ErrNotFound) {
+		t.Errorf("get missing: %v", err)
+	}
+}
+
+func TestGetOldOwner(t *testing.T) {
+	ctx := context.Background()
+
+	c := clustertest.NewHashCluster(clustertest.NewServer(1), clustertest.NewServer(2))
+	b, _ := newBalancer(t, c, gost.None[*coordinator.Coordinator]())
+
+	// the rebalancer hasn't moved the key to its owner yet
+	key := keyOwnedBy(c, 2)
+	server(c, 1).Put(key, models.Value{Value: "1"})
+
+	if v, err := b.Get(ctx, _claims, key, models.GetOptions{}); err != nil || v.Value != "1" {
+		t.Fatalf("get: %q, %v", v.Value, err)
+	}
+
+	if err := b.Delete(ctx, _claims, key, models.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if server(c, 1).Has(key) {
+		t.Error("the old owner still has the key")
+	}
+}
+
+func TestDeleteRoutes(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2 := clustertest.NewServer(1), clustertest.NewServer(2)
+	c := clustertest.NewCluster(s1, s2)
+	b, cat := newBalancer(t, c, gost.None[*coordinator.Coordinator]())
+
+	if _, err := b.Set(ctx, _claims, "known", "1", models.SetOptions{Server: 2}); err != nil {
+		t.Fatal(err)
+	}
+	s2.Put("unknown", models.Value{Value: "2"})
+
+	for _, key := range []string{"known", "unknown"} {
+		if err := b.Delete(ctx, _claims, key, models.DeleteOptions{}); err != nil {
+			t.Fatalf("delete %s: %v", key, err)
+		}
+
+		if s2.Has(key) {
+			t.Errorf("s#2 still has %s", key)
+		}
+	}
+
+	if r := cat.KeyServer("known"); r.IsSome() {
+		t.Errorf("the catalog still has s#%d for the deleted key", r.Unwrap())
+	}
+
+	if err := b.Delete(ctx, _claims, "missing", models.DeleteOptions{}); !is(err, constants.ErrNotFound) {
+		t.Errorf("delete missing: %v", err)
+	}
+
+	// every server gets the key and loses it
+	if _, err := b.Set(ctx, _claims, "everywhere", "3", models.SetOptions{Server: constants.SetToAllServers}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s1.Has("everywhere") || !s2.Has("everywhere") {
+		t.Fatal("the key is not on every server")
+	}
+
+	if err := b.Delete(ctx, _claims, "everywhere", models.DeleteOptions{Server: constants.DeleteFromAllServers}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s1.Has("everywhere") || s2.Has("everywhere") {
+		t.Error("the key is still on a server")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+
+	c := clustertest.NewHashCluster(clustertest.NewServer(1), clustertest.NewServer(2), clustertest.NewServer(3))
+	b, _ := newBalancer(t, c, gost.None[*coordinator.Coordinator]())
+
+	values := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		values[fmt.Sprintf("key%d", i)] = fmt.Sprint(i)
+	}
+
+	results, err := b.MSet(ctx, _claims, values, models.SetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != len(values) {
+		t.Fatalf("%d results for %d keys", len(results), len(values))
+	}
+
+	for _, res := range results {
+		if res.Err != nil {
+			t.Errorf("set %s: %v", res.Key, res.Err)
+			continue
+		}
+
+		if owner := c.Owner(res.Key); res.Server != owner || !server(c, owner).Has(res.Key) {
+			t.Errorf("%s went to s#%d, the owner is s#%d", res.Key, res.Server, owner)
+		}
+	}
+
+	keys := []string{"key3", "missing", "key1", "key20"}
+
+	got, err := b.MGet(ctx, _claims, keys, models.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, res := range got {
+		if res.Key != keys[i] {
+			t.Errorf("result %d is for %s, want %s", i, res.Key, keys[i])
+		}
+
+		if res.Key == "missing" {
+			if !is(res.Err, constants.ErrNotFound) {
+				t.Errorf("get missing: %v", res.Err)
+			}
+			continue
+		}
+
+		if res.Err != nil || res.Value.Value != values[res.Key] {
+			t.Errorf("get %s: %q, %v", res.Key, res.Value.Value, res.Err)
+		}
+	}
+}
+
+// node is a server with its own storage, it takes part in the transactions.
+type node struct {
+	*servers.LocalServer
+	storage *storage.Storage
+	number  int32
+}
+
+func (n *node) Number() int32 { return n.number }
+
+func (n *node) IsOffline() bool { return false }
+
+func (n *node) has(key string) bool { return n.storage.Get(key).IsSome() }
+
+func TestTransact(t *testing.T) {
+	ctx := context.Background()
+
+	sec := security.NewSecurityService(config.SecurityConfig{}, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+
+	c := clustertest.NewCluster()
+
+	var nodes []*node
+	for i := int32(1); i <= 2; i++ {
+		store, err := storage.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l := logic.NewLogic(store, config.Config{}, nil, zap.NewNop(), sec)
+		n := &node{LocalServer: servers.NewLocalServer(l), storage: store, number: i}
+
+		c.Add(n)
+		nodes = append(nodes, n)
+	}
+
+	alone, _ := newBalancer(t, c, gost.None[*coordinator.Coordinator]())
+	if _, err := alone.Transact(ctx, _claims, nil, models.SetOptions{}); !is(err, constants.ErrNoCoordinator) {
+		t.Fatalf("transact without a coordinator: %v", err)
+	}
+
+	co, err := coordinator.New(config.TransactionsConfig{ID: "b1", Directory: t.TempDir(), RecoverInterval: time.Second}, c, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer co.Close()
+
+	b, _ := newBalancer(t, c, gost.Some(co))
+
+	if _, err := b.Set(ctx, _claims, "b", "0", models.SetOptions{Server: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Set(ctx, _claims, "c", "0", models.SetOptions{Server: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// each write goes where Set would put it, the deletion goes where the key is
+	ops := []models.TxOp{
+		{Key: "a", Value: "1"},
+		{Key: "b", Value: "2"},
+		{Key: "c", Delete: true},
+		{Key: "missing", Delete: true},
+	}
+
+	if _, err := b.Transact(ctx, _claims, ops, models.SetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !nodes[0].has("a") || nodes[1].has("a") {
+		t.Error("the new key is not on s#1 only")
+	}
+
+	if v, err := b.Get(ctx, _claims, "b", models.GetOptions{}); err != nil || v.Value != "2" || nodes[0].has("b") {
+		t.Errorf("get b: %q, %v", v.Value, err)
+	}
+
+	if nodes[1].has("c") {
+		t.Error("the deleted key is still on s#2")
+	}
+}
@@ -3,28 +3,47 @@ package balancer
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sync"
 
 	"github.com/egorgasay/gost"
 	"go.uber.org/zap"
 	"itisadb/internal/constants"
+	"itisadb/internal/domains"
 	"itisadb/internal/models"
+	"itisadb/internal/service/deadline"
+	"itisadb/internal/service/quorum"
 	"itisadb/pkg"
 )
 
+// Set sets the value of the key. A value that looks like a versioned one is rejected,
+// the replicas would take it for a version of the key.
 func (c *Balancer) Set(ctx context.Context, claims gost.Option[models.UserClaims], key, value string, opts models.SetOptions) (val int32, err error) {
-	return val, gost.WithContextPool(ctx, func() error {
+	if err := c.writable(); err != nil {
+		return 0, err
+	}
+
+	if quorum.Reserved(value) {
+		return 0, constants.ErrReservedValue
+	}
+
+	// the write may have reached the server even when it failed
+	defer c.nearCache.Invalidate(key)
+
+	return val, c.run(ctx, c.timeouts.Write, func(ctx context.Context) error {
 		val, err = c.set(ctx, claims, key, value, opts)
 		return err
-	}, c.pool)
+	})
 }
 
 func (c *Balancer) set(ctx context.Context, claims gost.Option[models.UserClaims], key, val string, opts models.SetOptions) (int32, error) {
-	if opts.Server == constants.AutoServerNumber {
-		res := c.getKeyServer(key)
-		if res.IsSome() {
-			opts.Server = res.Unwrap()
-		}
-	} else if opts.Server == constants.SetToAllServers {
+	if opts.Server == constants.AutoServerNumber && c.quorum.Enabled() {
+		return c.setReplicated(ctx, claims, key, val, opts)
+	}
+
+	val = c.stamp(val)
+
+	if opts.Server == constants.SetToAllServers {
 		failedServers := c.servers.SetToAll(ctx, claims, key, val, opts)
 		if len(failedServers) != 0 {
 			return opts.Server, fmt.Errorf("some servers failed: %v", failedServers)
@@ -33,13 +52,13 @@ func (c *Balancer) set(ctx context.Context, claims gost.Option[models.UserClaims
 		return opts.Server, nil
 	}
 
-	cl, ok := c.servers.GetServer(opts.Server)
-	if !ok || cl == nil {
-		return 0, constants.ErrUnknownServer
+	cl, err := c.setServer(key, opts)
+	if err != nil {
+		return 0, err
 	}
+	opts.Server = cl.Number()
 
-	err := cl.SetOne(ctx, claims, key, val, opts).Error()
-	if err != nil {
+	if err := cl.SetOne(ctx, claims, key, val, opts).Error(); err != nil {
 		return 0, err
 	}
 
@@ -48,11 +67,45 @@ func (c *Balancer) set(ctx context.Context, claims gost.Option[models.UserClaims
 	return cl.Number(), nil
 }
 
+// setServer chooses the server for a new value of the key: the one the catalog remembers,
+// the one the placement hint leads to or the owner on the ring, in this order.
+func (c *Balancer) setServer(key string, opts models.SetOptions) (domains.Server, error) {
+	if opts.Server == constants.AutoServerNumber {
+		res := c.getKeyServer(key)
+		if res.IsSome() {
+			opts.Server = res.Unwrap()
+		} else if opts.Placement != "" {
+			// the catalog remembers the server, so the key is found without the ring
+			r := c.servers.Place(opts.Placement)
+			if r.IsErr() {
+				return nil, r.Error()
+			}
+			opts.Server = r.Unwrap().Number()
+		} else if owner := c.servers.KeyOwner(key); owner.IsSome() {
+			opts.Server = owner.Unwrap().Number()
+		} else {
+			// fails when every server is leaving or in maintenance
+			r := c.servers.Place("")
+			if r.IsErr() {
+				return nil, r.Error()
+			}
+			opts.Server = r.Unwrap().Number()
+		}
+	}
+
+	cl, ok := c.servers.GetServer(opts.Server)
+	if !ok || cl == nil {
+		return nil, constants.ErrUnknownServer
+	}
+
+	return cl, nil
+}
+
 func (c *Balancer) Get(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.GetOptions) (val models.Value, err error) {
-	return val, gost.WithContextPool(ctx, func() error {
+	return val, c.run(ctx, c.timeouts.Read, func(ctx context.Context) error {
 		val, err = c.get(ctx, claims, key, opts)
 		return err
-	}, c.pool)
+	})
 }
 
 func (c *Balancer) getObjectInfo(object string) (models.ObjectInfo, error) {
@@ -64,18 +117,92 @@ func (c *Balancer) getObjectInfo(object string) (models.ObjectInfo, error) {
 	return infoR.Unwrap(), nil
 }
 
+// get serves the value from the near-cache or joins the identical read in flight, if any.
+// Only the values of the remote servers read without a server number are cached.
 func (c *Balancer) get(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.GetOptions) (models.Value, error) {
+	auto := opts.Server == constants.AutoServerNumber
+
+	if auto {
+		if v, ok := c.nearCache.Get(key); ok {
+			if !c.security.HasPermission(claims, v.Level) {
+				return models.Value{}, constants.ErrForbidden
+			}
+
+			return v, nil
+		}
+	}
+
+	gen := c.nearCache.Gen()
+
+	// the callers with the same level are allowed the same values, so they can share the read
+	level := -1
+	if claims.IsSome() {
+		level = int(claims.Unwrap().Level)
+	}
+	readKey := fmt.Sprintf("%d:%d:%s", level, opts.Server, key)
+
+	v, shared, err := c.reads.Do(ctx, readKey, func() (models.Value, error) {
+		// the read goes on for the others when the first caller gives up
+		ctx, cancel := deadline.Detached(ctx, c.timeouts.Read)
+		defer cancel()
+
+		return c.read(ctx, claims, key, opts)
+	})
+	if shared {
+		_coalesced.Add(1)
+	}
+	if err != nil {
+		return models.Value{}, err
+	}
+
+	if auto && c.isRemoteKey(key) {
+		c.nearCache.Put(key, v, gen)
+	}
+
+	return v, nil
+}
+
+// isRemoteKey reports whether the key is known to be kept on a remote server.
+func (c *Balancer) isRemoteKey(key string) bool {
+	server := c.getKeyServer(key)
+	return server.IsSome() && server.Unwrap() != constants.LocalServerNumber
+}
+
+func (c *Balancer) read(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.GetOptions) (models.Value, error) {
+	if opts.Server == constants.AutoServerNumber && c.quorum.Enabled() {
+		r := c.getReplicated(ctx, claims, key, opts)
+		if r.IsErr() {
+			return models.Value{}, r.Error()
+		}
+
+		if v := r.Unwrap(); v.IsSome() {
+			return v.Unwrap(), nil
+		}
+	}
+
+	return c.readPlaced(ctx, claims, key, opts)
+}
+
+// readPlaced reads the key from the server the catalog or the ring places it on, past the replicas.
+func (c *Balancer) readPlaced(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.GetOptions) (models.Value, error) {
 	if opts.Server == constants.AutoServerNumber {
 		res := c.getKeyServer(key)
 		if res.IsNone() {
-			if r := c.servers.DeepSearch(ctx, claims, key, opts); r.IsErr() {
-				return models.Value{}, fmt.Errorf("can't get key after deep search: %w", r.Error())
-			} else {
-				res := r.Unwrap()
-				c.addKeyServer(key, res.Left)
+			if owner := c.servers.KeyOwner(key); owner.IsSome() {
+				cl := owner.Unwrap()
+				r := cl.GetOne(ctx, claims, key, opts)
+				if r.IsOk() {
+					return unwrapValue(r.Unwrap())
+				}
 
-				return res.Right, nil
+				// until the rebalancer moves the key to its new owner, the old one still has it,
+				// so a miss falls back to the search
+				if !quorum.IsNotFound(r.Error()) {
+					return models.Value{}, r.Error().ExtendMsg(fmt.Sprintf("can't get key from server: %d", cl.Number()))
+				}
 			}
+
+			return c.search(ctx, claims, key, opts)
 		}
 
 		opts.Server = res.Unwrap()
@@ -88,12 +215,25 @@ func (c *Balancer) get(ctx context.Context, claims gost.Option[models.UserClaims
 
 	switch r := cl.GetOne(ctx, claims, key, opts); r.IsOk() {
 	case true:
-		return r.Unwrap(), nil
+		return unwrapValue(r.Unwrap())
 	default:
 		return models.Value{}, r.Error().ExtendMsg(fmt.Sprintf("can't get key from server: %d", cl.Number()))
 	}
 }
 
+// search asks every server for the key and remembers the one that has it.
+func (c *Balancer) search(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.GetOptions) (models.Value, error) {
+	r := c.servers.DeepSearch(ctx, claims, key, opts)
+	if r.IsErr() {
+		return models.Value{}, fmt.Errorf("can't get key after deep search: %w", r.Error())
+	}
+
+	res := r.Unwrap()
+	c.addKeyServer(key, res.Left)
+
+	return unwrapValue(res.Right)
+}
+
 func (c *Balancer) Connect(ctx context.Context, address string) (number int32, err error) {
 	return number, gost.WithContextPool(ctx, func() error {
 		number, err = c.servers.AddServer(ctx, address, false)
@@ -101,6 +241,8 @@ func (c *Balancer) Connect(ctx context.Context, address string) (number int32, e
 			return err
 		}
 
+		c.rebalancer.Rebalance(fmt.Sprintf("s#%d joined", number))
+
 		return nil
 	}, c.pool)
 }
@@ -112,14 +254,126 @@ func (c *Balancer) Disconnect(ctx context.Context, server int32) error {
 	}, c.pool)
 }
 
+// Drain stops placing new data on the server and moves its keys and objects to the other servers.
+// The server is disconnected once it is empty, the progress is reported by the rebalancer.
+// An offline server can't give its data away, it can only be disconnected.
+func (c *Balancer) Drain(ctx context.Context, server int32) error {
+	return gost.WithContextPool(ctx, func() error {
+		cl, ok := c.servers.GetServer(server)
+		if !ok {
+			return constants.ErrUnknownServer
+		}
+
+		if cl.IsOffline() {
+			return fmt.Errorf("%w: s#%d is offline, disconnect it instead", constants.ErrUnavailable, server)
+		}
+
+		leaving := c.servers.Leaving()
+
+		targets := 0
+		c.servers.Iter(func(cl domains.Server) error {
+			if n := cl.Number(); n != server && !slices.Contains(leaving, n) {
+				targets++
+			}
+			return nil
+		})
+
+		if targets == 0 {
+			return constants.ErrNoTargetServer.ExtendMsg(fmt.Sprintf("s#%d is the last online server", server))
+		}
+
+		c.logger.Info("draining server", zap.Int32("server", server))
+		c.rebalancer.Remove(server)
+
+		return nil
+	}, c.pool)
+}
+
+// SetMaintenance keeps new data off the server, or lets it take new data again.
+// Unlike Drain, the data stays on the server and is still served from it.
+func (c *Balancer) SetMaintenance(ctx context.Context, server int32, on bool) error {
+	if !c.servers.SetMaintenance(server, on) {
+		return constants.ErrUnknownServer
+	}
+
+	c.logger.Info("maintenance mode changed", zap.Int32("server", server), zap.Bool("on", on))
+
+	return nil
+}
+
+// Servers describes the servers in text for the public API, see ClusterInfo for the details.
 func (c *Balancer) Servers() []string {
-	return c.servers.GetServersInfo()
+	infos := c.servers.Info()
+
+	servers := make([]string, 0, len(infos))
+	for _, info := range infos {
+		var res string
+		if info.State == models.ServerOffline {
+			res = fmt.Sprintf("s#%d Offline", info.Number)
+		} else {
+			res = fmt.Sprintf("s#%d Avaliable: %d MB, Total: %d MB", info.Number, info.RAM.Available, info.RAM.Total)
+		}
+
+		switch info.State {
+		case models.ServerDraining:
+			res += ", Draining"
+		case models.ServerMaintenance:
+			res += ", Maintenance"
+		}
+
+		servers = append(servers, res)
+	}
+
+	return servers
+}
+
+// ClusterInfo describes every server, the online ones are asked about their storage in parallel.
+func (c *Balancer) ClusterInfo(ctx context.Context) (infos []models.ServerInfo, err error) {
+	return infos, gost.WithContextPool(ctx, func() error {
+		infos = c.servers.Info()
+
+		var wg sync.WaitGroup
+		for i := range infos {
+			if infos[i].State == models.ServerOffline {
+				infos[i].StatsError = "server is offline"
+				continue
+			}
+
+			cl, ok := c.servers.GetServer(infos[i].Number)
+			if !ok {
+				infos[i].StatsError = constants.ErrUnknownServer.Error()
+				continue
+			}
+
+			wg.Add(1)
+			go func(info *models.ServerInfo) {
+				defer wg.Done()
+
+				r := cl.NodeStats(ctx)
+				if r.IsErr() {
+					info.StatsError = r.Error().Error()
+					return
+				}
+
+				info.NodeStats = r.Unwrap()
+			}(&infos[i])
+		}
+		wg.Wait()
+
+		return nil
+	}, c.pool)
 }
 
 func (c *Balancer) Delete(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.DeleteOptions) (err error) {
-	return gost.WithContextPool(ctx, func() error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+
+	defer c.nearCache.Invalidate(key)
+
+	return c.run(ctx, c.timeouts.Write, func(ctx context.Context) error {
 		return c.delete(ctx, claims, key, opts)
-	}, c.pool)
+	})
 }
 
 func (c *Balancer) delete(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.DeleteOptions) (err error) {
@@ -129,6 +383,28 @@ func (c *Balancer) delete(ctx context.Context, claims gost.Option[models.UserCla
 			return constants.ErrNotFound
 		}
 		return nil
+	} else if opts.Server == constants.AutoServerNumber && c.quorum.Enabled() {
+		r := c.deleteReplicated(ctx, claims, key, opts)
+		if r.IsOk() {
+			return nil
+		}
+
+		if !quorum.IsNotFound(r.Error()) {
+			return r.Error()
+		}
+
+		// a key written before the replication was turned on is found only through the catalog
+		res := c.getKeyServer(key)
+		if res.IsNone() {
+			return constants.ErrNotFound
+		}
+
+		opts.Server = res.Unwrap()
+		defer func() {
+			if err == nil {
+				c.delKeyServer(key)
+			}
+		}()
 	} else if opts.Server == constants.AutoServerNumber {
 		switch res := c.getKeyServer(key); res.IsSome() {
 		case true:
@@ -139,6 +415,18 @@ func (c *Balancer) delete(ctx context.Context, claims gost.Option[models.UserCla
 				}
 			}()
 		default:
+			if owner := c.servers.KeyOwner(key); owner.IsSome() {
+				cl := owner.Unwrap()
+				r := cl.DelOne(ctx, claims, key, opts)
+				if r.IsOk() {
+					return nil
+				}
+
+				if !quorum.IsNotFound(r.Error()) {
+					return r.Error().ExtendMsg(fmt.Sprintf("can't delete key from server: %d", cl.Number()))
+				}
+			}
+
 			if r := c.servers.DeepSearch(ctx, claims, key, models.GetOptions{}); r.IsErr() {
 				return fmt.Errorf("can't delete key after deep search: %w", r.Error())
 			} else {
@@ -0,0 +1,294 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+	"itisadb/internal/service/quorum"
+
+	"github.com/egorgasay/gost"
+)
+
+// MGet reads the keys with one batch request per server, the servers are asked in parallel.
+// The results come in the order of the keys. The replicated keys are read from their replicas
+// with one batch request per replica server, the keys the balancer can't place are read one by one,
+// like Get does it.
+func (c *Balancer) MGet(ctx context.Context, claims gost.Option[models.UserClaims], keys []string, opts models.GetOptions) (results []models.GetResult, err error) {
+	return results, c.run(ctx, c.timeouts.Read, func(ctx context.Context) error {
+		results = c.mget(ctx, claims, keys, opts)
+		return nil
+	})
+}
+
+func (c *Balancer) mget(ctx context.Context, claims gost.Option[models.UserClaims], keys []string, opts models.GetOptions) []models.GetResult {
+	results := make([]models.GetResult, len(keys))
+
+	var (
+		batches    = make(map[int32][]int)
+		single     []int
+		replicated []int
+		// owned keys are placed by the ring, the catalog doesn't know them
+		owned = make([]bool, len(keys))
+	)
+
+	for i, key := range keys {
+		results[i].Key = key
+
+		switch {
+		case opts.Server != constants.AutoServerNumber:
+			batches[opts.Server] = append(batches[opts.Server], i)
+		case c.quorum.Enabled():
+			replicated = append(replicated, i)
+		default:
+			if s := c.getKeyServer(key); s.IsSome() {
+				batches[s.Unwrap()] = append(batches[s.Unwrap()], i)
+			} else if owner := c.servers.KeyOwner(key); owner.IsSome() {
+				number := owner.Unwrap().Number()
+				batches[number] = append(batches[number], i)
+				owned[i] = true
+			} else {
+				single = append(single, i)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	for number, batch := range batches {
+		wg.Add(1)
+		go func(number int32, batch []int) {
+			defer wg.Done()
+			c.mgetFrom(ctx, claims, number, keys, batch, opts, results)
+		}(number, batch)
+	}
+
+	for _, i := range single {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i].Value, results[i].Err = c.get(ctx, claims, keys[i], opts)
+		}(i)
+	}
+
+	if len(replicated) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.mgetReplicated(ctx, claims, keys, replicated, opts, results)
+		}()
+	}
+
+	wg.Wait()
+
+	// until the rebalancer moves a key to its new owner, the old one still has it
+	for i := range keys {
+		if !owned[i] || !isNotFound(results[i].Err) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i].Value, results[i].Err = c.search(ctx, claims, keys[i], opts)
+		}(i)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// mgetFrom reads the keys at the indexes of batch from one server into results.
+func (c *Balancer) mgetFrom(ctx context.Context, claims gost.Option[models.UserClaims], number int32, keys []string, batch []int, opts models.GetOptions, results []models.GetResult) {
+	fail := func(err error) {
+		for _, i := range batch {
+			results[i].Err = err
+		}
+	}
+
+	cl, ok := c.servers.GetServer(number)
+	if !ok || cl == nil {
+		fail(constants.ErrUnknownServer)
+		return
+	}
+
+	batchKeys := make([]string, len(batch))
+	for j, i := range batch {
+		batchKeys[j] = keys[i]
+	}
+
+	r := cl.MGet(ctx, claims, batchKeys, opts)
+	if r.IsErr() {
+		fail(r.Error().ExtendMsg(fmt.Sprintf("can't get keys from server: %d", number)))
+		return
+	}
+
+	got := r.Unwrap()
+	if len(got) != len(batch) {
+		fail(fmt.Errorf("server %d returned %d results for %d keys", number, len(got), len(batch)))
+		return
+	}
+
+	for j, i := range batch {
+		if got[j].Err != nil {
+			results[i].Err = got[j].Err
+			continue
+		}
+
+		results[i].Value, results[i].Err = unwrapValue(got[j].Value)
+	}
+}
+
+// MSet writes the values with one batch request per server, the servers are asked in parallel.
+// The results are sorted by key. The replicated keys are written to their replicas
+// with one batch request per replica server.
+func (c *Balancer) MSet(ctx context.Context, claims gost.Option[models.UserClaims], values map[string]string, opts models.SetOptions) (results []models.SetResult, err error) {
+	if err := c.writable(); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		c.nearCache.Invalidate(keys...)
+	}()
+
+	return results, c.run(ctx, c.timeouts.Write, func(ctx context.Context) error {
+		results = c.mset(ctx, claims, values, opts)
+		return nil
+	})
+}
+
+func (c *Balancer) mset(ctx context.Context, claims gost.Option[models.UserClaims], values map[string]string, opts models.SetOptions) []models.SetResult {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	results := make([]models.SetResult, len(keys))
+	index := make(map[string]int, len(keys))
+
+	var (
+		batches    = make(map[int32]map[string]string)
+		single     []int
+		replicated []int
+	)
+
+	for i, key := range keys {
+		results[i].Key = key
+		index[key] = i
+
+		if quorum.Reserved(values[key]) {
+			results[i].Err = constants.ErrReservedValue
+			continue
+		}
+
+		if opts.Server == constants.SetToAllServers {
+			single = append(single, i)
+			continue
+		}
+
+		if opts.Server == constants.AutoServerNumber && c.quorum.Enabled() {
+			replicated = append(replicated, i)
+			continue
+		}
+
+		cl, err := c.setServer(key, opts)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		if batches[cl.Number()] == nil {
+			batches[cl.Number()] = make(map[string]string)
+		}
+		batches[cl.Number()][key] = c.stamp(values[key])
+	}
+
+	var wg sync.WaitGroup
+
+	for number, batch := range batches {
+		wg.Add(1)
+		go func(number int32, batch map[string]string) {
+			defer wg.Done()
+			c.msetTo(ctx, claims, number, batch, opts, index, results)
+		}(number, batch)
+	}
+
+	for _, i := range single {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i].Server, results[i].Err = c.set(ctx, claims, keys[i], values[keys[i]], opts)
+		}(i)
+	}
+
+	if len(replicated) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.msetReplicated(ctx, claims, keys, replicated, values, opts, results)
+		}()
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// msetTo writes batch to one server, the results go to results at the indexes of their keys.
+func (c *Balancer) msetTo(ctx context.Context, claims gost.Option[models.UserClaims], number int32, batch map[string]string, opts models.SetOptions, index map[string]int, results []models.SetResult) {
+	fail := func(err error) {
+		for key := range batch {
+			results[index[key]].Err = err
+		}
+	}
+
+	cl, ok := c.servers.GetServer(number)
+	if !ok || cl == nil {
+		fail(constants.ErrUnknownServer)
+		return
+	}
+
+	opts.Server = number
+
+	r := cl.MSet(ctx, claims, batch, opts)
+	if r.IsErr() {
+		fail(r.Error().ExtendMsg(fmt.Sprintf("can't set keys to server: %d", number)))
+		return
+	}
+
+	answered := make(map[string]bool, len(batch))
+	for _, res := range r.Unwrap() {
+		if _, sent := batch[res.Key]; !sent {
+			continue
+		}
+		answered[res.Key] = true
+
+		i := index[res.Key]
+		results[i].Server, results[i].Err = number, res.Err
+		if res.Err == nil {
+			c.addKeyServer(res.Key, number)
+		}
+	}
+
+	for key := range batch {
+		if !answered[key] {
+			results[index[key]].Err = fmt.Errorf("server %d returned no result for the key", number)
+		}
+	}
+}
+
+// isNotFound is quorum.IsNotFound for the errors of the batch results.
+func isNotFound(err error) bool {
+	var errX *gost.ErrX
+	return errors.As(err, &errX) && quorum.IsNotFound(errX)
+}
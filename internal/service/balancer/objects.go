@@ -6,16 +6,22 @@ import (
 	"strings"
 
 	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
 	"itisadb/internal/constants"
 	"itisadb/internal/domains"
 	"itisadb/internal/models"
+	"itisadb/internal/service/reference"
 )
 
 func (c *Balancer) Object(ctx context.Context, claims gost.Option[models.UserClaims], name string, opts models.ObjectOptions) (s int32, err error) {
-	return s, gost.WithContextPool(ctx, func() error {
+	if err := c.writable(); err != nil {
+		return 0, err
+	}
+
+	return s, c.run(ctx, c.timeouts.Object, func(ctx context.Context) error {
 		s, err = c.object(ctx, claims, name, opts)
 		return err
-	}, c.pool)
+	})
 }
 
 func (c *Balancer) addObjectServer(object string, server int32) {
@@ -24,8 +30,9 @@ func (c *Balancer) addObjectServer(object string, server int32) {
 		object = objects[0]
 	}
 
-	defer c.objectServers.WRelease()
-	(*c.objectServers.WBorrow().Ref())[object] = server
+	if r := c.catalog.SetObjectServer(object, server); r.IsErr() {
+		c.logger.Error("can't save object server", zap.String("object", object), zap.Error(r.Error()))
+	}
 }
 
 func (c *Balancer) getObjectServer(object string) (opt gost.Option[int32]) {
@@ -34,40 +41,29 @@ func (c *Balancer) getObjectServer(object string) (opt gost.Option[int32]) {
 		object = objects[0]
 	}
 
-	defer c.objectServers.Release()
-
-	server, ok := c.objectServers.RBorrow().Read()[object]
-	if !ok {
-		return opt.None()
-	}
-
-	return opt.Some(server)
+	return c.catalog.ObjectServer(object)
 }
 
 func (c *Balancer) delObjectServer(object string) {
-	defer c.objectServers.WRelease()
-	delete(c.objectServers.WBorrow().Read(), object)
+	if r := c.catalog.DelObjectServer(object); r.IsErr() {
+		c.logger.Error("can't delete object server", zap.String("object", object), zap.Error(r.Error()))
+	}
 }
 
 func (c *Balancer) addKeyServer(key string, server int32) {
-	defer c.keyServers.WRelease()
-	(*c.keyServers.WBorrow().Ref())[key] = server
+	if r := c.catalog.SetKeyServer(key, server); r.IsErr() {
+		c.logger.Error("can't save key server", zap.String("key", key), zap.Error(r.Error()))
+	}
 }
 
 func (c *Balancer) getKeyServer(key string) (opt gost.Option[int32]) {
-	defer c.keyServers.Release()
-
-	server, ok := c.keyServers.RBorrow().Read()[key]
-	if !ok {
-		return opt.None()
-	}
-
-	return opt.Some(server)
+	return c.catalog.KeyServer(key)
 }
 
 func (c *Balancer) delKeyServer(key string) {
-	defer c.keyServers.WRelease()
-	delete(*c.keyServers.WBorrow().Ref(), key)
+	if r := c.catalog.DelKeyServer(key); r.IsErr() {
+		c.logger.Error("can't delete key server", zap.String("key", key), zap.Error(r.Error()))
+	}
 }
 
 func (c *Balancer) isObject(ctx context.Context, claims gost.Option[models.UserClaims], object string) (res gost.Result[gost.Option[int32]]) {
@@ -93,7 +89,11 @@ func (c *Balancer) isObject(ctx context.Context, claims gost.Option[models.UserC
 }
 
 func (c *Balancer) object(ctx context.Context, claims gost.Option[models.UserClaims], object string, opts models.ObjectOptions) (int32, error) {
-	r := c.findServerForObject(ctx, claims, object, opts.Server)
+	if opts.Shards > 1 {
+		return c.newShardedObject(ctx, claims, object, opts)
+	}
+
+	r := c.findServerForObject(ctx, claims, object, opts.Server, opts.Placement)
 	if r.IsErr() {
 		return 0, r.Error()
 	}
@@ -110,13 +110,20 @@ func (c *Balancer) object(ctx context.Context, claims gost.Option[models.UserCla
 }
 
 func (c *Balancer) GetFromObject(ctx context.Context, claims gost.Option[models.UserClaims], object, key string, opts models.GetFromObjectOptions) (v string, err error) {
-	return v, gost.WithContextPool(ctx, func() error {
+	return v, c.run(ctx, c.timeouts.Object, func(ctx context.Context) error {
 		v, err = c.getFromObject(ctx, claims, object, key, opts)
 		return err
-	}, c.pool)
+	})
 }
 
-func (c *Balancer) findServerForObject(ctx context.Context, claims gost.Option[models.UserClaims], object string, server int32) (res gost.Result[domains.Server]) {
+// findServerForObject returns the server that holds the object, or the one to create it on.
+// The placement hint is used only to choose the server for a new object.
+func (c *Balancer) findServerForObject(ctx context.Context, claims gost.Option[models.UserClaims], object string, server int32, placement string) (res gost.Result[domains.Server]) {
+	// the nested objects of a sharded object are on the shards of the attributes they are under
+	if number, ok := c.shardOf(object, ""); ok {
+		return c.shardServer(number, server)
+	}
+
 	objects := strings.Split(object, constants.ObjectSeparator)
 	var resolvedServer int32
 
@@ -137,10 +144,25 @@ func (c *Balancer) findServerForObject(ctx context.Context, claims gost.Option[m
 	isRequestedServerAuto := server == constants.AutoServerNumber
 	isResolvedServerNone := resolvedServer == 0
 
+	if isRequestedServerAuto && isResolvedServerNone {
+		if placement != "" {
+			return c.servers.Place(placement)
+		}
+
+		if owner := c.servers.KeyOwner(objects[0]); owner.IsSome() {
+			return res.Ok(owner.Unwrap())
+		}
+	}
+
 	if !isRequestedServerAuto && !isResolvedServerNone && server != resolvedServer {
 		return res.Err(constants.ErrAlreadyExists.ExtendMsg(fmt.Sprintf("can't get inner object[%d] from different[%d] server", server, serverOpt)))
 	}
 
+	// no server holds the object yet, it goes to the requested one
+	if isResolvedServerNone {
+		resolvedServer = server
+	}
+
 	s, ok := c.servers.GetServer(resolvedServer)
 	if !ok {
 		return res.Err(constants.ErrServerNotFound)
@@ -150,27 +172,55 @@ func (c *Balancer) findServerForObject(ctx context.Context, claims gost.Option[m
 }
 
 func (c *Balancer) getFromObject(ctx context.Context, claims gost.Option[models.UserClaims], object, key string, opts models.GetFromObjectOptions) (string, error) {
-	r := c.findServerForObject(ctx, claims, object, opts.Server)
-	if r.IsErr() {
-		return "", r.Error()
+	v, err := throughReference(ctx, c, claims, object, func(object string) (string, error) {
+		r := c.findServerForAttr(ctx, claims, object, key, opts.Server)
+		if r.IsErr() {
+			return "", r.Error()
+		}
+
+		if r := r.Unwrap().GetFromObject(ctx, claims, object, key, opts); r.IsErr() {
+			return "", r.Error()
+		} else {
+			return r.Unwrap(), nil
+		}
+	})
+	if err != nil {
+		return "", err
 	}
 
-	if r := r.Unwrap().GetFromObject(ctx, claims, object, key, opts); r.IsErr() {
-		return "", r.Error()
-	} else {
-		return r.Unwrap(), nil
+	// an attached object is not a value, wherever it lives
+	if _, ok := reference.Decode(v); ok {
+		return "", constants.ErrObjectNotFound
 	}
+
+	return v, nil
 }
 
+// SetToObject sets the attribute of the object. A value that looks like a reference is rejected,
+// the balancer would follow it as an attached object.
 func (c *Balancer) SetToObject(ctx context.Context, claims gost.Option[models.UserClaims], object, key, val string, opts models.SetToObjectOptions) (s int32, err error) {
-	return s, gost.WithContextPool(ctx, func() error {
+	if err := c.writable(); err != nil {
+		return 0, err
+	}
+
+	if _, ok := reference.Decode(val); ok {
+		return 0, constants.ErrReservedValue
+	}
+
+	return s, c.run(ctx, c.timeouts.Object, func(ctx context.Context) error {
 		s, err = c.setToObject(ctx, claims, object, key, val, opts)
 		return err
-	}, c.pool)
+	})
 }
 
 func (c *Balancer) setToObject(ctx context.Context, claims gost.Option[models.UserClaims], object, key, val string, opts models.SetToObjectOptions) (int32, error) {
-	r := c.findServerForObject(ctx, claims, object, opts.Server)
+	return throughReference(ctx, c, claims, object, func(object string) (int32, error) {
+		return c.setToObjectOn(ctx, claims, object, key, val, opts)
+	})
+}
+
+func (c *Balancer) setToObjectOn(ctx context.Context, claims gost.Option[models.UserClaims], object, key, val string, opts models.SetToObjectOptions) (int32, error) {
+	r := c.findServerForAttr(ctx, claims, object, key, opts.Server)
 	if r.IsErr() {
 		return 0, r.Error()
 	}
@@ -182,20 +232,46 @@ func (c *Balancer) setToObject(ctx context.Context, claims gost.Option[models.Us
 		return 0, fmt.Errorf("can't set object: %w", rSet.Error())
 	}
 
-	c.addObjectServer(object, cl.Number())
+	// the object route of a sharded object leads to its first shard
+	if _, sharded := c.shardOf(object, key); !sharded {
+		c.addObjectServer(object, cl.Number())
+	}
 
 	return cl.Number(), nil
 }
 
-func (c *Balancer) ObjectToJSON(ctx context.Context, claims gost.Option[models.UserClaims], object string, opts models.ObjectToJSONOptions) (string, error) {
-	r := c.findServerForObject(ctx, claims, object, opts.Server)
+// ObjectToJSON returns the object with the objects attached from other servers in place of their references.
+func (c *Balancer) ObjectToJSON(ctx context.Context, claims gost.Option[models.UserClaims], object string, opts models.ObjectToJSONOptions) (data string, err error) {
+	return data, c.run(ctx, c.timeouts.Object, func(ctx context.Context) error {
+		data, err = throughReference(ctx, c, claims, object, func(object string) (string, error) {
+			return c.objectToJSON(ctx, claims, object, opts)
+		})
+		if err != nil {
+			return err
+		}
+
+		data, err = reference.Expand(data, c.fetcher(ctx, claims))
+		return err
+	})
+}
+
+// objectToJSON returns the JSON of the object as its servers store it, the shards merged.
+func (c *Balancer) objectToJSON(ctx context.Context, claims gost.Option[models.UserClaims], object string, opts models.ObjectToJSONOptions) (string, error) {
+	servers, sharded, err := c.shards(object)
+	if err != nil {
+		return "", err
+	}
+
+	if sharded {
+		return c.shardedJSON(ctx, claims, object, servers, opts)
+	}
+
+	r := c.findServerForObject(ctx, claims, object, opts.Server, "")
 	if r.IsErr() {
 		return "", r.Error()
 	}
 
-	cl := r.Unwrap()
-
-	resObj := cl.ObjectToJSON(ctx, claims, object, opts)
+	resObj := r.Unwrap().ObjectToJSON(ctx, claims, object, opts)
 	if resObj.IsErr() {
 		return "", resObj.Error()
 	}
@@ -212,30 +288,54 @@ func (c *Balancer) IsObject(ctx context.Context, claims gost.Option[models.UserC
 }
 
 func (c *Balancer) Size(ctx context.Context, claims gost.Option[models.UserClaims], name string, opts models.SizeOptions) (size uint64, err error) {
-	return size, gost.WithContextPool(ctx, func() error {
+	return size, c.run(ctx, c.timeouts.Object, func(ctx context.Context) error {
 		size, err = c.size(ctx, claims, name, opts)
 		return err
-	}, c.pool)
+	})
 }
 
 func (c *Balancer) size(ctx context.Context, claims gost.Option[models.UserClaims], object string, opts models.SizeOptions) (uint64, error) {
-	r := c.findServerForObject(ctx, claims, object, opts.Server)
-	if r.IsErr() {
-		return 0, r.Error()
-	}
+	return throughReference(ctx, c, claims, object, func(object string) (uint64, error) {
+		servers, sharded, err := c.shards(object)
+		if err != nil {
+			return 0, err
+		}
+
+		if sharded {
+			return c.shardedSize(ctx, claims, object, servers, opts)
+		}
 
-	res := r.Unwrap().ObjectSize(ctx, claims, object, opts)
-	return res.UnwrapOrDefault(), res.ErrorStd()
+		r := c.findServerForObject(ctx, claims, object, opts.Server, "")
+		if r.IsErr() {
+			return 0, r.Error()
+		}
+
+		res := r.Unwrap().ObjectSize(ctx, claims, object, opts)
+		return res.UnwrapOrDefault(), res.ErrorStd()
+	})
 }
 
 func (c *Balancer) DeleteObject(ctx context.Context, claims gost.Option[models.UserClaims], object string, opts models.DeleteObjectOptions) error {
-	return gost.WithContextPool(ctx, func() error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+
+	return c.run(ctx, c.timeouts.Object, func(ctx context.Context) error {
 		return c.deleteObject(ctx, claims, object, opts)
-	}, c.pool)
+	})
 }
 
 func (c *Balancer) deleteObject(ctx context.Context, claims gost.Option[models.UserClaims], object string, opts models.DeleteObjectOptions) error {
-	r := c.findServerForObject(ctx, claims, object, opts.Server)
+	servers, sharded, err := c.shards(object)
+	if err != nil {
+		return err
+	}
+
+	if sharded {
+		return c.deleteSharded(ctx, claims, object, servers, opts)
+	}
+
+	r := c.findServerForObject(ctx, claims, object, opts.Server, "")
 	if r.IsErr() {
 		return r.Error()
 	}
@@ -250,9 +350,13 @@ func (c *Balancer) deleteObject(ctx context.Context, claims gost.Option[models.U
 }
 
 func (c *Balancer) AttachToObject(ctx context.Context, claims gost.Option[models.UserClaims], dst, src string, opts models.AttachToObjectOptions) error {
-	return gost.WithContextPool(ctx, func() error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+
+	return c.run(ctx, c.timeouts.Object, func(ctx context.Context) error {
 		return c.attachToObject(ctx, claims, dst, src, opts)
-	}, c.pool)
+	})
 }
 
 func (c *Balancer) attachToObject(ctx context.Context, claims gost.Option[models.UserClaims], dst, src string, opts models.AttachToObjectOptions) error {
@@ -275,8 +379,10 @@ func (c *Balancer) attachToObject(ctx context.Context, claims gost.Option[models
 
 		server2 := res.Unwrap()
 
-		if server1 != server2 {
-			return fmt.Errorf("can't attach to objects from different servers: %w", constants.ErrForbidden)
+		// a server attaches only its own objects, the balancer keeps a reference to the other one.
+		// The attributes of a sharded object are spread by their keys, so a reference is kept for them too.
+		if server1 != server2 || c.isSharded(dst) || c.isSharded(src) {
+			return c.attachReference(ctx, claims, dst, src)
 		}
 
 		opts.Server = server1
@@ -295,22 +401,26 @@ func (c *Balancer) attachToObject(ctx context.Context, claims gost.Option[models
 }
 
 func (c *Balancer) DeleteAttr(ctx context.Context, claims gost.Option[models.UserClaims], key string, object string, opts models.DeleteAttrOptions) error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
-	return gost.WithContextPool(ctx, func() error {
+	return c.run(ctx, c.timeouts.Object, func(ctx context.Context) error {
 		return c.deleteAttr(ctx, claims, key, object, opts)
-	}, c.pool)
+	})
 }
 
 func (c *Balancer) deleteAttr(ctx context.Context, claims gost.Option[models.UserClaims], key, object string, opts models.DeleteAttrOptions) error {
-	r := c.findServerForObject(ctx, claims, object, opts.Server)
+	r := c.findServerForAttr(ctx, claims, object, key, opts.Server)
 	if r.IsErr() {
 		return r.Error()
 	}
 
-	if r := r.Unwrap().ObjectDeleteKey(ctx, claims, key, object, opts); r.IsErr() {
+	if r := r.Unwrap().ObjectDeleteKey(ctx, claims, object, key, opts); r.IsErr() {
 		return fmt.Errorf("can't delete attr: %w", r.Error())
 	}
 
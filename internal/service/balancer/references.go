@@ -0,0 +1,124 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+	"itisadb/internal/service/quorum"
+	"itisadb/internal/service/reference"
+
+	"github.com/egorgasay/gost"
+)
+
+// attachReference attaches src to dst, which live on different servers, as a reference.
+func (c *Balancer) attachReference(ctx context.Context, claims gost.Option[models.UserClaims], dst, src string) error {
+	name := reference.Name(src)
+
+	target, ok, err := c.reference(ctx, claims, dst, name)
+	switch {
+	case err != nil:
+		return err
+	case ok && target == src:
+		return nil
+	}
+
+	cycle, err := reference.Reaches(src, dst, c.fetcher(ctx, claims))
+	if err != nil {
+		return fmt.Errorf("can't check the attachment for cycles: %w", err)
+	}
+
+	if cycle {
+		return constants.ErrCircularAttachment
+	}
+
+	// the attribute must not exist, a plain value is not replaced by the reference
+	if _, err := c.setToObject(ctx, claims, dst, name, reference.Encode(src), models.SetToObjectOptions{ReadOnly: true}); err != nil {
+		return fmt.Errorf("can't attach to object: %w", err)
+	}
+
+	return nil
+}
+
+// reference returns the object the attribute key of object refers to,
+// ok is false when the attribute is a plain value or there is no such attribute.
+func (c *Balancer) reference(ctx context.Context, claims gost.Option[models.UserClaims], object, key string) (string, bool, error) {
+	// no server holds the object, so there is nothing to follow
+	r := c.findServerForAttr(ctx, claims, object, key, constants.AutoServerNumber)
+	if r.IsErr() {
+		return "", false, nil
+	}
+
+	rGet := r.Unwrap().GetFromObject(ctx, claims, object, key, models.GetFromObjectOptions{})
+	if rGet.IsErr() {
+		if isObjectNotFound(rGet.Error()) {
+			return "", false, nil
+		}
+		return "", false, rGet.Error()
+	}
+
+	target, ok := reference.Decode(rGet.Unwrap())
+	return target, ok, nil
+}
+
+// resolve returns the object the path leads to through the references.
+func (c *Balancer) resolve(ctx context.Context, claims gost.Option[models.UserClaims], object string) (string, error) {
+	return reference.Resolve(object, func(object, key string) (string, bool, error) {
+		return c.reference(ctx, claims, object, key)
+	})
+}
+
+// fetcher returns the JSON of the objects as their servers store them.
+func (c *Balancer) fetcher(ctx context.Context, claims gost.Option[models.UserClaims]) reference.FetchFunc {
+	return func(object string) (string, error) {
+		data, err := c.objectToJSON(ctx, claims, object, models.ObjectToJSONOptions{})
+		if err != nil {
+			if isServerNotFound(err) || isObjectNotFound(err) {
+				return "", fmt.Errorf("%w: %s", reference.ErrDangling, object)
+			}
+			return "", err
+		}
+
+		return data, nil
+	}
+}
+
+// throughReference runs f with the object, and again with the object the path leads to
+// when the object is not found and its path goes through a reference.
+func throughReference[T any](ctx context.Context, c *Balancer, claims gost.Option[models.UserClaims], object string, f func(object string) (T, error)) (T, error) {
+	v, err := f(object)
+	if err == nil || !isObjectNotFound(err) {
+		return v, err
+	}
+
+	resolved, errResolve := c.resolve(ctx, claims, object)
+	if errResolve != nil {
+		return v, errResolve
+	}
+
+	if resolved == object {
+		return v, err
+	}
+
+	return f(resolved)
+}
+
+// isServerNotFound reports whether err says that no server holds the object.
+func isServerNotFound(err error) bool {
+	var errX *gost.ErrX
+	return errors.As(err, &errX) && errX.Messages()[0] == constants.ErrServerNotFound.Message()
+}
+
+// isObjectNotFound reports whether err says that the object or its attribute is missing.
+// The local server says it with constants.ErrObjectNotFound, a remote one with the error of the SDK.
+func isObjectNotFound(err error) bool {
+	var errX *gost.ErrX
+	if !errors.As(err, &errX) {
+		return false
+	}
+
+	return quorum.IsNotFound(errX) || slices.Contains(errX.Messages(), constants.ErrObjectNotFound.Message())
+}
@@ -0,0 +1,122 @@
+package balancer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/egorgasay/gost"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/quorum"
+)
+
+func (c *Balancer) setReplicated(ctx context.Context, claims gost.Option[models.UserClaims], key, val string, opts models.SetOptions) (int32, error) {
+	replicas := c.servers.KeyReplicas(key, c.quorum.N())
+
+	r := c.quorum.Set(ctx, replicas, claims, key, val, opts)
+	if r.IsErr() {
+		return 0, r.Error()
+	}
+
+	return r.Unwrap(), nil
+}
+
+// getReplicated returns None when no replica knows the key,
+// it may have been written before the replication was turned on.
+func (c *Balancer) getReplicated(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.GetOptions) (res gost.Result[gost.Option[models.Value]]) {
+	replicas := c.servers.KeyReplicas(key, c.quorum.N())
+
+	r := c.quorum.Get(ctx, replicas, claims, key, opts)
+	if r.IsErr() {
+		return res.Err(r.Error())
+	}
+
+	v := r.Unwrap()
+	if v.IsNone() {
+		return res.Ok(gost.None[models.Value]())
+	}
+
+	if v.Unwrap().Tombstone {
+		return res.Err(constants.ErrNotFound)
+	}
+
+	return res.Ok(gost.Some(v.Unwrap().Value))
+}
+
+// msetReplicated writes the values of the keys at the indexes to their replicas, one request per server.
+func (c *Balancer) msetReplicated(ctx context.Context, claims gost.Option[models.UserClaims], keys []string, indexes []int, values map[string]string, opts models.SetOptions, results []models.SetResult) {
+	replicas := make(map[string][]domains.Server, len(indexes))
+	batch := make(map[string]string, len(indexes))
+	for _, i := range indexes {
+		replicas[keys[i]] = c.servers.KeyReplicas(keys[i], c.quorum.N())
+		batch[keys[i]] = values[keys[i]]
+	}
+
+	got := c.quorum.MSet(ctx, replicas, claims, batch, opts)
+	for _, i := range indexes {
+		if r := got[keys[i]]; r.IsErr() {
+			results[i].Err = r.Error()
+		} else {
+			results[i].Server = r.Unwrap()
+		}
+	}
+}
+
+// mgetReplicated reads the keys at the indexes from their replicas, one request per server.
+// A key no replica knows may have been written before the replication was turned on, it is read past the replicas.
+func (c *Balancer) mgetReplicated(ctx context.Context, claims gost.Option[models.UserClaims], keys []string, indexes []int, opts models.GetOptions, results []models.GetResult) {
+	replicas := make(map[string][]domains.Server, len(indexes))
+	for _, i := range indexes {
+		replicas[keys[i]] = c.servers.KeyReplicas(keys[i], c.quorum.N())
+	}
+
+	got := c.quorum.MGet(ctx, replicas, claims, opts)
+
+	var wg sync.WaitGroup
+	for _, i := range indexes {
+		r := got[keys[i]]
+
+		switch {
+		case r.IsErr():
+			results[i].Err = r.Error()
+		case r.Unwrap().IsNone():
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i].Value, results[i].Err = c.readPlaced(ctx, claims, keys[i], opts)
+			}(i)
+		case r.Unwrap().Unwrap().Tombstone:
+			results[i].Err = constants.ErrNotFound
+		default:
+			results[i].Value = r.Unwrap().Unwrap().Value
+		}
+	}
+
+	wg.Wait()
+}
+
+func (c *Balancer) deleteReplicated(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.DeleteOptions) gost.ResultN {
+	replicas := c.servers.KeyReplicas(key, c.quorum.N())
+	return c.quorum.Delete(ctx, replicas, claims, key, opts)
+}
+
+// stamp versions a value written past the coordinator while the keys are replicated,
+// so the replicas don't take it for older than the copies they keep.
+func (c *Balancer) stamp(val string) string {
+	if !c.quorum.Enabled() {
+		return val
+	}
+
+	return c.quorum.Stamp(val)
+}
+
+// unwrapValue strips the version that replication keeps inside the value.
+func unwrapValue(v models.Value) (models.Value, error) {
+	versioned := quorum.Decode(v)
+	if versioned.Tombstone {
+		return models.Value{}, constants.ErrNotFound
+	}
+
+	return versioned.Value, nil
+}
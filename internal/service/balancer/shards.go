@@ -0,0 +1,266 @@
+package balancer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"sync"
+
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+// A sharded object is created on several servers, each of them keeps the attributes
+// whose keys hash to its shard. A nested object goes with the attribute it is under,
+// so the paths are routed by their first part under the root.
+// The catalog keeps the shards, the object route leads to the first one.
+
+// shardIndex returns the shard of the n ones the attribute key belongs to.
+func shardIndex(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// shardOf returns the number of the server with the shard the attribute key of the object belongs to.
+// sharded is false when the object is not sharded, or the request is about the whole top-level object.
+func (c *Balancer) shardOf(object, key string) (number int32, sharded bool) {
+	parts := strings.Split(object, constants.ObjectSeparator)
+
+	shards := c.catalog.ObjectShards(parts[0])
+	if shards.IsNone() {
+		return 0, false
+	}
+
+	if len(parts) > 1 {
+		key = parts[1]
+	}
+
+	if key == "" {
+		return 0, false
+	}
+
+	servers := shards.Unwrap()
+	return servers[shardIndex(key, len(servers))], true
+}
+
+// isSharded reports whether the object or the top-level object it is in is sharded.
+func (c *Balancer) isSharded(object string) bool {
+	root, _, _ := strings.Cut(object, constants.ObjectSeparator)
+	return c.catalog.ObjectShards(root).IsSome()
+}
+
+// shardServer returns the server with the shard, the requested server must be this one.
+func (c *Balancer) shardServer(number, requested int32) (res gost.Result[domains.Server]) {
+	if requested != constants.AutoServerNumber && requested != number {
+		return res.Err(constants.ErrAlreadyExists.ExtendMsg(fmt.Sprintf("the shard is on server %d, not on %d", number, requested)))
+	}
+
+	s, ok := c.servers.GetServer(number)
+	if !ok {
+		return res.Err(constants.ErrServerNotFound)
+	}
+
+	return res.Ok(s)
+}
+
+// findServerForAttr returns the server that holds the attribute key of the object.
+func (c *Balancer) findServerForAttr(ctx context.Context, claims gost.Option[models.UserClaims], object, key string, server int32) gost.Result[domains.Server] {
+	if number, ok := c.shardOf(object, key); ok {
+		return c.shardServer(number, server)
+	}
+
+	return c.findServerForObject(ctx, claims, object, server, "")
+}
+
+// shards returns the servers of the shards of a sharded top-level object, each server once.
+func (c *Balancer) shards(object string) (servers []domains.Server, sharded bool, err error) {
+	if strings.Contains(object, constants.ObjectSeparator) {
+		return nil, false, nil
+	}
+
+	numbers := c.catalog.ObjectShards(object)
+	if numbers.IsNone() {
+		return nil, false, nil
+	}
+
+	for _, number := range numbers.Unwrap() {
+		s, ok := c.servers.GetServer(number)
+		if !ok {
+			// without the shard the answer would be incomplete
+			return nil, true, constants.ErrServerNotFound
+		}
+
+		// a shard of a server that left could have been moved to a server with another shard
+		if !slices.Contains(servers, s) {
+			servers = append(servers, s)
+		}
+	}
+
+	return servers, true, nil
+}
+
+// newShardedObject creates the object on opts.Shards servers.
+func (c *Balancer) newShardedObject(ctx context.Context, claims gost.Option[models.UserClaims], object string, opts models.ObjectOptions) (int32, error) {
+	if strings.Contains(object, constants.ObjectSeparator) {
+		return 0, constants.ErrNestedShards
+	}
+
+	// the object exists already, it keeps its servers
+	if c.getObjectServer(object).IsSome() {
+		return c.object(ctx, claims, object, models.ObjectOptions{Server: opts.Server, Level: opts.Level})
+	}
+
+	rPlace := c.servers.PlaceN(opts.Placement, int(opts.Shards))
+	if rPlace.IsErr() {
+		return 0, rPlace.Error()
+	}
+
+	servers := rPlace.Unwrap()
+	numbers := make([]int32, len(servers))
+
+	for i, s := range servers {
+		if r := s.NewObject(ctx, claims, object, models.ObjectOptions{Server: s.Number(), Level: opts.Level}); r.IsErr() {
+			c.dropShards(ctx, claims, object, servers[:i])
+			return 0, fmt.Errorf("can't create shard on server %d: %w", s.Number(), r.Error())
+		}
+
+		numbers[i] = s.Number()
+	}
+
+	if r := c.catalog.SetObjectShards(object, numbers); r.IsErr() {
+		c.logger.Error("can't save object shards", zap.String("object", object), zap.Error(r.Error()))
+		c.dropShards(ctx, claims, object, servers)
+		return 0, r.Error()
+	}
+
+	c.addObjectServer(object, numbers[0])
+
+	return numbers[0], nil
+}
+
+// dropShards deletes the shards created before the object failed to be created,
+// they would be found by nobody. It runs even when the request is canceled.
+func (c *Balancer) dropShards(ctx context.Context, claims gost.Option[models.UserClaims], object string, servers []domains.Server) {
+	ctx = context.WithoutCancel(ctx)
+
+	for _, s := range servers {
+		if r := s.DeleteObject(ctx, claims, object, models.DeleteObjectOptions{Server: s.Number()}); r.IsErr() {
+			c.logger.Error("can't delete shard", zap.String("object", object), zap.Int32("server", s.Number()), zap.Error(r.Error()))
+		}
+	}
+}
+
+// gather calls f on the servers in parallel and returns the results in their order.
+func gather[T any](servers []domains.Server, f func(domains.Server) (T, error)) ([]T, error) {
+	var (
+		wg      sync.WaitGroup
+		results = make([]T, len(servers))
+		errs    = make([]error, len(servers))
+	)
+
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, s domains.Server) {
+			defer wg.Done()
+			results[i], errs[i] = f(s)
+		}(i, s)
+	}
+
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("shard on server %d: %w", servers[i].Number(), err)
+		}
+	}
+
+	return results, nil
+}
+
+// shardedSize sums the sizes of the shards.
+func (c *Balancer) shardedSize(ctx context.Context, claims gost.Option[models.UserClaims], object string, servers []domains.Server, opts models.SizeOptions) (uint64, error) {
+	sizes, err := gather(servers, func(s domains.Server) (uint64, error) {
+		opts := opts
+		opts.Server = s.Number()
+		r := s.ObjectSize(ctx, claims, object, opts)
+		return r.UnwrapOrDefault(), r.ErrorStd()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var size uint64
+	for _, n := range sizes {
+		size += n
+	}
+
+	return size, nil
+}
+
+// shardedJSON merges the values of the shards into the JSON of one object.
+func (c *Balancer) shardedJSON(ctx context.Context, claims gost.Option[models.UserClaims], object string, servers []domains.Server, opts models.ObjectToJSONOptions) (string, error) {
+	parts, err := gather(servers, func(s domains.Server) (string, error) {
+		opts := opts
+		opts.Server = s.Number()
+		r := s.ObjectToJSON(ctx, claims, object, opts)
+		return r.UnwrapOrDefault(), r.ErrorStd()
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var merged map[string]any
+	values := make([]any, 0)
+
+	for _, part := range parts {
+		var obj map[string]any
+		if err := json.Unmarshal([]byte(part), &obj); err != nil {
+			return "", constants.ErrInternal.ExtendMsg(fmt.Sprintf("invalid shard JSON: %v", err))
+		}
+
+		if merged == nil {
+			merged = obj
+		}
+
+		if v, ok := obj["values"].([]any); ok {
+			values = append(values, v...)
+		}
+	}
+
+	merged["values"] = values
+
+	b, err := json.MarshalIndent(merged, "", "\t")
+	if err != nil {
+		return "", constants.ErrInternal.ExtendMsg(err.Error())
+	}
+
+	return string(b), nil
+}
+
+// deleteSharded deletes the shards of the object and forgets them.
+func (c *Balancer) deleteSharded(ctx context.Context, claims gost.Option[models.UserClaims], object string, servers []domains.Server, opts models.DeleteObjectOptions) error {
+	_, err := gather(servers, func(s domains.Server) (struct{}, error) {
+		opts := opts
+		opts.Server = s.Number()
+		return struct{}{}, s.DeleteObject(ctx, claims, object, opts).ErrorStd()
+	})
+	if err != nil {
+		return fmt.Errorf("can't delete object: %w", err)
+	}
+
+	if r := c.catalog.DelObjectShards(object); r.IsErr() {
+		c.logger.Error("can't delete object shards", zap.String("object", object), zap.Error(r.Error()))
+	}
+
+	c.delObjectServer(object)
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+	"itisadb/internal/service/quorum"
+
+	"github.com/egorgasay/gost"
+)
+
+// Transact applies the writes all at once: the keys are grouped by their servers,
+// which commit their parts with a two-phase commit, so either every write is applied or none.
+// A later write of a key replaces the earlier one. The replicated keys are not supported.
+func (c *Balancer) Transact(ctx context.Context, claims gost.Option[models.UserClaims], ops []models.TxOp, opts models.SetOptions) (id string, err error) {
+	if err := c.writable(); err != nil {
+		return "", err
+	}
+
+	for _, op := range ops {
+		if !op.Delete && quorum.Reserved(op.Value) {
+			return "", constants.ErrReservedValue
+		}
+	}
+
+	if c.coordinator.IsNone() {
+		return "", constants.ErrNoCoordinator
+	}
+
+	if opts.Server == constants.SetToAllServers || (opts.Server == constants.AutoServerNumber && c.quorum.Enabled()) {
+		return "", constants.ErrTxReplicated
+	}
+
+	defer func() {
+		keys := make([]string, len(ops))
+		for i, op := range ops {
+			keys[i] = op.Key
+		}
+		c.nearCache.Invalidate(keys...)
+	}()
+
+	return id, c.run(ctx, c.timeouts.Write, func(ctx context.Context) error {
+		parts, err := c.txParts(ctx, claims, ops, opts)
+		if err != nil {
+			return err
+		}
+
+		if len(parts) == 0 {
+			return nil
+		}
+
+		id, err = c.coordinator.Unwrap().Run(ctx, claims, parts, opts)
+		if err != nil {
+			return err
+		}
+
+		for number, part := range parts {
+			for _, op := range part {
+				if op.Delete {
+					c.delKeyServer(op.Key)
+				} else {
+					c.addKeyServer(op.Key, number)
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// txParts groups the writes by the servers of their keys. A value is set on the server Set would choose,
+// a key is deleted on the server that has it, the deletions of the keys no server has are dropped.
+func (c *Balancer) txParts(ctx context.Context, claims gost.Option[models.UserClaims], ops []models.TxOp, opts models.SetOptions) (map[int32][]models.TxOp, error) {
+	last := make(map[string]int, len(ops))
+	for i, op := range ops {
+		last[op.Key] = i
+	}
+
+	parts := make(map[int32][]models.TxOp)
+
+	for i, op := range ops {
+		if last[op.Key] != i {
+			continue
+		}
+
+		if !op.Delete {
+			cl, err := c.setServer(op.Key, opts)
+			if err != nil {
+				return nil, err
+			}
+
+			op.Value = c.stamp(op.Value)
+			parts[cl.Number()] = append(parts[cl.Number()], op)
+			continue
+		}
+
+		number := opts.Server
+		if number == constants.AutoServerNumber {
+			if s := c.getKeyServer(op.Key); s.IsSome() {
+				number = s.Unwrap()
+			} else {
+				r := c.servers.DeepSearch(ctx, claims, op.Key, models.GetOptions{})
+				if r.IsErr() {
+					if isNotFound(r.Error()) {
+						continue
+					}
+
+					return nil, r.Error().ExtendMsg(fmt.Sprintf("can't find key %s", op.Key))
+				}
+
+				number = r.Unwrap().Left
+			}
+		}
+
+		parts[number] = append(parts[number], op)
+	}
+
+	return parts, nil
+}
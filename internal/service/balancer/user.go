@@ -8,6 +8,7 @@ import (
 	"go.uber.org/zap"
 	"itisadb/internal/domains"
 	"itisadb/internal/models"
+	"itisadb/internal/service/deadline"
 )
 
 func (c *Balancer) Authenticate(ctx context.Context, login string, password string) (string, error) {
@@ -20,6 +21,13 @@ func (c *Balancer) Authenticate(ctx context.Context, login string, password stri
 }
 
 func (c *Balancer) NewUser(ctx context.Context, claims gost.Option[models.UserClaims], user models.User) error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+
+	ctx, cancel := deadline.Bound(ctx, c.timeouts.Users)
+	defer cancel()
+
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
@@ -29,8 +37,6 @@ func (c *Balancer) NewUser(ctx context.Context, claims gost.Option[models.UserCl
 			return nil
 		}
 
-		ctx := context.TODO()
-
 		if r := server.NewUser(ctx, claims, user); r.IsErr() {
 			c.logger.Warn("failed to create user", zap.Error(r.Error()), zap.String("user", user.Login))
 			return r.Error()
@@ -45,13 +51,18 @@ func (c *Balancer) NewUser(ctx context.Context, claims gost.Option[models.UserCl
 }
 
 func (c *Balancer) DeleteUser(ctx context.Context, claims gost.Option[models.UserClaims], login string) error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+
+	ctx, cancel := deadline.Bound(ctx, c.timeouts.Users)
+	defer cancel()
+
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
 	if err := c.servers.Iter(func(server domains.Server) error {
-		ctx := context.TODO()
-
 		if r := server.DeleteUser(ctx, claims, login); r.IsErr() {
 			c.logger.Warn("failed to delete user", zap.Error(r.Error()), zap.String("user", login))
 			return r.Error()
@@ -66,13 +77,18 @@ func (c *Balancer) DeleteUser(ctx context.Context, claims gost.Option[models.Use
 }
 
 func (c *Balancer) ChangePassword(ctx context.Context, claims gost.Option[models.UserClaims], login, password string) error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+
+	ctx, cancel := deadline.Bound(ctx, c.timeouts.Users)
+	defer cancel()
+
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
 	if err := c.servers.Iter(func(server domains.Server) error {
-		ctx := context.TODO()
-
 		if r := server.ChangePassword(ctx, claims, login, password); r.IsErr() {
 			c.logger.Warn("failed to change password", zap.Error(r.Error()), zap.String("user", login))
 			return r.Error()
@@ -87,13 +103,18 @@ func (c *Balancer) ChangePassword(ctx context.Context, claims gost.Option[models
 }
 
 func (c *Balancer) ChangeLevel(ctx context.Context, claims gost.Option[models.UserClaims], login string, level models.Level) error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+
+	ctx, cancel := deadline.Bound(ctx, c.timeouts.Users)
+	defer cancel()
+
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
 	if err := c.servers.Iter(func(server domains.Server) error {
-		ctx := context.TODO()
-
 		if err := server.ChangeLevel(ctx, claims, login, level).Error(); err != nil {
 			c.logger.Warn("failed to change level", zap.Error(err), zap.String("user", login))
 			return err
@@ -0,0 +1,693 @@
+// Package catalog keeps the balancer's routing tables: which server holds a key or an object.
+//
+// A sharded object has a route per shard and one more with the number of its shards.
+//
+// Routes are appended to bucket files on disk, so they survive a restart,
+// and the most recently used ones are cached in memory, the names without a route too.
+// The lines are buffered and written in batches, every _flushEvery or once _flushSize
+// bytes are pending, so a crash loses the routes set in the last moments.
+// A bucket file is a log of lines "<kind> <base64 name> <server>",
+// the last line for a name wins and server 0 marks a deleted route.
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"itisadb/config"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultDirectory = "catalog"
+	DefaultCacheSize = 100_000
+)
+
+const (
+	_buckets = 64
+	// _compactAfter is the number of appended lines after which a bucket file is rewritten.
+	_compactAfter = 10_000
+
+	_flushEvery = 100 * time.Millisecond
+	_flushSize  = 64 << 10
+)
+
+type kind byte
+
+const (
+	keyKind    kind = 'k'
+	objectKind kind = 'o'
+	// shardKind routes the shards of an object: the object's name leads to the number of its shards,
+	// the name with the index of a shard after _shardSeparator leads to the server of the shard.
+	// These routes are read only when the catalog is opened.
+	shardKind kind = 's'
+)
+
+const _shardSeparator = "\x00"
+
+const _deleted int32 = 0
+
+var ErrCorruptedLine = errors.New("corrupted catalog line")
+
+type route struct {
+	kind kind
+	name string
+}
+
+type bucket struct {
+	sync.Mutex
+	file     *os.File
+	path     string
+	appended int
+	// pending are the lines not written to the file yet.
+	pending []byte
+}
+
+type Catalog struct {
+	dir     string
+	buckets [_buckets]*bucket
+	logger  *zap.Logger
+
+	cacheMu   sync.Mutex
+	cacheSize int
+	lru       *list.List
+	cache     map[route]*list.Element
+
+	// the sharded objects are few, all of their shards are kept in memory
+	shardsMu sync.RWMutex
+	shards   map[string][]int32
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type entry struct {
+	route  route
+	server int32
+}
+
+// New opens the catalog in cfg.CatalogDirectory, compacts its files and warms the cache with the routes.
+func New(cfg config.BalancerConfig, logger *zap.Logger) (*Catalog, error) {
+	if cfg.CatalogDirectory == "" {
+		cfg.CatalogDirectory = DefaultDirectory
+	}
+
+	if cfg.CatalogCacheSize <= 0 {
+		cfg.CatalogCacheSize = DefaultCacheSize
+	}
+
+	if err := os.MkdirAll(cfg.CatalogDirectory, 0755); err != nil {
+		return nil, fmt.Errorf("can't create catalog directory: %w", err)
+	}
+
+	c := &Catalog{
+		dir:       cfg.CatalogDirectory,
+		logger:    logger,
+		cacheSize: cfg.CatalogCacheSize,
+		lru:       list.New(),
+		cache:     make(map[route]*list.Element),
+		shards:    make(map[string][]int32),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	shards := make(map[route]int32)
+
+	total := 0
+	for i := range c.buckets {
+		b := &bucket{path: filepath.Join(c.dir, strconv.Itoa(i))}
+		c.buckets[i] = b
+
+		routes, err := c.compact(b)
+		if err != nil {
+			c.closeFiles()
+			return nil, err
+		}
+
+		for r, server := range routes {
+			if r.kind == shardKind {
+				shards[r] = server
+				continue
+			}
+
+			c.cachePut(r, server)
+		}
+
+		total += len(routes)
+	}
+
+	c.loadShards(shards)
+
+	go c.flushLoop()
+
+	logger.Info("Catalog loaded", zap.String("directory", c.dir), zap.Int("routes", total))
+
+	return c, nil
+}
+
+// Close writes the pending routes and closes the bucket files.
+func (c *Catalog) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+	})
+
+	return c.closeFiles()
+}
+
+func (c *Catalog) closeFiles() error {
+	var errs []error
+	for _, b := range c.buckets {
+		if b == nil {
+			continue
+		}
+
+		b.Lock()
+		if b.file != nil {
+			errs = append(errs, c.flush(b), b.file.Close())
+			b.file = nil
+		}
+		b.Unlock()
+	}
+
+	return errors.Join(errs...)
+}
+
+// flushLoop writes the pending routes every _flushEvery until Close.
+func (c *Catalog) flushLoop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(_flushEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, b := range c.buckets {
+			b.Lock()
+			if err := c.flush(b); err != nil {
+				c.logger.Error("can't write to the catalog", zap.String("file", b.path), zap.Error(err))
+			}
+			b.Unlock()
+		}
+	}
+}
+
+// flush writes the pending lines to the bucket file. The caller holds the bucket lock.
+// The lines are kept on failure and written with the next flush.
+func (c *Catalog) flush(b *bucket) error {
+	if len(b.pending) == 0 || b.file == nil {
+		return nil
+	}
+
+	if _, err := b.file.Write(b.pending); err != nil {
+		return err
+	}
+	b.pending = b.pending[:0]
+
+	return nil
+}
+
+func (c *Catalog) KeyServer(key string) gost.Option[int32] {
+	return c.get(route{kind: keyKind, name: key})
+}
+
+func (c *Catalog) SetKeyServer(key string, server int32) gost.ResultN {
+	return c.set(route{kind: keyKind, name: key}, server)
+}
+
+func (c *Catalog) DelKeyServer(key string) gost.ResultN {
+	return c.set(route{kind: keyKind, name: key}, _deleted)
+}
+
+func (c *Catalog) ObjectServer(object string) gost.Option[int32] {
+	return c.get(route{kind: objectKind, name: object})
+}
+
+func (c *Catalog) SetObjectServer(object string, server int32) gost.ResultN {
+	return c.set(route{kind: objectKind, name: object}, server)
+}
+
+func (c *Catalog) DelObjectServer(object string) gost.ResultN {
+	return c.set(route{kind: objectKind, name: object}, _deleted)
+}
+
+// ObjectShards returns the servers of the shards of the object, in the order of the shards.
+// It returns None for an object that is not sharded.
+func (c *Catalog) ObjectShards(object string) (res gost.Option[[]int32]) {
+	c.shardsMu.RLock()
+	defer c.shardsMu.RUnlock()
+
+	servers, ok := c.shards[object]
+	if !ok {
+		return res.None()
+	}
+
+	return res.Some(slices.Clone(servers))
+}
+
+// SetObjectShards saves the servers of the shards of the object.
+func (c *Catalog) SetObjectShards(object string, servers []int32) (res gost.ResultN) {
+	c.shardsMu.Lock()
+	defer c.shardsMu.Unlock()
+
+	// the number goes last, the object is not sharded after a restart until all of its shards are saved
+	for i, server := range servers {
+		if r := c.set(shardRoute(object, i), server); r.IsErr() {
+			return r
+		}
+	}
+
+	if r := c.set(route{kind: shardKind, name: object}, int32(len(servers))); r.IsErr() {
+		return r
+	}
+
+	c.shards[object] = slices.Clone(servers)
+
+	return res.Ok()
+}
+
+func (c *Catalog) DelObjectShards(object string) (res gost.ResultN) {
+	c.shardsMu.Lock()
+	defer c.shardsMu.Unlock()
+
+	servers, ok := c.shards[object]
+	if !ok {
+		return res.Ok()
+	}
+
+	if r := c.set(route{kind: shardKind, name: object}, _deleted); r.IsErr() {
+		return r
+	}
+	delete(c.shards, object)
+
+	for i := range servers {
+		if r := c.set(shardRoute(object, i), _deleted); r.IsErr() {
+			return r
+		}
+	}
+
+	return res.Ok()
+}
+
+func shardRoute(object string, i int) route {
+	return route{kind: shardKind, name: object + _shardSeparator + strconv.Itoa(i)}
+}
+
+// loadShards puts together the shards of the objects from their routes.
+func (c *Catalog) loadShards(routes map[route]int32) {
+	for r, n := range routes {
+		if strings.Contains(r.name, _shardSeparator) {
+			continue
+		}
+
+		servers := make([]int32, n)
+		for i := range servers {
+			server, ok := routes[shardRoute(r.name, i)]
+			if !ok {
+				c.logger.Warn("missing shard route", zap.String("object", r.name), zap.Int("shard", i))
+				servers = nil
+				break
+			}
+
+			servers[i] = server
+		}
+
+		if servers != nil {
+			c.shards[r.name] = servers
+		}
+	}
+}
+
+func (c *Catalog) bucket(r route) *bucket {
+	h := fnv.New32a()
+	h.Write([]byte{byte(r.kind)})
+	h.Write([]byte(r.name))
+	return c.buckets[h.Sum32()%_buckets]
+}
+
+func (c *Catalog) get(r route) (res gost.Option[int32]) {
+	if server, ok := c.cacheGet(r); ok {
+		return routed(server)
+	}
+
+	b := c.bucket(r)
+	b.Lock()
+	defer b.Unlock()
+
+	// the route could have been cached while the bucket was waited for
+	if server, ok := c.cacheGet(r); ok {
+		return routed(server)
+	}
+
+	server, found, err := c.lookup(b, r)
+	if err != nil {
+		c.logger.Error("can't read the catalog", zap.String("file", b.path), zap.Error(err))
+		return res.None()
+	}
+
+	if !found {
+		server = _deleted
+	}
+
+	// a miss is cached too, the names that were never routed are not looked up again
+	c.cachePut(r, server)
+
+	if server == _deleted {
+		return res.None()
+	}
+
+	return res.Some(server)
+}
+
+func routed(server int32) (res gost.Option[int32]) {
+	if server == _deleted {
+		return res.None()
+	}
+
+	return res.Some(server)
+}
+
+func (c *Catalog) set(r route, server int32) (res gost.ResultN) {
+	b := c.bucket(r)
+	b.Lock()
+	defer b.Unlock()
+
+	if b.file == nil {
+		return res.ErrNewUnknown("catalog is closed")
+	}
+
+	b.pending = append(b.pending, encode(r, server)...)
+	b.appended++
+
+	c.cachePut(r, server)
+
+	if len(b.pending) >= _flushSize {
+		if err := c.flush(b); err != nil {
+			return res.ErrNewUnknown(fmt.Sprintf("can't write to the catalog: %v", err))
+		}
+	}
+
+	if b.appended >= _compactAfter {
+		if _, err := c.compact(b); err != nil {
+			c.logger.Error("can't compact the catalog", zap.String("file", b.path), zap.Error(err))
+		}
+	}
+
+	return res.Ok()
+}
+
+// lookup scans the bucket file and the pending lines for the last line about r.
+func (c *Catalog) lookup(b *bucket, r route) (server int32, found bool, err error) {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	prefix := encodeName(r)
+
+	sc := bufio.NewScanner(io.MultiReader(f, bytes.NewReader(b.pending)))
+	for sc.Scan() {
+		line := sc.Bytes()
+		if !bytes.HasPrefix(line, prefix) {
+			continue
+		}
+
+		_, s, err := decode(line)
+		if err != nil {
+			continue
+		}
+
+		server, found = s, true
+	}
+
+	return server, found, sc.Err()
+}
+
+// compact rewrites the bucket file with the live routes only and returns them.
+// The caller holds the bucket lock, unless the catalog is being opened.
+func (c *Catalog) compact(b *bucket) (map[route]int32, error) {
+	if err := c.flush(b); err != nil {
+		return nil, err
+	}
+
+	routes := make(map[route]int32)
+
+	if f, err := os.Open(b.path); err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			r, server, err := decode(sc.Bytes())
+			if err != nil {
+				c.logger.Warn("skipping catalog line", zap.String("file", b.path), zap.Error(err))
+				continue
+			}
+
+			if server == _deleted {
+				delete(routes, r)
+			} else {
+				routes[r] = server
+			}
+		}
+		f.Close()
+
+		if err := sc.Err(); err != nil {
+			return nil, fmt.Errorf("can't read %s: %w", b.path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for r, server := range routes {
+		buf.Write(encode(r, server))
+	}
+
+	if err := c.replace(b, buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+// replace swaps the bucket file for one with the lines, the pending lines are dropped.
+// The caller holds the bucket lock.
+func (c *Catalog) replace(b *bucket, lines []byte) error {
+	b.pending = b.pending[:0]
+
+	tmp, err := os.CreateTemp(c.dir, ".compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(lines); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if b.file != nil {
+		b.file.Close()
+		b.file = nil
+	}
+
+	if err := os.Rename(tmp.Name(), b.path); err != nil {
+		return err
+	}
+
+	b.file, err = os.OpenFile(b.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	b.appended = 0
+
+	return nil
+}
+
+// Dump writes all the live routes in the format of the bucket files.
+// The buckets are dumped one by one, the routes set meanwhile may be missed.
+func (c *Catalog) Dump(w io.Writer) error {
+	for _, b := range c.buckets {
+		b.Lock()
+		routes, err := c.compact(b)
+		b.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		for r, server := range routes {
+			if _, err := w.Write(encode(r, server)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Restore replaces all the routes with the ones Dump wrote.
+func (c *Catalog) Restore(r io.Reader) error {
+	lines := make(map[*bucket][]byte, _buckets)
+	shards := make(map[route]int32)
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		rt, server, err := decode(sc.Bytes())
+		if err != nil {
+			return err
+		}
+
+		if server == _deleted {
+			continue
+		}
+
+		if rt.kind == shardKind {
+			shards[rt] = server
+		}
+
+		b := c.bucket(rt)
+		lines[b] = append(lines[b], encode(rt, server)...)
+	}
+
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	c.shardsMu.Lock()
+	defer c.shardsMu.Unlock()
+
+	for _, b := range c.buckets {
+		b.Lock()
+		err := c.replace(b, lines[b])
+		b.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	c.cacheMu.Lock()
+	c.lru.Init()
+	c.cache = make(map[route]*list.Element)
+	c.cacheMu.Unlock()
+
+	c.shards = make(map[string][]int32)
+	c.loadShards(shards)
+
+	return nil
+}
+
+func (c *Catalog) cacheGet(r route) (int32, bool) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	el, ok := c.cache[r]
+	if !ok {
+		return 0, false
+	}
+
+	c.lru.MoveToFront(el)
+	return el.Value.(*entry).server, true
+}
+
+func (c *Catalog) cachePut(r route, server int32) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	if el, ok := c.cache[r]; ok {
+		el.Value.(*entry).server = server
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.cache[r] = c.lru.PushFront(&entry{route: r, server: server})
+
+	// the evicted routes stay in the bucket files and are read back on the next miss
+	for c.lru.Len() > c.cacheSize {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.cache, el.Value.(*entry).route)
+	}
+}
+
+func (c *Catalog) cacheDel(r route) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	if el, ok := c.cache[r]; ok {
+		c.lru.Remove(el)
+		delete(c.cache, r)
+	}
+}
+
+// Cached returns the number of routes held in memory.
+func (c *Catalog) Cached() int {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+	return c.lru.Len()
+}
+
+func encodeName(r route) []byte {
+	name := base64.StdEncoding.EncodeToString([]byte(r.name))
+	return []byte(string(r.kind) + " " + name + " ")
+}
+
+func encode(r route, server int32) []byte {
+	line := strconv.AppendInt(encodeName(r), int64(server), 10)
+	return append(line, '\n')
+}
+
+func decode(line []byte) (r route, server int32, err error) {
+	parts := bytes.Split(line, []byte(" "))
+	if len(parts) != 3 || len(parts[0]) != 1 {
+		return r, 0, ErrCorruptedLine
+	}
+
+	r.kind = kind(parts[0][0])
+	if r.kind != keyKind && r.kind != objectKind && r.kind != shardKind {
+		return r, 0, ErrCorruptedLine
+	}
+
+	name, err := base64.StdEncoding.DecodeString(string(parts[1]))
+	if err != nil {
+		return r, 0, fmt.Errorf("%w: %v", ErrCorruptedLine, err)
+	}
+	r.name = string(name)
+
+	n, err := strconv.ParseInt(string(parts[2]), 10, 32)
+	if err != nil {
+		return r, 0, fmt.Errorf("%w: %v", ErrCorruptedLine, err)
+	}
+
+	return r, int32(n), nil
+}
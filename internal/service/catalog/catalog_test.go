@@ -0,0 +1,315 @@
+package catalog
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"itisadb/config"
+
+	"go.uber.org/zap"
+)
+
+func open(t *testing.T, cfg config.BalancerConfig) *Catalog {
+	t.Helper()
+
+	c, err := New(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestCatalog(t *testing.T) {
+	const n = 1000
+
+	cfg := config.BalancerConfig{CatalogDirectory: t.TempDir(), CatalogCacheSize: 100}
+
+	c := open(t, cfg)
+
+	for i := 0; i < n; i++ {
+		if r := c.SetKeyServer(fmt.Sprintf("key%d", i), int32(i%3+1)); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+	}
+
+	c.SetObjectServer("obj", 2)
+	c.SetObjectServer("deleted", 2)
+	c.DelObjectServer("deleted")
+	c.SetKeyServer("moved", 1)
+	c.SetKeyServer("moved", 3)
+	c.SetKeyServer("strange \n key", 2)
+
+	if got := c.Cached(); got != 100 {
+		t.Errorf("cache holds %d routes, want 100", got)
+	}
+
+	check := func(t *testing.T, c *Catalog) {
+		t.Helper()
+
+		// most of the keys were evicted from the cache and are read from the disk
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("key%d", i)
+			if s := c.KeyServer(key); s.IsNone() || s.Unwrap() != int32(i%3+1) {
+				t.Fatalf("%s: got %v, want %d", key, s, i%3+1)
+			}
+		}
+
+		if s := c.ObjectServer("obj"); s.IsNone() || s.Unwrap() != 2 {
+			t.Errorf("obj: got %v", s)
+		}
+
+		if s := c.ObjectServer("deleted"); s.IsSome() {
+			t.Errorf("deleted object is routed to %d", s.Unwrap())
+		}
+
+		if s := c.KeyServer("obj"); s.IsSome() {
+			t.Errorf("object route is returned for a key")
+		}
+
+		if s := c.KeyServer("moved"); s.IsNone() || s.Unwrap() != 3 {
+			t.Errorf("moved: got %v", s)
+		}
+
+		if s := c.KeyServer("strange \n key"); s.IsNone() || s.Unwrap() != 2 {
+			t.Errorf("strange key: got %v", s)
+		}
+
+		if s := c.KeyServer("unknown"); s.IsSome() {
+			t.Errorf("unknown key is routed to %d", s.Unwrap())
+		}
+
+		if got := c.Cached(); got > 100 {
+			t.Errorf("cache holds %d routes, want at most 100", got)
+		}
+	}
+
+	check(t, c)
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("restart", func(t *testing.T) {
+		c := open(t, cfg)
+		defer c.Close()
+
+		check(t, c)
+	})
+
+	t.Run("torn write", func(t *testing.T) {
+		// a crash in the middle of a write leaves a partial line behind
+		path := filepath.Join(cfg.CatalogDirectory, "0")
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString("k a2V5")
+		f.Close()
+
+		c := open(t, cfg)
+		defer c.Close()
+
+		check(t, c)
+
+		c.SetKeyServer("after", 1)
+		if s := c.KeyServer("after"); s.IsNone() || s.Unwrap() != 1 {
+			t.Errorf("after: got %v", s)
+		}
+	})
+}
+
+func TestCompaction(t *testing.T) {
+	cfg := config.BalancerConfig{CatalogDirectory: t.TempDir(), CatalogCacheSize: 10}
+
+	c := open(t, cfg)
+	defer c.Close()
+
+	for i := 0; i < _compactAfter*2; i++ {
+		c.SetKeyServer("key", int32(i%2+1))
+	}
+
+	b := c.bucket(route{kind: keyKind, name: "key"})
+
+	info, err := os.Stat(b.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limit := int64(len(encode(route{kind: keyKind, name: "key"}, 1)) * _compactAfter); info.Size() >= limit {
+		t.Errorf("bucket file is %d bytes, it was not compacted", info.Size())
+	}
+
+	c.cacheDel(route{kind: keyKind, name: "key"})
+	if s := c.KeyServer("key"); s.IsNone() || s.Unwrap() != 2 {
+		t.Errorf("key: got %v, want 2", s)
+	}
+}
+
+func TestPending(t *testing.T) {
+	cfg := config.BalancerConfig{CatalogDirectory: t.TempDir(), CatalogCacheSize: 10}
+
+	c := open(t, cfg)
+
+	c.SetKeyServer("key", 2)
+
+	// the route is found among the lines that are not written yet
+	c.cacheDel(route{kind: keyKind, name: "key"})
+	if s := c.KeyServer("key"); s.IsNone() || s.Unwrap() != 2 {
+		t.Errorf("key: got %v, want 2", s)
+	}
+
+	if s := c.KeyServer("unknown"); s.IsSome() {
+		t.Errorf("unknown key is routed to %d", s.Unwrap())
+	}
+
+	if got := c.Cached(); got != 2 {
+		t.Errorf("cache holds %d routes, want the key and the miss", got)
+	}
+
+	// the miss is forgotten once the name is routed
+	c.SetKeyServer("unknown", 1)
+	if s := c.KeyServer("unknown"); s.IsNone() || s.Unwrap() != 1 {
+		t.Errorf("unknown: got %v, want 1", s)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c = open(t, cfg)
+	defer c.Close()
+
+	if s := c.KeyServer("key"); s.IsNone() || s.Unwrap() != 2 {
+		t.Errorf("key after a restart: got %v, want 2", s)
+	}
+}
+
+func TestShards(t *testing.T) {
+	cfg := config.BalancerConfig{CatalogDirectory: t.TempDir(), CatalogCacheSize: 2}
+
+	c := open(t, cfg)
+
+	if r := c.SetObjectShards("big", []int32{3, 1, 2}); r.IsErr() {
+		t.Fatal(r.Error())
+	}
+	c.SetObjectServer("big", 3)
+	c.SetObjectShards("gone", []int32{1, 2})
+	c.DelObjectShards("gone")
+
+	check := func(t *testing.T, c *Catalog) {
+		t.Helper()
+
+		if s := c.ObjectShards("big"); s.IsNone() || !slices.Equal(s.Unwrap(), []int32{3, 1, 2}) {
+			t.Errorf("big: got %v", s)
+		}
+
+		if s := c.ObjectServer("big"); s.IsNone() || s.Unwrap() != 3 {
+			t.Errorf("big: object server %v", s)
+		}
+
+		if s := c.ObjectShards("gone"); s.IsSome() {
+			t.Errorf("deleted shards: got %v", s.Unwrap())
+		}
+
+		if s := c.ObjectShards("plain"); s.IsSome() {
+			t.Errorf("plain object has shards %v", s.Unwrap())
+		}
+	}
+
+	check(t, c)
+
+	// a shard leaves for another server
+	c.SetObjectShards("big", []int32{3, 4, 2})
+	if s := c.ObjectShards("big"); s.IsNone() || !slices.Equal(s.Unwrap(), []int32{3, 4, 2}) {
+		t.Fatalf("big after a move: got %v", s)
+	}
+	c.SetObjectShards("big", []int32{3, 1, 2})
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("restart", func(t *testing.T) {
+		c := open(t, cfg)
+		defer c.Close()
+
+		check(t, c)
+	})
+}
+
+func TestDump(t *testing.T) {
+	src := open(t, config.BalancerConfig{CatalogDirectory: t.TempDir(), CatalogCacheSize: 10})
+	defer src.Close()
+
+	for i := 0; i < 100; i++ {
+		src.SetKeyServer(fmt.Sprintf("key%d", i), int32(i%3+1))
+	}
+	src.DelKeyServer("key0")
+	src.SetObjectServer("obj", 2)
+	src.SetObjectShards("big", []int32{3, 1, 2})
+
+	var buf bytes.Buffer
+	if err := src.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.BalancerConfig{CatalogDirectory: t.TempDir(), CatalogCacheSize: 10}
+
+	dst := open(t, cfg)
+	dst.SetKeyServer("stale", 1)
+	dst.SetObjectShards("stale", []int32{1})
+
+	if err := dst.Restore(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, c *Catalog) {
+		t.Helper()
+
+		for i := 1; i < 100; i++ {
+			key := fmt.Sprintf("key%d", i)
+			if s := c.KeyServer(key); s.IsNone() || s.Unwrap() != int32(i%3+1) {
+				t.Fatalf("%s: got %v, want %d", key, s, i%3+1)
+			}
+		}
+
+		if s := c.KeyServer("key0"); s.IsSome() {
+			t.Errorf("deleted key is routed to %d", s.Unwrap())
+		}
+
+		if s := c.ObjectServer("obj"); s.IsNone() || s.Unwrap() != 2 {
+			t.Errorf("obj: got %v", s)
+		}
+
+		if s := c.ObjectShards("big"); s.IsNone() || !slices.Equal(s.Unwrap(), []int32{3, 1, 2}) {
+			t.Errorf("big: got %v", s)
+		}
+
+		// the routes that were not dumped are gone
+		if s := c.KeyServer("stale"); s.IsSome() {
+			t.Errorf("stale key is routed to %d", s.Unwrap())
+		}
+
+		if s := c.ObjectShards("stale"); s.IsSome() {
+			t.Errorf("stale shards: got %v", s.Unwrap())
+		}
+	}
+
+	check(t, dst)
+
+	if err := dst.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("restart", func(t *testing.T) {
+		c := open(t, cfg)
+		defer c.Close()
+
+		check(t, c)
+	})
+}
@@ -0,0 +1,313 @@
+// Package coordinator commits the transactions whose keys live on different servers.
+//
+// A transaction is committed in two phases. First every server of the transaction prepares
+// its part: it checks the writes, locks the keys and logs the part. When all of them agreed,
+// the decision to commit is written to the log of the coordinator and the servers are told
+// to commit, otherwise they are told to abort. A transaction without a commit decision is
+// aborted, so nothing is logged for the aborts.
+//
+// The coordinator may crash or lose a server between the phases. Recover finishes the logged
+// commits and aborts the transactions of this coordinator the servers hold without a decision.
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/deadline"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultDirectory       = "transactions"
+	DefaultRecoverInterval = 10 * time.Second
+)
+
+type Coordinator struct {
+	cfg     config.TransactionsConfig
+	log     *Log
+	servers domains.Servers
+	logger  *zap.Logger
+
+	// own starts the IDs of the transactions of this coordinator,
+	// prefix is own with the time of the start, so the IDs are never reused.
+	own    string
+	prefix string
+	seq    atomic.Uint64
+
+	mu     sync.Mutex
+	active map[string]struct{}
+
+	// recoverMu lets one recovery run at a time.
+	recoverMu sync.Mutex
+
+	started atomic.Bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// New opens the log of the coordinator, cfg.ID must be unique among the balancers sharing the servers.
+func New(cfg config.TransactionsConfig, servers domains.Servers, logger *zap.Logger) (*Coordinator, error) {
+	if cfg.Directory == "" {
+		cfg.Directory = DefaultDirectory
+	}
+	if cfg.RecoverInterval <= 0 {
+		cfg.RecoverInterval = DefaultRecoverInterval
+	}
+
+	log, err := OpenLog(cfg.Directory)
+	if err != nil {
+		return nil, fmt.Errorf("can't open the transactions log: %w", err)
+	}
+
+	own := cfg.ID + "/"
+
+	return &Coordinator{
+		cfg:     cfg,
+		log:     log,
+		servers: servers,
+		logger:  logger,
+		own:     own,
+		prefix:  fmt.Sprintf("%s%d/", own, time.Now().UnixNano()),
+		active:  make(map[string]struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Start resolves the in-doubt transactions now and then every RecoverInterval.
+func (c *Coordinator) Start() {
+	c.started.Store(true)
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.cfg.RecoverInterval)
+		defer ticker.Stop()
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), c.cfg.RecoverInterval)
+			c.Recover(ctx)
+			cancel()
+
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the recovery and closes the log.
+func (c *Coordinator) Close() error {
+	close(c.stop)
+	if c.started.Load() {
+		<-c.done
+	}
+
+	return c.log.Close()
+}
+
+// Run commits the parts of a transaction, keyed by the numbers of their servers, all at once.
+// It returns the ID of the transaction. Once the commit is decided Run succeeds,
+// the servers that didn't answer commit it when the recovery reaches them.
+func (c *Coordinator) Run(ctx context.Context, claims gost.Option[models.UserClaims], parts map[int32][]models.TxOp, opts models.SetOptions) (string, error) {
+	id := fmt.Sprintf("%s%d", c.prefix, c.seq.Add(1))
+
+	numbers := make([]int32, 0, len(parts))
+	for number := range parts {
+		numbers = append(numbers, number)
+	}
+	slices.Sort(numbers)
+
+	c.mu.Lock()
+	c.active[id] = struct{}{}
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.active, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.prepare(ctx, claims, id, parts, opts); err != nil {
+		c.resolve(ctx, id, numbers, false)
+		return id, err
+	}
+
+	if err := c.log.Commit(id, numbers); err != nil {
+		c.resolve(ctx, id, numbers, false)
+		return id, err
+	}
+
+	if !c.resolve(ctx, id, numbers, true) {
+		c.logger.Warn("transaction is committed, the recovery will finish it", zap.String("tx", id))
+		return id, nil
+	}
+
+	if err := c.log.Done(id); err != nil {
+		c.logger.Warn("can't record the finished transaction", zap.String("tx", id), zap.Error(err))
+	}
+
+	return id, nil
+}
+
+// prepare asks the servers in parallel to prepare their parts, it returns the first refusal.
+func (c *Coordinator) prepare(ctx context.Context, claims gost.Option[models.UserClaims], id string, parts map[int32][]models.TxOp, opts models.SetOptions) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for number, ops := range parts {
+		wg.Add(1)
+		go func(number int32, ops []models.TxOp) {
+			defer wg.Done()
+
+			cl, ok := c.servers.GetServer(number)
+			if !ok || cl == nil {
+				fail(constants.ErrUnknownServer)
+				return
+			}
+
+			tx := models.Transaction{ID: id, Ops: ops, Opts: opts}
+			if r := cl.Prepare(ctx, claims, tx); r.IsErr() {
+				fail(r.Error().ExtendMsg(fmt.Sprintf("can't prepare transaction on server: %d", number)))
+			}
+		}(number, ops)
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
+
+// resolve tells the servers to commit or abort the transaction, it reports whether all of them did it.
+// The servers are told even when the caller gave up, it's the only way to unlock the keys quickly.
+func (c *Coordinator) resolve(ctx context.Context, id string, numbers []int32, commit bool) bool {
+	ctx, cancel := deadline.Detached(ctx, c.cfg.RecoverInterval)
+	defer cancel()
+
+	var (
+		wg  sync.WaitGroup
+		all atomic.Bool
+	)
+	all.Store(true)
+
+	for _, number := range numbers {
+		wg.Add(1)
+		go func(number int32) {
+			defer wg.Done()
+
+			if err := c.resolveOn(ctx, number, id, commit); err != nil {
+				c.logger.Warn("can't resolve transaction", zap.String("tx", id), zap.Int32("server", number), zap.Bool("commit", commit), zap.Error(err))
+				all.Store(false)
+			}
+		}(number)
+	}
+
+	wg.Wait()
+
+	return all.Load()
+}
+
+func (c *Coordinator) resolveOn(ctx context.Context, number int32, id string, commit bool) error {
+	cl, ok := c.servers.GetServer(number)
+	if !ok || cl == nil {
+		return constants.ErrUnknownServer
+	}
+
+	r := cl.AbortPrepared
+	if commit {
+		r = cl.CommitPrepared
+	}
+
+	if res := r(ctx, id); res.IsErr() {
+		return res.Error()
+	}
+
+	return nil
+}
+
+// Recover finishes the logged commits and resolves the transactions of this coordinator
+// the servers hold prepared: the ones with a commit decision are committed, the others are aborted.
+// The transactions in progress are left alone.
+func (c *Coordinator) Recover(ctx context.Context) error {
+	c.recoverMu.Lock()
+	defer c.recoverMu.Unlock()
+
+	for _, id := range c.log.Pending() {
+		if c.isActive(id) {
+			continue
+		}
+
+		servers, ok := c.log.Committed(id)
+		if !ok || !c.resolve(ctx, id, servers, true) {
+			continue
+		}
+
+		c.logger.Info("recovered committed transaction", zap.String("tx", id))
+
+		if err := c.log.Done(id); err != nil {
+			c.logger.Warn("can't record the finished transaction", zap.String("tx", id), zap.Error(err))
+		}
+	}
+
+	return c.servers.Iter(func(cl domains.Server) error {
+		if cl.IsOffline() {
+			return nil
+		}
+
+		r := cl.InDoubt(ctx)
+		if r.IsErr() {
+			c.logger.Warn("can't get in-doubt transactions", zap.Int32("server", cl.Number()), zap.Error(r.Error()))
+			return nil
+		}
+
+		for _, id := range r.Unwrap() {
+			if !strings.HasPrefix(id, c.own) || c.isActive(id) {
+				continue
+			}
+
+			_, commit := c.log.Committed(id)
+			if err := c.resolveOn(ctx, cl.Number(), id, commit); err != nil {
+				c.logger.Warn("can't resolve in-doubt transaction", zap.String("tx", id), zap.Int32("server", cl.Number()), zap.Error(err))
+				continue
+			}
+
+			c.logger.Info("resolved in-doubt transaction", zap.String("tx", id), zap.Int32("server", cl.Number()), zap.Bool("commit", commit))
+		}
+
+		return nil
+	})
+}
+
+func (c *Coordinator) isActive(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.active[id]
+	return ok
+}
@@ -0,0 +1,208 @@
+package coordinator
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/clustertest"
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+	"itisadb/internal/service/logic"
+	"itisadb/internal/service/security"
+	"itisadb/internal/service/servers"
+	"itisadb/internal/storage"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+// server is a participant with its own storage, its commits can be lost on the way.
+type server struct {
+	*servers.LocalServer
+	storage *storage.Storage
+	number  int32
+	down    atomic.Bool
+}
+
+func (s *server) Number() int32 { return s.number }
+
+func (s *server) IsOffline() bool { return s.down.Load() }
+
+func (s *server) CommitPrepared(ctx context.Context, id string) (res gost.ResultN) {
+	if s.down.Load() {
+		return res.Err(gost.NewErrX(0, "server is down"))
+	}
+
+	return s.LocalServer.CommitPrepared(ctx, id)
+}
+
+func (s *server) value(key string) string {
+	v := s.storage.Get(key)
+	if v.IsNone() {
+		return ""
+	}
+
+	return v.Unwrap().Value
+}
+
+func (s *server) inDoubt() []string {
+	return s.InDoubt(context.Background()).Unwrap()
+}
+
+// newCluster returns n participants, numbered from 1, and the cluster of them.
+func newCluster(t *testing.T, n int) (*clustertest.Cluster, []*server) {
+	sec := security.NewSecurityService(config.SecurityConfig{}, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+
+	c := clustertest.NewCluster()
+
+	var nodes []*server
+	for i := 1; i <= n; i++ {
+		store, err := storage.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l := logic.NewLogic(store, config.Config{}, nil, zap.NewNop(), sec)
+		s := &server{LocalServer: servers.NewLocalServer(l), storage: store, number: int32(i)}
+
+		c.Add(s)
+		nodes = append(nodes, s)
+	}
+
+	return c, nodes
+}
+
+func newCoordinator(t *testing.T, c *clustertest.Cluster, dir string) *Coordinator {
+	co, err := New(config.TransactionsConfig{ID: "b1", Directory: dir, RecoverInterval: time.Second}, c, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return co
+}
+
+var _claims gost.Option[models.UserClaims]
+
+func TestCommit(t *testing.T) {
+	c, nodes := newCluster(t, 2)
+	co := newCoordinator(t, c, t.TempDir())
+	defer co.Close()
+
+	parts := map[int32][]models.TxOp{
+		1: {{Key: "a", Value: "1"}},
+		2: {{Key: "b", Value: "2"}},
+	}
+
+	if _, err := co.Run(context.Background(), _claims, parts, models.SetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := nodes[0].value("a") + nodes[1].value("b"); got != "12" {
+		t.Errorf("got %q, want both values", got)
+	}
+
+	for _, s := range nodes {
+		if ids := s.inDoubt(); len(ids) != 0 {
+			t.Errorf("server %d holds %v", s.number, ids)
+		}
+	}
+
+	if ids := co.log.Pending(); len(ids) != 0 {
+		t.Errorf("the log keeps %v", ids)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	c, nodes := newCluster(t, 2)
+	co := newCoordinator(t, c, t.TempDir())
+	defer co.Close()
+
+	nodes[1].SetOne(context.Background(), _claims, "fixed", "f", models.SetOptions{ReadOnly: true})
+
+	parts := map[int32][]models.TxOp{
+		1: {{Key: "a", Value: "1"}},
+		2: {{Key: "fixed", Value: "x"}},
+	}
+
+	_, err := co.Run(context.Background(), _claims, parts, models.SetOptions{})
+	if errX, ok := err.(*gost.ErrX); !ok || errX.Messages()[0] != constants.ErrAlreadyExists.Message() {
+		t.Fatalf("got %v, want %v", err, constants.ErrAlreadyExists)
+	}
+
+	if got := nodes[0].value("a"); got != "" {
+		t.Errorf("the write of the aborted transaction was applied")
+	}
+
+	if ids := nodes[0].inDoubt(); len(ids) != 0 {
+		t.Errorf("the aborted transaction is still prepared: %v", ids)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	c, nodes := newCluster(t, 2)
+	dir := t.TempDir()
+	co := newCoordinator(t, c, dir)
+
+	// the second server misses the commit
+	nodes[1].down.Store(true)
+
+	parts := map[int32][]models.TxOp{
+		1: {{Key: "a", Value: "1"}},
+		2: {{Key: "b", Value: "2"}},
+	}
+
+	id, err := co.Run(context.Background(), _claims, parts, models.SetOptions{})
+	if err != nil {
+		t.Fatalf("a decided commit failed: %v", err)
+	}
+
+	if ids := nodes[1].inDoubt(); !slices.Equal(ids, []string{id}) {
+		t.Fatalf("got in-doubt %v, want [%s]", ids, id)
+	}
+
+	if err := co.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the coordinator crashed after the second server prepared a transaction, there is no decision
+	undecided := models.Transaction{ID: "b1/1/1", Ops: []models.TxOp{{Key: "c", Value: "3"}}}
+	foreign := models.Transaction{ID: "b2/1/1", Ops: []models.TxOp{{Key: "d", Value: "4"}}}
+	for _, tx := range []models.Transaction{undecided, foreign} {
+		if r := nodes[0].Prepare(context.Background(), _claims, tx); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+	}
+
+	co = newCoordinator(t, c, dir)
+	defer co.Close()
+
+	nodes[1].down.Store(false)
+
+	if err := co.Recover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := nodes[1].value("b"); got != "2" {
+		t.Errorf("b: got %q, want the committed value", got)
+	}
+
+	if got := nodes[0].value("c"); got != "" {
+		t.Errorf("the undecided transaction was committed")
+	}
+
+	if ids := nodes[0].inDoubt(); !slices.Equal(ids, []string{foreign.ID}) {
+		t.Errorf("got in-doubt %v, want only the transaction of the other coordinator", ids)
+	}
+
+	if ids := nodes[1].inDoubt(); len(ids) != 0 {
+		t.Errorf("server 2 holds %v", ids)
+	}
+
+	if ids := co.log.Pending(); len(ids) != 0 {
+		t.Errorf("the log keeps %v", ids)
+	}
+}
@@ -0,0 +1,220 @@
+package coordinator
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const _logFile = "decisions"
+
+// _compactAfter is the number of the finished transactions after which the log is rewritten.
+const _compactAfter = 10_000
+
+const (
+	_commit = "commit"
+	_done   = "done"
+)
+
+type record struct {
+	ID      string  `json:"id"`
+	State   string  `json:"state"`
+	Servers []int32 `json:"servers,omitempty"`
+}
+
+// Log keeps the commit decisions until every server of the transaction applied them.
+// A transaction that is not in the log is aborted, nothing is written for the aborts.
+type Log struct {
+	dir string
+
+	mu       sync.Mutex
+	file     *os.File
+	pending  map[string][]int32
+	finished int
+}
+
+// OpenLog reads the decisions left in dir and rewrites the log with the pending ones.
+func OpenLog(dir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	l := &Log{dir: dir, pending: make(map[string][]int32)}
+
+	if err := l.read(); err != nil {
+		return nil, err
+	}
+
+	if err := l.compact(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *Log) read() error {
+	f, err := os.Open(filepath.Join(l.dir, _logFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// the last line is cut when the balancer crashed while writing it,
+			// the decision was never reported then
+			break
+		}
+
+		switch rec.State {
+		case _commit:
+			l.pending[rec.ID] = rec.Servers
+		case _done:
+			delete(l.pending, rec.ID)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// compact replaces the log with the pending decisions, it is called with mu held or before the log is shared.
+func (l *Log) compact() error {
+	tmp, err := os.CreateTemp(l.dir, ".decisions-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, id := range l.pendingIDs() {
+		if err := writeRecord(w, record{ID: id, State: _commit, Servers: l.pending[id]}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	path := filepath.Join(l.dir, _logFile)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = f
+	l.finished = 0
+
+	return nil
+}
+
+func writeRecord(w io.Writer, rec record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Commit records the decision to commit the transaction on the servers, it is on the disk when Commit returns.
+func (l *Log) Commit(id string, servers []int32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := writeRecord(l.file, record{ID: id, State: _commit, Servers: servers}); err != nil {
+		return fmt.Errorf("can't record the commit of %s: %w", id, err)
+	}
+
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("can't record the commit of %s: %w", id, err)
+	}
+
+	l.pending[id] = servers
+
+	return nil
+}
+
+// Done forgets the transaction every server has committed.
+func (l *Log) Done(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.pending[id]; !ok {
+		return nil
+	}
+
+	delete(l.pending, id)
+
+	if l.finished++; l.finished >= _compactAfter {
+		return l.compact()
+	}
+
+	// a lost record only makes the recovery commit the transaction once more
+	return writeRecord(l.file, record{ID: id, State: _done})
+}
+
+// Committed returns the servers of the transaction when it was decided to commit it.
+func (l *Log) Committed(id string) ([]int32, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	servers, ok := l.pending[id]
+	return servers, ok
+}
+
+// Pending returns the committed transactions that some servers may not have applied yet, sorted by ID.
+func (l *Log) Pending() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.pendingIDs()
+}
+
+func (l *Log) pendingIDs() []string {
+	ids := make([]string, 0, len(l.pending))
+	for id := range l.pending {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
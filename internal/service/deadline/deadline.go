@@ -0,0 +1,56 @@
+// Package deadline bounds the operations of the balancer by their type.
+// The deadline goes with the context into the requests to the servers.
+package deadline
+
+import (
+	"context"
+	"time"
+
+	"itisadb/config"
+)
+
+const (
+	DefaultRead   = 5 * time.Second
+	DefaultWrite  = 5 * time.Second
+	DefaultObject = 10 * time.Second
+	DefaultUsers  = 10 * time.Second
+)
+
+// WithDefaults fills the unset timeouts, the negative ones stay turned off.
+func WithDefaults(cfg config.TimeoutsConfig) config.TimeoutsConfig {
+	if cfg.Read == 0 {
+		cfg.Read = DefaultRead
+	}
+	if cfg.Write == 0 {
+		cfg.Write = DefaultWrite
+	}
+	if cfg.Object == 0 {
+		cfg.Object = DefaultObject
+	}
+	if cfg.Users == 0 {
+		cfg.Users = DefaultUsers
+	}
+
+	return cfg
+}
+
+// Bound returns ctx that is done after d at the latest, a caller's sooner deadline is kept.
+// A non-positive d leaves ctx as it is.
+func Bound(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, d)
+}
+
+// Detached returns ctx that is not canceled with the caller's one and is done after d,
+// for the requests that keep going after the caller got its answer.
+// Such requests are always bounded, a non-positive d is replaced with DefaultWrite.
+func Detached(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		d = DefaultWrite
+	}
+
+	return context.WithTimeout(context.WithoutCancel(ctx), d)
+}
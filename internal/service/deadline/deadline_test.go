@@ -0,0 +1,52 @@
+package deadline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"itisadb/config"
+)
+
+func TestWithDefaults(t *testing.T) {
+	cfg := WithDefaults(config.TimeoutsConfig{Write: time.Second, Object: -1})
+
+	if cfg.Read != DefaultRead || cfg.Write != time.Second || cfg.Object != -1 || cfg.Users != DefaultUsers {
+		t.Errorf("got %+v", cfg)
+	}
+}
+
+func TestBound(t *testing.T) {
+	ctx, cancel := Bound(context.Background(), -1)
+	defer cancel()
+
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("a turned off timeout set a deadline")
+	}
+
+	// the sooner deadline of the caller is kept
+	parent, cancelParent := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancelParent()
+
+	ctx, cancel = Bound(parent, time.Hour)
+	defer cancel()
+
+	if d, _ := ctx.Deadline(); time.Until(d) > time.Second {
+		t.Errorf("deadline in %v", time.Until(d))
+	}
+
+	// a detached context outlives the caller's one
+	canceled, cancelCaller := context.WithCancel(context.Background())
+	cancelCaller()
+
+	ctx, cancel = Detached(canceled, 0)
+	defer cancel()
+
+	if ctx.Err() != nil {
+		t.Error("the detached context is done with the caller's one")
+	}
+
+	if d, ok := ctx.Deadline(); !ok || time.Until(d) > DefaultWrite {
+		t.Errorf("the detached context is not bounded: %v %v", d, ok)
+	}
+}
@@ -0,0 +1,480 @@
+// Package gossip is the membership protocol of the cluster, a simplified SWIM.
+//
+// Every Interval a node pings another one, the ping and its answer carry all the members
+// each side knows, so the news spread over the cluster in a few rounds. A node that doesn't answer
+// is pinged through IndirectChecks other nodes, then suspected. A suspected node that doesn't refute
+// the suspicion, by gossiping itself alive with a greater incarnation, is declared dead after SuspectTimeout.
+// A node that stops gracefully tells the others it left.
+//
+// A node joins the cluster by pinging the seeds, and pings them again while it knows no other node alive.
+package gossip
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/models"
+	"itisadb/pkg/api/cluster"
+
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultInterval       = time.Second
+	DefaultTimeout        = 500 * time.Millisecond
+	DefaultIndirectChecks = 3
+	DefaultSuspectTimeout = 5 * time.Second
+	DefaultReapTimeout    = 10 * time.Minute
+)
+
+// WithDefaults fills the fields the config does not set.
+func WithDefaults(cfg config.GossipConfig) config.GossipConfig {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.IndirectChecks <= 0 {
+		cfg.IndirectChecks = DefaultIndirectChecks
+	}
+	if cfg.SuspectTimeout <= 0 {
+		cfg.SuspectTimeout = DefaultSuspectTimeout
+	}
+	if cfg.ReapTimeout <= 0 {
+		cfg.ReapTimeout = DefaultReapTimeout
+	}
+
+	return cfg
+}
+
+// Transport sends the requests of a node to the other ones by their addresses.
+type Transport interface {
+	Ping(ctx context.Context, address string, req *cluster.PingRequest) (*cluster.PingResponse, error)
+	IndirectPing(ctx context.Context, via string, req *cluster.IndirectPingRequest) (*cluster.IndirectPingResponse, error)
+	// Forget closes the connection to the node that is gone.
+	Forget(address string)
+}
+
+type member struct {
+	models.Member
+	// since is when the state of the member changed last.
+	since time.Time
+}
+
+type Gossip struct {
+	cfg       config.GossipConfig
+	self      string
+	transport Transport
+	logger    *zap.Logger
+
+	mu      sync.Mutex
+	members map[string]*member
+	// probes is the order the other members are probed in, shuffled on every pass.
+	probes []string
+	left   bool
+
+	watchMu  sync.Mutex
+	watchers []func([]models.Member)
+	changed  chan struct{}
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// New returns the node at cfg.Address with the role, it joins the cluster after Start.
+func New(cfg config.GossipConfig, role string, transport Transport, logger *zap.Logger) *Gossip {
+	cfg = WithDefaults(cfg)
+
+	g := &Gossip{
+		cfg:       cfg,
+		self:      cfg.Address,
+		transport: transport,
+		logger:    logger.With(zap.String("gossip", cfg.Address)),
+		members:   make(map[string]*member),
+		changed:   make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+
+	g.members[g.self] = &member{
+		Member: models.Member{Address: g.self, Role: role, State: models.MemberAlive},
+		since:  time.Now(),
+	}
+
+	return g
+}
+
+// Start joins the cluster through the seeds and starts probing the members.
+func (g *Gossip) Start() {
+	g.stopped.Add(2)
+	go g.run()
+	go g.deliver()
+}
+
+// Stop stops the node without telling the others, they detect it as failed.
+func (g *Gossip) Stop() {
+	select {
+	case <-g.stop:
+		return
+	default:
+	}
+
+	close(g.stop)
+	g.stopped.Wait()
+}
+
+// Leave tells a few members that the node leaves the cluster and stops it.
+func (g *Gossip) Leave() {
+	g.mu.Lock()
+	self := g.members[g.self]
+	self.Incarnation++
+	self.State, self.since = models.MemberLeft, time.Now()
+	g.left = true
+
+	alive := g.others(models.MemberAlive)
+	g.mu.Unlock()
+
+	rand.Shuffle(len(alive), func(i, j int) { alive[i], alive[j] = alive[j], alive[i] })
+
+	var wg sync.WaitGroup
+	for _, address := range alive[:min(len(alive), g.cfg.IndirectChecks)] {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			g.ping(address)
+		}(address)
+	}
+	wg.Wait()
+
+	g.Stop()
+}
+
+// Members returns the members the node knows, itself too, sorted by their addresses.
+func (g *Gossip) Members() []models.Member {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	members := make([]models.Member, 0, len(g.members))
+	for _, m := range g.members {
+		members = append(members, m.Member)
+	}
+
+	slices.SortFunc(members, func(a, b models.Member) int {
+		return strings.Compare(a.Address, b.Address)
+	})
+
+	return members
+}
+
+// Watch calls f with the members now and after a member joins or changes its state,
+// with the latest ones if a few changes happened meanwhile. The calls are made one at a time from one goroutine.
+func (g *Gossip) Watch(f func(members []models.Member)) {
+	g.watchMu.Lock()
+	g.watchers = append(g.watchers, f)
+	g.watchMu.Unlock()
+
+	g.notify()
+}
+
+func (g *Gossip) notify() {
+	select {
+	case g.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (g *Gossip) deliver() {
+	defer g.stopped.Done()
+
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-g.changed:
+		}
+
+		g.watchMu.Lock()
+		watchers := g.watchers
+		g.watchMu.Unlock()
+
+		members := g.Members()
+		for _, f := range watchers {
+			f(slices.Clone(members))
+		}
+	}
+}
+
+// =============== protocol ====================== //
+
+func (g *Gossip) run() {
+	defer g.stopped.Done()
+
+	ticker := time.NewTicker(g.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		g.round()
+
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// round probes the next member, or the seeds while no other member is alive,
+// and moves on the members whose time in their state is over.
+func (g *Gossip) round() {
+	if target := g.nextProbe(); target != "" {
+		g.probe(target)
+	} else {
+		g.join()
+	}
+
+	g.expire()
+}
+
+func (g *Gossip) join() {
+	for _, seed := range g.cfg.Seeds {
+		if seed == g.self {
+			continue
+		}
+
+		if err := g.ping(seed); err != nil {
+			g.logger.Debug("can't reach the seed", zap.String("seed", seed), zap.Error(err))
+		}
+	}
+}
+
+// nextProbe returns the member to probe, the members are probed round-robin in a random order.
+func (g *Gossip) nextProbe() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for len(g.probes) > 0 {
+		address := g.probes[0]
+		g.probes = g.probes[1:]
+
+		if m, ok := g.members[address]; ok && probed(m.State) {
+			return address
+		}
+	}
+
+	g.probes = append(g.others(models.MemberAlive), g.others(models.MemberSuspect)...)
+	rand.Shuffle(len(g.probes), func(i, j int) { g.probes[i], g.probes[j] = g.probes[j], g.probes[i] })
+
+	if len(g.probes) == 0 {
+		return ""
+	}
+
+	address := g.probes[0]
+	g.probes = g.probes[1:]
+
+	return address
+}
+
+func probed(state string) bool {
+	return state == models.MemberAlive || state == models.MemberSuspect
+}
+
+// others returns the addresses of the other members in the state. The caller holds the lock.
+func (g *Gossip) others(state string) []string {
+	var addresses []string
+	for address, m := range g.members {
+		if address != g.self && m.State == state {
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
+}
+
+// probe pings the member directly, then through the others, and suspects it if it is not reached.
+func (g *Gossip) probe(target string) {
+	if g.ping(target) == nil {
+		return
+	}
+
+	g.mu.Lock()
+	helpers := slices.DeleteFunc(g.others(models.MemberAlive), func(address string) bool { return address == target })
+	g.mu.Unlock()
+
+	rand.Shuffle(len(helpers), func(i, j int) { helpers[i], helpers[j] = helpers[j], helpers[i] })
+	helpers = helpers[:min(len(helpers), g.cfg.IndirectChecks)]
+
+	// the helpers ping the target with their own timeout, so they get twice of it
+	ctx, cancel := context.WithTimeout(context.Background(), 2*g.cfg.Timeout)
+	defer cancel()
+
+	acks := make(chan bool, len(helpers))
+	for _, helper := range helpers {
+		go func(helper string) {
+			resp, err := g.transport.IndirectPing(ctx, helper, &cluster.IndirectPingRequest{Target: target})
+			acks <- err == nil && resp.Ack
+		}(helper)
+	}
+
+	for range helpers {
+		if <-acks {
+			return
+		}
+	}
+
+	g.suspect(target)
+}
+
+// ping exchanges the members with the node.
+func (g *Gossip) ping(address string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.cfg.Timeout)
+	defer cancel()
+
+	resp, err := g.transport.Ping(ctx, address, &cluster.PingRequest{From: g.self, Members: g.gossip()})
+	if err != nil {
+		return err
+	}
+
+	g.merge(resp.Members)
+
+	return nil
+}
+
+func (g *Gossip) gossip() []cluster.GossipMember {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	members := make([]cluster.GossipMember, 0, len(g.members))
+	for _, m := range g.members {
+		members = append(members, cluster.GossipMember{
+			Address:     m.Address,
+			Role:        m.Role,
+			State:       m.State,
+			Incarnation: m.Incarnation,
+		})
+	}
+
+	return members
+}
+
+func (g *Gossip) suspect(address string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	m, ok := g.members[address]
+	if !ok || m.State != models.MemberAlive {
+		return
+	}
+
+	g.logger.Info("Suspecting member", zap.String("member", address))
+	g.setState(m, models.MemberSuspect)
+}
+
+// expire declares dead the members suspected for too long and forgets the ones dead or left for too long.
+func (g *Gossip) expire() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for address, m := range g.members {
+		if address == g.self {
+			continue
+		}
+
+		switch {
+		case m.State == models.MemberSuspect && time.Since(m.since) >= g.cfg.SuspectTimeout:
+			g.logger.Warn("Member is dead", zap.String("member", address))
+			g.setState(m, models.MemberDead)
+		case !probed(m.State) && time.Since(m.since) >= g.cfg.ReapTimeout:
+			delete(g.members, address)
+			g.transport.Forget(address)
+		}
+	}
+}
+
+// setState changes the state of the member. The caller holds the lock.
+func (g *Gossip) setState(m *member, state string) {
+	m.State, m.since = state, time.Now()
+	g.notify()
+}
+
+// rank orders the states of the same incarnation, the news of a higher one win.
+func rank(state string) int {
+	switch state {
+	case models.MemberSuspect:
+		return 1
+	case models.MemberDead:
+		return 2
+	case models.MemberLeft:
+		return 3
+	}
+
+	return 0
+}
+
+// merge applies the news about the members that are newer than what the node knows.
+func (g *Gossip) merge(news []cluster.GossipMember) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, n := range news {
+		if n.Address == "" {
+			continue
+		}
+
+		m, ok := g.members[n.Address]
+		if !ok {
+			g.members[n.Address] = &member{
+				Member: models.Member{Address: n.Address, Role: n.Role, State: n.State, Incarnation: n.Incarnation},
+				since:  time.Now(),
+			}
+
+			g.logger.Info("New member", zap.String("member", n.Address), zap.String("state", n.State))
+			g.notify()
+			continue
+		}
+
+		if n.Address == g.self {
+			// the others think the node is suspected or dead, it tells them it is alive
+			if !g.left && n.State != models.MemberAlive && n.Incarnation >= m.Incarnation {
+				m.Incarnation = n.Incarnation + 1
+				g.logger.Info("Refuting", zap.String("state", n.State), zap.Uint64("incarnation", m.Incarnation))
+			}
+			continue
+		}
+
+		if n.Incarnation < m.Incarnation || (n.Incarnation == m.Incarnation && rank(n.State) <= rank(m.State)) {
+			continue
+		}
+
+		m.Incarnation, m.Role = n.Incarnation, n.Role
+		if n.State != m.State {
+			g.logger.Info("Member changed", zap.String("member", n.Address), zap.String("state", n.State))
+			g.setState(m, n.State)
+		}
+	}
+}
+
+// =============== server ====================== //
+
+// Server returns the gRPC service the other nodes reach the node through.
+func (g *Gossip) Server() cluster.GossipServer {
+	return server{g: g}
+}
+
+type server struct {
+	cluster.UnimplementedGossipServer
+	g *Gossip
+}
+
+func (s server) Ping(_ context.Context, req *cluster.PingRequest) (*cluster.PingResponse, error) {
+	s.g.merge(req.Members)
+	return &cluster.PingResponse{Members: s.g.gossip()}, nil
+}
+
+func (s server) IndirectPing(_ context.Context, req *cluster.IndirectPingRequest) (*cluster.IndirectPingResponse, error) {
+	return &cluster.IndirectPingResponse{Ack: s.g.ping(req.Target) == nil}, nil
+}
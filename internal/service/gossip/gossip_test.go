@@ -0,0 +1,235 @@
+package gossip
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/clustertest"
+	"itisadb/internal/models"
+	"itisadb/pkg/api/cluster"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+type node struct {
+	*Gossip
+	transport *GRPCTransport
+	blocking  *blockingTransport
+	srv       *grpc.Server
+	addr      string
+}
+
+type testCluster struct {
+	t     *testing.T
+	cfg   config.GossipConfig
+	nodes []*node
+}
+
+var _testConfig = config.GossipConfig{
+	Interval:       20 * time.Millisecond,
+	Timeout:        100 * time.Millisecond,
+	IndirectChecks: 2,
+	SuspectTimeout: 300 * time.Millisecond,
+	ReapTimeout:    time.Minute,
+}
+
+// newCluster starts n nodes on localhost, all of them are seeded with the first one.
+func newCluster(t *testing.T, n int) *testCluster {
+	c := &testCluster{t: t, cfg: _testConfig}
+
+	for _, addr := range clustertest.Addresses(t, n) {
+		c.nodes = append(c.nodes, &node{addr: addr})
+	}
+
+	c.cfg.Seeds = []string{c.nodes[0].addr}
+
+	for i := range c.nodes {
+		c.start(i)
+	}
+
+	t.Cleanup(func() {
+		for i := range c.nodes {
+			c.stop(i)
+		}
+	})
+
+	return c
+}
+
+func (c *testCluster) start(i int) {
+	n := c.nodes[i]
+
+	cfg := c.cfg
+	cfg.Address = n.addr
+
+	n.transport = NewTransport(nil, clustertest.Insecure())
+	n.blocking = &blockingTransport{Transport: n.transport}
+	n.Gossip = New(cfg, models.MemberServer, n.blocking, zap.NewNop())
+
+	n.srv = clustertest.Serve(c.t, n.addr, func(srv *grpc.Server) {
+		cluster.RegisterGossipServer(srv, n.Server())
+	})
+
+	n.Start()
+}
+
+// stop stops the node at once, like a crash.
+func (c *testCluster) stop(i int) {
+	n := c.nodes[i]
+	if n.srv == nil {
+		return
+	}
+
+	n.srv.Stop()
+	n.Stop()
+	n.transport.Close()
+	n.srv = nil
+}
+
+func (c *testCluster) leave(i int) {
+	n := c.nodes[i]
+
+	n.Leave()
+	n.srv.Stop()
+	n.transport.Close()
+	n.srv = nil
+}
+
+// sees waits until every running node sees the node in the state.
+func (c *testCluster) sees(i int, state string) {
+	c.t.Helper()
+
+	clustertest.Eventually(c.t, fmt.Sprintf("node %d to be %s for every node", i, state), func() bool {
+		for _, n := range c.nodes {
+			if n.srv != nil && stateOf(n.Members(), c.nodes[i].addr) != state {
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+func stateOf(members []models.Member, address string) string {
+	i := slices.IndexFunc(members, func(m models.Member) bool { return m.Address == address })
+	if i < 0 {
+		return ""
+	}
+
+	return members[i].State
+}
+
+func TestJoin(t *testing.T) {
+	c := newCluster(t, 5)
+
+	for i := range c.nodes {
+		c.sees(i, models.MemberAlive)
+	}
+
+	members := c.nodes[4].Members()
+	if len(members) != 5 {
+		t.Fatalf("got %d members, want 5", len(members))
+	}
+
+	if members[0].Role != models.MemberServer {
+		t.Errorf("got role %q, want %q", members[0].Role, models.MemberServer)
+	}
+}
+
+func TestFailure(t *testing.T) {
+	c := newCluster(t, 4)
+	for i := range c.nodes {
+		c.sees(i, models.MemberAlive)
+	}
+
+	var (
+		mu      sync.Mutex
+		watched []models.Member
+	)
+	c.nodes[0].Watch(func(members []models.Member) {
+		mu.Lock()
+		defer mu.Unlock()
+		watched = members
+	})
+
+	c.stop(3)
+	c.sees(3, models.MemberDead)
+
+	mu.Lock()
+	if stateOf(watched, c.nodes[3].addr) != models.MemberDead {
+		t.Errorf("the watcher didn't get the dead member: %v", watched)
+	}
+	mu.Unlock()
+
+	t.Run("restart", func(t *testing.T) {
+		// the node hears it is dead and refutes it with a greater incarnation
+		c.start(3)
+		c.sees(3, models.MemberAlive)
+
+		members := c.nodes[0].Members()
+		if i := slices.IndexFunc(members, func(m models.Member) bool { return m.Address == c.nodes[3].addr }); members[i].Incarnation == 0 {
+			t.Errorf("the node is alive again with incarnation 0")
+		}
+	})
+}
+
+func TestLeave(t *testing.T) {
+	c := newCluster(t, 3)
+	for i := range c.nodes {
+		c.sees(i, models.MemberAlive)
+	}
+
+	c.leave(2)
+	c.sees(2, models.MemberLeft)
+}
+
+func TestIndirect(t *testing.T) {
+	c := newCluster(t, 3)
+	for i := range c.nodes {
+		c.sees(i, models.MemberAlive)
+	}
+
+	// the first node can't reach the last one, the second one still can
+	c.nodes[0].blocking.block(c.nodes[2].addr)
+
+	time.Sleep(3 * c.cfg.SuspectTimeout)
+
+	// a suspicion would have been refuted with a greater incarnation
+	members := c.nodes[0].Members()
+	i := slices.IndexFunc(members, func(m models.Member) bool { return m.Address == c.nodes[2].addr })
+	if m := members[i]; m.State != models.MemberAlive || m.Incarnation != 0 {
+		t.Errorf("the node reached through the others is %s with incarnation %d", m.State, m.Incarnation)
+	}
+}
+
+// blockingTransport fails the direct pings to the blocked node.
+type blockingTransport struct {
+	Transport
+
+	mu      sync.Mutex
+	blocked string
+}
+
+func (t *blockingTransport) block(address string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.blocked = address
+}
+
+func (t *blockingTransport) Ping(ctx context.Context, address string, req *cluster.PingRequest) (*cluster.PingResponse, error) {
+	t.mu.Lock()
+	blocked := t.blocked == address
+	t.mu.Unlock()
+
+	if blocked {
+		return nil, context.DeadlineExceeded
+	}
+
+	return t.Transport.Ping(ctx, address, req)
+}
@@ -0,0 +1,49 @@
+package gossip
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"itisadb/internal/service/peers"
+	"itisadb/pkg/api/cluster"
+)
+
+// GRPCTransport reaches the members at their gRPC addresses.
+type GRPCTransport struct {
+	peers *peers.Peers
+}
+
+// NewTransport returns the transport that dials the members with the options.
+// Without auth the requests are made without a token.
+func NewTransport(auth peers.Authenticator, opts ...grpc.DialOption) *GRPCTransport {
+	return &GRPCTransport{peers: peers.New(auth, opts...)}
+}
+
+// Close closes the connections to the members.
+func (t *GRPCTransport) Close() {
+	t.peers.Close()
+}
+
+func (t *GRPCTransport) Forget(address string) {
+	t.peers.Forget(address)
+}
+
+func (t *GRPCTransport) Ping(ctx context.Context, address string, req *cluster.PingRequest) (*cluster.PingResponse, error) {
+	conn, ctx, err := t.peers.Conn(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cluster.NewGossipClient(conn).Ping(ctx, req)
+	return resp, t.peers.Checked(address, err)
+}
+
+func (t *GRPCTransport) IndirectPing(ctx context.Context, via string, req *cluster.IndirectPingRequest) (*cluster.IndirectPingResponse, error) {
+	conn, ctx, err := t.peers.Conn(ctx, via)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cluster.NewGossipClient(conn).IndirectPing(ctx, req)
+	return resp, t.peers.Checked(via, err)
+}
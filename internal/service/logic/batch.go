@@ -0,0 +1,90 @@
+package logic
+
+import (
+	"context"
+	"slices"
+
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+
+	"github.com/egorgasay/gost"
+)
+
+// MGet reads the keys under one storage lock. A key the caller may not read gets ErrForbidden.
+func (l *Logic) MGet(_ context.Context, claims gost.Option[models.UserClaims], keys []string, _ models.GetOptions) (res gost.Result[[]models.GetResult]) {
+	values := l.storage.GetMany(keys)
+
+	results := make([]models.GetResult, len(keys))
+	for i, key := range keys {
+		results[i].Key = key
+
+		switch v := values[i]; {
+		case v.IsNone():
+			results[i].Err = constants.ErrNotFound
+		case !l.security.HasPermission(claims, v.Unwrap().Level):
+			results[i].Err = constants.ErrForbidden
+		default:
+			results[i].Value = v.Unwrap()
+		}
+	}
+
+	return res.Ok(results)
+}
+
+// MSet writes the values under one storage lock, with the checks of SetOne for each key.
+// The results are sorted by key.
+func (l *Logic) MSet(_ context.Context, claims gost.Option[models.UserClaims], values map[string]string, opt models.SetOptions) (res gost.Result[[]models.SetResult]) {
+	if rW := l.reserve(len(values)); rW.IsErr() {
+		return res.Err(rW.Error())
+	}
+	defer l.unreserve(len(values))
+
+	if !l.security.HasPermission(claims, opt.Level) {
+		return res.Err(constants.ErrForbidden)
+	}
+
+	l.txMu.RLock()
+	defer l.txMu.RUnlock()
+
+	errs := l.storage.SetMany(values, opt, func(key string, old gost.Option[models.Value]) error {
+		if errX := l.locked(key); errX != nil {
+			return errX
+		}
+
+		if old.IsNone() {
+			return nil
+		}
+
+		if !l.security.HasPermission(claims, old.Unwrap().Level) {
+			return constants.ErrForbidden
+		}
+
+		if opt.Unique || old.Unwrap().ReadOnly {
+			return constants.ErrAlreadyExists
+		}
+
+		return nil
+	})
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	opt.Encrypt = opt.Level == constants.SecretLevel
+
+	results := make([]models.SetResult, len(keys))
+	for i, key := range keys {
+		results[i] = models.SetResult{Key: key, Server: constants.LocalServerNumber, Err: errs[key]}
+		if results[i].Err != nil || !l.cfg.TransactionLogger.On {
+			continue
+		}
+
+		if rLog := l.tlogger.WriteSet(key, values[key], opt); rLog.IsErr() {
+			results[i].Err = rLog.Error()
+		}
+	}
+
+	return res.Ok(results)
+}
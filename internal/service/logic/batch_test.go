@@ -0,0 +1,89 @@
+package logic
+
+import (
+	"context"
+	"testing"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+	"itisadb/internal/service/security"
+	"itisadb/internal/storage"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+
+	admin := gost.Some(models.UserClaims{Level: constants.MaxLevel})
+	user := gost.Some(models.UserClaims{Level: constants.DefaultLevel})
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec := security.NewSecurityService(config.SecurityConfig{MandatoryAuthorization: true}, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+	l := NewLogic(store, config.Config{}, nil, zap.NewNop(), sec)
+
+	l.SetOne(ctx, admin, "secret", "s", models.SetOptions{Level: constants.SecretLevel})
+	l.SetOne(ctx, admin, "fixed", "f", models.SetOptions{ReadOnly: true})
+
+	rSet := l.MSet(ctx, user, map[string]string{"b": "2", "a": "1", "secret": "x", "fixed": "x"}, models.SetOptions{})
+	if rSet.IsErr() {
+		t.Fatal(rSet.Error())
+	}
+
+	wantSet := map[string]*gost.ErrX{"a": nil, "b": nil, "fixed": constants.ErrAlreadyExists, "secret": constants.ErrForbidden}
+	gotSet := rSet.Unwrap()
+	if len(gotSet) != len(wantSet) || gotSet[0].Key != "a" || gotSet[3].Key != "secret" {
+		t.Fatalf("got %+v, want the keys sorted", gotSet)
+	}
+
+	for _, res := range gotSet {
+		if want := wantSet[res.Key]; (want == nil) != (res.Err == nil) || (want != nil && res.Err != want) {
+			t.Errorf("%s: got %v, want %v", res.Key, res.Err, want)
+		}
+	}
+
+	if v := store.Get("secret"); v.IsNone() || v.Unwrap().Value != "s" {
+		t.Errorf("forbidden write changed the value: %+v", v)
+	}
+
+	rGet := l.MGet(ctx, user, []string{"a", "missing", "secret", "b", "a"}, models.GetOptions{})
+	if rGet.IsErr() {
+		t.Fatal(rGet.Error())
+	}
+
+	got := rGet.Unwrap()
+	want := []struct {
+		key, value string
+		err        *gost.ErrX
+	}{
+		{"a", "1", nil},
+		{"missing", "", constants.ErrNotFound},
+		{"secret", "", constants.ErrForbidden},
+		{"b", "2", nil},
+		{"a", "1", nil},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, want %d", len(got), len(want))
+	}
+
+	for i, w := range want {
+		if got[i].Key != w.key || got[i].Value.Value != w.value {
+			t.Errorf("%d: got %+v, want %s=%q", i, got[i], w.key, w.value)
+		}
+
+		if (w.err == nil) != (got[i].Err == nil) || (w.err != nil && got[i].Err != w.err) {
+			t.Errorf("%s: got %v, want %v", w.key, got[i].Err, w.err)
+		}
+	}
+
+	if r := l.MSet(ctx, user, map[string]string{"c": "3"}, models.SetOptions{Level: constants.SecretLevel}); r.IsOk() {
+		t.Error("user wrote a secret batch")
+	}
+}
@@ -0,0 +1,20 @@
+package logic
+
+import (
+	"context"
+
+	"itisadb/pkg/bloom"
+
+	"github.com/egorgasay/gost"
+)
+
+// KeyFilter returns the Bloom filter over the keys of the node, the balancer pulls it
+// to skip the node when it searches for a key it doesn't know the server of.
+func (l *Logic) KeyFilter(_ context.Context) (res gost.Result[*bloom.Filter]) {
+	return res.Ok(l.storage.KeyFilter())
+}
+
+// MayHave reports false only when the key is not stored on the node.
+func (l *Logic) MayHave(key string) bool {
+	return l.storage.MayHave(key)
+}
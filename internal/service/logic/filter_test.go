@@ -0,0 +1,57 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"itisadb/config"
+	"itisadb/internal/models"
+	"itisadb/internal/service/security"
+	"itisadb/internal/storage"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+func TestKeyFilter(t *testing.T) {
+	ctx := context.Background()
+	claims := gost.None[models.UserClaims]()
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec := security.NewSecurityService(config.SecurityConfig{}, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+	l := NewLogic(store, config.Config{}, nil, zap.NewNop(), sec)
+
+	// more keys than the first filter is built for, so it is built again on the way
+	const n = 1 << 17
+	for i := 0; i < n; i++ {
+		l.SetOne(ctx, claims, fmt.Sprintf("key%d", i), "v", models.SetOptions{})
+	}
+	l.MSet(ctx, claims, map[string]string{"batch": "v"}, models.SetOptions{})
+
+	f := l.KeyFilter(ctx).Unwrap()
+	for i := 0; i < n; i++ {
+		if key := fmt.Sprintf("key%d", i); !l.MayHave(key) || !f.MayContain(key) {
+			t.Fatalf("%s is stored, but not in the filter", key)
+		}
+	}
+
+	if !l.MayHave("batch") {
+		t.Error("the key of the batch is not in the filter")
+	}
+
+	missed := 0
+	for i := 0; i < 1000; i++ {
+		if !l.MayHave(fmt.Sprintf("other%d", i)) {
+			missed++
+		}
+	}
+
+	if missed < 950 {
+		t.Errorf("the filter has only %d of 1000 absent keys", missed)
+	}
+}
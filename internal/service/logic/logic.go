@@ -2,6 +2,7 @@ package logic
 
 import (
 	"context"
+	"sync"
 
 	"itisadb/config"
 	"itisadb/internal/constants"
@@ -18,6 +19,16 @@ type Logic struct {
 	tlogger  domains.TransactionLogger
 	security domains.SecurityService
 
+	// usersMu serializes user changes, so the change ID written
+	// to the transaction log is the one the change got.
+	usersMu sync.Mutex
+
+	// txMu guards the prepared transactions and the keys they lock.
+	// The writes outside of transactions hold it for reading, so they don't interleave with Prepare.
+	txMu  sync.RWMutex
+	txs   map[string]models.Transaction
+	locks map[string]string
+
 	logger *zap.Logger
 }
 
@@ -44,6 +55,8 @@ func NewLogic(
 
 		if r.IsErr() {
 			logger.Error("failed to create default user", zap.Error(r.Error()))
+		} else {
+			logDefaultUser(storage, cfg, tlogger, "itisadb")
 		}
 	}
 
@@ -62,15 +75,63 @@ func NewLogic(
 
 		if r.IsErr() {
 			logger.Error("failed to create demo user", zap.Error(r.Error()))
+		} else {
+			logDefaultUser(storage, cfg, tlogger, "demo")
 		}
 	}
 
-	return &Logic{
+	l := &Logic{
 		storage:  storage,
 		cfg:      cfg,
 		tlogger:  tlogger,
 		logger:   logger,
 		security: security,
+		txs:      make(map[string]models.Transaction),
+		locks:    make(map[string]string),
+	}
+
+	// the coordinators resolve the transactions the node voted for before it stopped
+	if cfg.TransactionLogger.On {
+		for _, tx := range tlogger.InDoubt() {
+			logger.Info("transaction is in doubt", zap.String("tx", tx.ID))
+			l.hold(tx)
+		}
+	}
+
+	return l
+}
+
+// reserve takes room for n events in the transaction log queue before the data is changed,
+// so that the data is never changed without being logged. unreserve gives it back.
+func (l *Logic) reserve(n int) (r gost.ResultN) {
+	if !l.cfg.TransactionLogger.On {
+		return r.Ok()
+	}
+
+	return l.tlogger.Reserve(n)
+}
+
+func (l *Logic) unreserve(n int) {
+	if l.cfg.TransactionLogger.On {
+		l.tlogger.Release(n)
+	}
+}
+
+// logDefaultUser writes a freshly created default user to the transaction log,
+// so the next restore finds it and keeps the user change IDs in place.
+func logDefaultUser(storage domains.Storage, cfg config.Config, tlogger domains.TransactionLogger, login string) {
+	if !cfg.TransactionLogger.On {
+		return
+	}
+
+	r := storage.GetUserByName(login)
+	if r.IsErr() {
+		return
+	}
+
+	if tlogger.Reserve(1).IsOk() {
+		tlogger.WriteNewUser(r.Unwrap())
+		tlogger.Release(1)
 	}
 }
 
@@ -90,6 +151,18 @@ func (l *Logic) GetOne(_ context.Context, claims gost.Option[models.UserClaims],
 }
 
 func (l *Logic) DelOne(_ context.Context, claims gost.Option[models.UserClaims], key string, _ models.DeleteOptions) (res gost.ResultN) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return res.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
+	l.txMu.RLock()
+	defer l.txMu.RUnlock()
+
+	if errX := l.locked(key); errX != nil {
+		return res.Err(errX)
+	}
+
 	v := l.storage.Get(key)
 	if v.IsNone() {
 		return res.Err(constants.ErrNotFound)
@@ -107,17 +180,31 @@ func (l *Logic) DelOne(_ context.Context, claims gost.Option[models.UserClaims],
 	}
 
 	if l.cfg.TransactionLogger.On {
-		l.tlogger.WriteDelete(key)
+		if rLog := l.tlogger.WriteDelete(key); rLog.IsErr() {
+			return res.Err(rLog.Error())
+		}
 	}
 
 	return res.Ok()
 }
 
 func (l *Logic) SetOne(_ context.Context, claims gost.Option[models.UserClaims], key string, val string, opt models.SetOptions) (res gost.Result[int32]) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return res.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
 	if !l.security.HasPermission(claims, opt.Level) {
 		return res.Err(constants.ErrForbidden)
 	}
 
+	l.txMu.RLock()
+	defer l.txMu.RUnlock()
+
+	if errX := l.locked(key); errX != nil {
+		return res.Err(errX)
+	}
+
 	r := l.storage.Get(key)
 	if r.IsSome() {
 		if !l.security.HasPermission(claims, r.Unwrap().Level) {
@@ -136,7 +223,9 @@ func (l *Logic) SetOne(_ context.Context, claims gost.Option[models.UserClaims],
 
 	if l.cfg.TransactionLogger.On {
 		opt.Encrypt = opt.Level == constants.SecretLevel
-		l.tlogger.WriteSet(key, val, opt)
+		if rLog := l.tlogger.WriteSet(key, val, opt); rLog.IsErr() {
+			return res.Err(rLog.Error())
+		}
 	}
 
 	return res.Ok(constants.LocalServerNumber)
@@ -152,6 +241,11 @@ func (l *Logic) HasPermissionToObject(claims gost.Option[models.UserClaims], nam
 }
 
 func (l *Logic) NewObject(_ context.Context, claims gost.Option[models.UserClaims], name string, opts models.ObjectOptions) (res gost.ResultN) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return res.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
 	if !l.security.HasPermission(claims, opts.Level) {
 		return res.Err(constants.ErrForbidden)
 	}
@@ -168,13 +262,20 @@ func (l *Logic) NewObject(_ context.Context, claims gost.Option[models.UserClaim
 	l.storage.AddObjectInfo(name, info) // TODO: maybe you should union Create + AddObjectInfo? and keep all information about object in one place?
 
 	if l.cfg.TransactionLogger.On {
-		l.tlogger.WriteCreateObject(name, info)
+		if rLog := l.tlogger.WriteCreateObject(name, info); rLog.IsErr() {
+			return res.Err(rLog.Error())
+		}
 	}
 
 	return res.Ok()
 }
 
 func (l *Logic) SetToObject(ctx context.Context, claims gost.Option[models.UserClaims], object string, key string, value string, opts models.SetToObjectOptions) (res gost.ResultN) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return res.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
 	infoR := l.storage.GetObjectInfo(object)
 	if infoR.IsNone() {
 		if r := l.NewObject(ctx, claims, object, models.ObjectOptions{
@@ -196,7 +297,9 @@ func (l *Logic) SetToObject(ctx context.Context, claims gost.Option[models.UserC
 
 	if l.cfg.TransactionLogger.On {
 		opts.Encrypt = info.Level == constants.SecretLevel
-		l.tlogger.WriteSetToObject(object, key, value, opts)
+		if rLog := l.tlogger.WriteSetToObject(object, key, value, opts); rLog.IsErr() {
+			return res.Err(rLog.Error())
+		}
 	}
 
 	return res.Ok()
@@ -263,6 +366,11 @@ func (l *Logic) ObjectSize(_ context.Context, claims gost.Option[models.UserClai
 }
 
 func (l *Logic) DeleteObject(_ context.Context, claims gost.Option[models.UserClaims], object string, _ models.DeleteObjectOptions) (res gost.ResultN) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return res.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
 	infoR := l.storage.GetObjectInfo(object)
 	if infoR.IsNone() {
 		return res.Err(constants.ErrObjectNotFound)
@@ -279,13 +387,20 @@ func (l *Logic) DeleteObject(_ context.Context, claims gost.Option[models.UserCl
 	l.storage.DeleteObjectInfo(object)
 
 	if l.cfg.TransactionLogger.On {
-		l.tlogger.WriteDeleteObject(object)
+		if rLog := l.tlogger.WriteDeleteObject(object); rLog.IsErr() {
+			return res.Err(rLog.Error())
+		}
 	}
 
 	return res.Ok()
 }
 
 func (l *Logic) AttachToObject(_ context.Context, claims gost.Option[models.UserClaims], dst, src string, _ models.AttachToObjectOptions) (res gost.ResultN) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return res.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
 	infoDstR := l.storage.GetObjectInfo(dst)
 	if infoDstR.IsNone() {
 		return res.Err(constants.ErrObjectNotFound)
@@ -309,13 +424,20 @@ func (l *Logic) AttachToObject(_ context.Context, claims gost.Option[models.User
 	}
 
 	if l.cfg.TransactionLogger.On {
-		l.tlogger.WriteAttach(dst, src)
+		if rLog := l.tlogger.WriteAttach(dst, src); rLog.IsErr() {
+			return res.Err(rLog.Error())
+		}
 	}
 
 	return res.Ok()
 }
 
 func (l *Logic) ObjectDeleteKey(_ context.Context, claims gost.Option[models.UserClaims], object, key string, _ models.DeleteAttrOptions) (res gost.ResultN) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return res.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
 	infoR := l.storage.GetObjectInfo(object)
 	if infoR.IsNone() {
 		return res.Err(constants.ErrObjectNotFound)
@@ -332,7 +454,9 @@ func (l *Logic) ObjectDeleteKey(_ context.Context, claims gost.Option[models.Use
 	}
 
 	if l.cfg.TransactionLogger.On {
-		l.tlogger.WriteDeleteAttr(object, key)
+		if rLog := l.tlogger.WriteDeleteAttr(object, key); rLog.IsErr() {
+			return res.Err(rLog.Error())
+		}
 	}
 
 	return res.Ok()
@@ -0,0 +1,129 @@
+package logic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+	"itisadb/internal/service/security"
+	transactionlogger "itisadb/internal/service/transaction-logger"
+	"itisadb/internal/storage"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+// newFullQueueLogic returns a Logic whose transaction log queue is full:
+// the logger is not running and the queue holds the default users and one key.
+func newFullQueueLogic(t *testing.T, policy string) (*Logic, *storage.Storage, *transactionlogger.TransactionLogger) {
+	t.Helper()
+
+	cfg := config.Config{TransactionLogger: config.TransactionLoggerConfig{
+		On:              true,
+		BackupDirectory: t.TempDir(),
+		QueueSize:       3,
+		QueueFullPolicy: policy,
+		QueueTimeout:    50 * time.Millisecond,
+	}}
+	sec := security.NewSecurityService(config.SecurityConfig{}, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tl, err := transactionlogger.New(cfg.TransactionLogger, zap.NewNop(), sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewLogic(store, cfg, tl, zap.NewNop(), sec)
+
+	if r := l.SetOne(context.Background(), gost.None[models.UserClaims](), "first", "value", models.SetOptions{}); r.IsErr() {
+		t.Fatal(r.Error())
+	}
+
+	return l, store, tl
+}
+
+func TestFullQueue(t *testing.T) {
+	ctx := context.Background()
+	claims := gost.None[models.UserClaims]()
+
+	t.Run("reject", func(t *testing.T) {
+		l, store, _ := newFullQueueLogic(t, config.RejectPolicy)
+
+		r := l.SetOne(ctx, claims, "key", "value", models.SetOptions{})
+		if r.IsOk() || !r.Error().Is(constants.ErrLogQueueFull) {
+			t.Fatalf("got %v, want %v", r.Error(), constants.ErrLogQueueFull)
+		}
+
+		if store.Get("key").IsSome() {
+			t.Error("rejected write changed the storage")
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		l, store, _ := newFullQueueLogic(t, config.BlockPolicy)
+
+		start := time.Now()
+		r := l.SetOne(ctx, claims, "key", "value", models.SetOptions{})
+		if r.IsOk() || !r.Error().Is(constants.ErrLogQueueFull) {
+			t.Fatalf("got %v, want %v", r.Error(), constants.ErrLogQueueFull)
+		}
+
+		if took := time.Since(start); took < 50*time.Millisecond {
+			t.Errorf("write failed after %s, before the queue timeout", took)
+		}
+
+		if store.Get("key").IsSome() {
+			t.Error("rejected write changed the storage")
+		}
+	})
+
+	t.Run("readonly", func(t *testing.T) {
+		l, store, tl := newFullQueueLogic(t, config.ReadOnlyPolicy)
+
+		for _, key := range []string{"key1", "key2"} {
+			r := l.SetOne(ctx, claims, key, "value", models.SetOptions{})
+			if r.IsOk() || !r.Error().Is(constants.ErrLogReadOnly) {
+				t.Fatalf("got %v, want %v", r.Error(), constants.ErrLogReadOnly)
+			}
+
+			if store.Get(key).IsSome() {
+				t.Errorf("%s: rejected write changed the storage", key)
+			}
+		}
+
+		if r := l.NewObject(ctx, claims, "object", models.ObjectOptions{}); r.IsOk() || !r.Error().Is(constants.ErrLogReadOnly) {
+			t.Fatalf("got %v, want %v", r.Error(), constants.ErrLogReadOnly)
+		}
+
+		if m := tl.Metrics(); m["queue_depth"] != 3 || m["reserved"] != int64(0) || m["read_only"] != true {
+			t.Errorf("unexpected metrics: %v", m)
+		}
+
+		tl.Run()
+		defer tl.Stop()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for tl.Reserve(1).IsErr() {
+			if time.Now().After(deadline) {
+				t.Fatal("node stayed read-only after the queue was drained")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		tl.Release(1)
+
+		if r := l.SetOne(ctx, claims, "key1", "value", models.SetOptions{}); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+
+		if tl.Healthy() != nil {
+			t.Errorf("logger is unhealthy: %v", tl.Healthy())
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package logic
+
+import (
+	"context"
+	"slices"
+
+	"itisadb/internal/models"
+	"itisadb/internal/service/servers/ring"
+	"itisadb/pkg/merkle"
+
+	"github.com/egorgasay/gost"
+)
+
+// MerkleTree digests the values of the node whose points on the hash ring fall in ranges,
+// the balancer compares the trees of the replicas to find the keys they disagree on.
+// The objects have one copy and are left out.
+func (l *Logic) MerkleTree(ctx context.Context, ranges merkle.Ranges, depth int) (res gost.Result[*merkle.Tree]) {
+	tree := merkle.New(depth)
+
+	rScan := l.Scan(ctx, func(e models.Entry) error {
+		if e.Kind != models.ValueEntry {
+			return nil
+		}
+
+		if h := ring.Hash(e.Key); ranges.Contains(h) {
+			tree.Add(h, e.Key, e.Value.Value)
+		}
+
+		return nil
+	})
+	if rScan.IsErr() {
+		return res.Err(rScan.Error())
+	}
+
+	return res.Ok(tree)
+}
+
+// MerkleLeaves returns the values under the leaves of the tree MerkleTree builds with the same ranges and depth.
+func (l *Logic) MerkleLeaves(ctx context.Context, ranges merkle.Ranges, depth int, leaves []int) (res gost.Result[[]models.Entry]) {
+	tree := merkle.New(depth)
+
+	var entries []models.Entry
+	rScan := l.Scan(ctx, func(e models.Entry) error {
+		if e.Kind != models.ValueEntry {
+			return nil
+		}
+
+		if h := ring.Hash(e.Key); ranges.Contains(h) && slices.Contains(leaves, tree.Leaf(h)) {
+			entries = append(entries, e)
+		}
+
+		return nil
+	})
+	if rScan.IsErr() {
+		return res.Err(rScan.Error())
+	}
+
+	return res.Ok(entries)
+}
@@ -0,0 +1,72 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"itisadb/config"
+	"itisadb/internal/models"
+	"itisadb/internal/service/security"
+	"itisadb/internal/service/servers/ring"
+	"itisadb/internal/storage"
+	"itisadb/pkg/merkle"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+func TestMerkleTree(t *testing.T) {
+	ctx := context.Background()
+	claims := gost.None[models.UserClaims]()
+	sec := security.NewSecurityService(config.SecurityConfig{}, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+
+	newLogic := func() *Logic {
+		store, err := storage.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return NewLogic(store, config.Config{}, nil, zap.NewNop(), sec)
+	}
+
+	a, b := newLogic(), newLogic()
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		a.SetOne(ctx, claims, key, "v", models.SetOptions{})
+		b.SetOne(ctx, claims, key, "v", models.SetOptions{})
+	}
+	b.SetOne(ctx, claims, "key7", "changed", models.SetOptions{})
+
+	// a part of the ring that ends at the changed key
+	h := ring.Hash("key7")
+	ranges := merkle.Normalize([]merkle.Range{{From: h / 2, To: h}})
+
+	treeA := a.MerkleTree(ctx, ranges, 4).Unwrap()
+	treeB := b.MerkleTree(ctx, ranges, 4).Unwrap()
+
+	leaves, err := treeA.Diff(treeB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(leaves) != 1 || leaves[0] != treeA.Leaf(h) {
+		t.Fatalf("the trees differ at %v, want leaf %d", leaves, treeA.Leaf(h))
+	}
+
+	entries := b.MerkleLeaves(ctx, ranges, 4, leaves).Unwrap()
+
+	found := false
+	for _, e := range entries {
+		if h := ring.Hash(e.Key); !ranges.Contains(h) || treeA.Leaf(h) != leaves[0] {
+			t.Errorf("%s is not under leaf %d", e.Key, leaves[0])
+		}
+		if e.Key == "key7" && e.Value.Value == "changed" {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Error("the changed key is not under its leaf")
+	}
+}
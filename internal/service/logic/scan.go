@@ -0,0 +1,60 @@
+package logic
+
+import (
+	"context"
+
+	"github.com/egorgasay/gost"
+	"itisadb/internal/models"
+)
+
+// Scan passes the values and objects of the storage to f, the users are left out.
+// The entries are copied under the storage locks and passed to f once they are released,
+// so a slow f doesn't hold up the writes.
+func (l *Logic) Scan(ctx context.Context, f func(models.Entry) error) (res gost.ResultN) {
+	v := &entryVisitor{ctx: ctx}
+	if err := l.storage.Snapshot(v); err != nil {
+		return res.ErrNewUnknown(err.Error())
+	}
+
+	for _, e := range v.entries {
+		if err := ctx.Err(); err != nil {
+			return res.ErrNewUnknown(err.Error())
+		}
+
+		if err := f(e); err != nil {
+			return res.ErrNewUnknown(err.Error())
+		}
+	}
+
+	return res.Ok()
+}
+
+type entryVisitor struct {
+	ctx     context.Context
+	entries []models.Entry
+}
+
+func (v *entryVisitor) Value(key string, val models.Value) error {
+	if err := v.ctx.Err(); err != nil {
+		return err
+	}
+
+	v.entries = append(v.entries, models.Entry{Kind: models.ValueEntry, Key: key, Value: val})
+	return nil
+}
+
+func (v *entryVisitor) Object(name string, info models.ObjectInfo) error {
+	if err := v.ctx.Err(); err != nil {
+		return err
+	}
+
+	v.entries = append(v.entries, models.Entry{Kind: models.ObjectEntry, Object: name, Value: models.Value{Level: info.Level}})
+	return nil
+}
+
+func (v *entryVisitor) ObjectValue(object, key string, val models.OValue) error {
+	v.entries = append(v.entries, models.Entry{Kind: models.ObjectValueEntry, Object: object, Key: key, Value: models.Value{ReadOnly: val.ReadOnly, Value: val.Value}})
+	return nil
+}
+
+func (v *entryVisitor) User(models.User) error { return nil }
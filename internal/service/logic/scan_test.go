@@ -0,0 +1,54 @@
+package logic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/models"
+	"itisadb/internal/service/security"
+	"itisadb/internal/storage"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+func TestScanWrites(t *testing.T) {
+	ctx := context.Background()
+	claims := gost.None[models.UserClaims]()
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec := security.NewSecurityService(config.SecurityConfig{}, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+	l := NewLogic(store, config.Config{}, nil, zap.NewNop(), sec)
+
+	l.SetOne(ctx, claims, "key", "value", models.SetOptions{})
+
+	// f writes to the storage it scans, it would wait forever under the lock
+	done := make(chan gost.ResultN)
+	go func() {
+		done <- l.Scan(ctx, func(e models.Entry) error {
+			if r := l.SetOne(ctx, claims, e.Key+"_copy", e.Value.Value, models.SetOptions{}); r.IsErr() {
+				return r.Error()
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case r := <-done:
+		if r.IsErr() {
+			t.Fatal(r.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the scan holds the storage while f runs")
+	}
+
+	if r := l.GetOne(ctx, claims, "key_copy", models.GetOptions{}); r.IsErr() || r.Unwrap().Value != "value" {
+		t.Fatalf("key_copy: %+v", r)
+	}
+}
@@ -0,0 +1,22 @@
+package logic
+
+import (
+	"context"
+
+	"itisadb/config"
+	"itisadb/internal/models"
+
+	"github.com/egorgasay/gost"
+)
+
+// NodeStats reports the size of the storage and the replication role of the node.
+func (l *Logic) NodeStats(_ context.Context) (res gost.Result[models.NodeStats]) {
+	keys, objects := l.storage.Count()
+
+	role := l.cfg.Replication.Role
+	if role == "" {
+		role = config.PrimaryRole
+	}
+
+	return res.Ok(models.NodeStats{Keys: uint64(keys), Objects: uint64(objects), Role: role})
+}
@@ -0,0 +1,43 @@
+package logic
+
+import (
+	"context"
+	"testing"
+
+	"itisadb/config"
+	"itisadb/internal/models"
+	"itisadb/internal/service/security"
+	"itisadb/internal/storage"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+func TestNodeStats(t *testing.T) {
+	ctx := context.Background()
+	claims := gost.None[models.UserClaims]()
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{Replication: config.ReplicationConfig{Role: config.ReplicaRole}}
+	sec := security.NewSecurityService(config.SecurityConfig{}, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+	l := NewLogic(store, config.Config{}, nil, zap.NewNop(), sec)
+
+	l.SetOne(ctx, claims, "a", "1", models.SetOptions{})
+	l.SetOne(ctx, claims, "b", "2", models.SetOptions{})
+	l.NewObject(ctx, claims, "obj", models.ObjectOptions{})
+	l.NewObject(ctx, claims, "obj.inner", models.ObjectOptions{})
+
+	st := l.NodeStats(ctx).Unwrap()
+	if st.Keys != 2 || st.Objects != 2 || st.Role != config.PrimaryRole {
+		t.Errorf("got %+v", st)
+	}
+
+	l.cfg = cfg
+	if st := l.NodeStats(ctx).Unwrap(); st.Role != config.ReplicaRole {
+		t.Errorf("got role %q, want %q", st.Role, config.ReplicaRole)
+	}
+}
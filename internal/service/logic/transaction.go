@@ -0,0 +1,169 @@
+package logic
+
+import (
+	"context"
+	"slices"
+
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+// Prepare checks that tx can be committed, locks its keys and logs it.
+// Once Prepare succeeds the node commits tx whenever it is asked to, even after a restart,
+// and the other writes of its keys fail with ErrTxConflict until it is resolved.
+func (l *Logic) Prepare(_ context.Context, claims gost.Option[models.UserClaims], tx models.Transaction) (res gost.ResultN) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return res.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
+	if !l.security.HasPermission(claims, tx.Opts.Level) {
+		return res.Err(constants.ErrForbidden)
+	}
+
+	l.txMu.Lock()
+	defer l.txMu.Unlock()
+
+	if _, ok := l.txs[tx.ID]; ok {
+		return res.Ok()
+	}
+
+	for _, op := range tx.Ops {
+		if _, locked := l.locks[op.Key]; locked {
+			return res.Err(constants.ErrTxConflict.ExtendMsg(op.Key))
+		}
+
+		old := l.storage.Get(op.Key)
+		if old.IsNone() {
+			continue
+		}
+
+		if !l.security.HasPermission(claims, old.Unwrap().Level) {
+			return res.Err(constants.ErrForbidden)
+		}
+
+		if !op.Delete && (tx.Opts.Unique || old.Unwrap().ReadOnly) {
+			return res.Err(constants.ErrAlreadyExists.ExtendMsg(op.Key))
+		}
+	}
+
+	tx.Opts.Encrypt = tx.Opts.Level == constants.SecretLevel
+
+	if l.cfg.TransactionLogger.On {
+		if rLog := l.tlogger.WritePrepare(tx); rLog.IsErr() {
+			return res.Err(rLog.Error())
+		}
+	}
+
+	l.hold(tx)
+
+	return res.Ok()
+}
+
+// CommitPrepared applies the writes of the prepared transaction and unlocks its keys.
+// The node doesn't know a transaction that is already resolved, nothing is done then.
+func (l *Logic) CommitPrepared(_ context.Context, id string) (res gost.ResultN) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return res.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
+	l.txMu.Lock()
+	defer l.txMu.Unlock()
+
+	tx, ok := l.txs[id]
+	if !ok {
+		return res.Ok()
+	}
+
+	if l.cfg.TransactionLogger.On {
+		if rLog := l.tlogger.WriteCommit(tx); rLog.IsErr() {
+			return res.Err(rLog.Error())
+		}
+	}
+
+	for _, op := range tx.Ops {
+		if op.Delete {
+			l.storage.DeleteIfExists(op.Key)
+			continue
+		}
+
+		if r := l.storage.Set(op.Key, op.Value, tx.Opts); r.IsErr() {
+			l.logger.Error("can't apply committed write", zap.String("tx", id), zap.String("key", op.Key), zap.Error(r.Error()))
+		}
+	}
+
+	l.release(tx)
+
+	return res.Ok()
+}
+
+// AbortPrepared forgets the prepared transaction and unlocks its keys.
+func (l *Logic) AbortPrepared(_ context.Context, id string) (res gost.ResultN) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return res.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
+	l.txMu.Lock()
+	defer l.txMu.Unlock()
+
+	tx, ok := l.txs[id]
+	if !ok {
+		return res.Ok()
+	}
+
+	if l.cfg.TransactionLogger.On {
+		if rLog := l.tlogger.WriteAbort(id); rLog.IsErr() {
+			return res.Err(rLog.Error())
+		}
+	}
+
+	l.release(tx)
+
+	return res.Ok()
+}
+
+// InDoubt returns the IDs of the prepared transactions, sorted.
+func (l *Logic) InDoubt(_ context.Context) (res gost.Result[[]string]) {
+	l.txMu.RLock()
+	defer l.txMu.RUnlock()
+
+	ids := make([]string, 0, len(l.txs))
+	for id := range l.txs {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	return res.Ok(ids)
+}
+
+// hold registers the prepared transaction and locks its keys, txMu must be held.
+func (l *Logic) hold(tx models.Transaction) {
+	l.txs[tx.ID] = tx
+	for _, key := range tx.Keys() {
+		l.locks[key] = tx.ID
+	}
+}
+
+// release unregisters the resolved transaction and unlocks its keys, txMu must be held.
+func (l *Logic) release(tx models.Transaction) {
+	delete(l.txs, tx.ID)
+	for _, key := range tx.Keys() {
+		if l.locks[key] == tx.ID {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// locked returns ErrTxConflict when a prepared transaction holds the key, txMu must be held.
+func (l *Logic) locked(key string) *gost.ErrX {
+	if _, ok := l.locks[key]; ok {
+		return constants.ErrTxConflict.ExtendMsg(key)
+	}
+
+	return nil
+}
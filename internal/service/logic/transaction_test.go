@@ -0,0 +1,180 @@
+package logic
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+	"itisadb/internal/service/security"
+	transactionlogger "itisadb/internal/service/transaction-logger"
+	"itisadb/internal/storage"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+func TestTransaction(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Config{TransactionLogger: config.TransactionLoggerConfig{On: true, BackupDirectory: dir}}
+	sec := security.NewSecurityService(config.SecurityConfig{}, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tl, err := transactionlogger.New(cfg.TransactionLogger, zap.NewNop(), sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.Run()
+
+	ctx := context.Background()
+	var claims gost.Option[models.UserClaims]
+
+	l := NewLogic(store, cfg, tl, zap.NewNop(), sec)
+
+	l.SetOne(ctx, claims, "b", "old", models.SetOptions{})
+	l.SetOne(ctx, claims, "fixed", "f", models.SetOptions{ReadOnly: true})
+
+	tx := models.Transaction{ID: "tx1", Ops: []models.TxOp{{Key: "a", Value: "1"}, {Key: "b", Delete: true}}}
+	if r := l.Prepare(ctx, claims, tx); r.IsErr() {
+		t.Fatal(r.Error())
+	}
+
+	t.Run("locked", func(t *testing.T) {
+		if r := l.SetOne(ctx, claims, "a", "x", models.SetOptions{}); !isConflict(r.Error()) {
+			t.Errorf("set of a locked key: got %v, want %v", r.Error(), constants.ErrTxConflict)
+		}
+
+		if r := l.DelOne(ctx, claims, "b", models.DeleteOptions{}); !isConflict(r.Error()) {
+			t.Errorf("delete of a locked key: got %v, want %v", r.Error(), constants.ErrTxConflict)
+		}
+
+		other := models.Transaction{ID: "tx2", Ops: []models.TxOp{{Key: "a", Value: "2"}}}
+		if r := l.Prepare(ctx, claims, other); !isConflict(r.Error()) {
+			t.Errorf("prepare of a locked key: got %v, want %v", r.Error(), constants.ErrTxConflict)
+		}
+
+		if r := l.Prepare(ctx, claims, tx); r.IsErr() {
+			t.Errorf("prepare again: %v", r.Error())
+		}
+	})
+
+	if r := l.CommitPrepared(ctx, "tx1"); r.IsErr() {
+		t.Fatal(r.Error())
+	}
+
+	if v := store.Get("a"); v.IsNone() || v.Unwrap().Value != "1" {
+		t.Errorf("a: got %+v, want 1", v)
+	}
+	if store.Get("b").IsSome() {
+		t.Errorf("b was not deleted by the commit")
+	}
+
+	if r := l.SetOne(ctx, claims, "a", "x", models.SetOptions{}); r.IsErr() {
+		t.Errorf("the commit left a locked: %v", r.Error())
+	}
+
+	t.Run("abort", func(t *testing.T) {
+		tx := models.Transaction{ID: "tx3", Ops: []models.TxOp{{Key: "c", Value: "3"}}}
+		if r := l.Prepare(ctx, claims, tx); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+
+		if r := l.AbortPrepared(ctx, "tx3"); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+
+		if store.Get("c").IsSome() {
+			t.Errorf("the aborted write was applied")
+		}
+
+		if r := l.SetOne(ctx, claims, "c", "x", models.SetOptions{}); r.IsErr() {
+			t.Errorf("the abort left c locked: %v", r.Error())
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		tx := models.Transaction{ID: "tx4", Ops: []models.TxOp{{Key: "d", Value: "4"}, {Key: "fixed", Value: "x"}}}
+		if r := l.Prepare(ctx, claims, tx); r.IsOk() || r.Error().Messages()[0] != constants.ErrAlreadyExists.Message() {
+			t.Fatalf("got %v, want %v", r.Error(), constants.ErrAlreadyExists)
+		}
+
+		if r := l.SetOne(ctx, claims, "d", "x", models.SetOptions{}); r.IsErr() {
+			t.Errorf("the refused transaction locked d: %v", r.Error())
+		}
+	})
+
+	// the last prepare is flushed together with everything before it
+	inDoubt := models.Transaction{
+		ID:   "tx5",
+		Ops:  []models.TxOp{{Key: "e", Value: "secret"}, {Key: "a", Delete: true}},
+		Opts: models.SetOptions{Level: constants.SecretLevel},
+	}
+	if r := l.Prepare(ctx, claims, inDoubt); r.IsErr() {
+		t.Fatal(r.Error())
+	}
+
+	if err := tl.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("restart", func(t *testing.T) {
+		restored, err := storage.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tl, err := transactionlogger.New(cfg.TransactionLogger, zap.NewNop(), sec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tl.Restore(restored); err != nil {
+			t.Fatal(err)
+		}
+
+		tl.Run()
+		defer tl.Stop()
+
+		l := NewLogic(restored, cfg, tl, zap.NewNop(), sec)
+
+		if v := restored.Get("a"); v.IsNone() || v.Unwrap().Value != "x" {
+			t.Errorf("a: got %+v, want x", v)
+		}
+		if restored.Get("b").IsSome() {
+			t.Errorf("the deletion of b was not restored")
+		}
+		if restored.Get("e").IsSome() {
+			t.Errorf("the write of the in-doubt transaction was applied")
+		}
+
+		ids := l.InDoubt(ctx).Unwrap()
+		if !slices.Equal(ids, []string{"tx5"}) {
+			t.Fatalf("got in-doubt transactions %v, want [tx5]", ids)
+		}
+
+		if r := l.SetOne(ctx, claims, "e", "x", models.SetOptions{}); !isConflict(r.Error()) {
+			t.Errorf("the in-doubt transaction doesn't lock e: %v", r.Error())
+		}
+
+		if r := l.CommitPrepared(ctx, "tx5"); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+
+		if v := restored.Get("e"); v.IsNone() || v.Unwrap().Value != "secret" || v.Unwrap().Level != constants.SecretLevel {
+			t.Errorf("e: got %+v, want the secret value", v)
+		}
+		if restored.Get("a").IsSome() {
+			t.Errorf("a was not deleted by the commit")
+		}
+	})
+}
+
+func isConflict(err *gost.ErrX) bool {
+	return err != nil && err.Messages()[0] == constants.ErrTxConflict.Message()
+}
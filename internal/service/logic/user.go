@@ -10,23 +10,42 @@ import (
 )
 
 func (l *Logic) NewUser(ctx context.Context, claims gost.Option[models.UserClaims], user models.User) (r gost.ResultN) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return r.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
 	if !l.security.HasPermission(claims, user.Level) {
 		return r.Err(constants.ErrForbidden)
 	}
 
+	l.usersMu.Lock()
+	defer l.usersMu.Unlock()
+
 	user.Active = true
-	if rUser := l.storage.NewUser(user); r.IsErr() {
+	if rUser := l.storage.NewUser(user); rUser.IsErr() {
 		return r.Err(rUser.Error())
 	}
 
 	if l.cfg.TransactionLogger.On {
-		l.tlogger.WriteNewUser(user)
+		user.SetChangeID(l.storage.GetUserChangeID())
+		if rLog := l.tlogger.WriteNewUser(user); rLog.IsErr() {
+			return r.Err(rLog.Error())
+		}
 	}
 
 	return r.Ok()
 }
 
 func (l *Logic) DeleteUser(ctx context.Context, claims gost.Option[models.UserClaims], login string) (r gost.Result[bool]) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return r.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
+	l.usersMu.Lock()
+	defer l.usersMu.Unlock()
+
 	rUser := l.storage.GetUserByName(login)
 	if rUser.IsErr() {
 		return r.Err(rUser.Error())
@@ -48,13 +67,23 @@ func (l *Logic) DeleteUser(ctx context.Context, claims gost.Option[models.UserCl
 	}
 
 	if l.cfg.TransactionLogger.On {
-		l.tlogger.WriteDeleteUser(login)
+		if rLog := l.tlogger.WriteDeleteUser(login, l.storage.GetUserChangeID()); rLog.IsErr() {
+			return r.Err(rLog.Error())
+		}
 	}
 
 	return r
 }
 
 func (l *Logic) ChangePassword(ctx context.Context, claims gost.Option[models.UserClaims], login string, password string) (r gost.ResultN) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return r.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
+	l.usersMu.Lock()
+	defer l.usersMu.Unlock()
+
 	rUser := l.storage.GetUserByName(login)
 	if rUser.IsErr() {
 		return r.Err(rUser.Error())
@@ -73,13 +102,24 @@ func (l *Logic) ChangePassword(ctx context.Context, claims gost.Option[models.Us
 	}
 
 	if l.cfg.TransactionLogger.On {
-		l.tlogger.WriteNewUser(user)
+		user.SetChangeID(l.storage.GetUserChangeID())
+		if rLog := l.tlogger.WriteChangePassword(user); rLog.IsErr() {
+			return r.Err(rLog.Error())
+		}
 	}
 
 	return r
 }
 
 func (l *Logic) ChangeLevel(ctx context.Context, claims gost.Option[models.UserClaims], login string, level models.Level) (r gost.ResultN) {
+	if rW := l.reserve(1); rW.IsErr() {
+		return r.Err(rW.Error())
+	}
+	defer l.unreserve(1)
+
+	l.usersMu.Lock()
+	defer l.usersMu.Unlock()
+
 	rUser := l.storage.GetUserByName(login)
 	if rUser.IsErr() {
 		return r.Err(rUser.Error())
@@ -98,7 +138,10 @@ func (l *Logic) ChangeLevel(ctx context.Context, claims gost.Option[models.UserC
 	}
 
 	if l.cfg.TransactionLogger.On {
-		l.tlogger.WriteNewUser(user)
+		user.SetChangeID(l.storage.GetUserChangeID())
+		if rLog := l.tlogger.WriteChangeLevel(user); rLog.IsErr() {
+			return r.Err(rLog.Error())
+		}
 	}
 
 	return r
@@ -109,9 +152,25 @@ func (l *Logic) GetLastUserChangeID(ctx context.Context) (r gost.Result[uint64])
 }
 
 func (l *Logic) Sync(ctx context.Context, syncID uint64, users []models.User) (r gost.ResultN) {
+	if rW := l.reserve(len(users)); rW.IsErr() {
+		return r.Err(rW.Error())
+	}
+	defer l.unreserve(len(users))
+
+	l.usersMu.Lock()
+	defer l.usersMu.Unlock()
+
 	for _, user := range users {
 		user.SetChangeID(syncID)
-		l.storage.SaveUser(user)
+		if rUser := l.storage.RestoreUser(user); rUser.IsErr() {
+			return rUser
+		}
+
+		if l.cfg.TransactionLogger.On {
+			if rLog := l.tlogger.WriteUpdateUser(user); rLog.IsErr() {
+				return r.Err(rLog.Error())
+			}
+		}
 	}
 
 	l.storage.SetUserChangeID(syncID)
@@ -0,0 +1,154 @@
+package logic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+	"itisadb/internal/service/security"
+	transactionlogger "itisadb/internal/service/transaction-logger"
+	"itisadb/internal/storage"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+func TestUserRestore(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Config{TransactionLogger: config.TransactionLoggerConfig{On: true, BackupDirectory: dir}}
+	sec := security.NewSecurityService(config.SecurityConfig{}, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tl, err := transactionlogger.New(cfg.TransactionLogger, zap.NewNop(), sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.Run()
+
+	ctx := context.Background()
+	var claims gost.Option[models.UserClaims]
+
+	l := NewLogic(store, cfg, tl, zap.NewNop(), sec)
+
+	steps := []gost.ResultN{
+		l.NewUser(ctx, claims, models.User{Login: "alice", Password: "1", Level: constants.DefaultLevel}),
+		l.ChangePassword(ctx, claims, "alice", "2"),
+		l.ChangeLevel(ctx, claims, "alice", constants.RestrictedLevel),
+		l.NewUser(ctx, claims, models.User{Login: "bob", Password: "3", Level: constants.DefaultLevel}),
+	}
+	for i, r := range steps {
+		if r.IsErr() {
+			t.Fatalf("step %d: %v", i, r.Error())
+		}
+	}
+
+	if r := l.DeleteUser(ctx, claims, "bob"); r.IsErr() {
+		t.Fatal(r.Error())
+	}
+
+	want := []transactionlogger.EventType{
+		transactionlogger.CreateUser, // itisadb
+		transactionlogger.CreateUser, // demo
+		transactionlogger.CreateUser,
+		transactionlogger.ChangePassword,
+		transactionlogger.ChangeLevel,
+		transactionlogger.CreateUser,
+		transactionlogger.DeleteUser,
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tl.Head() != uint64(len(want)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for the log, head: %d", tl.Head())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := tl.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []transactionlogger.EventType
+	err = transactionlogger.ScanDir(dir, func(_ transactionlogger.Position, e transactionlogger.Event) (bool, error) {
+		got = append(got, e.EventType)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got events %v, want %v", got, want)
+		}
+	}
+
+	restored, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tl, err = transactionlogger.New(cfg.TransactionLogger, zap.NewNop(), sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tl.Restore(restored); err != nil {
+		t.Fatal(err)
+	}
+
+	tl.Run()
+	defer tl.Stop()
+
+	// a restart must not create the default users again
+	NewLogic(restored, cfg, tl, zap.NewNop(), sec)
+
+	if got, want := restored.GetUserChangeID(), store.GetUserChangeID(); got != want {
+		t.Errorf("users change ID: got %d, want %d", got, want)
+	}
+
+	before := usersByLogin(t, store)
+	after := usersByLogin(t, restored)
+
+	if len(after) != len(before) {
+		t.Fatalf("got %d users, want %d", len(after), len(before))
+	}
+
+	for login, u := range before {
+		r, ok := after[login]
+		if !ok {
+			t.Errorf("user %s was not restored", login)
+			continue
+		}
+
+		if r.GetChangeID() != u.GetChangeID() || r.Password != u.Password || r.Level != u.Level || r.Active != u.Active {
+			t.Errorf("user %s: got %+v (change ID %d), want %+v (change ID %d)", login, r, r.GetChangeID(), u, u.GetChangeID())
+		}
+	}
+}
+
+func usersByLogin(t *testing.T, s *storage.Storage) map[string]models.User {
+	t.Helper()
+
+	r := s.GetUsersFromChangeID(s.GetUserChangeID() + 1)
+	if r.IsErr() {
+		t.Fatal(r.Error())
+	}
+
+	users := make(map[string]models.User)
+	for _, u := range r.Unwrap() {
+		users[u.Login] = u
+	}
+
+	return users
+}
@@ -0,0 +1,419 @@
+// Package metadata keeps the state the balancer instances share in a Raft-replicated state machine:
+// the servers with their numbers, the routes of the catalog and the synced user change ID.
+//
+// Every instance applies the same commands in the same order, so any of them serves the reads
+// from its own copy. The changes are proposed to the leader, an instance that is not the leader
+// forwards them and returns once it has applied them itself.
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/service/catalog"
+	"itisadb/internal/service/raft"
+	"itisadb/pkg/api/cluster"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+// _proposeTimeout limits the changes of the routes, the catalog methods take no context.
+const _proposeTimeout = 5 * time.Second
+
+type op string
+
+const (
+	setKeyOp       op = "set_key"
+	delKeyOp       op = "del_key"
+	setObjectOp    op = "set_object"
+	delObjectOp    op = "del_object"
+	setShardsOp    op = "set_shards"
+	delShardsOp    op = "del_shards"
+	addMemberOp    op = "add_member"
+	removeMemberOp op = "remove_member"
+	changeIDOp     op = "change_id"
+)
+
+// command is a change of the metadata. The commands are idempotent,
+// so a command retried after a leader change does no harm if it was applied before.
+type command struct {
+	Op       op      `json:"op"`
+	Name     string  `json:"name,omitempty"`
+	Server   int32   `json:"server,omitempty"`
+	Servers  []int32 `json:"servers,omitempty"`
+	Address  string  `json:"address,omitempty"`
+	ChangeID uint64  `json:"change_id,omitempty"`
+}
+
+// state is the snapshot of the metadata.
+type state struct {
+	Members  map[int32]string `json:"members"`
+	FreeID   int32            `json:"free_id"`
+	ChangeID uint64           `json:"change_id"`
+	Routes   []byte           `json:"routes"`
+}
+
+type Store struct {
+	node    *raft.Node
+	catalog *catalog.Catalog
+	retry   time.Duration
+	logger  *zap.Logger
+
+	mu       sync.RWMutex
+	members  map[int32]string
+	freeID   int32
+	changeID uint64
+
+	watchMu  sync.Mutex
+	watchers []func(map[int32]string)
+	changed  chan struct{}
+	stop     chan struct{}
+}
+
+// New restores the metadata from cfg.Raft.Directory, the routes are applied to the catalog.
+// The store takes part in the cluster after Start.
+func New(cfg config.RaftConfig, cat *catalog.Catalog, transport raft.Transport, logger *zap.Logger) (*Store, error) {
+	cfg = raft.WithDefaults(cfg)
+
+	s := &Store{
+		catalog: cat,
+		retry:   cfg.HeartbeatInterval,
+		logger:  logger,
+		members: make(map[int32]string),
+		freeID:  constants.LocalServerNumber + 1,
+		changed: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+
+	node, err := raft.New(cfg, machine{s}, transport, logger)
+	if err != nil {
+		return nil, err
+	}
+	s.node = node
+
+	return s, nil
+}
+
+// Start joins the other instances and starts delivering the changes of the members to the watchers.
+func (s *Store) Start() {
+	go s.deliver()
+	s.node.Start()
+}
+
+// Close leaves the cluster, the catalog stays open.
+func (s *Store) Close() {
+	select {
+	case <-s.stop:
+		return
+	default:
+	}
+
+	close(s.stop)
+	s.node.Stop()
+}
+
+// Server returns the gRPC service the other instances reach this one through.
+func (s *Store) Server() cluster.RaftServer {
+	return s.node.Server()
+}
+
+func (s *Store) IsLeader() bool {
+	return s.node.IsLeader()
+}
+
+func (s *Store) Status() raft.Status {
+	return s.node.Status()
+}
+
+// =============== catalog ====================== //
+
+func (s *Store) KeyServer(key string) gost.Option[int32] {
+	return s.catalog.KeyServer(key)
+}
+
+func (s *Store) SetKeyServer(key string, server int32) gost.ResultN {
+	return s.change(command{Op: setKeyOp, Name: key, Server: server})
+}
+
+func (s *Store) DelKeyServer(key string) gost.ResultN {
+	return s.change(command{Op: delKeyOp, Name: key})
+}
+
+func (s *Store) ObjectServer(object string) gost.Option[int32] {
+	return s.catalog.ObjectServer(object)
+}
+
+func (s *Store) SetObjectServer(object string, server int32) gost.ResultN {
+	return s.change(command{Op: setObjectOp, Name: object, Server: server})
+}
+
+func (s *Store) DelObjectServer(object string) gost.ResultN {
+	return s.change(command{Op: delObjectOp, Name: object})
+}
+
+func (s *Store) ObjectShards(object string) gost.Option[[]int32] {
+	return s.catalog.ObjectShards(object)
+}
+
+func (s *Store) SetObjectShards(object string, servers []int32) gost.ResultN {
+	return s.change(command{Op: setShardsOp, Name: object, Servers: servers})
+}
+
+func (s *Store) DelObjectShards(object string) gost.ResultN {
+	return s.change(command{Op: delShardsOp, Name: object})
+}
+
+func (s *Store) change(cmd command) (res gost.ResultN) {
+	ctx, cancel := context.WithTimeout(context.Background(), _proposeTimeout)
+	defer cancel()
+
+	if r := s.propose(ctx, cmd); r.IsErr() {
+		return res.Err(r.Error())
+	}
+
+	return res.Ok()
+}
+
+// =============== members ====================== //
+
+func (s *Store) AddMember(ctx context.Context, address string) (res gost.Result[int32]) {
+	r := s.propose(ctx, command{Op: addMemberOp, Address: address})
+	if r.IsErr() {
+		return res.Err(r.Error())
+	}
+
+	number, err := strconv.ParseInt(string(r.Unwrap()), 10, 32)
+	if err != nil {
+		return res.Err(constants.ErrInternal.ExtendMsg(err.Error()))
+	}
+
+	return res.Ok(int32(number))
+}
+
+func (s *Store) RemoveMember(ctx context.Context, number int32) (res gost.ResultN) {
+	if r := s.propose(ctx, command{Op: removeMemberOp, Server: number}); r.IsErr() {
+		return res.Err(r.Error())
+	}
+
+	return res.Ok()
+}
+
+func (s *Store) Members() map[int32]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.members)
+}
+
+// Watch calls f with the members now and after they change, with the latest ones if they changed a few times.
+// The calls are made one at a time from one goroutine.
+func (s *Store) Watch(f func(members map[int32]string)) {
+	s.watchMu.Lock()
+	s.watchers = append(s.watchers, f)
+	s.watchMu.Unlock()
+
+	s.notify()
+}
+
+func (s *Store) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Store) deliver() {
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.changed:
+		}
+
+		s.watchMu.Lock()
+		watchers := s.watchers
+		s.watchMu.Unlock()
+
+		members := s.Members()
+		for _, f := range watchers {
+			f(maps.Clone(members))
+		}
+	}
+}
+
+// =============== user change ID ====================== //
+
+func (s *Store) UserChangeID() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.changeID
+}
+
+func (s *Store) SetUserChangeID(ctx context.Context, id uint64) (res gost.ResultN) {
+	if r := s.propose(ctx, command{Op: changeIDOp, ChangeID: id}); r.IsErr() {
+		return res.Err(r.Error())
+	}
+
+	return res.Ok()
+}
+
+// =============== proposals ====================== //
+
+// propose retries the command while the leader is being elected.
+func (s *Store) propose(ctx context.Context, cmd command) (res gost.Result[[]byte]) {
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return res.Err(constants.ErrInternal.ExtendMsg(err.Error()))
+	}
+
+	for {
+		out, err := s.node.Propose(ctx, b)
+		if err == nil {
+			return res.Ok(out)
+		}
+
+		// the state machine refused the command
+		var errX *gost.ErrX
+		if errors.As(err, &errX) {
+			return res.Err(errX)
+		}
+
+		if errors.Is(err, raft.ErrStopped) {
+			return res.Err(constants.ErrNoMetadataLeader.ExtendMsg(err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return res.Err(constants.ErrNoMetadataLeader.ExtendMsg(err.Error()))
+		case <-time.After(s.retry):
+		}
+	}
+}
+
+// =============== state machine ====================== //
+
+// machine applies the commands, the raft node calls it one command at a time.
+type machine struct {
+	s *Store
+}
+
+func (m machine) Apply(b []byte) ([]byte, error) {
+	var cmd command
+	if err := json.Unmarshal(b, &cmd); err != nil {
+		return nil, fmt.Errorf("can't decode metadata command: %w", err)
+	}
+
+	s := m.s
+
+	var r gost.ResultN
+	switch cmd.Op {
+	case setKeyOp:
+		r = s.catalog.SetKeyServer(cmd.Name, cmd.Server)
+	case delKeyOp:
+		r = s.catalog.DelKeyServer(cmd.Name)
+	case setObjectOp:
+		r = s.catalog.SetObjectServer(cmd.Name, cmd.Server)
+	case delObjectOp:
+		r = s.catalog.DelObjectServer(cmd.Name)
+	case setShardsOp:
+		r = s.catalog.SetObjectShards(cmd.Name, cmd.Servers)
+	case delShardsOp:
+		r = s.catalog.DelObjectShards(cmd.Name)
+	case addMemberOp:
+		return strconv.AppendInt(nil, int64(s.addMember(cmd.Address)), 10), nil
+	case removeMemberOp:
+		s.removeMember(cmd.Server)
+	case changeIDOp:
+		s.mu.Lock()
+		s.changeID = max(s.changeID, cmd.ChangeID)
+		s.mu.Unlock()
+	default:
+		return nil, fmt.Errorf("unknown metadata command %q", cmd.Op)
+	}
+
+	if r.IsErr() {
+		return nil, r.Error()
+	}
+
+	return nil, nil
+}
+
+func (s *Store) addMember(address string) int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for number, addr := range s.members {
+		if addr == address {
+			return number
+		}
+	}
+
+	number := s.freeID
+	s.freeID++
+	s.members[number] = address
+
+	s.notify()
+
+	return number
+}
+
+func (s *Store) removeMember(number int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.members[number]; !ok {
+		return
+	}
+
+	delete(s.members, number)
+	s.notify()
+}
+
+func (m machine) Snapshot() ([]byte, error) {
+	var routes bytes.Buffer
+	if err := m.s.catalog.Dump(&routes); err != nil {
+		return nil, err
+	}
+
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	return json.Marshal(state{
+		Members:  m.s.members,
+		FreeID:   m.s.freeID,
+		ChangeID: m.s.changeID,
+		Routes:   routes.Bytes(),
+	})
+}
+
+func (m machine) Restore(b []byte) error {
+	var st state
+	if err := json.Unmarshal(b, &st); err != nil {
+		return fmt.Errorf("can't decode metadata snapshot: %w", err)
+	}
+
+	if err := m.s.catalog.Restore(bytes.NewReader(st.Routes)); err != nil {
+		return err
+	}
+
+	if st.Members == nil {
+		st.Members = make(map[int32]string)
+	}
+
+	m.s.mu.Lock()
+	m.s.members, m.s.freeID, m.s.changeID = st.Members, st.FreeID, st.ChangeID
+	m.s.mu.Unlock()
+
+	m.s.notify()
+
+	return nil
+}
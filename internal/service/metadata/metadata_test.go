@@ -0,0 +1,334 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/clustertest"
+	"itisadb/internal/service/catalog"
+	"itisadb/internal/service/raft"
+	"itisadb/pkg/api/cluster"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+type instance struct {
+	*Store
+	cat  *catalog.Catalog
+	srv  *grpc.Server
+	addr string
+	dir  string
+}
+
+type testCluster struct {
+	t         *testing.T
+	cfg       config.RaftConfig
+	instances []*instance
+}
+
+// newCluster starts n balancer instances on localhost, each with its own catalog and raft log.
+func newCluster(t *testing.T, n int, cfg config.RaftConfig) *testCluster {
+	c := &testCluster{t: t, cfg: cfg}
+
+	for _, addr := range clustertest.Addresses(t, n) {
+		c.instances = append(c.instances, &instance{addr: addr, dir: t.TempDir()})
+		c.cfg.Peers = append(c.cfg.Peers, addr)
+	}
+
+	for i := range c.instances {
+		c.start(i)
+	}
+
+	t.Cleanup(func() {
+		for i := range c.instances {
+			c.stop(i)
+		}
+	})
+
+	return c
+}
+
+func (c *testCluster) start(i int) {
+	in := c.instances[i]
+
+	cat, err := catalog.New(config.BalancerConfig{CatalogDirectory: in.dir + "/catalog"}, zap.NewNop())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	in.cat = cat
+
+	cfg := c.cfg
+	cfg.ID, cfg.Directory = in.addr, in.dir+"/raft"
+
+	transport := raft.NewTransport(nil, clustertest.Insecure())
+	store, err := New(cfg, cat, transport, zap.NewNop())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	in.Store = store
+
+	in.srv = clustertest.Serve(c.t, in.addr, func(srv *grpc.Server) {
+		cluster.RegisterRaftServer(srv, store.Server())
+	})
+
+	store.Start()
+}
+
+func (c *testCluster) stop(i int) {
+	in := c.instances[i]
+	if in.srv == nil {
+		return
+	}
+
+	in.srv.Stop()
+	in.Store.Close()
+	in.cat.Close()
+	in.srv = nil
+}
+
+func (c *testCluster) running() []*instance {
+	var running []*instance
+	for _, in := range c.instances {
+		if in.srv != nil {
+			running = append(running, in)
+		}
+	}
+
+	return running
+}
+
+// leader waits until one of the running instances leads the others.
+func (c *testCluster) leader() *instance {
+	c.t.Helper()
+
+	var leader *instance
+	clustertest.Eventually(c.t, "a leader", func() bool {
+		i := slices.IndexFunc(c.running(), (*instance).IsLeader)
+		if i < 0 {
+			return false
+		}
+
+		leader = c.running()[i]
+		return true
+	})
+
+	return leader
+}
+
+func (c *testCluster) follower() *instance {
+	leader := c.leader()
+	for _, in := range c.running() {
+		if in != leader {
+			return in
+		}
+	}
+
+	c.t.Fatal("no follower")
+	return nil
+}
+
+// eventually waits until ok holds on every running instance.
+func (c *testCluster) eventually(what string, ok func(in *instance) bool) {
+	c.t.Helper()
+
+	clustertest.Eventually(c.t, what+" on every instance", func() bool {
+		return !slices.ContainsFunc(c.running(), func(in *instance) bool { return !ok(in) })
+	})
+}
+
+func (c *testCluster) hasMembers(want map[int32]string) {
+	c.t.Helper()
+
+	c.eventually(fmt.Sprint("members ", want), func(in *instance) bool {
+		return maps.Equal(in.Members(), want)
+	})
+}
+
+func (c *testCluster) hasKey(key string, server int32) {
+	c.t.Helper()
+
+	c.eventually("key "+key, func(in *instance) bool {
+		s := in.KeyServer(key)
+		return s.IsSome() && s.Unwrap() == server
+	})
+}
+
+var _testConfig = config.RaftConfig{
+	HeartbeatInterval: 20 * time.Millisecond,
+	ElectionTimeout:   150 * time.Millisecond,
+}
+
+func addMember(t *testing.T, in *instance, address string) int32 {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r := in.AddMember(ctx, address)
+	if r.IsErr() {
+		t.Fatal(r.Error())
+	}
+
+	return r.Unwrap()
+}
+
+func TestStore(t *testing.T) {
+	c := newCluster(t, 3, _testConfig)
+
+	leader, follower := c.leader(), c.follower()
+
+	var (
+		mu      sync.Mutex
+		watched []map[int32]string
+	)
+	follower.Watch(func(members map[int32]string) {
+		mu.Lock()
+		defer mu.Unlock()
+		watched = append(watched, members)
+	})
+
+	// the numbers are given in the order of the additions, wherever they are made
+	if n := addMember(t, follower, "a:1"); n != 2 {
+		t.Errorf("a:1 got number %d, want 2", n)
+	}
+	if n := addMember(t, leader, "b:1"); n != 3 {
+		t.Errorf("b:1 got number %d, want 3", n)
+	}
+	if n := addMember(t, leader, "a:1"); n != 2 {
+		t.Errorf("a:1 added again got number %d, want 2", n)
+	}
+
+	c.hasMembers(map[int32]string{2: "a:1", 3: "b:1"})
+
+	t.Run("routes", func(t *testing.T) {
+		// an instance reads what it wrote at once
+		if r := follower.SetKeyServer("key", 3); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+		if s := follower.KeyServer("key"); s.IsNone() || s.Unwrap() != 3 {
+			t.Errorf("the follower doesn't read its write: %v", s)
+		}
+		c.hasKey("key", 3)
+
+		follower.SetObjectShards("big", []int32{2, 3})
+		c.eventually("shards", func(in *instance) bool {
+			s := in.ObjectShards("big")
+			return s.IsSome() && slices.Equal(s.Unwrap(), []int32{2, 3})
+		})
+
+		leader.DelKeyServer("key")
+		c.eventually("deleted key", func(in *instance) bool { return in.KeyServer("key").IsNone() })
+	})
+
+	t.Run("change id", func(t *testing.T) {
+		ctx := context.Background()
+		follower.SetUserChangeID(ctx, 5)
+		leader.SetUserChangeID(ctx, 3)
+
+		c.eventually("change id", func(in *instance) bool { return in.UserChangeID() == 5 })
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		if r := follower.RemoveMember(context.Background(), 2); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+		c.hasMembers(map[int32]string{3: "b:1"})
+
+		// a removed number is not given again
+		if n := addMember(t, follower, "a:1"); n != 4 {
+			t.Errorf("a:1 added after removal got number %d, want 4", n)
+		}
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		want := map[int32]string{3: "b:1", 4: "a:1"}
+
+		clustertest.Eventually(t, fmt.Sprint("the watcher to get the members ", want), func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(watched) > 0 && maps.Equal(watched[len(watched)-1], want)
+		})
+	})
+}
+
+func TestFailover(t *testing.T) {
+	c := newCluster(t, 3, _testConfig)
+
+	addMember(t, c.leader(), "a:1")
+
+	old := c.leader()
+	for i, in := range c.instances {
+		if in == old {
+			c.stop(i)
+		}
+	}
+
+	// the proposals wait for a new leader
+	follower := c.running()[0]
+	if n := addMember(t, follower, "b:1"); n != 3 {
+		t.Errorf("b:1 got number %d, want 3", n)
+	}
+
+	if c.leader() == old {
+		t.Fatal("the stopped instance still leads")
+	}
+
+	c.hasMembers(map[int32]string{2: "a:1", 3: "b:1"})
+}
+
+func TestSnapshot(t *testing.T) {
+	cfg := _testConfig
+	cfg.SnapshotThreshold = 10
+
+	c := newCluster(t, 3, cfg)
+
+	lagging := c.follower()
+	var stopped int
+	for i, in := range c.instances {
+		if in == lagging {
+			stopped = i
+			c.stop(i)
+		}
+	}
+
+	leader := c.leader()
+	addMember(t, leader, "a:1")
+	for i := 0; i < 50; i++ {
+		if r := leader.SetKeyServer(fmt.Sprint("key", i), 2); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+	}
+	leader.SetUserChangeID(context.Background(), 7)
+
+	// the lagging instance gets the snapshot, its catalog is replaced with the routes in it
+	c.start(stopped)
+	c.hasMembers(map[int32]string{2: "a:1"})
+	c.hasKey("key0", 2)
+	c.hasKey("key49", 2)
+	c.eventually("change id", func(in *instance) bool { return in.UserChangeID() == 7 })
+
+	t.Run("restart", func(t *testing.T) {
+		for i := range c.instances {
+			c.stop(i)
+		}
+
+		for i := range c.instances {
+			c.start(i)
+		}
+
+		c.leader()
+		c.hasMembers(map[int32]string{2: "a:1"})
+		c.hasKey("key49", 2)
+
+		if n := addMember(t, c.follower(), "b:1"); n != 3 {
+			t.Errorf("b:1 got number %d after a restart, want 3", n)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+// Package nearcache keeps the hot values the balancer read from the remote servers.
+//
+// The balancer invalidates a key after every write to it that passes through the balancer.
+// A write that goes to a server past the balancer is not seen until the value gets older than
+// the staleness bound, then it is read from the server again.
+package nearcache
+
+import (
+	"container/list"
+	"expvar"
+	"sync"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/models"
+)
+
+const DefaultMaxStaleness = time.Second
+
+var _stats = expvar.NewMap("near_cache")
+
+type entry struct {
+	key     string
+	value   models.Value
+	expires time.Time
+}
+
+// Cache is a bounded LRU of values. A nil Cache is turned off: it keeps nothing.
+type Cache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List
+	entries map[string]*list.Element
+
+	// gen changes with every invalidation, a value read before it is not put into the cache.
+	gen uint64
+
+	now func() time.Time
+}
+
+// New returns the cache, nil when cfg.Size turns it off.
+func New(cfg config.NearCacheConfig) *Cache {
+	if cfg.Size <= 0 {
+		return nil
+	}
+
+	if cfg.MaxStaleness <= 0 {
+		cfg.MaxStaleness = DefaultMaxStaleness
+	}
+
+	return &Cache{
+		size:    cfg.Size,
+		ttl:     cfg.MaxStaleness,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// Get returns the value of the key if it is cached and not older than the staleness bound.
+func (c *Cache) Get(key string) (models.Value, bool) {
+	if c == nil {
+		return models.Value{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		_stats.Add("misses", 1)
+		return models.Value{}, false
+	}
+
+	e := el.Value.(*entry)
+	if c.now().After(e.expires) {
+		c.remove(el)
+		_stats.Add("misses", 1)
+		return models.Value{}, false
+	}
+
+	c.lru.MoveToFront(el)
+	_stats.Add("hits", 1)
+
+	return e.value, true
+}
+
+// Gen returns the generation to pass to Put with the value that is about to be read.
+func (c *Cache) Gen() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// Put caches the value of the key read at the generation gen.
+// It is dropped if a key was invalidated since then, the value could be older than the write.
+func (c *Cache) Put(key string, value models.Value, gen uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	expires := c.now().Add(c.ttl)
+
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expires = value, expires
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&entry{key: key, value: value, expires: expires})
+
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		_stats.Add("evictions", 1)
+	}
+}
+
+// Invalidate forgets the values of the keys.
+func (c *Cache) Invalidate(keys ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+// Len returns the number of the cached values.
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
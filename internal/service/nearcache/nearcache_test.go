@@ -0,0 +1,78 @@
+package nearcache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/models"
+)
+
+func TestCache(t *testing.T) {
+	c := New(config.NearCacheConfig{Size: 3, MaxStaleness: time.Minute})
+
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		c.Put(fmt.Sprintf("key%d", i), models.Value{Value: fmt.Sprint(i)}, c.Gen())
+	}
+
+	// key0 is used, so key1 is the least recent one
+	if v, ok := c.Get("key0"); !ok || v.Value != "0" {
+		t.Fatalf("key0: got %+v, %v", v, ok)
+	}
+
+	c.Put("key3", models.Value{Value: "3"}, c.Gen())
+
+	if _, ok := c.Get("key1"); ok {
+		t.Error("key1 was not evicted")
+	}
+
+	if c.Len() != 3 {
+		t.Errorf("len %d, want 3", c.Len())
+	}
+
+	t.Run("invalidate", func(t *testing.T) {
+		// the read started before the write, its value may be older
+		gen := c.Gen()
+		c.Invalidate("key0")
+
+		if _, ok := c.Get("key0"); ok {
+			t.Error("key0 was not invalidated")
+		}
+
+		c.Put("key0", models.Value{Value: "old"}, gen)
+		if _, ok := c.Get("key0"); ok {
+			t.Error("the value read before the write was cached")
+		}
+
+		c.Put("key0", models.Value{Value: "new"}, c.Gen())
+		if v, ok := c.Get("key0"); !ok || v.Value != "new" {
+			t.Errorf("key0: got %+v, %v", v, ok)
+		}
+	})
+
+	t.Run("staleness", func(t *testing.T) {
+		now = now.Add(time.Minute + time.Second)
+
+		if _, ok := c.Get("key3"); ok {
+			t.Error("the stale value was returned")
+		}
+	})
+
+	t.Run("off", func(t *testing.T) {
+		var off *Cache = New(config.NearCacheConfig{})
+		if off != nil {
+			t.Fatal("the cache of size 0 is on")
+		}
+
+		off.Put("key", models.Value{Value: "v"}, off.Gen())
+		off.Invalidate("key")
+
+		if _, ok := off.Get("key"); ok || off.Len() != 0 {
+			t.Error("the turned off cache keeps values")
+		}
+	})
+}
@@ -0,0 +1,123 @@
+// Package peers keeps the connections of a node to the other nodes of the cluster it talks to
+// over the internal services, e.g. the balancer instances replicating the metadata
+// or the nodes gossiping about the membership.
+package peers
+
+import (
+	"context"
+	"sync"
+
+	api "github.com/egorgasay/itisadb-shared-proto/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Authenticator returns the token the requests to the peer on the connection are made with.
+type Authenticator func(ctx context.Context, conn *grpc.ClientConn) (string, error)
+
+// Authenticate returns the Authenticator that signs in to the peer with the credentials.
+func Authenticate(login, password string) Authenticator {
+	return func(ctx context.Context, conn *grpc.ClientConn) (string, error) {
+		resp, err := api.NewItisaDBClient(conn).Authenticate(ctx, &api.AuthRequest{Login: login, Password: password})
+		if err != nil {
+			return "", err
+		}
+
+		return resp.Token, nil
+	}
+}
+
+// Peers dials the peers by their addresses on the first request to each of them.
+type Peers struct {
+	opts []grpc.DialOption
+	auth Authenticator
+
+	mu    sync.Mutex
+	peers map[string]*peer
+}
+
+type peer struct {
+	conn  *grpc.ClientConn
+	token string
+}
+
+// New returns the peers dialed with the options. Without auth the requests are made without a token.
+func New(auth Authenticator, opts ...grpc.DialOption) *Peers {
+	return &Peers{
+		opts:  opts,
+		auth:  auth,
+		peers: make(map[string]*peer),
+	}
+}
+
+// Close closes the connections to the peers.
+func (p *Peers) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for address, pr := range p.peers {
+		pr.conn.Close()
+		delete(p.peers, address)
+	}
+}
+
+// Forget closes the connection to the peer that is gone.
+func (p *Peers) Forget(address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pr, ok := p.peers[address]; ok {
+		pr.conn.Close()
+		delete(p.peers, address)
+	}
+}
+
+// Conn returns the connection to the peer and ctx with the token for it.
+func (p *Peers) Conn(ctx context.Context, address string) (*grpc.ClientConn, context.Context, error) {
+	p.mu.Lock()
+	pr, ok := p.peers[address]
+	if !ok {
+		conn, err := grpc.Dial(address, p.opts...)
+		if err != nil {
+			p.mu.Unlock()
+			return nil, nil, err
+		}
+
+		pr = &peer{conn: conn}
+		p.peers[address] = pr
+	}
+	token := pr.token
+	p.mu.Unlock()
+
+	if p.auth != nil && token == "" {
+		var err error
+		if token, err = p.auth(ctx, pr.conn); err != nil {
+			return nil, nil, err
+		}
+
+		p.mu.Lock()
+		pr.token = token
+		p.mu.Unlock()
+	}
+
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "token", token)
+	}
+
+	return pr.conn, ctx, nil
+}
+
+// Checked forgets the token the peer didn't accept, the next request signs in again.
+func (p *Peers) Checked(address string, err error) error {
+	if status.Code(err) == codes.Unauthenticated {
+		p.mu.Lock()
+		if pr, ok := p.peers[address]; ok {
+			pr.token = ""
+		}
+		p.mu.Unlock()
+	}
+
+	return err
+}
@@ -0,0 +1,310 @@
+package quorum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/deadline"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+// batchReply is the answer of one server to a batch request, by key.
+type batchReply struct {
+	server  domains.Server
+	replies map[string]reply
+}
+
+// tally counts the answers of the replicas of one key of a batch.
+type tally struct {
+	replicas          int
+	answers, failures int
+	first             int32
+	lastErr           *gost.ErrX
+	got               []reply
+	done              bool
+}
+
+// MSet writes the values to the replicas of their keys with one request per server.
+// Like Set, a key is written once W of its replicas acknowledge it, the rest keep going in the background.
+// The results go by key: the first server that acknowledged it or the error.
+func (c *Coordinator) MSet(ctx context.Context, replicas map[string][]domains.Server, claims gost.Option[models.UserClaims], values map[string]string, opts models.SetOptions) map[string]gost.Result[int32] {
+	results := make(map[string]gost.Result[int32], len(values))
+	tallies := make(map[string]*tally, len(values))
+	servers := make(map[int32]domains.Server)
+	batches := make(map[int32]map[string]string)
+
+	for key, val := range values {
+		if n := len(replicas[key]); n < c.w {
+			results[key] = gost.Err[int32](constants.ErrQuorum.ExtendMsg(fmt.Sprintf("%d of %d replicas are online, %d are needed", n, c.n, c.w)))
+			continue
+		}
+
+		// the replicas get the same version of the value
+		versioned := Encode(c.clock.Next(), false, val)
+
+		tallies[key] = &tally{replicas: len(replicas[key])}
+		for _, server := range replicas[key] {
+			number := server.Number()
+			if batches[number] == nil {
+				servers[number], batches[number] = server, make(map[string]string)
+			}
+			batches[number][key] = versioned
+		}
+	}
+
+	bg, cancel := deadline.Detached(ctx, c.timeouts.Write)
+	replies := send(servers, cancel, func(server domains.Server) map[string]reply {
+		batch := batches[server.Number()]
+
+		opts := opts
+		opts.Server = server.Number()
+
+		return setReplies(server, batch, server.MSet(bg, claims, batch, opts))
+	})
+
+	pending := len(tallies)
+	for received := 0; pending > 0 && received < len(batches); received++ {
+		select {
+		case rep := <-replies:
+			for key, r := range rep.replies {
+				t := tallies[key]
+				if t.done {
+					continue
+				}
+
+				if r.err != nil {
+					c.logger.Warn("replica write failed", zap.Int32("server", r.server.Number()), zap.String("key", key), zap.Error(r.err))
+
+					t.lastErr = r.err
+					if t.failures++; t.failures > t.replicas-c.w {
+						t.done, pending = true, pending-1
+						results[key] = gost.Err[int32](t.lastErr.ExtendMsg(fmt.Sprintf("quorum not reached: %d of %d replicas acknowledged, %d are needed", t.answers, t.replicas, c.w)))
+					}
+
+					continue
+				}
+
+				if t.answers == 0 {
+					t.first = r.server.Number()
+				}
+
+				if t.answers++; t.answers == c.w {
+					t.done, pending = true, pending-1
+					results[key] = gost.Ok(t.first)
+				}
+			}
+		case <-ctx.Done():
+			received = len(batches)
+		}
+	}
+
+	for key, t := range tallies {
+		if !t.done {
+			results[key] = gost.Err[int32](constants.ErrQuorum.ExtendMsg(fmt.Sprintf("%d of %d replicas acknowledged, %d are needed", t.answers, t.replicas, c.w)))
+		}
+	}
+
+	return results
+}
+
+// MGet reads the keys from their replicas with one request per server.
+// Like Get, a key is resolved once R of its replicas answer, the replicas that answered
+// with an older version are updated in the background. The reads are not hedged.
+// The results go by key, None when no replica knows the key.
+func (c *Coordinator) MGet(ctx context.Context, replicas map[string][]domains.Server, claims gost.Option[models.UserClaims], opts models.GetOptions) map[string]gost.Result[gost.Option[Versioned]] {
+	results := make(map[string]gost.Result[gost.Option[Versioned]], len(replicas))
+	tallies := make(map[string]*tally, len(replicas))
+	servers := make(map[int32]domains.Server)
+	batches := make(map[int32][]string)
+
+	for key, keyReplicas := range replicas {
+		if n := len(keyReplicas); n < c.r {
+			results[key] = gost.Err[gost.Option[Versioned]](constants.ErrQuorum.ExtendMsg(fmt.Sprintf("%d of %d replicas are online, %d are needed", n, c.n, c.r)))
+			continue
+		}
+
+		tallies[key] = &tally{replicas: len(keyReplicas)}
+		for _, server := range keyReplicas {
+			number := server.Number()
+			servers[number] = server
+			batches[number] = append(batches[number], key)
+		}
+	}
+
+	bg, cancel := deadline.Detached(ctx, c.timeouts.Read)
+	replies := send(servers, func() {}, func(server domains.Server) map[string]reply {
+		batch := batches[server.Number()]
+
+		opts := opts
+		opts.Server = server.Number()
+
+		return getReplies(server, batch, server.MGet(bg, claims, batch, opts))
+	})
+
+	count := func(rep batchReply) {
+		for key, r := range rep.replies {
+			t := tallies[key]
+			t.got = append(t.got, r)
+			if t.done {
+				continue
+			}
+
+			if r.err != nil {
+				if t.failures++; t.failures > t.replicas-c.r {
+					t.done = true
+					results[key] = gost.Err[gost.Option[Versioned]](r.err.ExtendMsg(fmt.Sprintf("quorum not reached: %d of %d replicas answered, %d are needed", t.answers, t.replicas, c.r)))
+				}
+
+				continue
+			}
+
+			if t.answers++; t.answers == c.r {
+				t.done = true
+				results[key] = gost.Ok(newestOf(t.got))
+			}
+		}
+	}
+
+	resolved := func() bool {
+		for _, t := range tallies {
+			if !t.done {
+				return false
+			}
+		}
+		return true
+	}
+
+	received := 0
+wait:
+	for ; received < len(batches) && !resolved(); received++ {
+		select {
+		case rep := <-replies:
+			count(rep)
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	for key, t := range tallies {
+		if !t.done {
+			t.done = true
+			results[key] = gost.Err[gost.Option[Versioned]](constants.ErrQuorum.ExtendMsg(fmt.Sprintf("%d of %d replicas answered, %d are needed", t.answers, t.replicas, c.r)))
+		}
+	}
+
+	// the replicas past the quorum are waited for, so every replica that lags behind is updated
+	go func() {
+		defer cancel()
+
+		for ; received < len(batches); received++ {
+			select {
+			case rep := <-replies:
+				count(rep)
+			case <-bg.Done():
+				received = len(batches)
+			}
+		}
+
+		for key, t := range tallies {
+			c.update(bg, claims, key, t.got)
+		}
+	}()
+
+	return results
+}
+
+// send runs op on each server in parallel, the replies come from the returned channel.
+// done is called once every op is over.
+func send(servers map[int32]domains.Server, done func(), op func(domains.Server) map[string]reply) <-chan batchReply {
+	replies := make(chan batchReply, len(servers))
+
+	var wg sync.WaitGroup
+	wg.Add(len(servers))
+
+	for _, server := range servers {
+		go func(server domains.Server) {
+			defer wg.Done()
+			replies <- batchReply{server: server, replies: op(server)}
+		}(server)
+	}
+
+	go func() {
+		wg.Wait()
+		done()
+	}()
+
+	return replies
+}
+
+// setReplies splits the answer of a server to MSet by key.
+func setReplies(server domains.Server, batch map[string]string, r gost.Result[[]models.SetResult]) map[string]reply {
+	replies := make(map[string]reply, len(batch))
+	for key := range batch {
+		rep := reply{server: server, err: r.Error()}
+		if r.IsOk() {
+			rep.err = gost.NewErrX(0, fmt.Sprintf("s#%d returned no result for the key", server.Number()))
+		}
+		replies[key] = rep
+	}
+
+	if r.IsErr() {
+		return replies
+	}
+
+	for _, res := range r.Unwrap() {
+		if _, sent := batch[res.Key]; !sent {
+			continue
+		}
+
+		rep := reply{server: server}
+		if res.Err != nil {
+			rep.err = errX(res.Err)
+		}
+		replies[res.Key] = rep
+	}
+
+	return replies
+}
+
+// getReplies splits the answer of a server to MGet by key, a missing key is not an error.
+func getReplies(server domains.Server, batch []string, r gost.Result[[]models.GetResult]) map[string]reply {
+	replies := make(map[string]reply, len(batch))
+
+	if r.IsOk() && len(r.Unwrap()) != len(batch) {
+		r = gost.Err[[]models.GetResult](gost.NewErrX(0, fmt.Sprintf("s#%d returned %d results for %d keys", server.Number(), len(r.Unwrap()), len(batch))))
+	}
+
+	for i, key := range batch {
+		rep := reply{server: server}
+
+		switch {
+		case r.IsErr():
+			rep.err = r.Error()
+		case r.Unwrap()[i].Err == nil:
+			rep.value = rep.value.Some(Decode(r.Unwrap()[i].Value))
+		case !IsNotFound(errX(r.Unwrap()[i].Err)):
+			rep.err = errX(r.Unwrap()[i].Err)
+		}
+
+		replies[key] = rep
+	}
+
+	return replies
+}
+
+// errX returns the error of a batch result as an ErrX, the servers may return plain errors.
+func errX(err error) *gost.ErrX {
+	var e *gost.ErrX
+	if errors.As(err, &e) {
+		return e
+	}
+
+	return gost.NewErrX(0, err.Error())
+}
@@ -0,0 +1,50 @@
+package quorum
+
+import (
+	"sync/atomic"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/service/servers/health"
+)
+
+const (
+	DefaultHedgeMinDelay = 5 * time.Millisecond
+
+	// _hedgePercentile of the recent reads is the wait before a read is hedged.
+	_hedgePercentile = 95
+	// _hedgeRecompute is the number of reads after which the wait is computed again.
+	_hedgeRecompute = 64
+)
+
+// hedger tells how long a read waits for the first replicas before it is sent to the rest.
+// A replica that is slower than most of the reads is not waited for then.
+type hedger struct {
+	minDelay time.Duration
+	latency  *health.Latency
+	reads    atomic.Uint64
+	delay    atomic.Int64
+}
+
+func newHedger(cfg config.HedgeConfig) *hedger {
+	if cfg.MinDelay <= 0 {
+		cfg.MinDelay = DefaultHedgeMinDelay
+	}
+
+	return &hedger{minDelay: cfg.MinDelay, latency: health.NewLatency()}
+}
+
+// record adds the duration of a read from a replica.
+func (h *hedger) record(d time.Duration) {
+	h.latency.Record(d)
+
+	// sorting the window on every read would cost more than the read itself
+	if h.reads.Add(1)%_hedgeRecompute == 1 {
+		h.delay.Store(int64(h.latency.Percentiles(_hedgePercentile)[0]))
+	}
+}
+
+// wait returns the time to wait before hedging a read.
+func (h *hedger) wait() time.Duration {
+	return max(time.Duration(h.delay.Load()), h.minDelay)
+}
@@ -0,0 +1,442 @@
+// Package quorum keeps several copies of a key on different servers.
+// A write succeeds once W replicas acknowledge it, a read asks R replicas and
+// returns the newest version, updating the replicas that answered with an older one.
+package quorum
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/deadline"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+// _hedged counts the reads and the ones that were sent to the rest of the replicas.
+var _hedged = expvar.NewMap("hedged_reads")
+
+type Coordinator struct {
+	n, r, w int
+	clock   Clock
+	logger  *zap.Logger
+
+	// timeouts bound the requests that keep going after the caller got its answer.
+	timeouts config.TimeoutsConfig
+	// hedger is nil when the reads are not hedged.
+	hedger *hedger
+}
+
+func New(cfg config.BalancerConfig, logger *zap.Logger) (*Coordinator, error) {
+	n := max(cfg.ReplicationFactor, 1)
+
+	majority := n/2 + 1
+
+	r, w := cfg.ReadQuorum, cfg.WriteQuorum
+	if r == 0 {
+		r = majority
+	}
+	if w == 0 {
+		w = majority
+	}
+
+	if r < 1 || r > n {
+		return nil, fmt.Errorf("ReadQuorum must be between 1 and ReplicationFactor (%d), got %d", n, r)
+	}
+
+	if w < 1 || w > n {
+		return nil, fmt.Errorf("WriteQuorum must be between 1 and ReplicationFactor (%d), got %d", n, w)
+	}
+
+	if n > 1 && cfg.Placement != config.HashPlacement {
+		return nil, fmt.Errorf("ReplicationFactor %d needs the %q placement to find the replicas of a key", n, config.HashPlacement)
+	}
+
+	c := &Coordinator{n: n, r: r, w: w, logger: logger, timeouts: deadline.WithDefaults(cfg.Timeouts)}
+	if cfg.Hedge.On {
+		c.hedger = newHedger(cfg.Hedge)
+	}
+
+	return c, nil
+}
+
+// Enabled reports whether keys have more than one copy.
+func (c *Coordinator) Enabled() bool {
+	return c.n > 1
+}
+
+// N returns the replication factor.
+func (c *Coordinator) N() int {
+	return c.n
+}
+
+type reply struct {
+	server domains.Server
+	value  gost.Option[Versioned]
+	err    *gost.ErrX
+}
+
+// Set writes val to the replicas and returns the first server that acknowledged it.
+func (c *Coordinator) Set(ctx context.Context, replicas []domains.Server, claims gost.Option[models.UserClaims], key, val string, opts models.SetOptions) (res gost.Result[int32]) {
+	versioned := Encode(c.clock.Next(), false, val)
+
+	return c.write(ctx, replicas, func(ctx context.Context, server domains.Server) *gost.ErrX {
+		opts := opts
+		opts.Server = server.Number()
+		return server.SetOne(ctx, claims, key, versioned, opts).Error()
+	})
+}
+
+// Stamp wraps val with a new version, for the writes that don't go through Set,
+// so that they are not taken for older than the replicated ones.
+func (c *Coordinator) Stamp(val string) string {
+	return Encode(c.clock.Next(), false, val)
+}
+
+// Delete replaces the value with a tombstone on the replicas and returns once W of them have it.
+// When every replica has the tombstone, it is removed as well once the reads that began before it are over.
+// It returns ErrNotFound when the newest version on the replicas that acknowledged is not a live value.
+func (c *Coordinator) Delete(ctx context.Context, replicas []domains.Server, claims gost.Option[models.UserClaims], key string, opts models.DeleteOptions) (res gost.ResultN) {
+	tombstone := Encode(c.clock.Next(), true, "")
+
+	var (
+		acked = make(chan domains.Server, len(replicas))
+		// seen gets what each replica had before the delete
+		seen = make(chan reply, len(replicas))
+	)
+
+	r := c.write(ctx, replicas, func(ctx context.Context, server domains.Server) *gost.ErrX {
+		rGet := server.GetOne(ctx, claims, key, models.GetOptions{Server: server.Number()})
+		if rGet.IsErr() && !IsNotFound(rGet.Error()) {
+			seen <- reply{server: server, err: rGet.Error()}
+			return rGet.Error()
+		}
+
+		rep := reply{server: server}
+		if rGet.IsOk() {
+			rep.value = rep.value.Some(Decode(rGet.Unwrap()))
+		}
+		seen <- rep
+
+		if rep.value.IsSome() && !rep.value.Unwrap().Tombstone {
+			opts := opts
+			opts.Server = server.Number()
+
+			// the delete checks the permissions to the value, the tombstone is a plain value
+			if r := server.DelOne(ctx, claims, key, opts); r.IsErr() && !IsNotFound(r.Error()) {
+				return r.Error()
+			}
+		}
+
+		if r := server.SetOne(ctx, claims, key, tombstone, models.SetOptions{Server: server.Number()}); r.IsErr() {
+			return r.Error()
+		}
+
+		acked <- server
+		return nil
+	})
+	if r.IsErr() {
+		return res.Err(r.Error())
+	}
+
+	// the replicas that acknowledged have told what they had, the rest are waited for in the background
+	got := make([]reply, 0, len(replicas))
+drain:
+	for {
+		select {
+		case rep := <-seen:
+			got = append(got, rep)
+		default:
+			break drain
+		}
+	}
+
+	newest := newestOf(got)
+	found := newest.IsSome() && !newest.Unwrap().Tombstone
+
+	go func() {
+		ctx, cancel := deadline.Detached(ctx, c.timeouts.Write)
+		defer cancel()
+
+		var done []domains.Server
+		for len(done) < len(replicas) || len(got) < len(replicas) {
+			select {
+			case server := <-acked:
+				done = append(done, server)
+			case rep := <-seen:
+				got = append(got, rep)
+			case <-ctx.Done():
+				// a replica missed the delete, the tombstones stay until it is repaired
+				return
+			}
+		}
+
+		if newest := newestOf(got); !found && newest.IsSome() && !newest.Unwrap().Tombstone {
+			c.logger.Debug("the value was deleted from the replicas past the quorum only", zap.String("key", key))
+		}
+
+		if len(done) < c.n {
+			return
+		}
+
+		// a read that got the value from a replica before its tombstone would repair the others with it,
+		// so the tombstones stay until such reads are over
+		grace := c.timeouts.Read
+		if grace <= 0 {
+			grace = deadline.DefaultWrite
+		}
+		time.Sleep(grace)
+
+		ctx, cancel = deadline.Detached(ctx, c.timeouts.Write)
+		defer cancel()
+
+		for _, server := range done {
+			// a value written since then is kept
+			rGet := server.GetOne(ctx, claims, key, models.GetOptions{Server: server.Number()})
+			if rGet.IsErr() || rGet.Unwrap().Value != tombstone {
+				continue
+			}
+
+			if r := server.DelOne(ctx, claims, key, models.DeleteOptions{Server: server.Number()}); r.IsErr() && !IsNotFound(r.Error()) {
+				c.logger.Warn("can't remove tombstone", zap.Int32("server", server.Number()), zap.String("key", key), zap.Error(r.Error()))
+			}
+		}
+	}()
+
+	if !found {
+		return res.Err(constants.ErrNotFound)
+	}
+
+	return res.Ok()
+}
+
+// write runs op on the replicas and waits for W of them to succeed.
+// The rest keep going in the background.
+func (c *Coordinator) write(ctx context.Context, replicas []domains.Server, op func(context.Context, domains.Server) *gost.ErrX) (res gost.Result[int32]) {
+	if len(replicas) < c.w {
+		return res.Err(constants.ErrQuorum.ExtendMsg(fmt.Sprintf("%d of %d replicas are online, %d are needed", len(replicas), c.n, c.w)))
+	}
+
+	bg, cancel := deadline.Detached(ctx, c.timeouts.Write)
+
+	var wg sync.WaitGroup
+	wg.Add(len(replicas))
+
+	replies := make(chan reply, len(replicas))
+	for _, server := range replicas {
+		go func(server domains.Server) {
+			defer wg.Done()
+			replies <- reply{server: server, err: op(bg, server)}
+		}(server)
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+
+	var (
+		acks, failures int
+		first          int32
+		lastErr        *gost.ErrX
+	)
+
+	for acks < c.w {
+		select {
+		case rep := <-replies:
+			if rep.err != nil {
+				c.logger.Warn("replica write failed", zap.Int32("server", rep.server.Number()), zap.Error(rep.err))
+
+				lastErr = rep.err
+				if failures++; failures > len(replicas)-c.w {
+					return res.Err(lastErr.ExtendMsg(fmt.Sprintf("quorum not reached: %d of %d replicas acknowledged, %d are needed", acks, len(replicas), c.w)))
+				}
+
+				continue
+			}
+
+			if acks == 0 {
+				first = rep.server.Number()
+			}
+			acks++
+		case <-ctx.Done():
+			return res.Err(constants.ErrQuorum.ExtendMsg(ctx.Err().Error()))
+		}
+	}
+
+	return res.Ok(first)
+}
+
+// Get asks R replicas for key and returns the newest version.
+// None means no replica has ever seen the key, a deleted key comes back as a tombstone.
+// The replicas that answered with an older version are repaired in the background.
+// Without hedging the read is sent to every replica, with it to R of them first, see hedger.
+func (c *Coordinator) Get(ctx context.Context, replicas []domains.Server, claims gost.Option[models.UserClaims], key string, opts models.GetOptions) (res gost.Result[gost.Option[Versioned]]) {
+	if len(replicas) < c.r {
+		return res.Err(constants.ErrQuorum.ExtendMsg(fmt.Sprintf("%d of %d replicas are online, %d are needed", len(replicas), c.n, c.r)))
+	}
+
+	bg, cancel := deadline.Detached(ctx, c.timeouts.Read)
+
+	replies := make(chan reply, len(replicas))
+	send := func(servers []domains.Server) {
+		for _, server := range servers {
+			go func(server domains.Server) {
+				start := time.Now()
+				rep := reply{server: server}
+
+				opts := opts
+				opts.Server = server.Number()
+				switch r := server.GetOne(bg, claims, key, opts); {
+				case r.IsOk():
+					rep.value = rep.value.Some(Decode(r.Unwrap()))
+				case !IsNotFound(r.Error()):
+					rep.err = r.Error()
+				}
+
+				if c.hedger != nil {
+					c.hedger.record(time.Since(start))
+				}
+
+				replies <- rep
+			}(server)
+		}
+	}
+
+	first, rest := replicas, []domains.Server(nil)
+	var hedge <-chan time.Time
+	if c.hedger != nil && len(replicas) > c.r {
+		first, rest = replicas[:c.r], replicas[c.r:]
+
+		timer := time.NewTimer(c.hedger.wait())
+		defer timer.Stop()
+		hedge = timer.C
+	}
+
+	send(first)
+	_hedged.Add("reads", 1)
+
+	// sendRest sends the read to the replicas that didn't get it yet
+	sendRest := func() {
+		if rest != nil {
+			send(rest)
+			_hedged.Add("hedged", 1)
+			rest, hedge = nil, nil
+		}
+	}
+
+	var (
+		got               []reply
+		answers, failures int
+	)
+
+	for answers < c.r {
+		select {
+		case <-hedge:
+			sendRest()
+		case rep := <-replies:
+			got = append(got, rep)
+
+			if rep.err != nil {
+				// a failed replica leaves a place for one the read was not sent to yet
+				sendRest()
+
+				if failures++; failures > len(replicas)-c.r {
+					go c.repair(bg, cancel, claims, key, got, replies, len(replicas))
+					return res.Err(rep.err.ExtendMsg(fmt.Sprintf("quorum not reached: %d of %d replicas answered, %d are needed", answers, len(replicas), c.r)))
+				}
+
+				continue
+			}
+
+			answers++
+		case <-ctx.Done():
+			go c.repair(bg, cancel, claims, key, got, replies, len(replicas)-len(rest))
+			return res.Err(constants.ErrQuorum.ExtendMsg(ctx.Err().Error()))
+		}
+	}
+
+	newest := newestOf(got)
+	go c.repair(bg, cancel, claims, key, got, replies, len(replicas)-len(rest))
+
+	return res.Ok(newest)
+}
+
+// repair waits for the rest of the replies and writes the newest version to the replicas that lag behind.
+func (c *Coordinator) repair(ctx context.Context, cancel context.CancelFunc, claims gost.Option[models.UserClaims], key string, got []reply, replies chan reply, total int) {
+	defer cancel()
+
+	for len(got) < total {
+		select {
+		case rep := <-replies:
+			got = append(got, rep)
+		case <-ctx.Done():
+			total = len(got)
+		}
+	}
+
+	c.update(ctx, claims, key, got)
+}
+
+// update writes the newest version of the replies to the replicas that answered with an older one.
+func (c *Coordinator) update(ctx context.Context, claims gost.Option[models.UserClaims], key string, got []reply) {
+	newest := newestOf(got)
+	if newest.IsNone() {
+		return
+	}
+
+	latest := newest.Unwrap()
+	c.clock.Observe(latest.Version)
+
+	versioned := Encode(latest.Version, latest.Tombstone, latest.Value.Value)
+
+	for _, rep := range got {
+		if rep.err != nil {
+			continue
+		}
+
+		if rep.value.IsSome() && rep.value.Unwrap().Version >= latest.Version {
+			continue
+		}
+
+		opts := models.SetOptions{Server: rep.server.Number(), ReadOnly: latest.ReadOnly, Level: latest.Level}
+		if latest.Tombstone {
+			opts = models.SetOptions{Server: rep.server.Number()}
+		}
+
+		if r := rep.server.SetOne(ctx, claims, key, versioned, opts); r.IsErr() {
+			c.logger.Warn("can't repair replica", zap.Int32("server", rep.server.Number()), zap.String("key", key), zap.Error(r.Error()))
+			continue
+		}
+
+		c.logger.Debug("replica repaired", zap.Int32("server", rep.server.Number()), zap.String("key", key), zap.Uint64("version", latest.Version))
+	}
+}
+
+func newestOf(replies []reply) (res gost.Option[Versioned]) {
+	for _, rep := range replies {
+		if rep.err != nil || rep.value.IsNone() {
+			continue
+		}
+
+		if v := rep.value.Unwrap(); res.IsNone() || v.Version > res.Unwrap().Version {
+			res = res.Some(v)
+		}
+	}
+
+	return res
+}
+
+// IsNotFound reports whether err says that the key is missing.
+// ErrX.Is compares the codes only, and the errors of the servers share code 0,
+// so the message of the root error is compared instead.
+func IsNotFound(err *gost.ErrX) bool {
+	return err != nil && err.Messages()[0] == constants.ErrNotFound.Message()
+}
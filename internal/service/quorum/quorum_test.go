@@ -0,0 +1,457 @@
+package quorum
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/clustertest"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+// stored decodes the value of the key on the server.
+func stored(s *clustertest.Server, key string) (Versioned, bool) {
+	v, ok := s.Value(key)
+	return Decode(v), ok
+}
+
+func rootIs(err, target *gost.ErrX) bool {
+	return err.Messages()[0] == target.Message()
+}
+
+func newCluster(t *testing.T) (*Coordinator, []*clustertest.Server, []domains.Server) {
+	t.Helper()
+
+	// the tombstones are removed after the read timeout
+	bcfg := config.BalancerConfig{Placement: config.HashPlacement, ReplicationFactor: 3, Timeouts: config.TimeoutsConfig{Read: 100 * time.Millisecond}}
+
+	c, err := New(bcfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	servers := []*clustertest.Server{clustertest.NewServer(1), clustertest.NewServer(2), clustertest.NewServer(3)}
+	replicas := make([]domains.Server, len(servers))
+	for i, s := range servers {
+		replicas[i] = s
+	}
+
+	return c, servers, replicas
+}
+
+func TestReadRepair(t *testing.T) {
+	ctx := context.Background()
+	claims := gost.None[models.UserClaims]()
+
+	c, servers, replicas := newCluster(t)
+
+	if r := c.Set(ctx, replicas, claims, "key", "v1", models.SetOptions{Level: constants.RestrictedLevel}); r.IsErr() {
+		t.Fatal(r.Error())
+	}
+
+	// the write reaches every replica even after the quorum acknowledged it
+	for _, s := range servers {
+		clustertest.Eventually(t, "the first write", func() bool {
+			v, ok := stored(s, "key")
+			return ok && v.Value.Value == "v1"
+		})
+	}
+
+	servers[0].SetDown(true)
+
+	if r := c.Set(ctx, replicas, claims, "key", "v2", models.SetOptions{Level: constants.RestrictedLevel}); r.IsErr() {
+		t.Fatal(r.Error())
+	}
+
+	clustertest.Eventually(t, "the write to miss the replica", func() bool {
+		return servers[0].Rejected() > 0
+	})
+	servers[0].SetDown(false)
+
+	if v, _ := stored(servers[0], "key"); v.Value.Value != "v1" {
+		t.Fatalf("replica 1 got %q while it was down", v.Value.Value)
+	}
+
+	// any two replicas include one with the newest version
+	for i := 0; i < 10; i++ {
+		r := c.Get(ctx, replicas, claims, "key", models.GetOptions{})
+		if r.IsErr() {
+			t.Fatal(r.Error())
+		}
+
+		if v := r.Unwrap(); v.IsNone() || v.Unwrap().Value.Value != "v2" || v.Unwrap().Level != constants.RestrictedLevel {
+			t.Fatalf("got %+v, want v2", v)
+		}
+	}
+
+	clustertest.Eventually(t, "read repair", func() bool {
+		v, _ := stored(servers[0], "key")
+		return v.Value.Value == "v2" && v.Level == constants.RestrictedLevel
+	})
+}
+
+func TestQuorumNotReached(t *testing.T) {
+	ctx := context.Background()
+	claims := gost.None[models.UserClaims]()
+
+	c, servers, replicas := newCluster(t)
+
+	servers[0].SetDown(true)
+	servers[1].SetDown(true)
+
+	if r := c.Set(ctx, replicas, claims, "key", "value", models.SetOptions{}); r.IsOk() {
+		t.Fatal("write succeeded on one replica of three")
+	}
+
+	if r := c.Get(ctx, replicas, claims, "key", models.GetOptions{}); r.IsOk() {
+		t.Fatal("read succeeded on one replica of three")
+	}
+
+	if r := c.Set(ctx, replicas[2:], claims, "key", "value", models.SetOptions{}); r.IsOk() || !rootIs(r.Error(), constants.ErrQuorum) {
+		t.Fatalf("got %v, want %v", r.Error(), constants.ErrQuorum)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	claims := gost.None[models.UserClaims]()
+
+	t.Run("missed by a replica", func(t *testing.T) {
+		c, servers, replicas := newCluster(t)
+
+		c.Set(ctx, replicas, claims, "key", "value", models.SetOptions{})
+		for _, s := range servers {
+			clustertest.Eventually(t, "the write", func() bool {
+				_, ok := stored(s, "key")
+				return ok
+			})
+		}
+
+		servers[2].SetDown(true)
+
+		if r := c.Delete(ctx, replicas, claims, "key", models.DeleteOptions{}); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+
+		clustertest.Eventually(t, "the delete to miss the replica", func() bool {
+			return servers[2].Rejected() > 0
+		})
+		servers[2].SetDown(false)
+
+		for i := 0; i < 10; i++ {
+			r := c.Get(ctx, replicas, claims, "key", models.GetOptions{})
+			if r.IsErr() {
+				t.Fatal(r.Error())
+			}
+
+			if v := r.Unwrap(); v.IsNone() || !v.Unwrap().Tombstone {
+				t.Fatalf("deleted key came back: %+v", v)
+			}
+		}
+
+		clustertest.Eventually(t, "the tombstone on the replica that missed the delete", func() bool {
+			v, ok := stored(servers[2], "key")
+			return ok && v.Tombstone
+		})
+
+		if r := c.Delete(ctx, replicas, claims, "key", models.DeleteOptions{}); r.IsOk() || !rootIs(r.Error(), constants.ErrNotFound) {
+			t.Fatalf("second delete: got %v, want %v", r.Error(), constants.ErrNotFound)
+		}
+	})
+
+	t.Run("value past the quorum", func(t *testing.T) {
+		c, servers, replicas := newCluster(t)
+
+		// only the slowest replica has the value, the delete returns once the other two have the tombstone
+		servers[2].Put("key", models.Value{Value: Encode(1, false, "value")})
+		servers[2].SetDelay(time.Second)
+
+		start := time.Now()
+		if r := c.Delete(ctx, replicas, claims, "key", models.DeleteOptions{}); r.IsOk() || !rootIs(r.Error(), constants.ErrNotFound) {
+			t.Fatalf("delete past the quorum: got %v, want %v", r.Error(), constants.ErrNotFound)
+		}
+
+		if took := time.Since(start); took >= time.Second {
+			t.Fatalf("the delete waited %v for the slowest replica", took)
+		}
+
+		clustertest.Eventually(t, "the delete on the slowest replica", func() bool {
+			v, ok := stored(servers[2], "key")
+			return !ok || v.Tombstone
+		})
+
+		// an older value doesn't count once the key is deleted
+		c, servers, replicas = newCluster(t)
+		servers[0].Put("key", models.Value{Value: Encode(2, true, "")})
+		servers[2].Put("key", models.Value{Value: Encode(1, false, "value")})
+
+		if r := c.Delete(ctx, replicas, claims, "key", models.DeleteOptions{}); r.IsOk() || !rootIs(r.Error(), constants.ErrNotFound) {
+			t.Fatalf("delete of a deleted key: got %v, want %v", r.Error(), constants.ErrNotFound)
+		}
+	})
+
+	t.Run("every replica", func(t *testing.T) {
+		c, servers, replicas := newCluster(t)
+
+		c.Set(ctx, replicas, claims, "key", "value", models.SetOptions{})
+		for _, s := range servers {
+			clustertest.Eventually(t, "the write", func() bool {
+				_, ok := stored(s, "key")
+				return ok
+			})
+		}
+
+		if r := c.Delete(ctx, replicas, claims, "key", models.DeleteOptions{}); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+
+		// nothing is left once every replica has the tombstone
+		for _, s := range servers {
+			clustertest.Eventually(t, "the tombstones to be removed", func() bool {
+				_, ok := stored(s, "key")
+				return !ok
+			})
+		}
+
+		if r := c.Get(ctx, replicas, claims, "key", models.GetOptions{}); r.IsErr() || r.Unwrap().IsSome() {
+			t.Fatalf("got %+v", r)
+		}
+	})
+
+	t.Run("written again", func(t *testing.T) {
+		c, servers, replicas := newCluster(t)
+
+		c.Set(ctx, replicas, claims, "key", "old", models.SetOptions{})
+		if r := c.Delete(ctx, replicas, claims, "key", models.DeleteOptions{}); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+
+		// the tombstones are still kept, the removal leaves the new value alone
+		if r := c.Set(ctx, replicas, claims, "key", "new", models.SetOptions{}); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+
+		for _, s := range servers {
+			clustertest.Eventually(t, "the new value", func() bool {
+				v, ok := stored(s, "key")
+				return ok && v.Value.Value == "new"
+			})
+		}
+
+		time.Sleep(3 * c.timeouts.Read)
+
+		for _, s := range servers {
+			if v, ok := stored(s, "key"); !ok || v.Value.Value != "new" {
+				t.Errorf("s#%d: got %+v", s.Number(), v)
+			}
+		}
+	})
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	claims := gost.None[models.UserClaims]()
+
+	c, servers, replicas := newCluster(t)
+
+	// a and b share the replicas, c is kept by two of them
+	keys := map[string][]domains.Server{"a": replicas, "b": replicas, "c": replicas[1:]}
+
+	written := c.MSet(ctx, keys, claims, map[string]string{"a": "1", "b": "2", "c": "3"}, models.SetOptions{})
+	for key, r := range written {
+		if r.IsErr() {
+			t.Fatalf("mset %s: %v", key, r.Error())
+		}
+	}
+
+	for _, s := range servers {
+		clustertest.Eventually(t, "the batch on every replica", func() bool {
+			_, ok := stored(s, "a")
+			return ok && s.Calls("MSet") == 1
+		})
+
+		if n := s.Calls("SetOne"); n != 0 {
+			t.Errorf("s#%d got %d single writes", s.Number(), n)
+		}
+	}
+
+	if servers[0].Has("c") {
+		t.Error("c reached a server that is not its replica")
+	}
+
+	a1, _ := stored(servers[1], "a")
+	if a0, _ := stored(servers[0], "a"); a0.Version != a1.Version || a1.Version == 0 {
+		t.Errorf("the replicas got different versions of a: %d and %d", a0.Version, a1.Version)
+	}
+
+	// one replica lags behind, the newest version is read and the replica is updated
+	servers[0].Put("a", models.Value{Value: Encode(1, false, "old")})
+	b, _ := stored(servers[0], "b")
+	for _, s := range servers[1:] {
+		s.Put("b", models.Value{Value: Encode(b.Version+1, true, "")})
+	}
+
+	read := c.MGet(ctx, map[string][]domains.Server{"a": replicas, "b": replicas, "missing": replicas}, claims, models.GetOptions{})
+
+	if r := read["a"]; r.IsErr() || r.Unwrap().IsNone() || r.Unwrap().Unwrap().Value.Value != "1" {
+		t.Errorf("mget a: %+v", r)
+	}
+
+	if r := read["b"]; r.IsErr() || r.Unwrap().IsNone() || !r.Unwrap().Unwrap().Tombstone {
+		t.Errorf("mget a deleted key: %+v", r)
+	}
+
+	if r := read["missing"]; r.IsErr() || r.Unwrap().IsSome() {
+		t.Errorf("mget a missing key: %+v", r)
+	}
+
+	clustertest.Eventually(t, "the lagging replica to be updated", func() bool {
+		v, _ := stored(servers[0], "a")
+		return v.Value.Value == "1"
+	})
+
+	for _, s := range servers {
+		if n := s.Calls("MGet"); n != 1 {
+			t.Errorf("s#%d got %d batch reads", s.Number(), n)
+		}
+
+		if n := s.Calls("GetOne"); n != 0 {
+			t.Errorf("s#%d got %d single reads", s.Number(), n)
+		}
+	}
+
+	// a key is resolved by its own replicas
+	servers[0].SetDown(true)
+	servers[1].SetDown(true)
+
+	written = c.MSet(ctx, map[string][]domains.Server{"a": replicas, "d": replicas[2:]}, claims, map[string]string{"a": "4", "d": "5"}, models.SetOptions{})
+	if r := written["a"]; r.IsOk() || !rootIs(r.Error(), clustertest.ErrDown) {
+		t.Errorf("mset with one replica of three: %+v", r)
+	}
+
+	if r := written["d"]; r.IsOk() || !rootIs(r.Error(), constants.ErrQuorum) {
+		t.Errorf("mset with too few replicas: %+v", r)
+	}
+
+	read = c.MGet(ctx, map[string][]domains.Server{"a": replicas}, claims, models.GetOptions{})
+	if r := read["a"]; r.IsOk() || !rootIs(r.Error(), clustertest.ErrDown) {
+		t.Errorf("mget with one replica of three: %+v", r)
+	}
+}
+
+func TestVersioned(t *testing.T) {
+	plain := models.Value{Value: "plain:value", Level: constants.SecretLevel}
+	if v := Decode(plain); v.Version != 0 || v.Tombstone || v.Value != plain {
+		t.Errorf("plain value: got %+v", v)
+	}
+
+	v := Decode(models.Value{Value: Encode(42, false, "a:b:c"), ReadOnly: true})
+	if v.Version != 42 || v.Tombstone || v.Value.Value != "a:b:c" || !v.ReadOnly {
+		t.Errorf("got %+v", v)
+	}
+
+	if v := Decode(models.Value{Value: Encode(43, true, "")}); v.Version != 43 || !v.Tombstone {
+		t.Errorf("tombstone: got %+v", v)
+	}
+
+	var clock Clock
+	clock.Observe(uint64(time.Now().Add(time.Hour).UnixNano()))
+	if a, b := clock.Next(), clock.Next(); b <= a || a < uint64(time.Now().Add(time.Hour).UnixNano())-uint64(time.Minute) {
+		t.Errorf("clock went back: %d, %d", a, b)
+	}
+}
+
+func TestHedge(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := config.BalancerConfig{
+		Placement:         config.HashPlacement,
+		ReplicationFactor: 3,
+		ReadQuorum:        1,
+		Hedge:             config.HedgeConfig{On: true, MinDelay: 20 * time.Millisecond},
+	}
+
+	c, err := New(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	servers := []*clustertest.Server{clustertest.NewServer(1), clustertest.NewServer(2), clustertest.NewServer(3)}
+	replicas := []domains.Server{servers[0], servers[1], servers[2]}
+
+	if r := c.Set(ctx, replicas, gost.None[models.UserClaims](), "key", "value", models.SetOptions{}); r.IsErr() {
+		t.Fatal(r.Error())
+	}
+
+	// the write returns after two replicas, the reads below expect it on each of them
+	for _, s := range servers {
+		clustertest.Eventually(t, "the write", func() bool {
+			_, ok := stored(s, "key")
+			return ok
+		})
+	}
+
+	reads := func() (n []int) {
+		for _, s := range servers {
+			n = append(n, s.Reads())
+		}
+		return n
+	}
+
+	// the first replica answers in time, the others are not asked
+	if r := c.Get(ctx, replicas, gost.None[models.UserClaims](), "key", models.GetOptions{}); r.IsErr() || r.Unwrap().Unwrap().Value.Value != "value" {
+		t.Fatalf("got %+v", r)
+	}
+
+	if n := reads(); n[0] != 1 || n[1] != 0 || n[2] != 0 {
+		t.Errorf("a fast read was hedged: %v", n)
+	}
+
+	// the slow replica is not waited for
+	servers[0].SetDelay(2 * time.Second)
+
+	start := time.Now()
+	if r := c.Get(ctx, replicas, gost.None[models.UserClaims](), "key", models.GetOptions{}); r.IsErr() || r.Unwrap().Unwrap().Value.Value != "value" {
+		t.Fatalf("got %+v", r)
+	}
+
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("the hedged read took %v", d)
+	}
+
+	clustertest.Eventually(t, "the hedged reads", func() bool {
+		n := reads()
+		return n[0] == 2 && n[1] == 1 && n[2] == 1
+	})
+
+	// a failed replica is replaced at once
+	servers[0].SetDelay(0)
+	servers[0].SetDown(true)
+
+	if r := c.Get(ctx, replicas, gost.None[models.UserClaims](), "key", models.GetOptions{}); r.IsErr() || r.Unwrap().Unwrap().Value.Value != "value" {
+		t.Fatalf("got %+v", r)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, cfg := range []config.BalancerConfig{
+		{ReplicationFactor: 3},
+		{Placement: config.HashPlacement, ReplicationFactor: 3, ReadQuorum: 4},
+		{Placement: config.HashPlacement, ReplicationFactor: 3, WriteQuorum: -1},
+	} {
+		if _, err := New(cfg, zap.NewNop()); err == nil {
+			t.Errorf("%+v: no error", cfg)
+		}
+	}
+
+	c, err := New(config.BalancerConfig{}, zap.NewNop())
+	if err != nil || c.Enabled() {
+		t.Errorf("default config: %v, enabled %v", err, c.Enabled())
+	}
+}
@@ -0,0 +1,101 @@
+package quorum
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"itisadb/internal/models"
+)
+
+// _prefix marks a value written through the coordinator.
+// Servers store the version inside the value, so replication works with any server
+// that can store a string, the local one and the remote ones alike.
+const _prefix = "\x00itisadb:v1:"
+
+const (
+	_live      = "v"
+	_tombstone = "t"
+)
+
+// Versioned is a value with the version it was written at.
+// A tombstone is left by a delete, so read repair can't bring the value back.
+type Versioned struct {
+	models.Value
+	Version   uint64
+	Tombstone bool
+}
+
+// Encode wraps value with its version.
+func Encode(version uint64, tombstone bool, value string) string {
+	flag := _live
+	if tombstone {
+		flag = _tombstone
+	}
+
+	return _prefix + strconv.FormatUint(version, 10) + ":" + flag + ":" + value
+}
+
+// Reserved reports whether value starts like a versioned one, Decode would take it for one.
+func Reserved(value string) bool {
+	return strings.HasPrefix(value, _prefix)
+}
+
+// Decode unwraps a stored value. A value written without replication has version 0.
+func Decode(v models.Value) Versioned {
+	rest, ok := strings.CutPrefix(v.Value, _prefix)
+	if !ok {
+		return Versioned{Value: v}
+	}
+
+	version, rest, ok := strings.Cut(rest, ":")
+	if !ok {
+		return Versioned{Value: v}
+	}
+
+	flag, value, ok := strings.Cut(rest, ":")
+	if !ok || (flag != _live && flag != _tombstone) {
+		return Versioned{Value: v}
+	}
+
+	n, err := strconv.ParseUint(version, 10, 64)
+	if err != nil {
+		return Versioned{Value: v}
+	}
+
+	v.Value = value
+
+	return Versioned{Value: v, Version: n, Tombstone: flag == _tombstone}
+}
+
+// Clock hands out increasing versions close to the wall time,
+// so the last write wins across balancers with roughly synchronised clocks.
+type Clock struct {
+	last atomic.Uint64
+}
+
+func (c *Clock) Next() uint64 {
+	for {
+		last := c.last.Load()
+
+		next := uint64(time.Now().UnixNano())
+		if next <= last {
+			next = last + 1
+		}
+
+		if c.last.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+// Observe moves the clock past a version seen on a replica.
+func (c *Clock) Observe(version uint64) {
+	for {
+		last := c.last.Load()
+		if version <= last || c.last.CompareAndSwap(last, version) {
+			return
+		}
+	}
+}
@@ -0,0 +1,1041 @@
+// Package raft replicates a state machine between a few nodes with the Raft consensus algorithm.
+//
+// One of the nodes is elected the leader, it alone appends the commands to the log and sends
+// them to the others. A command is applied once a majority keeps it, on every node in the same order.
+// A node that is not the leader forwards the commands proposed to it to the leader.
+// The log is compacted into a snapshot of the state machine after SnapshotThreshold applied entries,
+// a follower that is too far behind gets the snapshot instead of the entries.
+//
+// The nodes are fixed by the config, the membership of the nodes themselves doesn't change at runtime.
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"itisadb/config"
+	"itisadb/pkg/api/cluster"
+)
+
+const (
+	DefaultDirectory         = "raft"
+	DefaultHeartbeatInterval = 100 * time.Millisecond
+	DefaultElectionTimeout   = time.Second
+	DefaultSnapshotThreshold = 10_000
+
+	// _maxBatch is the number of the entries sent to a follower at once.
+	_maxBatch = 512
+)
+
+var (
+	ErrNoLeader       = errors.New("raft: no leader")
+	ErrNotLeader      = errors.New("raft: not the leader")
+	ErrLeadershipLost = errors.New("raft: leadership lost before the command was committed")
+	ErrStopped        = errors.New("raft: node is stopped")
+)
+
+// WithDefaults fills the fields the config does not set.
+func WithDefaults(cfg config.RaftConfig) config.RaftConfig {
+	if cfg.Directory == "" {
+		cfg.Directory = DefaultDirectory
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = DefaultElectionTimeout
+	}
+	if cfg.SnapshotThreshold <= 0 {
+		cfg.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+
+	return cfg
+}
+
+// StateMachine is the state the nodes replicate. The commands are applied one at a time.
+type StateMachine interface {
+	// Apply applies a committed command, the result and the error go back to the proposer.
+	Apply(cmd []byte) ([]byte, error)
+	// Snapshot returns the whole state, Restore replaces the state with it.
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// Transport sends the requests of a node to the other ones by their IDs.
+type Transport interface {
+	RequestVote(ctx context.Context, peer string, req *cluster.VoteRequest) (*cluster.VoteResponse, error)
+	AppendEntries(ctx context.Context, peer string, req *cluster.AppendRequest) (*cluster.AppendResponse, error)
+	InstallSnapshot(ctx context.Context, peer string, req *cluster.SnapshotRequest) (*cluster.SnapshotResponse, error)
+	Propose(ctx context.Context, peer string, req *cluster.ProposeRequest) (*cluster.ProposeResponse, error)
+}
+
+type Role int
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+func (r Role) String() string {
+	switch r {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+	return "unknown"
+}
+
+// Status is what a node knows about the cluster.
+type Status struct {
+	ID          string `json:"id"`
+	Role        string `json:"role"`
+	Leader      string `json:"leader"`
+	Term        uint64 `json:"term"`
+	CommitIndex uint64 `json:"commit_index"`
+	LastApplied uint64 `json:"last_applied"`
+}
+
+type result struct {
+	index uint64
+	value []byte
+	err   error
+}
+
+type waiter struct {
+	term uint64
+	ch   chan result
+}
+
+type Node struct {
+	cfg       config.RaftConfig
+	id        string
+	peers     []string
+	sm        StateMachine
+	transport Transport
+	store     *storage
+	logger    *zap.Logger
+
+	mu       sync.Mutex
+	role     Role
+	term     uint64
+	votedFor string
+	leader   string
+	// log[0] is the last entry of the snapshot, without the command.
+	log         []cluster.RaftEntry
+	commitIndex uint64
+	lastApplied uint64
+	deadline    time.Time
+
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	replicating map[string]bool
+	waiters     map[uint64]waiter
+	// applied is closed when lastApplied grows.
+	applied chan struct{}
+
+	// applyMu keeps the state machine to one applier at a time: the applied entries or a snapshot.
+	applyMu  sync.Mutex
+	commitCh chan struct{}
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// New opens the node's log in cfg.Directory and restores the state machine from it.
+// The node takes part in the cluster after Start.
+func New(cfg config.RaftConfig, sm StateMachine, transport Transport, logger *zap.Logger) (*Node, error) {
+	cfg = WithDefaults(cfg)
+
+	if cfg.ID == "" {
+		return nil, fmt.Errorf("raft: the ID of the node is not set")
+	}
+
+	peers := make([]string, 0, len(cfg.Peers))
+	for _, p := range cfg.Peers {
+		if p != cfg.ID && !slices.Contains(peers, p) {
+			peers = append(peers, p)
+		}
+	}
+
+	store, err := openStorage(cfg.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	state, snap, entries, err := store.load()
+	if err != nil {
+		store.close()
+		return nil, err
+	}
+
+	if snap.Index > 0 {
+		if err := sm.Restore(snap.Data); err != nil {
+			store.close()
+			return nil, fmt.Errorf("raft: can't restore the snapshot: %w", err)
+		}
+	}
+
+	n := &Node{
+		cfg:         cfg,
+		id:          cfg.ID,
+		peers:       peers,
+		sm:          sm,
+		transport:   transport,
+		store:       store,
+		logger:      logger.With(zap.String("raft", cfg.ID)),
+		term:        state.Term,
+		votedFor:    state.VotedFor,
+		log:         append([]cluster.RaftEntry{{Index: snap.Index, Term: snap.Term}}, entries...),
+		commitIndex: snap.Index,
+		lastApplied: snap.Index,
+		nextIndex:   make(map[string]uint64),
+		matchIndex:  make(map[string]uint64),
+		replicating: make(map[string]bool),
+		waiters:     make(map[uint64]waiter),
+		applied:     make(chan struct{}),
+		commitCh:    make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+
+	return n, nil
+}
+
+// Start starts the elections, the heartbeats and the applier.
+func (n *Node) Start() {
+	n.mu.Lock()
+	n.resetDeadline()
+	n.mu.Unlock()
+
+	n.stopped.Add(2)
+	go n.tick()
+	go n.apply()
+}
+
+// Stop stops the node, it doesn't answer the other nodes since then.
+func (n *Node) Stop() {
+	select {
+	case <-n.stop:
+		return
+	default:
+	}
+
+	close(n.stop)
+	n.stopped.Wait()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.role = Follower
+	for index, w := range n.waiters {
+		w.ch <- result{err: ErrStopped}
+		delete(n.waiters, index)
+	}
+
+	n.store.close()
+}
+
+func (n *Node) isStopped() bool {
+	select {
+	case <-n.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// ID returns the ID of the node.
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Leader returns the ID of the leader the node knows of, "" during an election.
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// IsLeader reports whether the node is the leader.
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == Leader
+}
+
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return Status{
+		ID:          n.id,
+		Role:        n.role.String(),
+		Leader:      n.leader,
+		Term:        n.term,
+		CommitIndex: n.commitIndex,
+		LastApplied: n.lastApplied,
+	}
+}
+
+// =============== log ====================== //
+
+func (n *Node) firstIndex() uint64 {
+	return n.log[0].Index
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+// termAt returns the term of the entry, false when it is compacted or not in the log yet.
+func (n *Node) termAt(index uint64) (uint64, bool) {
+	if index < n.firstIndex() || index > n.lastIndex() {
+		return 0, false
+	}
+
+	return n.log[index-n.firstIndex()].Term, true
+}
+
+func (n *Node) majority() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+func (n *Node) resetDeadline() {
+	timeout := n.cfg.ElectionTimeout
+	n.deadline = time.Now().Add(timeout + time.Duration(rand.Int63n(int64(timeout))))
+}
+
+func (n *Node) persistState() {
+	if err := n.store.saveState(hardState{Term: n.term, VotedFor: n.votedFor}); err != nil {
+		n.logger.Error("can't save raft state", zap.Error(err))
+	}
+}
+
+// stepDown makes the node a follower of the term.
+func (n *Node) stepDown(term uint64) {
+	if term > n.term {
+		n.term, n.votedFor = term, ""
+		n.persistState()
+	}
+
+	if n.role == Leader {
+		n.logger.Info("raft leadership lost", zap.Uint64("term", n.term))
+	}
+
+	n.role = Follower
+	n.resetDeadline()
+}
+
+// truncate drops the entries from index on, the proposals of them fail.
+func (n *Node) truncate(index uint64) {
+	n.log = n.log[:index-n.firstIndex()]
+
+	for i, w := range n.waiters {
+		if i >= index {
+			w.ch <- result{err: ErrLeadershipLost}
+			delete(n.waiters, i)
+		}
+	}
+
+	if err := n.store.rewrite(n.log[1:]); err != nil {
+		n.logger.Error("can't rewrite raft log", zap.Error(err))
+	}
+}
+
+// =============== elections ====================== //
+
+func (n *Node) tick() {
+	defer n.stopped.Done()
+
+	t := time.NewTicker(n.cfg.HeartbeatInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-t.C:
+		}
+
+		n.mu.Lock()
+		switch {
+		case n.role == Leader:
+			n.broadcast()
+		case time.Now().After(n.deadline):
+			n.campaign()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// campaign starts an election of the node in the next term.
+func (n *Node) campaign() {
+	n.role = Candidate
+	n.term++
+	n.votedFor = n.id
+	n.leader = ""
+	n.persistState()
+	n.resetDeadline()
+
+	term := n.term
+	votes := 1
+
+	n.logger.Debug("raft election", zap.Uint64("term", term))
+
+	if votes >= n.majority() {
+		n.becomeLeader()
+		return
+	}
+
+	req := &cluster.VoteRequest{Term: term, Candidate: n.id, LastLogIndex: n.lastIndex(), LastLogTerm: n.lastTerm()}
+
+	for _, peer := range n.peers {
+		go func(peer string) {
+			ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+			defer cancel()
+
+			resp, err := n.transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			if resp.Term > n.term {
+				n.stepDown(resp.Term)
+				return
+			}
+
+			if n.role != Candidate || n.term != term || !resp.Granted {
+				return
+			}
+
+			votes++
+			if votes >= n.majority() {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+func (n *Node) becomeLeader() {
+	n.role = Leader
+	n.leader = n.id
+
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+	}
+
+	n.logger.Info("raft leader elected", zap.Uint64("term", n.term))
+
+	// an entry of its own term commits the entries of the previous terms
+	n.appendEntry(nil)
+	n.advanceCommit()
+	n.broadcast()
+}
+
+// requestVote grants the vote to a candidate whose log is not behind the node's one.
+func (n *Node) requestVote(_ context.Context, req *cluster.VoteRequest) (*cluster.VoteResponse, error) {
+	if n.isStopped() {
+		return nil, status.Error(codes.Unavailable, ErrStopped.Error())
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return &cluster.VoteResponse{Term: n.term}, nil
+	}
+
+	if req.Term > n.term {
+		n.stepDown(req.Term)
+		n.leader = ""
+	}
+
+	upToDate := req.LastLogTerm > n.lastTerm() || (req.LastLogTerm == n.lastTerm() && req.LastLogIndex >= n.lastIndex())
+
+	if (n.votedFor == "" || n.votedFor == req.Candidate) && upToDate {
+		n.votedFor = req.Candidate
+		n.persistState()
+		n.resetDeadline()
+
+		return &cluster.VoteResponse{Term: n.term, Granted: true}, nil
+	}
+
+	return &cluster.VoteResponse{Term: n.term}, nil
+}
+
+// =============== replication ====================== //
+
+// appendEntry appends the command to the log of the leader.
+func (n *Node) appendEntry(cmd []byte) cluster.RaftEntry {
+	e := cluster.RaftEntry{Index: n.lastIndex() + 1, Term: n.term, Command: cmd}
+	n.log = append(n.log, e)
+
+	if err := n.store.append(e); err != nil {
+		n.logger.Error("can't append to raft log", zap.Error(err))
+	}
+
+	return e
+}
+
+// broadcast sends the new entries, or a heartbeat, to the followers that are not being sent to already.
+func (n *Node) broadcast() {
+	for _, peer := range n.peers {
+		if n.replicating[peer] {
+			continue
+		}
+
+		n.replicating[peer] = true
+		go n.replicate(peer)
+	}
+}
+
+// replicate sends the entries to the follower until it has all of them.
+func (n *Node) replicate(peer string) {
+	for {
+		n.mu.Lock()
+		if n.role != Leader || n.isStopped() {
+			n.replicating[peer] = false
+			n.mu.Unlock()
+			return
+		}
+
+		more, ok := n.sendTo(peer)
+		if !ok || !more {
+			n.replicating[peer] = false
+			n.mu.Unlock()
+			return
+		}
+		n.mu.Unlock()
+	}
+}
+
+// sendTo sends the follower the next entries or the snapshot, it is called with n.mu held and releases it
+// while the request is in flight. more reports whether there is more to send, ok is false on a failure.
+func (n *Node) sendTo(peer string) (more bool, ok bool) {
+	term := n.term
+	next := n.nextIndex[peer]
+
+	if next <= n.firstIndex() {
+		return n.sendSnapshot(peer, term)
+	}
+
+	prev := next - 1
+	prevTerm, _ := n.termAt(prev)
+
+	end := min(n.lastIndex(), prev+_maxBatch)
+	entries := slices.Clone(n.log[next-n.firstIndex() : end-n.firstIndex()+1])
+
+	req := &cluster.AppendRequest{
+		Term:         term,
+		Leader:       n.id,
+		PrevLogIndex: prev,
+		PrevLogTerm:  prevTerm,
+		Entries:      entries,
+		LeaderCommit: n.commitIndex,
+	}
+
+	n.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	resp, err := n.transport.AppendEntries(ctx, peer, req)
+	cancel()
+	n.mu.Lock()
+
+	if err != nil {
+		return false, false
+	}
+
+	if resp.Term > n.term {
+		n.stepDown(resp.Term)
+		return false, false
+	}
+
+	if n.role != Leader || n.term != term {
+		return false, false
+	}
+
+	if !resp.Success {
+		n.nextIndex[peer] = max(1, min(next-1, resp.LastIndex+1))
+		return true, true
+	}
+
+	match := prev + uint64(len(entries))
+	if match > n.matchIndex[peer] {
+		n.matchIndex[peer] = match
+	}
+	n.nextIndex[peer] = match + 1
+
+	n.advanceCommit()
+
+	return n.nextIndex[peer] <= n.lastIndex(), true
+}
+
+func (n *Node) sendSnapshot(peer string, term uint64) (more bool, ok bool) {
+	n.mu.Unlock()
+	snap, err := n.store.loadSnapshot()
+	n.mu.Lock()
+
+	if err != nil {
+		n.logger.Error("can't read raft snapshot", zap.Error(err))
+		return false, false
+	}
+
+	req := &cluster.SnapshotRequest{Term: term, Leader: n.id, LastIndex: snap.Index, LastTerm: snap.Term, Data: snap.Data}
+
+	n.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*n.cfg.ElectionTimeout)
+	resp, err := n.transport.InstallSnapshot(ctx, peer, req)
+	cancel()
+	n.mu.Lock()
+
+	if err != nil {
+		return false, false
+	}
+
+	if resp.Term > n.term {
+		n.stepDown(resp.Term)
+		return false, false
+	}
+
+	if n.role != Leader || n.term != term {
+		return false, false
+	}
+
+	n.matchIndex[peer] = max(n.matchIndex[peer], snap.Index)
+	n.nextIndex[peer] = snap.Index + 1
+
+	return n.nextIndex[peer] <= n.lastIndex(), true
+}
+
+// advanceCommit commits the last entry of the current term a majority keeps, and the ones before it.
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if term, _ := n.termAt(index); term != n.term {
+			return
+		}
+
+		count := 1
+		for _, peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+
+		if count >= n.majority() {
+			n.commit(index)
+			return
+		}
+	}
+}
+
+func (n *Node) commit(index uint64) {
+	n.commitIndex = index
+
+	select {
+	case n.commitCh <- struct{}{}:
+	default:
+	}
+}
+
+// appendEntries appends the entries of the leader to the log and commits the ones the leader committed.
+func (n *Node) appendEntries(_ context.Context, req *cluster.AppendRequest) (*cluster.AppendResponse, error) {
+	if n.isStopped() {
+		return nil, status.Error(codes.Unavailable, ErrStopped.Error())
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return &cluster.AppendResponse{Term: n.term, LastIndex: n.lastIndex()}, nil
+	}
+
+	n.stepDown(req.Term)
+	n.leader = req.Leader
+
+	if req.PrevLogIndex > n.lastIndex() {
+		return &cluster.AppendResponse{Term: n.term, LastIndex: n.lastIndex()}, nil
+	}
+
+	if term, ok := n.termAt(req.PrevLogIndex); ok && term != req.PrevLogTerm {
+		// the leader goes back to the entry before the conflicting term at once
+		index := req.PrevLogIndex
+		for index > n.firstIndex()+1 {
+			if t, _ := n.termAt(index - 1); t != term {
+				break
+			}
+			index--
+		}
+
+		return &cluster.AppendResponse{Term: n.term, LastIndex: index - 1}, nil
+	}
+
+	var appended []cluster.RaftEntry
+	for _, e := range req.Entries {
+		if e.Index <= n.firstIndex() {
+			continue
+		}
+
+		if term, ok := n.termAt(e.Index); ok {
+			if term == e.Term {
+				continue
+			}
+
+			n.truncate(e.Index)
+		}
+
+		n.log = append(n.log, e)
+		appended = append(appended, e)
+	}
+
+	if len(appended) > 0 {
+		if err := n.store.append(appended...); err != nil {
+			n.logger.Error("can't append to raft log", zap.Error(err))
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	if last := req.PrevLogIndex + uint64(len(req.Entries)); req.LeaderCommit > n.commitIndex {
+		n.commit(min(req.LeaderCommit, last))
+	}
+
+	return &cluster.AppendResponse{Term: n.term, Success: true, LastIndex: n.lastIndex()}, nil
+}
+
+// installSnapshot replaces the state of a follower that is too far behind with the snapshot of the leader.
+func (n *Node) installSnapshot(_ context.Context, req *cluster.SnapshotRequest) (*cluster.SnapshotResponse, error) {
+	if n.isStopped() {
+		return nil, status.Error(codes.Unavailable, ErrStopped.Error())
+	}
+
+	n.mu.Lock()
+	if req.Term < n.term {
+		defer n.mu.Unlock()
+		return &cluster.SnapshotResponse{Term: n.term}, nil
+	}
+
+	n.stepDown(req.Term)
+	n.leader = req.Leader
+
+	if req.LastIndex <= n.commitIndex {
+		defer n.mu.Unlock()
+		return &cluster.SnapshotResponse{Term: n.term}, nil
+	}
+	n.mu.Unlock()
+
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	if err := n.sm.Restore(req.Data); err != nil {
+		n.logger.Error("can't restore raft snapshot", zap.Error(err))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	snap := snapshot{Index: req.LastIndex, Term: req.LastTerm, Data: req.Data}
+	if err := n.store.saveSnapshot(snap); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.compact(snap.Index, snap.Term)
+	n.commitIndex = max(n.commitIndex, snap.Index)
+	n.setApplied(snap.Index)
+
+	return &cluster.SnapshotResponse{Term: n.term}, nil
+}
+
+// compact drops the entries up to index, which the snapshot has. The entries after it are kept
+// if the log has the entry of the snapshot, otherwise the log is replaced by the snapshot.
+func (n *Node) compact(index, term uint64) {
+	if t, ok := n.termAt(index); ok && t == term {
+		n.log = slices.Clone(n.log[index-n.firstIndex():])
+		n.log[0].Command = nil
+	} else {
+		n.log = []cluster.RaftEntry{{Index: index, Term: term}}
+	}
+
+	if err := n.store.rewrite(n.log[1:]); err != nil {
+		n.logger.Error("can't rewrite raft log", zap.Error(err))
+	}
+}
+
+// =============== applying ====================== //
+
+func (n *Node) setApplied(index uint64) {
+	n.lastApplied = index
+	close(n.applied)
+	n.applied = make(chan struct{})
+}
+
+// apply applies the committed entries in order.
+func (n *Node) apply() {
+	defer n.stopped.Done()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-n.commitCh:
+		}
+
+		n.applyCommitted()
+	}
+}
+
+func (n *Node) applyCommitted() {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	for {
+		n.mu.Lock()
+		if n.lastApplied >= n.commitIndex {
+			n.mu.Unlock()
+			break
+		}
+
+		first := n.lastApplied + 1 - n.firstIndex()
+		entries := slices.Clone(n.log[first : first+min(n.commitIndex-n.lastApplied, _maxBatch)])
+		n.mu.Unlock()
+
+		for _, e := range entries {
+			var res result
+			if e.Command != nil {
+				res.value, res.err = n.sm.Apply(e.Command)
+			}
+			res.index = e.Index
+
+			n.mu.Lock()
+			n.setApplied(e.Index)
+
+			if w, ok := n.waiters[e.Index]; ok {
+				if w.term != e.Term {
+					res = result{err: ErrLeadershipLost}
+				}
+				w.ch <- res
+				delete(n.waiters, e.Index)
+			}
+			n.mu.Unlock()
+		}
+	}
+
+	n.maybeSnapshot()
+}
+
+// maybeSnapshot compacts the log when enough entries are applied since the last snapshot.
+func (n *Node) maybeSnapshot() {
+	n.mu.Lock()
+	index := n.lastApplied
+	term, _ := n.termAt(index)
+	due := index-n.firstIndex() >= uint64(n.cfg.SnapshotThreshold)
+	n.mu.Unlock()
+
+	if !due {
+		return
+	}
+
+	data, err := n.sm.Snapshot()
+	if err != nil {
+		n.logger.Error("can't take raft snapshot", zap.Error(err))
+		return
+	}
+
+	if err := n.store.saveSnapshot(snapshot{Index: index, Term: term, Data: data}); err != nil {
+		n.logger.Error("can't save raft snapshot", zap.Error(err))
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.compact(index, term)
+	n.logger.Info("raft log compacted", zap.Uint64("index", index))
+}
+
+// waitApplied waits until the node applies the entry.
+func (n *Node) waitApplied(ctx context.Context, index uint64) error {
+	for {
+		n.mu.Lock()
+		applied, ch := n.lastApplied, n.applied
+		n.mu.Unlock()
+
+		if applied >= index {
+			return nil
+		}
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.stop:
+			return ErrStopped
+		}
+	}
+}
+
+// =============== proposals ====================== //
+
+// Propose replicates the command and returns the result of applying it. A follower forwards the command
+// to the leader and waits until it applies the command itself too, so it reads what it wrote.
+func (n *Node) Propose(ctx context.Context, cmd []byte) ([]byte, error) {
+	if n.isStopped() {
+		return nil, ErrStopped
+	}
+
+	res, err := n.propose(ctx, cmd)
+	if !errors.Is(err, ErrNotLeader) {
+		if err != nil {
+			return nil, err
+		}
+
+		return res.value, res.err
+	}
+
+	leader := n.Leader()
+	if leader == "" {
+		return nil, ErrNoLeader
+	}
+
+	resp, err := n.transport.Propose(ctx, leader, &cluster.ProposeRequest{Command: cmd})
+	if err != nil {
+		if st, ok := status.FromError(err); ok && st.Code() == codes.FailedPrecondition {
+			return nil, ErrNoLeader
+		}
+
+		return nil, fmt.Errorf("raft: can't forward the command to %s: %w", leader, err)
+	}
+
+	if err := n.waitApplied(ctx, resp.Index); err != nil {
+		return nil, err
+	}
+
+	return resp.Result, fromMessages(resp.Error)
+}
+
+// propose appends the command to the log of the leader and waits until it is applied.
+func (n *Node) propose(ctx context.Context, cmd []byte) (result, error) {
+	if cmd == nil {
+		cmd = []byte{}
+	}
+
+	n.mu.Lock()
+	if n.role != Leader {
+		n.mu.Unlock()
+		return result{}, ErrNotLeader
+	}
+
+	e := n.appendEntry(cmd)
+	ch := make(chan result, 1)
+	n.waiters[e.Index] = waiter{term: e.Term, ch: ch}
+
+	n.advanceCommit()
+	n.broadcast()
+	n.mu.Unlock()
+
+	select {
+	case res := <-ch:
+		return res, res.errRaft()
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.waiters, e.Index)
+		n.mu.Unlock()
+
+		return result{}, ctx.Err()
+	}
+}
+
+// errRaft returns the error of the node, not of the state machine.
+func (r result) errRaft() error {
+	if errors.Is(r.err, ErrLeadershipLost) || errors.Is(r.err, ErrStopped) {
+		return r.err
+	}
+	return nil
+}
+
+// proposeForwarded serves the commands the followers forward, only the leader accepts them.
+func (n *Node) proposeForwarded(ctx context.Context, req *cluster.ProposeRequest) (*cluster.ProposeResponse, error) {
+	res, err := n.propose(ctx, req.Command)
+	switch {
+	case errors.Is(err, ErrNotLeader):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &cluster.ProposeResponse{Index: res.index, Result: res.value, Error: toMessages(res.err)}, nil
+}
+
+// toMessages keeps the messages of the error of a command, so the follower gets the same error.
+func toMessages(err error) []string {
+	if err == nil {
+		return nil
+	}
+
+	var errX *gost.ErrX
+	if errors.As(err, &errX) {
+		return errX.Messages()
+	}
+
+	return []string{err.Error()}
+}
+
+func fromMessages(messages []string) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	err := gost.NewErrX(0, messages[0])
+	for _, msg := range messages[1:] {
+		err = err.ExtendMsg(msg)
+	}
+
+	return err
+}
+
+// =============== server ====================== //
+
+// Server returns the gRPC service the other nodes reach the node through.
+func (n *Node) Server() cluster.RaftServer {
+	return server{n: n}
+}
+
+type server struct {
+	cluster.UnimplementedRaftServer
+	n *Node
+}
+
+func (s server) RequestVote(ctx context.Context, req *cluster.VoteRequest) (*cluster.VoteResponse, error) {
+	return s.n.requestVote(ctx, req)
+}
+
+func (s server) AppendEntries(ctx context.Context, req *cluster.AppendRequest) (*cluster.AppendResponse, error) {
+	return s.n.appendEntries(ctx, req)
+}
+
+func (s server) InstallSnapshot(ctx context.Context, req *cluster.SnapshotRequest) (*cluster.SnapshotResponse, error) {
+	return s.n.installSnapshot(ctx, req)
+}
+
+func (s server) Propose(ctx context.Context, req *cluster.ProposeRequest) (*cluster.ProposeResponse, error) {
+	return s.n.proposeForwarded(ctx, req)
+}
@@ -0,0 +1,333 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"itisadb/config"
+	"itisadb/internal/clustertest"
+	"itisadb/pkg/api/cluster"
+)
+
+// kv is a map of strings, a command sets a key and returns its previous value.
+type kv struct {
+	mu sync.Mutex
+	m  map[string]string
+}
+
+type setCmd struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+var errFail = gost.NewErrX(0, "the command failed")
+
+func (s *kv) Apply(cmd []byte) ([]byte, error) {
+	var c setCmd
+	if err := json.Unmarshal(cmd, &c); err != nil {
+		return nil, err
+	}
+
+	if c.Value == "fail" {
+		return nil, errFail
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.m[c.Key]
+	s.m[c.Key] = c.Value
+
+	return []byte(prev), nil
+}
+
+func (s *kv) Snapshot() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.Marshal(s.m)
+}
+
+func (s *kv) Restore(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.m = make(map[string]string)
+	return json.Unmarshal(data, &s.m)
+}
+
+func (s *kv) get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.m[key]
+}
+
+type testNode struct {
+	*Node
+	kv   *kv
+	srv  *grpc.Server
+	addr string
+	dir  string
+}
+
+type testCluster struct {
+	t     *testing.T
+	cfg   config.RaftConfig
+	nodes []*testNode
+}
+
+// newCluster starts n nodes on localhost, each with its own directory.
+func newCluster(t *testing.T, n int, cfg config.RaftConfig) *testCluster {
+	c := &testCluster{t: t, cfg: cfg}
+
+	for _, addr := range clustertest.Addresses(t, n) {
+		c.nodes = append(c.nodes, &testNode{addr: addr, dir: t.TempDir()})
+		c.cfg.Peers = append(c.cfg.Peers, addr)
+	}
+
+	for i := range c.nodes {
+		c.start(i)
+	}
+
+	t.Cleanup(func() {
+		for i := range c.nodes {
+			c.stop(i)
+		}
+	})
+
+	return c
+}
+
+func (c *testCluster) start(i int) {
+	tn := c.nodes[i]
+
+	cfg := c.cfg
+	cfg.ID, cfg.Directory = tn.addr, tn.dir
+
+	tn.kv = &kv{m: make(map[string]string)}
+
+	transport := NewTransport(nil, clustertest.Insecure())
+	node, err := New(cfg, tn.kv, transport, zap.NewNop())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	tn.Node = node
+
+	tn.srv = clustertest.Serve(c.t, tn.addr, func(srv *grpc.Server) {
+		cluster.RegisterRaftServer(srv, node.Server())
+	})
+
+	node.Start()
+}
+
+func (c *testCluster) stop(i int) {
+	tn := c.nodes[i]
+	if tn.srv == nil {
+		return
+	}
+
+	tn.srv.Stop()
+	tn.Node.Stop()
+	tn.srv = nil
+}
+
+// leader waits until all the running nodes agree on the leader.
+func (c *testCluster) leader() *testNode {
+	c.t.Helper()
+
+	var leader *testNode
+	clustertest.Eventually(c.t, "a leader", func() bool {
+		leaders := make(map[string]bool)
+		for _, tn := range c.nodes {
+			if tn.srv != nil {
+				leaders[tn.Leader()] = true
+			}
+		}
+
+		if len(leaders) != 1 || leaders[""] {
+			return false
+		}
+
+		for _, tn := range c.nodes {
+			if tn.srv != nil && tn.IsLeader() {
+				leader = tn
+			}
+		}
+
+		return leader != nil
+	})
+
+	return leader
+}
+
+func (c *testCluster) follower() *testNode {
+	leader := c.leader()
+	for _, tn := range c.nodes {
+		if tn != leader && tn.srv != nil {
+			return tn
+		}
+	}
+
+	c.t.Fatal("no follower")
+	return nil
+}
+
+// applied waits until every running node has the value of the key.
+func (c *testCluster) applied(key, value string) {
+	c.t.Helper()
+
+	clustertest.Eventually(c.t, fmt.Sprintf("%s to be %q on every node", key, value), func() bool {
+		for _, tn := range c.nodes {
+			if tn.srv != nil && tn.kv.get(key) != value {
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+func set(t *testing.T, n *testNode, key, value string) ([]byte, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd, _ := json.Marshal(setCmd{Key: key, Value: value})
+	return n.Propose(ctx, cmd)
+}
+
+var _testConfig = config.RaftConfig{
+	HeartbeatInterval: 20 * time.Millisecond,
+	ElectionTimeout:   150 * time.Millisecond,
+}
+
+func TestReplication(t *testing.T) {
+	c := newCluster(t, 3, _testConfig)
+
+	leader := c.leader()
+	if _, err := set(t, leader, "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	c.applied("a", "1")
+
+	// the follower forwards the command and applies it before it returns
+	follower := c.follower()
+	prev, err := set(t, follower, "a", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(prev) != "1" {
+		t.Errorf("got previous value %q, want %q", prev, "1")
+	}
+
+	if v := follower.kv.get("a"); v != "2" {
+		t.Errorf("the follower doesn't read its write: %q", v)
+	}
+	c.applied("a", "2")
+
+	t.Run("error", func(t *testing.T) {
+		for _, n := range []*testNode{leader, follower} {
+			_, err := set(t, n, "a", "fail")
+
+			var errX *gost.ErrX
+			if !errors.As(err, &errX) || errX.Messages()[0] != errFail.Messages()[0] {
+				t.Errorf("got %v, want %v", err, errFail)
+			}
+		}
+	})
+}
+
+func TestFailover(t *testing.T) {
+	c := newCluster(t, 3, _testConfig)
+
+	old := c.leader()
+	if _, err := set(t, old, "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	c.applied("a", "1")
+
+	var stopped int
+	for i, tn := range c.nodes {
+		if tn == old {
+			stopped = i
+			c.stop(i)
+		}
+	}
+
+	// two of three nodes are a majority
+	leader := c.leader()
+	if leader == old {
+		t.Fatal("the stopped node is still the leader")
+	}
+
+	if _, err := set(t, leader, "b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	c.applied("b", "2")
+
+	// the old leader restores its log from the disk and catches up
+	c.start(stopped)
+	c.leader()
+
+	c.applied("a", "1")
+	c.applied("b", "2")
+}
+
+func TestSnapshot(t *testing.T) {
+	cfg := _testConfig
+	cfg.SnapshotThreshold = 10
+
+	c := newCluster(t, 3, cfg)
+
+	follower := c.follower()
+	var lagging int
+	for i, tn := range c.nodes {
+		if tn == follower {
+			lagging = i
+			c.stop(i)
+		}
+	}
+
+	leader := c.leader()
+	for i := 0; i < 50; i++ {
+		if _, err := set(t, leader, fmt.Sprint("key", i), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	leader.mu.Lock()
+	first := leader.firstIndex()
+	leader.mu.Unlock()
+
+	if first == 0 {
+		t.Fatal("the log is not compacted after 50 entries")
+	}
+
+	// the entries the follower misses are compacted, it gets the snapshot
+	c.start(lagging)
+	c.applied("key0", "0")
+	c.applied("key49", "49")
+
+	t.Run("restart", func(t *testing.T) {
+		for i := range c.nodes {
+			c.stop(i)
+		}
+
+		for i := range c.nodes {
+			c.start(i)
+		}
+
+		c.leader()
+		c.applied("key0", "0")
+		c.applied("key49", "49")
+	})
+}
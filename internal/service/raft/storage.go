@@ -0,0 +1,221 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"itisadb/pkg/api/cluster"
+)
+
+const (
+	_stateFile    = "state"
+	_logFile      = "log"
+	_snapshotFile = "snapshot"
+)
+
+// storage keeps what a node must not forget over a restart: the term and the vote,
+// the entries of the log after the snapshot and the snapshot itself.
+// The log is a file of JSON lines, appended to and rewritten only when it is truncated or compacted.
+type storage struct {
+	dir string
+	log *os.File
+}
+
+type hardState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for"`
+}
+
+type snapshot struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data"`
+}
+
+func openStorage(dir string) (*storage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("can't create raft directory: %w", err)
+	}
+
+	s := &storage{dir: dir}
+
+	f, err := os.OpenFile(s.path(_logFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.log = f
+
+	return s, nil
+}
+
+func (s *storage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *storage) close() error {
+	return s.log.Close()
+}
+
+// load returns the saved state, the snapshot and the entries after it.
+func (s *storage) load() (state hardState, snap snapshot, entries []cluster.RaftEntry, err error) {
+	if err = readJSON(s.path(_stateFile), &state); err != nil {
+		return
+	}
+
+	if err = readJSON(s.path(_snapshotFile), &snap); err != nil {
+		return
+	}
+
+	f, err := os.Open(s.path(_logFile))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 64<<20)
+
+	for sc.Scan() {
+		var e cluster.RaftEntry
+		if err = json.Unmarshal(sc.Bytes(), &e); err != nil {
+			// the last line may be cut by a crash, it was never acknowledged
+			break
+		}
+
+		if e.Index <= snap.Index {
+			continue
+		}
+
+		// a rewrite replaces the file, so the indexes only grow in it
+		if len(entries) > 0 && e.Index != entries[len(entries)-1].Index+1 {
+			return state, snap, nil, fmt.Errorf("raft log has a gap after entry %d", entries[len(entries)-1].Index)
+		}
+
+		entries = append(entries, e)
+	}
+
+	return state, snap, entries, sc.Err()
+}
+
+func (s *storage) saveState(state hardState) error {
+	return writeJSON(s.path(_stateFile), state)
+}
+
+func (s *storage) saveSnapshot(snap snapshot) error {
+	return writeJSON(s.path(_snapshotFile), snap)
+}
+
+func (s *storage) loadSnapshot() (snap snapshot, err error) {
+	err = readJSON(s.path(_snapshotFile), &snap)
+	return snap, err
+}
+
+// append adds the entries to the log and syncs it before they are acknowledged.
+func (s *storage) append(entries ...cluster.RaftEntry) error {
+	w := bufio.NewWriter(s.log)
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		w.Write(append(b, '\n'))
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return s.log.Sync()
+}
+
+// rewrite replaces the log with the entries.
+func (s *storage) rewrite(entries []cluster.RaftEntry) error {
+	tmp, err := os.CreateTemp(s.dir, ".log-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	s.log.Close()
+
+	if err := os.Rename(tmp.Name(), s.path(_logFile)); err != nil {
+		return err
+	}
+
+	s.log, err = os.OpenFile(s.path(_logFile), os.O_APPEND|os.O_WRONLY, 0644)
+	return err
+}
+
+func readJSON(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("can't read %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// writeJSON replaces the file at once, so a crash leaves either the old or the new one.
+func writeJSON(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,74 @@
+package raft
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"itisadb/internal/service/peers"
+	"itisadb/pkg/api/cluster"
+)
+
+// GRPCTransport reaches the peers at their IDs, which are their gRPC addresses.
+type GRPCTransport struct {
+	peers *peers.Peers
+}
+
+// NewTransport returns the transport that dials the peers with the options.
+// Without auth the requests are made without a token.
+func NewTransport(auth peers.Authenticator, opts ...grpc.DialOption) *GRPCTransport {
+	return &GRPCTransport{peers: peers.New(auth, opts...)}
+}
+
+// Close closes the connections to the peers.
+func (t *GRPCTransport) Close() {
+	t.peers.Close()
+}
+
+func (t *GRPCTransport) client(ctx context.Context, id string) (cluster.RaftClient, context.Context, error) {
+	conn, ctx, err := t.peers.Conn(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cluster.NewRaftClient(conn), ctx, nil
+}
+
+func (t *GRPCTransport) RequestVote(ctx context.Context, id string, req *cluster.VoteRequest) (*cluster.VoteResponse, error) {
+	cl, ctx, err := t.client(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cl.RequestVote(ctx, req)
+	return resp, t.peers.Checked(id, err)
+}
+
+func (t *GRPCTransport) AppendEntries(ctx context.Context, id string, req *cluster.AppendRequest) (*cluster.AppendResponse, error) {
+	cl, ctx, err := t.client(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cl.AppendEntries(ctx, req)
+	return resp, t.peers.Checked(id, err)
+}
+
+func (t *GRPCTransport) InstallSnapshot(ctx context.Context, id string, req *cluster.SnapshotRequest) (*cluster.SnapshotResponse, error) {
+	cl, ctx, err := t.client(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cl.InstallSnapshot(ctx, req)
+	return resp, t.peers.Checked(id, err)
+}
+
+func (t *GRPCTransport) Propose(ctx context.Context, id string, req *cluster.ProposeRequest) (*cluster.ProposeResponse, error) {
+	cl, ctx, err := t.client(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cl.Propose(ctx, req)
+	return resp, t.peers.Checked(id, err)
+}
@@ -0,0 +1,109 @@
+package rebalancer
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+)
+
+// objectJSON is an object as ObjectToJSON returns it, the values hold the attributes and the nested objects.
+type objectJSON struct {
+	Name   string            `json:"name"`
+	Level  string            `json:"level"`
+	Values []json.RawMessage `json:"values"`
+}
+
+type attrJSON struct {
+	Key      *string `json:"key"`
+	Value    string  `json:"value"`
+	ReadOnly bool    `json:"read_only"`
+}
+
+// objectEntries returns the entries of the object the way the scan passes them, the parents first.
+func objectEntries(name, data string) ([]models.Entry, error) {
+	var obj objectJSON
+	if err := json.Unmarshal([]byte(data), &obj); err != nil {
+		return nil, fmt.Errorf("can't decode object %s: %w", name, err)
+	}
+
+	var entries []models.Entry
+	return entries, appendObject(&entries, name, obj)
+}
+
+func appendObject(entries *[]models.Entry, name string, obj objectJSON) error {
+	*entries = append(*entries, models.Entry{Kind: models.ObjectEntry, Object: name, Value: models.Value{Level: parseLevel(obj.Level)}})
+
+	var nested []objectJSON
+	for _, raw := range obj.Values {
+		var attr attrJSON
+		if err := json.Unmarshal(raw, &attr); err != nil {
+			return fmt.Errorf("can't decode object %s: %w", name, err)
+		}
+
+		if attr.Key != nil {
+			*entries = append(*entries, models.Entry{
+				Kind:   models.ObjectValueEntry,
+				Object: name,
+				Key:    *attr.Key,
+				Value:  models.Value{Value: attr.Value, ReadOnly: attr.ReadOnly},
+			})
+			continue
+		}
+
+		var child objectJSON
+		if err := json.Unmarshal(raw, &child); err != nil {
+			return fmt.Errorf("can't decode object %s: %w", name, err)
+		}
+		nested = append(nested, child)
+	}
+
+	for _, child := range nested {
+		if err := appendObject(entries, name+constants.ObjectSeparator+child.Name, child); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseLevel(s string) models.Level {
+	for _, level := range []models.Level{constants.RestrictedLevel, constants.SecretLevel} {
+		if level.String() == s {
+			return level
+		}
+	}
+
+	return constants.DefaultLevel
+}
+
+// diffEntries returns the entries of cur that are new or differ from the ones in old
+// and the attributes of old that cur doesn't have. The parents stay before their values.
+func diffEntries(old, cur []models.Entry) (changed, removed []models.Entry) {
+	type id struct {
+		kind        models.EntryKind
+		object, key string
+	}
+
+	seen := make(map[id]models.Value, len(old))
+	for _, e := range old {
+		seen[id{e.Kind, e.Object, e.Key}] = e.Value
+	}
+
+	for _, e := range cur {
+		k := id{e.Kind, e.Object, e.Key}
+		if v, ok := seen[k]; !ok || v != e.Value {
+			changed = append(changed, e)
+		}
+		delete(seen, k)
+	}
+
+	for _, e := range old {
+		if _, ok := seen[id{e.Kind, e.Object, e.Key}]; ok && e.Kind == models.ObjectValueEntry {
+			removed = append(removed, e)
+		}
+	}
+
+	return changed, removed
+}
@@ -0,0 +1,584 @@
+// Package rebalancer moves the keys and objects to the servers that should hold them
+// after a server joins or is asked to leave.
+//
+// A pass scans every server, collects the entries that are placed elsewhere now
+// and copies them to their new servers at a limited rate. The catalog is pointed at the new
+// server once an entry is copied, then the entry is removed from the old one unless it has
+// changed in the meantime: the change is copied as well and the entry is compared again.
+// Until an entry is moved, the balancer finds it by asking every server.
+package rebalancer
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/quorum"
+
+	"github.com/egorgasay/gost"
+	"github.com/egorgasay/itisadb-go-sdk"
+	"go.uber.org/zap"
+)
+
+// DefaultRate is the number of entries moved per second when the config does not set it.
+const DefaultRate = 1000
+
+// _attempts is the number of times an entry that keeps changing on the old server is copied.
+const _attempts = 3
+
+var _current atomic.Pointer[Rebalancer]
+
+func init() {
+	expvar.Publish("rebalance", expvar.Func(func() any {
+		r := _current.Load()
+		if r == nil {
+			return nil
+		}
+
+		st := r.Status()
+		return map[string]any{
+			"running": st.Running,
+			"reason":  st.Reason,
+			"scanned": st.Scanned,
+			"moved":   st.Moved,
+			"failed":  st.Failed,
+			"leaving": st.Leaving,
+		}
+	}))
+}
+
+// _admin is used for the requests of the rebalancer, it moves the data of any level.
+var _admin = gost.Some(models.UserClaims{Level: constants.MaxLevel})
+
+type Rebalancer struct {
+	servers  domains.Servers
+	catalog  domains.Catalog
+	logger   *zap.Logger
+	hash     bool
+	replicas int
+	interval time.Duration
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	status models.RebalanceStatus
+
+	scanned, moved, failed atomic.Uint64
+}
+
+func New(cfg config.BalancerConfig, servers domains.Servers, catalog domains.Catalog, logger *zap.Logger) *Rebalancer {
+	rate := cfg.RebalanceRate
+	if rate <= 0 {
+		rate = DefaultRate
+	}
+
+	r := &Rebalancer{
+		servers:  servers,
+		catalog:  catalog,
+		logger:   logger,
+		hash:     cfg.Placement == config.HashPlacement,
+		replicas: max(cfg.ReplicationFactor, 1),
+		interval: time.Second / time.Duration(rate),
+	}
+
+	_current.Store(r)
+
+	return r
+}
+
+// Rebalance starts a pass in the background, a running pass is stopped first.
+func (r *Rebalancer) Rebalance(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cancel != nil {
+		r.cancel()
+	}
+	prev := r.done
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel, r.done = cancel, make(chan struct{})
+
+	r.scanned.Store(0)
+	r.moved.Store(0)
+	r.failed.Store(0)
+	r.status = models.RebalanceStatus{Running: true, Reason: reason, StartedAt: time.Now()}
+
+	go r.run(ctx, reason, prev, r.done)
+}
+
+// Remove stops placing new data on the server and moves its data away.
+// The server is disconnected when nothing is left on it.
+func (r *Rebalancer) Remove(server int32) {
+	if !r.servers.Leave(server) {
+		return
+	}
+
+	r.Rebalance(fmt.Sprintf("s#%d is leaving", server))
+}
+
+func (r *Rebalancer) Status() models.RebalanceStatus {
+	r.mu.Lock()
+	st := r.status
+	r.mu.Unlock()
+
+	st.Scanned, st.Moved, st.Failed = r.scanned.Load(), r.moved.Load(), r.failed.Load()
+	st.Leaving = r.servers.Leaving()
+	slices.Sort(st.Leaving)
+
+	return st
+}
+
+// Wait blocks until the current pass is over.
+func (r *Rebalancer) Wait() {
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+func (r *Rebalancer) run(ctx context.Context, reason string, prev, done chan struct{}) {
+	defer close(done)
+
+	// two passes moving the same entries would race
+	if prev != nil {
+		<-prev
+	}
+
+	r.logger.Info("rebalancing started", zap.String("reason", reason))
+
+	err := r.pass(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// a newer pass owns the status
+	if r.done != done {
+		return
+	}
+
+	r.status.Running = false
+	r.status.FinishedAt = time.Now()
+	if err != nil {
+		r.status.Error = err.Error()
+	}
+
+	r.logger.Info("rebalancing finished",
+		zap.String("reason", reason),
+		zap.Uint64("scanned", r.scanned.Load()),
+		zap.Uint64("moved", r.moved.Load()),
+		zap.Uint64("failed", r.failed.Load()),
+		zap.Error(err),
+	)
+}
+
+func (r *Rebalancer) pass(ctx context.Context) error {
+	leaving := r.servers.Leaving()
+
+	// with the RAM placement the data stays where it was put, only the leaving servers are emptied
+	if !r.hash && len(leaving) == 0 {
+		return nil
+	}
+
+	var sources []domains.Server
+	r.servers.Iter(func(s domains.Server) error {
+		sources = append(sources, s)
+		return nil
+	})
+
+	throttle := time.NewTicker(r.interval)
+	defer throttle.Stop()
+
+	for _, source := range sources {
+		isLeaving := slices.Contains(leaving, source.Number())
+		if !r.hash && !isLeaving {
+			continue
+		}
+
+		failedBefore := r.failed.Load()
+
+		if err := r.drain(ctx, source, isLeaving, throttle.C); err != nil {
+			return err
+		}
+
+		if isLeaving && r.failed.Load() == failedBefore {
+			r.servers.Disconnect(source.Number())
+			r.logger.Info("server left the cluster", zap.Int32("server", source.Number()))
+		}
+	}
+
+	for _, number := range r.servers.Leaving() {
+		if s, ok := r.servers.GetServer(number); ok && s.IsOffline() {
+			r.logger.Warn("leaving server is offline, its data will be moved when it is back", zap.Int32("server", number))
+		}
+	}
+
+	return nil
+}
+
+// drain moves the entries of source that belong elsewhere.
+// The scan only collects them, the entries are read again when they are moved.
+func (r *Rebalancer) drain(ctx context.Context, source domains.Server, leaving bool, throttle <-chan time.Time) error {
+	var (
+		keys    []string
+		objects = make(map[string][]models.Entry)
+		moves   = make(map[string]bool)
+		roots   []string
+	)
+
+	rScan := source.Scan(ctx, func(e models.Entry) error {
+		r.scanned.Add(1)
+
+		switch e.Kind {
+		case models.ValueEntry:
+			if leaving || !r.holds(r.keyTargets(e.Key), source) {
+				keys = append(keys, e.Key)
+			}
+		case models.ObjectEntry, models.ObjectValueEntry:
+			root, _, _ := strings.Cut(e.Object, constants.ObjectSeparator)
+
+			move, seen := moves[root]
+			if !seen {
+				switch {
+				case leaving:
+					move = true
+				case r.catalog.ObjectShards(root).IsSome():
+					// a shard stays on its server until the server leaves
+					move = false
+				default:
+					move = !r.holds(r.objectTargets(root), source)
+				}
+				moves[root] = move
+
+				if move {
+					roots = append(roots, root)
+				}
+			}
+
+			if move {
+				objects[root] = append(objects[root], e)
+			}
+		}
+
+		return nil
+	})
+	if rScan.IsErr() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		r.failed.Add(1)
+		r.logger.Error("can't scan server", zap.Int32("server", source.Number()), zap.Error(rScan.Error()))
+
+		return nil
+	}
+
+	for _, key := range keys {
+		select {
+		case <-throttle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		r.moveKey(ctx, source, key)
+	}
+
+	for _, root := range roots {
+		select {
+		case <-throttle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		r.moveObject(ctx, source, root, objects[root], throttle)
+	}
+
+	return nil
+}
+
+func (r *Rebalancer) holds(targets []domains.Server, source domains.Server) bool {
+	return slices.ContainsFunc(targets, func(s domains.Server) bool {
+		return s.Number() == source.Number()
+	})
+}
+
+// keyTargets returns the servers that should keep key.
+func (r *Rebalancer) keyTargets(key string) []domains.Server {
+	if r.hash {
+		return r.servers.KeyReplicas(key, r.replicas)
+	}
+
+	if s, ok := r.servers.GetServer(constants.AutoServerNumber); ok {
+		return []domains.Server{s}
+	}
+
+	return nil
+}
+
+// objectTargets returns the server that should keep the object, objects have one copy.
+func (r *Rebalancer) objectTargets(root string) []domains.Server {
+	if r.hash {
+		if s := r.servers.KeyOwner(root); s.IsSome() {
+			return []domains.Server{s.Unwrap()}
+		}
+
+		return nil
+	}
+
+	return r.keyTargets(root)
+}
+
+// shardTargets returns the server to move a shard of an object to, one without another shard of it if there is such.
+func (r *Rebalancer) shardTargets(shards []int32) []domains.Server {
+	rPlace := r.servers.PlaceN("", len(shards)+1)
+	if rPlace.IsErr() {
+		return nil
+	}
+
+	candidates := rPlace.Unwrap()
+	for _, s := range candidates {
+		if !slices.Contains(shards, s.Number()) {
+			return []domains.Server{s}
+		}
+	}
+
+	// the shards share a server then, their attributes don't overlap
+	return candidates[:1]
+}
+
+func (r *Rebalancer) moveKey(ctx context.Context, source domains.Server, key string) {
+	// the value is read again, it could have changed or gone since the scan
+	rGet := source.GetOne(ctx, _admin, key, models.GetOptions{Server: source.Number()})
+	if rGet.IsErr() {
+		if !quorum.IsNotFound(rGet.Error()) {
+			r.fail("can't read key", source, key, rGet.Error())
+		}
+		return
+	}
+	val := rGet.Unwrap()
+
+	targets := r.keyTargets(key)
+	if len(targets) == 0 {
+		r.fail("no server to move key to", source, key, constants.ErrServerNotFound)
+		return
+	}
+
+	for _, target := range targets {
+		if target.Number() == source.Number() {
+			continue
+		}
+
+		// a value written to the target after the placement changed is newer, it is kept
+		opts := models.SetOptions{Server: target.Number(), Level: val.Level, ReadOnly: val.ReadOnly, Unique: true}
+		if rSet := target.SetOne(ctx, _admin, key, val.Value, opts); rSet.IsErr() && !isExists(rSet.Error()) {
+			r.fail("can't copy key", source, key, rSet.Error())
+			return
+		}
+	}
+
+	// the writes go to the target from now on
+	if s := r.catalog.KeyServer(key); s.IsSome() && s.Unwrap() == source.Number() {
+		r.catalog.SetKeyServer(key, targets[0].Number())
+	}
+
+	if !r.holds(targets, source) {
+		if err := r.deleteKey(ctx, source, targets, key, val); err != nil {
+			r.fail("can't delete moved key", source, key, err)
+			return
+		}
+	}
+
+	r.moved.Add(1)
+}
+
+// deleteKey removes the moved key from source if it still has the copied value.
+// A value written to the source before the catalog was pointed at the targets is copied to them first,
+// unless a target got a value of its own since the copy.
+func (r *Rebalancer) deleteKey(ctx context.Context, source domains.Server, targets []domains.Server, key string, copied models.Value) error {
+	for i := 0; i < _attempts; i++ {
+		rGet := source.GetOne(ctx, _admin, key, models.GetOptions{Server: source.Number()})
+		if rGet.IsErr() {
+			if quorum.IsNotFound(rGet.Error()) {
+				return nil
+			}
+			return rGet.Error()
+		}
+
+		cur := rGet.Unwrap()
+		if cur == copied {
+			if rDel := source.DelOne(ctx, _admin, key, models.DeleteOptions{Server: source.Number()}); rDel.IsErr() && !quorum.IsNotFound(rDel.Error()) {
+				return rDel.Error()
+			}
+			return nil
+		}
+
+		for _, target := range targets {
+			rTarget := target.GetOne(ctx, _admin, key, models.GetOptions{Server: target.Number()})
+			if rTarget.IsErr() && !quorum.IsNotFound(rTarget.Error()) {
+				return rTarget.Error()
+			}
+
+			if rTarget.IsOk() && rTarget.Unwrap() != copied {
+				continue
+			}
+
+			opts := models.SetOptions{Server: target.Number(), Level: cur.Level, ReadOnly: cur.ReadOnly}
+			if rSet := target.SetOne(ctx, _admin, key, cur.Value, opts); rSet.IsErr() {
+				return rSet.Error()
+			}
+		}
+
+		copied = cur
+	}
+
+	return fmt.Errorf("the value keeps changing on s#%d", source.Number())
+}
+
+func (r *Rebalancer) moveObject(ctx context.Context, source domains.Server, root string, entries []models.Entry, throttle <-chan time.Time) {
+	shards := r.catalog.ObjectShards(root)
+
+	var targets []domains.Server
+	if shards.IsSome() {
+		targets = r.shardTargets(shards.Unwrap())
+	} else {
+		targets = r.objectTargets(root)
+	}
+
+	if len(targets) == 0 {
+		r.fail("no server to move object to", source, root, constants.ErrServerNotFound)
+		return
+	}
+	target := targets[0]
+
+	if err := r.copyEntries(ctx, target, entries, throttle); err != nil {
+		r.fail("can't copy object", source, root, err)
+		return
+	}
+
+	// the writes go to the target from now on
+	if s := r.catalog.ObjectServer(root); s.IsSome() && s.Unwrap() == source.Number() {
+		r.catalog.SetObjectServer(root, target.Number())
+	}
+
+	if shards.IsSome() {
+		moved := shards.Unwrap()
+		for i, number := range moved {
+			if number == source.Number() {
+				moved[i] = target.Number()
+			}
+		}
+
+		if rSet := r.catalog.SetObjectShards(root, moved); rSet.IsErr() {
+			r.fail("can't save moved shard", source, root, rSet.Error())
+			return
+		}
+	}
+
+	if err := r.deleteObject(ctx, source, target, root, entries, throttle); err != nil {
+		r.fail("can't delete moved object", source, root, err)
+		return
+	}
+
+	r.moved.Add(1)
+}
+
+// copyEntries copies the objects and their values to the target.
+// The parents come before their values and nested objects, as the scan returned them.
+func (r *Rebalancer) copyEntries(ctx context.Context, target domains.Server, entries []models.Entry, throttle <-chan time.Time) error {
+	for _, e := range entries {
+		var rCopy gost.ResultN
+
+		switch e.Kind {
+		case models.ObjectEntry:
+			rCopy = target.NewObject(ctx, _admin, e.Object, models.ObjectOptions{Server: target.Number(), Level: e.Value.Level})
+		case models.ObjectValueEntry:
+			select {
+			case <-throttle:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			rCopy = target.SetToObject(ctx, _admin, e.Object, e.Key, e.Value.Value, models.SetToObjectOptions{Server: target.Number(), ReadOnly: e.Value.ReadOnly})
+		}
+
+		if rCopy.IsErr() && !isExists(rCopy.Error()) {
+			return rCopy.Error()
+		}
+	}
+
+	return nil
+}
+
+// deleteObject removes the moved object from source if it still has the copied entries.
+// The entries changed on the source before the catalog was pointed at the target are copied first.
+func (r *Rebalancer) deleteObject(ctx context.Context, source, target domains.Server, root string, copied []models.Entry, throttle <-chan time.Time) error {
+	for i := 0; i < _attempts; i++ {
+		rJSON := source.ObjectToJSON(ctx, _admin, root, models.ObjectToJSONOptions{})
+		if rJSON.IsErr() {
+			if isNotFound(rJSON.Error()) {
+				return nil
+			}
+			return rJSON.Error()
+		}
+
+		cur, err := objectEntries(root, rJSON.Unwrap())
+		if err != nil {
+			return err
+		}
+
+		changed, removed := diffEntries(copied, cur)
+		if len(changed) == 0 && len(removed) == 0 {
+			if rDel := source.DeleteObject(ctx, _admin, root, models.DeleteObjectOptions{Server: source.Number()}); rDel.IsErr() && !isNotFound(rDel.Error()) {
+				return rDel.Error()
+			}
+			return nil
+		}
+
+		if err := r.copyEntries(ctx, target, changed, throttle); err != nil {
+			return err
+		}
+
+		for _, e := range removed {
+			rDel := target.ObjectDeleteKey(ctx, _admin, e.Object, e.Key, models.DeleteAttrOptions{Server: target.Number()})
+			if rDel.IsErr() && !isNotFound(rDel.Error()) {
+				return rDel.Error()
+			}
+		}
+
+		copied = cur
+	}
+
+	return fmt.Errorf("the object keeps changing on s#%d", source.Number())
+}
+
+func (r *Rebalancer) fail(msg string, source domains.Server, name string, err error) {
+	r.failed.Add(1)
+	r.logger.Warn(msg, zap.Int32("server", source.Number()), zap.String("name", name), zap.Error(err))
+}
+
+// isNotFound is quorum.IsNotFound for the objects and their attributes as well.
+func isNotFound(err *gost.ErrX) bool {
+	return quorum.IsNotFound(err) || (err != nil && slices.Contains(err.Messages(), constants.ErrObjectNotFound.Message()))
+}
+
+// isExists compares the root messages like quorum.IsNotFound, the local and the remote servers word it differently.
+func isExists(err *gost.ErrX) bool {
+	if err == nil {
+		return false
+	}
+
+	root := err.Messages()[0]
+	return root == constants.ErrAlreadyExists.Message() || root == itisadb.ErrUniqueConstraint.Message()
+}
@@ -0,0 +1,250 @@
+package rebalancer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"itisadb/config"
+	"itisadb/internal/clustertest"
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+	"itisadb/internal/service/catalog"
+
+	"go.uber.org/zap"
+)
+
+// level returns the level of the object on the server.
+func level(s *clustertest.Server, object string) models.Level {
+	l, _ := s.ObjectLevel(object)
+	return l
+}
+
+// server returns the server of the cluster by its number.
+func server(c *clustertest.Cluster, number int32) *clustertest.Server {
+	s, _ := c.GetServer(number)
+	return s.(*clustertest.Server)
+}
+
+func newRebalancer(t *testing.T, cfg config.BalancerConfig, c *clustertest.Cluster) (*Rebalancer, *catalog.Catalog) {
+	t.Helper()
+
+	cfg.CatalogDirectory = t.TempDir()
+	cfg.RebalanceRate = 1_000_000
+
+	cat, err := catalog.New(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cat.Close() })
+
+	return New(cfg, c, cat, zap.NewNop()), cat
+}
+
+func TestJoin(t *testing.T) {
+	const n = 300
+
+	s1, s2, s3 := clustertest.NewServer(1), clustertest.NewServer(2), clustertest.NewServer(3)
+	c := clustertest.NewHashCluster(s1, s2)
+
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		server(c, c.Owner(key)).Put(key, models.Value{Value: key, Level: constants.RestrictedLevel})
+	}
+
+	r, _ := newRebalancer(t, config.BalancerConfig{Placement: config.HashPlacement}, c)
+
+	c.Add(s3)
+	r.Rebalance("s#3 joined")
+	r.Wait()
+
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owner := c.Owner(key)
+
+		for _, s := range []*clustertest.Server{s1, s2, s3} {
+			if got := s.Has(key); got != (s.Number() == owner) {
+				t.Fatalf("%s: on s#%d %v, owner is s#%d", key, s.Number(), got, owner)
+			}
+		}
+
+		if v, _ := server(c, owner).Value(key); v.Value != key || v.Level != constants.RestrictedLevel {
+			t.Fatalf("%s: got %+v", key, v)
+		}
+	}
+
+	st := r.Status()
+	if st.Running || st.Reason != "s#3 joined" || st.FinishedAt.IsZero() {
+		t.Errorf("status: %+v", st)
+	}
+
+	if st.Scanned < n || st.Moved != uint64(s3.Len()) || st.Moved == 0 || st.Failed != 0 {
+		t.Errorf("status: %+v, s#3 got %d keys", st, s3.Len())
+	}
+}
+
+func TestLeave(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2 := clustertest.NewServer(1), clustertest.NewServer(2)
+	c := clustertest.NewCluster(s1, s2)
+
+	r, cat := newRebalancer(t, config.BalancerConfig{}, c)
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		s2.SetOne(ctx, _admin, key, key, models.SetOptions{ReadOnly: true})
+		cat.SetKeyServer(key, 2)
+	}
+
+	s2.NewObject(ctx, _admin, "obj", models.ObjectOptions{Level: constants.SecretLevel})
+	s2.SetToObject(ctx, _admin, "obj", "a", "1", models.SetToObjectOptions{})
+	s2.NewObject(ctx, _admin, "obj.inner", models.ObjectOptions{})
+	s2.SetToObject(ctx, _admin, "obj.inner", "b", "2", models.SetToObjectOptions{})
+	cat.SetObjectServer("obj", 2)
+
+	// the key written to the staying server after the leave started is newer
+	s1.SetOne(ctx, _admin, "key0", "newer", models.SetOptions{})
+
+	r.Remove(2)
+	r.Wait()
+
+	if c.Connected(2) {
+		t.Fatal("s#2 is still connected")
+	}
+
+	if st := r.Status(); st.Running || len(st.Leaving) != 0 || st.Moved != 11 || st.Failed != 0 {
+		t.Errorf("status: %+v", st)
+	}
+
+	if s2.Len() != 0 || len(s2.Objects()) != 0 {
+		t.Errorf("s#2 kept %d keys and %d objects", s2.Len(), len(s2.Objects()))
+	}
+
+	for i := 1; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if v, _ := s1.Value(key); v.Value != key || !v.ReadOnly {
+			t.Errorf("%s: got %+v", key, v)
+		}
+
+		if s := cat.KeyServer(key); s.IsNone() || s.Unwrap() != 1 {
+			t.Errorf("%s is routed to %v", key, s)
+		}
+	}
+
+	if v, _ := s1.Value("key0"); v.Value != "newer" {
+		t.Errorf("key0: got %+v, the newer value was overwritten", v)
+	}
+
+	if level(s1, "obj") != constants.SecretLevel || s1.Fields("obj")["a"] != "1" || s1.Fields("obj.inner")["b"] != "2" {
+		t.Errorf("objects: %v, obj: %v, obj.inner: %v", s1.Objects(), s1.Fields("obj"), s1.Fields("obj.inner"))
+	}
+
+	if s := cat.ObjectServer("obj"); s.IsNone() || s.Unwrap() != 1 {
+		t.Errorf("obj is routed to %v", s)
+	}
+}
+
+func TestWriteDuringMove(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2 := clustertest.NewServer(1), clustertest.NewServer(2)
+	c := clustertest.NewCluster(s1, s2)
+
+	r, cat := newRebalancer(t, config.BalancerConfig{}, c)
+
+	s2.SetOne(ctx, _admin, "key", "v1", models.SetOptions{})
+	cat.SetKeyServer("key", 2)
+
+	s2.NewObject(ctx, _admin, "obj", models.ObjectOptions{})
+	s2.SetToObject(ctx, _admin, "obj", "a", "1", models.SetToObjectOptions{})
+	s2.NewObject(ctx, _admin, "obj.inner", models.ObjectOptions{})
+	s2.SetToObject(ctx, _admin, "obj.inner", "b", "2", models.SetToObjectOptions{})
+	cat.SetObjectServer("obj", 2)
+
+	// the balancer still writes to s#2 while the entries are copied to s#1
+	var once, onceObj sync.Once
+	s1.OnWrite(func(name string) {
+		switch name {
+		case "key":
+			once.Do(func() { s2.SetOne(ctx, _admin, "key", "v2", models.SetOptions{}) })
+		case "obj", "obj.inner":
+			onceObj.Do(func() {
+				s2.SetToObject(ctx, _admin, "obj", "a", "changed", models.SetToObjectOptions{})
+				s2.SetToObject(ctx, _admin, "obj.inner", "c", "3", models.SetToObjectOptions{})
+			})
+		}
+	})
+
+	r.Remove(2)
+	r.Wait()
+
+	if c.Connected(2) {
+		t.Fatalf("s#2 is still connected, status: %+v", r.Status())
+	}
+
+	if v, _ := s1.Value("key"); v.Value != "v2" {
+		t.Errorf("key: got %+v, the write during the move was lost", v)
+	}
+
+	if got := s1.Fields("obj")["a"]; got != "changed" {
+		t.Errorf("obj.a: got %q", got)
+	}
+
+	if got := s1.Fields("obj.inner"); got["b"] != "2" || got["c"] != "3" {
+		t.Errorf("obj.inner: got %v", got)
+	}
+}
+
+func TestShards(t *testing.T) {
+	ctx := context.Background()
+
+	s1, s2, s3, s4 := clustertest.NewServer(1), clustertest.NewServer(2), clustertest.NewServer(3), clustertest.NewServer(4)
+	c := clustertest.NewHashCluster(s1, s2, s3)
+
+	r, cat := newRebalancer(t, config.BalancerConfig{Placement: config.HashPlacement}, c)
+
+	for _, s := range []*clustertest.Server{s2, s3} {
+		s.NewObject(ctx, _admin, "big", models.ObjectOptions{Level: constants.RestrictedLevel})
+		s.SetToObject(ctx, _admin, "big", fmt.Sprintf("key%d", s.Number()), "v", models.SetToObjectOptions{})
+	}
+	s2.NewObject(ctx, _admin, "big.inner", models.ObjectOptions{})
+	s2.SetToObject(ctx, _admin, "big.inner", "a", "1", models.SetToObjectOptions{})
+	cat.SetObjectShards("big", []int32{2, 3})
+	cat.SetObjectServer("big", 2)
+
+	// the shards are not gathered on the owner of the object
+	c.Add(s4)
+	r.Rebalance("s#4 joined")
+	r.Wait()
+
+	if s2.Fields("big")["key2"] != "v" || s3.Fields("big")["key3"] != "v" || s2.Fields("big.inner")["a"] != "1" {
+		t.Fatalf("the shards moved: s#1 %v, s#2 %v, s#3 %v, s#4 %v", s1.Objects(), s2.Objects(), s3.Objects(), s4.Objects())
+	}
+
+	// the shard of the leaving server goes to a server without a shard of the object
+	r.Remove(2)
+	r.Wait()
+
+	if c.Connected(2) {
+		t.Fatal("s#2 is still connected")
+	}
+
+	if level(s1, "big") != constants.RestrictedLevel || s1.Fields("big")["key2"] != "v" || s1.Fields("big.inner")["a"] != "1" {
+		t.Errorf("s#1: %v, big: %v, big.inner: %v", s1.Objects(), s1.Fields("big"), s1.Fields("big.inner"))
+	}
+
+	if s3.Fields("big")["key3"] != "v" {
+		t.Errorf("s#3 lost its shard: %v", s3.Fields("big"))
+	}
+
+	if s := cat.ObjectShards("big"); s.IsNone() || !slices.Equal(s.Unwrap(), []int32{1, 3}) {
+		t.Errorf("big has shards %v", s)
+	}
+
+	if s := cat.ObjectServer("big"); s.IsNone() || s.Unwrap() != 1 {
+		t.Errorf("big is routed to %v", s)
+	}
+}
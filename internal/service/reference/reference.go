@@ -0,0 +1,245 @@
+// Package reference attaches objects across servers.
+//
+// A server attaches only its own objects. When dst and src live on different servers,
+// the balancer sets an attribute of dst, named like src, to a reference to src.
+// The balancer follows the references: on the paths of GetFromObject, SetToObject and Size,
+// and in the JSON of ObjectToJSON, where a reference is replaced by the object it refers to.
+package reference
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"itisadb/internal/constants"
+)
+
+// _prefix marks the value of a reference, the rest of the value is the name of the object.
+const _prefix = "\x00ref:"
+
+// _maxDepth bounds the references followed for one request.
+const _maxDepth = 64
+
+// Encode returns the value of the attribute that refers to the object.
+func Encode(object string) string {
+	return _prefix + object
+}
+
+// Decode returns the object the value refers to, ok is false for a plain value.
+func Decode(value string) (object string, ok bool) {
+	return strings.CutPrefix(value, _prefix)
+}
+
+// Name returns the name of the attribute the object is attached as, the last part of its path.
+func Name(object string) string {
+	return object[strings.LastIndex(object, constants.ObjectSeparator)+1:]
+}
+
+// LookupFunc returns the object the attribute key of object refers to,
+// ok is false when the attribute is not a reference or does not exist.
+type LookupFunc func(object, key string) (target string, ok bool, err error)
+
+// Resolve returns the object the path leads to, following the references on its way.
+// A path that goes through no reference is returned as it is.
+func Resolve(path string, lookup LookupFunc) (string, error) {
+	seen := map[string]bool{path: true}
+
+	for depth := 0; ; depth++ {
+		next, followed, err := follow(path, lookup)
+		if err != nil || !followed {
+			return next, err
+		}
+
+		if seen[next] || depth >= _maxDepth {
+			return "", constants.ErrCircularAttachment.ExtendMsg(next)
+		}
+
+		seen[next] = true
+		path = next
+	}
+}
+
+// follow replaces the first reference on the path with the object it refers to.
+func follow(path string, lookup LookupFunc) (string, bool, error) {
+	parts := strings.Split(path, constants.ObjectSeparator)
+
+	for i := 1; i < len(parts); i++ {
+		parent := strings.Join(parts[:i], constants.ObjectSeparator)
+
+		target, ok, err := lookup(parent, parts[i])
+		if err != nil {
+			return "", false, err
+		}
+
+		if ok {
+			rest := append([]string{target}, parts[i+1:]...)
+			return strings.Join(rest, constants.ObjectSeparator), true, nil
+		}
+	}
+
+	return path, false, nil
+}
+
+// FetchFunc returns the JSON of the object as its server stores it, with the references in it.
+// It returns ErrDangling when the object does not exist.
+type FetchFunc func(object string) (string, error)
+
+// ErrDangling is the error of a reference to an object that was deleted. Such a reference
+// is left out of the JSON, an attachment of the local server goes away with the object too.
+var ErrDangling = errors.New("the object the reference refers to does not exist")
+
+// Targets returns the objects the JSON of an object refers to, the nested objects included.
+func Targets(data string) ([]string, error) {
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(data), &obj); err != nil {
+		return nil, fmt.Errorf("invalid object JSON: %w", err)
+	}
+
+	var targets []string
+	walk(obj, func(_ map[string]any, target string) bool {
+		targets = append(targets, target)
+		return true
+	})
+
+	return targets, nil
+}
+
+// Reaches reports whether the object to is reachable from the object from:
+// it is from, or is inside an object from refers to, directly or through other references.
+// Attaching from to to would make a cycle then.
+func Reaches(from, to string, fetch FetchFunc) (bool, error) {
+	seen := make(map[string]bool)
+	queue := []string{from}
+
+	for len(queue) > 0 {
+		object := queue[0]
+		queue = queue[1:]
+
+		if object == to || strings.HasPrefix(to, object+constants.ObjectSeparator) {
+			return true, nil
+		}
+
+		if seen[object] {
+			continue
+		}
+		seen[object] = true
+
+		data, err := fetch(object)
+		if errors.Is(err, ErrDangling) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		targets, err := Targets(data)
+		if err != nil {
+			return false, err
+		}
+
+		queue = append(queue, targets...)
+	}
+
+	return false, nil
+}
+
+// Expand replaces the references in the JSON of an object with the objects they refer to.
+// The JSON without references is returned as it is.
+func Expand(data string, fetch FetchFunc) (string, error) {
+	if !strings.Contains(data, jsonPrefix()) {
+		return data, nil
+	}
+
+	obj, err := expand(data, fetch, nil)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.MarshalIndent(obj, "", "\t")
+	if err != nil {
+		return "", constants.ErrInternal.ExtendMsg(err.Error())
+	}
+
+	return string(b), nil
+}
+
+// expand parses the JSON and replaces its references, chain holds the objects being expanded.
+func expand(data string, fetch FetchFunc, chain []string) (map[string]any, error) {
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(data), &obj); err != nil {
+		return nil, fmt.Errorf("invalid object JSON: %w", err)
+	}
+
+	var errWalk error
+	walk(obj, func(entry map[string]any, target string) bool {
+		if errWalk != nil {
+			return true
+		}
+
+		if slices.Contains(chain, target) || len(chain) >= _maxDepth {
+			errWalk = constants.ErrCircularAttachment.ExtendMsg(target)
+			return true
+		}
+
+		nested, err := fetch(target)
+		if errors.Is(err, ErrDangling) {
+			return false
+		}
+		if err != nil {
+			errWalk = err
+			return true
+		}
+
+		expanded, err := expand(nested, fetch, append(chain, target))
+		if err != nil {
+			errWalk = err
+			return true
+		}
+
+		// the entry becomes the object, under the name it is attached as
+		name := entry["key"]
+		clear(entry)
+		for k, v := range expanded {
+			entry[k] = v
+		}
+		entry["name"] = name
+
+		return true
+	})
+
+	return obj, errWalk
+}
+
+// walk calls f for every reference in the values of the object and its nested objects,
+// the references f returns false for are removed from the values.
+func walk(obj map[string]any, f func(entry map[string]any, target string) bool) {
+	values, ok := obj["values"].([]any)
+	if !ok {
+		return
+	}
+
+	kept := values[:0]
+	for _, v := range values {
+		if entry, ok := v.(map[string]any); ok {
+			value, _ := entry["value"].(string)
+
+			if _, nested := entry["values"]; nested {
+				walk(entry, f)
+			} else if target, ok := Decode(value); ok && !f(entry, target) {
+				continue
+			}
+		}
+
+		kept = append(kept, v)
+	}
+
+	obj["values"] = kept
+}
+
+// jsonPrefix is the prefix as it appears in the JSON, the control character is escaped there.
+func jsonPrefix() string {
+	b, _ := json.Marshal(_prefix)
+	return strings.Trim(string(b), `"`)
+}
@@ -0,0 +1,179 @@
+package reference
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// cluster keeps the JSON of the objects of two servers, the way their servers return it.
+type cluster map[string]string
+
+func (c cluster) fetch(object string) (string, error) {
+	data, ok := c[object]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrDangling, object)
+	}
+
+	return data, nil
+}
+
+func (c cluster) lookup(object, key string) (string, bool, error) {
+	data, ok := c[object]
+	if !ok {
+		return "", false, nil
+	}
+
+	var obj struct {
+		Values []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"values"`
+	}
+	if err := json.Unmarshal([]byte(data), &obj); err != nil {
+		return "", false, err
+	}
+
+	for _, v := range obj.Values {
+		if v.Key == key {
+			target, ok := Decode(v.Value)
+			return target, ok, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+func object(name string, values ...string) string {
+	entries := make([]string, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		value, _ := json.Marshal(values[i+1])
+		entries = append(entries, fmt.Sprintf(`{"key":%q,"value":%s,"read_only":false}`, values[i], value))
+	}
+
+	return fmt.Sprintf(`{"name":%q,"level":"Default","attached_to":null,"values":[%s]}`, name, strings.Join(entries, ","))
+}
+
+func TestResolve(t *testing.T) {
+	c := cluster{
+		"user":          object("user", "name", "bob", "address", Encode("addresses.bob")),
+		"addresses.bob": object("bob", "city", "Paris", "geo", Encode("geo")),
+		"geo":           object("geo", "lat", "48.8"),
+	}
+
+	tests := map[string]string{
+		"user":             "user",
+		"user.address":     "addresses.bob",
+		"user.address.geo": "geo",
+		"user.missing":     "user.missing",
+	}
+
+	for path, want := range tests {
+		got, err := Resolve(path, c.lookup)
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %q", path, got, err, want)
+		}
+	}
+
+	// a path through a cycle of objects is finite, a reference to itself is not
+	c["geo"] = object("geo", "back", Encode("user"))
+	if got, err := Resolve("user.address.geo.back.name", c.lookup); err != nil || got != "user.name" {
+		t.Errorf("got %q, %v, want user.name", got, err)
+	}
+
+	c["loop"] = object("loop", "self", Encode("loop.self"))
+	if _, err := Resolve("loop.self.key", c.lookup); err == nil {
+		t.Error("no error for a reference to itself")
+	}
+}
+
+func TestExpand(t *testing.T) {
+	c := cluster{
+		"user":          object("user", "name", "bob", "address", Encode("addresses.bob"), "old", Encode("deleted")),
+		"addresses.bob": object("bob", "city", "Paris"),
+	}
+
+	if data, err := Expand(c["addresses.bob"], c.fetch); err != nil || data != c["addresses.bob"] {
+		t.Errorf("JSON without references changed: %q, %v", data, err)
+	}
+
+	data, err := Expand(c["user"], c.fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Values []map[string]any `json:"values"`
+	}
+	if err := json.Unmarshal([]byte(data), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	// the dangling reference is left out, the other one is the object it refers to
+	if len(got.Values) != 2 {
+		t.Fatalf("got %d values, want 2: %s", len(got.Values), data)
+	}
+
+	address := got.Values[1]
+	if address["name"] != "address" || address["values"] == nil {
+		t.Fatalf("the reference is not expanded: %s", data)
+	}
+
+	if !strings.Contains(data, "Paris") || strings.Contains(data, jsonPrefix()) {
+		t.Errorf("got %s", data)
+	}
+
+	c["addresses.bob"] = object("bob", "owner", Encode("user"))
+	if _, err := Expand(c["user"], c.fetch); err == nil {
+		t.Error("no error for a cycle")
+	}
+}
+
+func TestReaches(t *testing.T) {
+	c := cluster{
+		"a":   object("a", "b", Encode("b.x")),
+		"b.x": object("x", "c", Encode("c")),
+		"c":   object("c", "gone", Encode("deleted")),
+		"d":   object("d"),
+	}
+
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"a", "a", true},
+		{"a", "c", true},
+		{"a", "c.inner", true},
+		{"a", "b", false},
+		{"c", "a", false},
+		{"d", "a", false},
+	}
+
+	for _, tt := range tests {
+		got, err := Reaches(tt.from, tt.to, c.fetch)
+		if err != nil || got != tt.want {
+			t.Errorf("%s -> %s: got %v, %v, want %v", tt.from, tt.to, got, err, tt.want)
+		}
+	}
+
+	failing := func(string) (string, error) { return "", errors.New("unavailable") }
+	if _, err := Reaches("a", "b", failing); err == nil {
+		t.Error("no error when the object can't be fetched")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	if target, ok := Decode(Encode("a.b")); !ok || target != "a.b" {
+		t.Errorf("got %q, %v", target, ok)
+	}
+
+	if _, ok := Decode("a.b"); ok {
+		t.Error("a plain value is a reference")
+	}
+
+	if Name("a.b.c") != "c" || Name("a") != "a" {
+		t.Errorf("got %q and %q", Name("a.b.c"), Name("a"))
+	}
+}
@@ -0,0 +1,236 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"itisadb/internal/service/backup"
+	transactionlogger "itisadb/internal/service/transaction-logger"
+	"itisadb/pkg/api/cluster"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// _positionName is the file next to the log of the replica with its position.
+const _positionName = "replica.json"
+
+var ErrNotReplicaLog = errors.New("transaction log was not written by a replica, start the replica with an empty one")
+
+// Log keeps the events applied by the replica, so it resumes from them after a restart.
+type Log interface {
+	Head() uint64
+	Write(e transactionlogger.Event) gost.ResultN
+}
+
+// position ties the log of the replica to the log of the primary:
+// the first Events events of the replica are the snapshot of the primary at Seq.
+type position struct {
+	Seq    uint64 `json:"seq"`
+	Events uint64 `json:"events"`
+}
+
+// Bootstrap prepares the transaction log of the replica in dir before it is opened.
+// An empty log is seeded with a snapshot of the primary, so the replica tails the primary
+// from the sequence number of the snapshot instead of replaying its whole log.
+// The primary is asked again every ReconnectInterval until ctx is done.
+func (r *Replica) Bootstrap(ctx context.Context, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	files, err := transactionlogger.Files(dir)
+	if err != nil {
+		return err
+	}
+
+	pos, err := readPosition(dir)
+	switch {
+	case err != nil:
+		return err
+	case len(files) > 0 && pos.IsNone():
+		return fmt.Errorf("%w: %s", ErrNotReplicaLog, dir)
+	case len(files) > 0:
+		r.position = pos
+		return nil
+	}
+
+	for {
+		err := r.seed(ctx, dir)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		r.logger.Warn("can't bootstrap from the primary, retrying", zap.String("primary", r.cfg.Primary), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.cfg.ReconnectInterval):
+		}
+	}
+}
+
+// Resume makes the replica write the applied events to log and continue after the last of them.
+// log must be opened in the directory prepared by Bootstrap and restored.
+func (r *Replica) Resume(log Log) {
+	r.log = gost.Some(log)
+
+	if r.position.IsSome() {
+		pos := r.position.Unwrap()
+		r.applied.Store(pos.Seq + log.Head() - pos.Events)
+	}
+}
+
+// seed writes the snapshot of the primary as the first file of the log in dir.
+// The position is saved first, so a seed that was cut short is made again.
+func (r *Replica) seed(ctx context.Context, dir string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	conn, ctx, err := r.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	a, err := r.snapshot(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	pos := position{Seq: a.Manifest.Seq, Events: a.Manifest.Events}
+	if err := writePosition(dir, pos); err != nil {
+		return err
+	}
+
+	if err := transactionlogger.Seed(dir, a.Events); err != nil {
+		return fmt.Errorf("can't seed the log: %w", err)
+	}
+
+	r.position = gost.Some(pos)
+
+	r.logger.Info("replica bootstrapped from the snapshot of the primary",
+		zap.Uint64("seq", pos.Seq),
+		zap.Uint64("events", pos.Events),
+	)
+
+	return nil
+}
+
+// load applies the snapshot of the primary to the storage of a replica without a log
+// and returns the sequence number to tail the primary from.
+func (r *Replica) load(ctx context.Context, conn *grpc.ClientConn) (uint64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	a, err := r.snapshot(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	defer a.Close()
+
+	err = a.Events(func(e transactionlogger.Event) error {
+		return transactionlogger.ApplyEvent(r.restorer, r.security, e)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("can't apply the snapshot: %w", err)
+	}
+
+	return a.Manifest.Seq, nil
+}
+
+// snapshot opens the backup archive the primary streams.
+func (r *Replica) snapshot(ctx context.Context, conn *grpc.ClientConn) (*backup.Archive, error) {
+	stream, err := cluster.NewClusterClient(conn).Backup(ctx, &cluster.BackupRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("can't get the snapshot: %w", err)
+	}
+
+	a, err := backup.Open(&chunks{stream: stream}, r.key)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the snapshot: %w", err)
+	}
+
+	return a, nil
+}
+
+// chunks reads the archive sent by the Backup stream.
+type chunks struct {
+	stream cluster.Cluster_BackupClient
+	buf    []byte
+}
+
+func (c *chunks) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		chunk, err := c.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+
+		c.buf = chunk.Data
+	}
+
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+
+	return n, nil
+}
+
+func readPosition(dir string) (pos gost.Option[position], err error) {
+	data, err := os.ReadFile(filepath.Join(dir, _positionName))
+	if errors.Is(err, os.ErrNotExist) {
+		return pos.None(), nil
+	}
+	if err != nil {
+		return pos, err
+	}
+
+	var p position
+	if err := json.Unmarshal(data, &p); err != nil {
+		return pos, fmt.Errorf("invalid replica position %s: %w", dir, err)
+	}
+
+	return pos.Some(p), nil
+}
+
+// writePosition replaces the position file, it is never left half written.
+func writePosition(dir string, pos position) error {
+	data, err := json.Marshal(pos)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".position-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, _positionName))
+}
@@ -0,0 +1,217 @@
+package replication
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	transactionlogger "itisadb/internal/service/transaction-logger"
+	"itisadb/pkg/api/cluster"
+
+	"github.com/egorgasay/gost"
+	api "github.com/egorgasay/itisadb-shared-proto/go"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const _defaultReconnectInterval = 5 * time.Second
+
+var (
+	_current     atomic.Pointer[Replica]
+	_applyErrors = expvar.NewInt("replication_apply_errors")
+)
+
+func init() {
+	expvar.Publish("replication", expvar.Func(func() any {
+		r := _current.Load()
+		if r == nil {
+			return nil
+		}
+
+		st := r.Status()
+		return map[string]any{
+			"connected":   st.Connected,
+			"applied":     st.Applied,
+			"head":        st.Head,
+			"lag_events":  st.LagEvents(),
+			"lag_seconds": st.LagTime().Seconds(),
+		}
+	}))
+}
+
+// Replica keeps the local storage in sync with the transaction log of a primary node.
+// It bootstraps from a snapshot of the primary and then tails the events written after it.
+type Replica struct {
+	cfg      config.ReplicationConfig
+	restorer domains.Restorer
+	security domains.SecurityService
+	key      string
+	logger   *zap.Logger
+	opts     []grpc.DialOption
+
+	// log and position are set before Start, see Bootstrap and Resume.
+	log      gost.Option[Log]
+	position gost.Option[position]
+
+	connected atomic.Bool
+	applied   atomic.Uint64
+	head      atomic.Uint64
+	lastEvent atomic.Int64
+}
+
+// NewReplica returns the replica that dials the primary with opts, the connections of the balancer use the same ones.
+// The primary must have the same encryption key, its snapshots are checked against it.
+func NewReplica(
+	cfg config.ReplicationConfig,
+	restorer domains.Restorer,
+	security domains.SecurityService,
+	encryption config.EncryptionConfig,
+	logger *zap.Logger,
+	opts ...grpc.DialOption,
+) *Replica {
+	if cfg.ReconnectInterval == 0 {
+		cfg.ReconnectInterval = _defaultReconnectInterval
+	}
+
+	r := &Replica{
+		cfg:      cfg,
+		restorer: restorer,
+		security: security,
+		key:      encryption.Key,
+		logger:   logger,
+		opts:     opts,
+	}
+
+	_current.Store(r)
+
+	return r
+}
+
+func (r *Replica) Start(ctx context.Context) {
+	r.logger.Info("starting replication", zap.String("primary", r.cfg.Primary))
+
+	for {
+		err := r.replicate(ctx)
+		r.connected.Store(false)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		r.logger.Warn("replication stopped, reconnecting",
+			zap.String("primary", r.cfg.Primary),
+			zap.Uint64("applied", r.applied.Load()),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.cfg.ReconnectInterval):
+		}
+	}
+}
+
+// dial connects to the primary and returns ctx with the token for it.
+func (r *Replica) dial(ctx context.Context) (*grpc.ClientConn, context.Context, error) {
+	conn, err := grpc.DialContext(ctx, r.cfg.Primary, r.opts...)
+	if err != nil {
+		return nil, ctx, fmt.Errorf("can't dial primary: %w", err)
+	}
+
+	resp, err := api.NewItisaDBClient(conn).Authenticate(ctx, &api.AuthRequest{
+		Login:    r.cfg.Login,
+		Password: r.cfg.Password,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, ctx, fmt.Errorf("can't authenticate on primary: %w", err)
+	}
+
+	return conn, metadata.AppendToOutgoingContext(ctx, "token", resp.Token), nil
+}
+
+func (r *Replica) replicate(ctx context.Context) error {
+	conn, ctx, err := r.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// without a log the data is lost with the process, the snapshot is loaded on every start
+	if r.log.IsNone() && r.applied.Load() == 0 {
+		seq, err := r.load(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		r.applied.Store(seq)
+	}
+
+	stream, err := cluster.NewClusterClient(conn).Replicate(ctx, &cluster.ReplicateRequest{From: r.applied.Load()})
+	if err != nil {
+		return fmt.Errorf("can't start replication: %w", err)
+	}
+
+	r.connected.Store(true)
+
+	for {
+		e, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		r.head.Store(e.Head)
+
+		if e.Seq <= r.applied.Load() {
+			continue
+		}
+
+		// an event that can't be applied is not skipped, the replica would miss the write for good.
+		// Start reconnects and the primary sends it again from the last applied one.
+		ev := transactionlogger.Event{
+			EventType: transactionlogger.EventType(e.Type),
+			Name:      string(e.Name),
+			Value:     string(e.Value),
+			Metadata:  string(e.Metadata),
+		}
+
+		if err := transactionlogger.ApplyEvent(r.restorer, r.security, ev); err != nil {
+			_applyErrors.Add(1)
+			return fmt.Errorf("can't apply replicated event %d: %w", e.Seq, err)
+		}
+
+		if r.log.IsSome() {
+			if rLog := r.log.Unwrap().Write(ev); rLog.IsErr() {
+				return fmt.Errorf("can't log replicated event %d: %w", e.Seq, rLog.Error())
+			}
+		}
+
+		r.applied.Store(e.Seq)
+		if e.Time != 0 {
+			r.lastEvent.Store(e.Time)
+		}
+	}
+}
+
+func (r *Replica) Status() models.ReplicationStatus {
+	st := models.ReplicationStatus{
+		Role:      config.ReplicaRole,
+		Primary:   r.cfg.Primary,
+		Connected: r.connected.Load(),
+		Applied:   r.applied.Load(),
+		Head:      r.head.Load(),
+	}
+
+	if t := r.lastEvent.Load(); t != 0 {
+		st.LastEvent = time.Unix(0, t)
+	}
+
+	return st
+}
@@ -0,0 +1,458 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/clustertest"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/backup"
+	"itisadb/internal/service/security"
+	transactionlogger "itisadb/internal/service/transaction-logger"
+	"itisadb/internal/storage"
+	"itisadb/pkg/api/cluster"
+
+	"github.com/egorgasay/gost"
+	api "github.com/egorgasay/itisadb-shared-proto/go"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+var _encryption = config.EncryptionConfig{Key: "PLEASE CHANGE ME"}
+
+type testPrimary struct {
+	api.UnimplementedItisaDBServer
+	cluster.UnimplementedClusterServer
+	tl     *transactionlogger.TransactionLogger
+	backup *backup.Service
+
+	// from is where the replica asked to be replicated from the last time
+	from atomic.Uint64
+}
+
+func newTestPrimary(t *testing.T, tl *transactionlogger.TransactionLogger, sec domains.SecurityService) *testPrimary {
+	return &testPrimary{tl: tl, backup: newBackup(t, gost.Some[domains.ReplicationSource](tl), sec)}
+}
+
+func (p *testPrimary) Authenticate(context.Context, *api.AuthRequest) (*api.AuthResponse, error) {
+	return &api.AuthResponse{Token: "token"}, nil
+}
+
+func (p *testPrimary) Backup(_ *cluster.BackupRequest, stream cluster.Cluster_BackupServer) error {
+	return sendBackup(p.backup, stream)
+}
+
+func (p *testPrimary) Replicate(r *cluster.ReplicateRequest, stream cluster.Cluster_ReplicateServer) error {
+	p.from.Store(r.From)
+
+	return p.tl.Stream(stream.Context(), r.From, func(e models.LogEvent) error {
+		return stream.Send(&cluster.ReplicationEvent{
+			Seq:      e.Seq,
+			Head:     p.tl.Head(),
+			Type:     e.Type,
+			Name:     []byte(e.Name),
+			Value:    []byte(e.Value),
+			Metadata: []byte(e.Metadata),
+		})
+	})
+}
+
+// newBackup returns the backups of the primary, the log is the source of them when there is one.
+func newBackup(t *testing.T, source gost.Option[domains.ReplicationSource], sec domains.SecurityService) *backup.Service {
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := backup.New(store, source, sec, _encryption, zap.NewNop())
+	t.Cleanup(svc.Close)
+
+	return svc
+}
+
+// sendBackup sends the archive in one chunk.
+func sendBackup(svc *backup.Service, stream cluster.Cluster_BackupServer) error {
+	var archive bytes.Buffer
+	if _, err := svc.Backup(stream.Context(), &archive); err != nil {
+		return err
+	}
+
+	return stream.Send(&cluster.BackupChunk{Data: archive.Bytes()})
+}
+
+func TestReplica(t *testing.T) {
+	sec := security.NewSecurityService(config.SecurityConfig{}, _encryption)
+
+	tl, err := transactionlogger.New(config.TransactionLoggerConfig{BackupDirectory: t.TempDir()}, zap.NewNop(), sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.Run()
+	defer tl.Stop()
+
+	// written before the replica connects, so they come from the snapshot
+	for i := 0; i < 10; i++ {
+		tl.WriteSet(fmt.Sprint("old", i), fmt.Sprint("value", i), models.SetOptions{})
+	}
+	tl.WriteSet("secret", "password", models.SetOptions{Level: 2, Encrypt: true})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer()
+	primary := newTestPrimary(t, tl, sec)
+	api.RegisterItisaDBServer(srv, primary)
+	cluster.RegisterClusterServer(srv, primary)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := NewReplica(config.ReplicationConfig{Primary: lis.Addr().String()}, store, sec, _encryption, zap.NewNop(), clustertest.Insecure())
+	go r.Start(ctx)
+
+	waitFor(t, func() bool { return r.Status().Applied == 11 })
+
+	// written after the replica has caught up, so they are tailed live
+	for i := 0; i < 10; i++ {
+		tl.WriteSet(fmt.Sprint("new", i), fmt.Sprint("value", i), models.SetOptions{})
+	}
+	tl.WriteDelete("old0")
+
+	waitFor(t, func() bool { return r.Status().Applied == 22 })
+
+	if from := primary.from.Load(); from != 11 {
+		t.Fatalf("the replica tails the primary from %d, not from the snapshot", from)
+	}
+
+	for _, key := range []string{"old1", "old9", "new0", "new9"} {
+		if store.Get(key).IsNone() {
+			t.Errorf("key %s was not replicated", key)
+		}
+	}
+
+	if store.Get("old0").IsSome() {
+		t.Error("deleted key old0 is still present on the replica")
+	}
+
+	if v := store.Get("secret"); v.IsNone() || v.Unwrap().Value != "password" {
+		t.Errorf("encrypted value was not replicated correctly: %v", v)
+	}
+
+	if st := r.Status(); !st.Connected || st.LagEvents() != 0 {
+		t.Errorf("unexpected status after catching up: %+v", st)
+	}
+}
+
+func TestReplicaObjects(t *testing.T) {
+	sec := security.NewSecurityService(config.SecurityConfig{}, _encryption)
+
+	tl, err := transactionlogger.New(config.TransactionLoggerConfig{BackupDirectory: t.TempDir()}, zap.NewNop(), sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.Run()
+	defer tl.Stop()
+
+	tl.WriteCreateObject("user", models.ObjectInfo{Level: constants.DefaultLevel})
+	tl.WriteSetToObject("user", "name", "Ann", models.SetToObjectOptions{})
+	tl.WriteSetToObject("user", "city", "Paris", models.SetToObjectOptions{})
+	tl.WriteDeleteAttr("user", "city")
+	tl.WriteSet("after", "value", models.SetOptions{})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer()
+	primary := newTestPrimary(t, tl, sec)
+	api.RegisterItisaDBServer(srv, primary)
+	cluster.RegisterClusterServer(srv, primary)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := NewReplica(config.ReplicationConfig{Primary: lis.Addr().String()}, store, sec, _encryption, zap.NewNop(), clustertest.Insecure())
+	go r.Start(ctx)
+
+	// the events after the deletion of the attribute are applied too
+	waitFor(t, func() bool { return r.Status().Applied == 5 })
+
+	if v := store.GetFromObject("user", "name"); v.IsNone() || v.Unwrap() != "Ann" {
+		t.Errorf("name was not replicated: %v", v)
+	}
+
+	if v := store.GetFromObject("user", "city"); v.IsSome() {
+		t.Errorf("deleted attribute city is still present on the replica: %v", v.Unwrap())
+	}
+}
+
+// localReplica is a replica that keeps what it applies in the log in dir.
+type localReplica struct {
+	*Replica
+	store *storage.Storage
+	log   *transactionlogger.TransactionLogger
+	stop  func()
+}
+
+func startLocalReplica(t *testing.T, primary, dir string, sec domains.SecurityService) *localReplica {
+	t.Helper()
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := NewReplica(config.ReplicationConfig{Primary: primary}, store, sec, _encryption, zap.NewNop(), clustertest.Insecure())
+	if err := r.Bootstrap(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := transactionlogger.New(config.TransactionLoggerConfig{BackupDirectory: dir}, zap.NewNop(), sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := log.Restore(store); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Resume(log)
+	log.Run()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Start(ctx)
+	}()
+
+	lr := &localReplica{Replica: r, store: store, log: log}
+	lr.stop = func() {
+		cancel()
+		<-done
+		log.Stop()
+	}
+	t.Cleanup(func() {
+		if lr.stop != nil {
+			lr.stop()
+		}
+	})
+
+	return lr
+}
+
+func (lr *localReplica) Stop() {
+	lr.stop()
+	lr.stop = nil
+}
+
+func TestReplicaResumes(t *testing.T) {
+	sec := security.NewSecurityService(config.SecurityConfig{}, _encryption)
+
+	tl, err := transactionlogger.New(config.TransactionLoggerConfig{BackupDirectory: t.TempDir()}, zap.NewNop(), sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.Run()
+	defer tl.Stop()
+
+	for i := 0; i < 10; i++ {
+		tl.WriteSet(fmt.Sprint("old", i), fmt.Sprint("value", i), models.SetOptions{})
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer()
+	primary := newTestPrimary(t, tl, sec)
+	api.RegisterItisaDBServer(srv, primary)
+	cluster.RegisterClusterServer(srv, primary)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	dir := t.TempDir()
+
+	r := startLocalReplica(t, lis.Addr().String(), dir, sec)
+	waitFor(t, func() bool { return r.Status().Applied == 10 })
+
+	tl.WriteSet("new", "value", models.SetOptions{})
+	tl.WriteDelete("old0")
+
+	// the snapshot and the tailed events are in the log of the replica
+	waitFor(t, func() bool { return r.Status().Applied == 12 && r.log.Head() == seeded(t, dir)+2 })
+	r.Stop()
+
+	tl.WriteSet("offline", "value", models.SetOptions{})
+
+	r = startLocalReplica(t, lis.Addr().String(), dir, sec)
+
+	// the data comes from the local log, the primary is asked only for what was missed
+	if v := r.store.Get("new"); v.IsNone() || r.store.Get("old0").IsSome() {
+		t.Errorf("the replica lost its data on restart: new %v, old0 %v", v, r.store.Get("old0"))
+	}
+
+	waitFor(t, func() bool { return r.Status().Applied == 13 })
+
+	if from := primary.from.Load(); from != 12 {
+		t.Fatalf("the replica resumed from %d instead of 12", from)
+	}
+
+	if v := r.store.Get("offline"); v.IsNone() {
+		t.Error("the missed event was not replicated")
+	}
+}
+
+// seeded returns the number of the events the log of the replica in dir was seeded with.
+func seeded(t *testing.T, dir string) uint64 {
+	pos, err := readPosition(dir)
+	if err != nil || pos.IsNone() {
+		t.Fatalf("no position: %v", err)
+	}
+
+	return pos.Unwrap().Events
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// flakyPrimary serves fixed events, the second one can't be applied until it is fixed.
+type flakyPrimary struct {
+	api.UnimplementedItisaDBServer
+	cluster.UnimplementedClusterServer
+
+	backup *backup.Service
+
+	mu    sync.Mutex
+	fixed bool
+	from  []uint64
+}
+
+func (p *flakyPrimary) Authenticate(context.Context, *api.AuthRequest) (*api.AuthResponse, error) {
+	return &api.AuthResponse{Token: "token"}, nil
+}
+
+func (p *flakyPrimary) Backup(_ *cluster.BackupRequest, stream cluster.Cluster_BackupServer) error {
+	return sendBackup(p.backup, stream)
+}
+
+func (p *flakyPrimary) Replicate(r *cluster.ReplicateRequest, stream cluster.Cluster_ReplicateServer) error {
+	p.mu.Lock()
+	p.from = append(p.from, r.From)
+	metadata := "broken"
+	if p.fixed {
+		metadata = "0" + constants.MetadataSeparator + "0"
+	}
+	p.mu.Unlock()
+
+	events := []*cluster.ReplicationEvent{
+		{Seq: 1, Head: 2, Type: uint8(transactionlogger.Set), Name: []byte("a"), Value: []byte("1"), Metadata: []byte("0" + constants.MetadataSeparator + "0")},
+		{Seq: 2, Head: 2, Type: uint8(transactionlogger.Set), Name: []byte("b"), Value: []byte("2"), Metadata: []byte(metadata)},
+	}
+
+	for _, e := range events {
+		if e.Seq <= r.From {
+			continue
+		}
+
+		if err := stream.Send(e); err != nil {
+			return err
+		}
+	}
+
+	<-stream.Context().Done()
+	return nil
+}
+
+func (p *flakyPrimary) requests() []uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return slices.Clone(p.from)
+}
+
+func TestReplicaRetriesFailedEvent(t *testing.T) {
+	sec := security.NewSecurityService(config.SecurityConfig{}, _encryption)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer()
+	// the primary has no log, its snapshot is empty
+	primary := &flakyPrimary{backup: newBackup(t, gost.None[domains.ReplicationSource](), sec)}
+	api.RegisterItisaDBServer(srv, primary)
+	cluster.RegisterClusterServer(srv, primary)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := NewReplica(config.ReplicationConfig{Primary: lis.Addr().String(), ReconnectInterval: 10 * time.Millisecond}, store, sec, _encryption, zap.NewNop(), clustertest.Insecure())
+	go r.Start(ctx)
+
+	// the replica comes back for the failed event instead of moving past it
+	waitFor(t, func() bool { return len(primary.requests()) >= 2 })
+
+	if st := r.Status(); st.Applied != 1 {
+		t.Fatalf("applied %d events, the second one failed", st.Applied)
+	}
+	if from := primary.requests(); from[len(from)-1] != 1 {
+		t.Fatalf("replica asked for the events from %v, want from 1", from)
+	}
+
+	primary.mu.Lock()
+	primary.fixed = true
+	primary.mu.Unlock()
+
+	waitFor(t, func() bool { return r.Status().Applied == 2 })
+
+	if v := store.Get("b"); v.IsNone() || v.Unwrap().Value != "2" {
+		t.Errorf("retried event was not applied: %v", v)
+	}
+}
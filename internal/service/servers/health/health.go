@@ -0,0 +1,239 @@
+// Package health keeps track of whether a remote server answers.
+//
+// A Breaker is closed while the server answers. After FailureThreshold failures in a row
+// it opens: the server gets no requests until the next probe. A due probe moves it to half-open,
+// SuccessThreshold successful probes close it again and a failed one opens it for twice as long.
+package health
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"itisadb/config"
+)
+
+const (
+	DefaultInterval          = 5 * time.Second
+	DefaultTimeout           = 5 * time.Second
+	DefaultFailureThreshold  = 3
+	DefaultSuccessThreshold  = 1
+	DefaultMinBackoff        = time.Second
+	DefaultMaxBackoff        = time.Minute
+	DefaultKeyFilterInterval = 30 * time.Second
+)
+
+// WithDefaults fills the fields the config does not set.
+func WithDefaults(cfg config.HealthConfig) config.HealthConfig {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = DefaultFailureThreshold
+	}
+	if cfg.SuccessThreshold <= 0 {
+		cfg.SuccessThreshold = DefaultSuccessThreshold
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = max(DefaultMaxBackoff, cfg.MinBackoff)
+	}
+	if cfg.KeyFilterInterval == 0 {
+		cfg.KeyFilterInterval = DefaultKeyFilterInterval
+	}
+
+	return cfg
+}
+
+type State int32
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+
+	return "unknown"
+}
+
+// Stats describe a breaker for the metrics.
+type Stats struct {
+	State     State
+	Failures  int
+	Changes   uint64
+	Since     time.Time
+	NextProbe time.Time
+	// LastSeen is when the server answered last, zero if it never did.
+	LastSeen time.Time
+}
+
+type Breaker struct {
+	cfg      config.HealthConfig
+	onChange func(from, to State)
+	now      func() time.Time
+
+	mu        sync.Mutex
+	rnd       *rand.Rand
+	state     State
+	failures  int
+	successes int
+	backoff   time.Duration
+	nextProbe time.Time
+	since     time.Time
+	changes   uint64
+	lastSeen  time.Time
+}
+
+// NewBreaker returns a closed breaker. onChange is called on every state change
+// without the lock held, it may be nil.
+func NewBreaker(cfg config.HealthConfig, onChange func(from, to State)) *Breaker {
+	return &Breaker{
+		cfg:      WithDefaults(cfg),
+		onChange: onChange,
+		now:      time.Now,
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		since:    time.Now(),
+	}
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return Stats{
+		State:     b.state,
+		Failures:  b.failures,
+		Changes:   b.changes,
+		Since:     b.since,
+		NextProbe: b.nextProbe,
+		LastSeen:  b.lastSeen,
+	}
+}
+
+// Success records an answer of the server.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+
+	from := b.state
+	b.lastSeen = b.now()
+
+	switch b.state {
+	case Closed:
+		b.failures = 0
+	case HalfOpen:
+		if b.successes++; b.successes >= b.cfg.SuccessThreshold {
+			b.failures, b.backoff = 0, 0
+			b.set(Closed)
+		}
+	case Open:
+		// an answer to a request sent before the breaker opened, the probes decide
+	}
+
+	b.unlock(from)
+}
+
+// Failure records a request the server did not answer.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+
+	from := b.state
+	switch b.state {
+	case Closed:
+		if b.failures++; b.failures >= b.cfg.FailureThreshold {
+			b.open()
+		}
+	case HalfOpen:
+		b.failures++
+		b.open()
+	}
+
+	b.unlock(from)
+}
+
+// Trip opens the breaker at once, e.g. when the first connection failed.
+func (b *Breaker) Trip() {
+	b.mu.Lock()
+
+	from := b.state
+	if b.state != Open {
+		b.failures = max(b.failures, b.cfg.FailureThreshold)
+		b.open()
+	}
+
+	b.unlock(from)
+}
+
+// ProbeDue reports whether the server should be probed now.
+// The first due probe of an open breaker moves it to half-open.
+func (b *Breaker) ProbeDue() bool {
+	b.mu.Lock()
+
+	from := b.state
+	due := false
+
+	switch b.state {
+	case Open:
+		if !b.now().Before(b.nextProbe) {
+			b.successes = 0
+			b.set(HalfOpen)
+			due = true
+		}
+	case HalfOpen:
+		due = true
+	}
+
+	b.unlock(from)
+
+	return due
+}
+
+// open doubles the backoff, the wait is a random time between its half and its full length.
+func (b *Breaker) open() {
+	b.backoff = min(max(b.backoff*2, b.cfg.MinBackoff), b.cfg.MaxBackoff)
+
+	wait := b.backoff/2 + time.Duration(b.rnd.Int63n(int64(b.backoff/2)+1))
+	b.nextProbe = b.now().Add(wait)
+
+	b.set(Open)
+}
+
+func (b *Breaker) set(s State) {
+	if b.state == s {
+		return
+	}
+
+	b.state = s
+	b.since = b.now()
+	b.changes++
+}
+
+// unlock releases the lock and reports the change that happened since from.
+func (b *Breaker) unlock(from State) {
+	to := b.state
+	b.mu.Unlock()
+
+	if from != to && b.onChange != nil {
+		b.onChange(from, to)
+	}
+}
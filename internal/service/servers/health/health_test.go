@@ -0,0 +1,155 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	"itisadb/config"
+)
+
+// clock is moved by the tests instead of sleeping.
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newBreaker(cfg config.HealthConfig) (*Breaker, *clock, *[]State) {
+	var changes []State
+
+	c := &clock{t: time.Unix(0, 0)}
+	b := NewBreaker(cfg, func(_, to State) { changes = append(changes, to) })
+	b.now = c.now
+
+	return b, c, &changes
+}
+
+func TestBreaker(t *testing.T) {
+	b, c, changes := newBreaker(config.HealthConfig{FailureThreshold: 3, SuccessThreshold: 2, MinBackoff: time.Second, MaxBackoff: 3 * time.Second})
+
+	// a success in between starts the count again
+	b.Failure()
+	b.Failure()
+	b.Success()
+	b.Failure()
+	b.Failure()
+	if b.State() != Closed {
+		t.Fatalf("got %v after 2 failures in a row", b.State())
+	}
+
+	b.Failure()
+	if b.State() != Open {
+		t.Fatalf("got %v after 3 failures in a row", b.State())
+	}
+
+	if b.ProbeDue() {
+		t.Fatal("probe is due before the backoff is over")
+	}
+
+	// the first wait is between 0.5s and 1s
+	c.advance(time.Second)
+	if !b.ProbeDue() || b.State() != HalfOpen {
+		t.Fatalf("got %v, want a probe", b.State())
+	}
+
+	// a failed probe opens the breaker for up to 2s
+	b.Failure()
+	if st := b.Stats(); st.State != Open || st.NextProbe.Sub(c.t) < time.Second || st.NextProbe.Sub(c.t) > 2*time.Second {
+		t.Fatalf("got %+v at %v", st, c.t)
+	}
+
+	c.advance(2 * time.Second)
+	if !b.ProbeDue() {
+		t.Fatal("no probe after the backoff")
+	}
+
+	b.Success()
+	if b.State() != HalfOpen {
+		t.Fatalf("got %v after 1 successful probe of 2", b.State())
+	}
+
+	b.Success()
+	if b.State() != Closed {
+		t.Fatalf("got %v after 2 successful probes", b.State())
+	}
+
+	want := []State{Open, HalfOpen, Open, HalfOpen, Closed}
+	if len(*changes) != len(want) {
+		t.Fatalf("got changes %v, want %v", *changes, want)
+	}
+	for i := range want {
+		if (*changes)[i] != want[i] {
+			t.Fatalf("got changes %v, want %v", *changes, want)
+		}
+	}
+
+	if st := b.Stats(); st.Changes != uint64(len(want)) || st.Failures != 0 {
+		t.Errorf("stats: %+v", st)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b, c, _ := newBreaker(config.HealthConfig{MinBackoff: time.Second, MaxBackoff: 4 * time.Second})
+
+	b.Trip()
+
+	for i, limit := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		wait := b.Stats().NextProbe.Sub(c.t)
+		if wait < limit/2 || wait > limit {
+			t.Fatalf("probe %d: waits %v, want between %v and %v", i, wait, limit/2, limit)
+		}
+
+		c.advance(wait)
+		if !b.ProbeDue() {
+			t.Fatalf("probe %d is not due", i)
+		}
+		b.Failure()
+	}
+
+	// the backoff starts over once the server is back
+	c.advance(4 * time.Second)
+	b.ProbeDue()
+	b.Success()
+	b.Trip()
+
+	if wait := b.Stats().NextProbe.Sub(c.t); wait > time.Second {
+		t.Errorf("waits %v after the server was back", wait)
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	cfg := WithDefaults(config.HealthConfig{MinBackoff: 2 * time.Minute})
+
+	if cfg.FailureThreshold != DefaultFailureThreshold || cfg.SuccessThreshold != DefaultSuccessThreshold || cfg.Interval != DefaultInterval {
+		t.Errorf("got %+v", cfg)
+	}
+
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		t.Errorf("MaxBackoff %v is less than MinBackoff %v", cfg.MaxBackoff, cfg.MinBackoff)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	l := NewLatency()
+
+	if ps := l.Percentiles(50); ps[0] != 0 {
+		t.Errorf("got %v without requests", ps)
+	}
+
+	// the window keeps the last requests only
+	for i := 0; i < LatencyWindow; i++ {
+		l.Record(time.Hour)
+	}
+	for i := 1; i <= LatencyWindow; i++ {
+		l.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	ps := l.Percentiles(50, 90, 99, 100)
+	want := []time.Duration{512 * time.Millisecond, 921 * time.Millisecond, 1013 * time.Millisecond, 1024 * time.Millisecond}
+	for i := range want {
+		if ps[i] != want[i] {
+			t.Fatalf("got %v, want %v", ps, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package health
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// LatencyWindow is the number of recent requests the percentiles are computed over.
+const LatencyWindow = 1024
+
+// Latency keeps the durations of the recent requests to a server.
+type Latency struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func NewLatency() *Latency {
+	return &Latency{samples: make([]time.Duration, 0, LatencyWindow)}
+}
+
+func (l *Latency) Record(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.samples) < LatencyWindow {
+		l.samples = append(l.samples, d)
+		return
+	}
+
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % LatencyWindow
+}
+
+// Percentiles returns the p-th percentiles of the recent durations, zeros when there were no requests.
+func (l *Latency) Percentiles(ps ...float64) []time.Duration {
+	l.mu.Lock()
+	sorted := slices.Clone(l.samples)
+	l.mu.Unlock()
+
+	slices.Sort(sorted)
+
+	res := make([]time.Duration, len(ps))
+	if len(sorted) == 0 {
+		return res
+	}
+
+	for i, p := range ps {
+		idx := int(p / 100 * float64(len(sorted)-1))
+		res[i] = sorted[min(max(idx, 0), len(sorted)-1)]
+	}
+
+	return res
+}
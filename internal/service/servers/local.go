@@ -57,6 +57,11 @@ func (s *LocalServer) RefreshRAM(_ context.Context) (res gost.ResultN) {
 	return res.Ok()
 }
 
+// RefreshKeyFilter does nothing, MayHave of the local server asks the storage itself.
+func (s *LocalServer) RefreshKeyFilter(_ context.Context) (res gost.ResultN) {
+	return res.Ok()
+}
+
 func (s *LocalServer) NewUser(ctx context.Context, claims gost.Option[models.UserClaims], user models.User) (r gost.ResultN) {
 	if s.config.Balancer.On {
 		return r.Ok()
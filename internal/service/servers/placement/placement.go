@@ -0,0 +1,229 @@
+// Package placement holds the strategies that choose a server for new keys and objects.
+package placement
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+
+	"github.com/egorgasay/gost"
+)
+
+// ZoneLabel is the label the "labels" strategy spreads the data by.
+const ZoneLabel = "zone"
+
+// New returns the strategy named in the config, the most free RAM one by default.
+func New(name string) (domains.PlacementStrategy, error) {
+	switch name {
+	case "", config.MostFreeRAMStrategy:
+		return MostFreeRAM{}, nil
+	case config.RoundRobinStrategy:
+		return &RoundRobin{}, nil
+	case config.FewestKeysStrategy:
+		return NewFewestKeys(), nil
+	case config.WeightedStrategy:
+		return NewWeighted(time.Now().UnixNano()), nil
+	case config.LabelsStrategy:
+		return NewZones(), nil
+	default:
+		return nil, fmt.Errorf("unknown placement strategy %q", name)
+	}
+}
+
+// ParseHint parses a placement hint of the form "key=value,key=value".
+func ParseHint(hint string) (map[string]string, error) {
+	if hint == "" {
+		return nil, nil
+	}
+
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(hint, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid placement hint %q, want key=value", pair)
+		}
+
+		labels[k] = v
+	}
+
+	return labels, nil
+}
+
+// Matches reports whether labels have every label of the hint.
+func Matches(labels, hint map[string]string) bool {
+	for k, v := range hint {
+		if labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MostFreeRAM picks the server with the largest share of free RAM.
+// A server that has not reported its RAM yet is not picked.
+type MostFreeRAM struct{}
+
+func (MostFreeRAM) Pick(candidates []models.PlacementCandidate) (res gost.Option[int32]) {
+	best := 0.0
+
+	for _, c := range candidates {
+		if val := float64(c.RAM.Available) / float64(c.RAM.Total) * 100; val > best {
+			res = res.Some(c.Number)
+			best = val
+		}
+	}
+
+	return res
+}
+
+// RoundRobin lets the servers take turns.
+type RoundRobin struct {
+	next atomic.Uint64
+}
+
+func (r *RoundRobin) Pick(candidates []models.PlacementCandidate) gost.Option[int32] {
+	numbers := sortedNumbers(candidates)
+	return gost.Some(numbers[(r.next.Add(1)-1)%uint64(len(numbers))])
+}
+
+// FewestKeys picks the server that got the fewest keys and objects from this strategy.
+// The balancer can't count the data a server already has, so the count starts at zero on every start.
+type FewestKeys struct {
+	mu     sync.Mutex
+	placed map[int32]uint64
+}
+
+func NewFewestKeys() *FewestKeys {
+	return &FewestKeys{placed: make(map[int32]uint64)}
+}
+
+func (f *FewestKeys) Pick(candidates []models.PlacementCandidate) gost.Option[int32] {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	numbers := sortedNumbers(candidates)
+
+	best := numbers[0]
+	for _, n := range numbers[1:] {
+		if f.placed[n] < f.placed[best] {
+			best = n
+		}
+	}
+
+	f.placed[best]++
+
+	return gost.Some(best)
+}
+
+// Weighted picks a random server, the chance is proportional to its weight.
+// A server without a weight has the weight 1, a negative weight keeps new data off the server.
+type Weighted struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func NewWeighted(seed int64) *Weighted {
+	return &Weighted{rnd: rand.New(rand.NewSource(seed))}
+}
+
+func (w *Weighted) Pick(candidates []models.PlacementCandidate) (res gost.Option[int32]) {
+	total := 0
+	for _, c := range candidates {
+		total += weight(c)
+	}
+
+	if total == 0 {
+		return res.None()
+	}
+
+	w.mu.Lock()
+	n := w.rnd.Intn(total)
+	w.mu.Unlock()
+
+	for _, c := range candidates {
+		if n -= weight(c); n < 0 {
+			return res.Some(c.Number)
+		}
+	}
+
+	return res.None()
+}
+
+func weight(c models.PlacementCandidate) int {
+	switch {
+	case c.Weight == 0:
+		return 1
+	case c.Weight < 0:
+		return 0
+	}
+
+	return c.Weight
+}
+
+// Zones spreads the data evenly across the values of the zone label,
+// inside a zone it picks the server with the most free RAM.
+// The servers without the label make a zone of their own.
+type Zones struct {
+	mu     sync.Mutex
+	placed map[string]uint64
+}
+
+func NewZones() *Zones {
+	return &Zones{placed: make(map[string]uint64)}
+}
+
+func (z *Zones) Pick(candidates []models.PlacementCandidate) (res gost.Option[int32]) {
+	byZone := make(map[string][]models.PlacementCandidate)
+	for _, c := range candidates {
+		zone := c.Labels[ZoneLabel]
+		byZone[zone] = append(byZone[zone], c)
+	}
+
+	zones := make([]string, 0, len(byZone))
+	for zone := range byZone {
+		zones = append(zones, zone)
+	}
+	slices.Sort(zones)
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	slices.SortStableFunc(zones, func(a, b string) int {
+		switch pa, pb := z.placed[a], z.placed[b]; {
+		case pa < pb:
+			return -1
+		case pa > pb:
+			return 1
+		}
+		return 0
+	})
+
+	// a zone whose servers have not reported their RAM yet is passed over
+	for _, zone := range zones {
+		if picked := (MostFreeRAM{}).Pick(byZone[zone]); picked.IsSome() {
+			z.placed[zone]++
+			return picked
+		}
+	}
+
+	return res.None()
+}
+
+func sortedNumbers(candidates []models.PlacementCandidate) []int32 {
+	numbers := make([]int32, len(candidates))
+	for i, c := range candidates {
+		numbers[i] = c.Number
+	}
+	slices.Sort(numbers)
+
+	return numbers
+}
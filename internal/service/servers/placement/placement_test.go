@@ -0,0 +1,139 @@
+package placement
+
+import (
+	"testing"
+
+	"itisadb/config"
+	"itisadb/internal/models"
+)
+
+func candidates() []models.PlacementCandidate {
+	return []models.PlacementCandidate{
+		{Number: 3, RAM: models.RAM{Total: 100, Available: 50}, Weight: 3, Labels: map[string]string{"zone": "b"}},
+		{Number: 1, RAM: models.RAM{Total: 100, Available: 90}, Labels: map[string]string{"zone": "a"}},
+		{Number: 2, RAM: models.RAM{Total: 100, Available: 10}, Weight: -1, Labels: map[string]string{"zone": "a", "disk": "ssd"}},
+	}
+}
+
+func TestMostFreeRAM(t *testing.T) {
+	if got := (MostFreeRAM{}).Pick(candidates()); got.IsNone() || got.Unwrap() != 1 {
+		t.Errorf("got %v, want s#1", got)
+	}
+
+	// the RAM of a new server is unknown until it is refreshed
+	if got := (MostFreeRAM{}).Pick([]models.PlacementCandidate{{Number: 1}}); got.IsSome() {
+		t.Errorf("got %v for a server without RAM", got)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	var rr RoundRobin
+
+	var got []int32
+	for i := 0; i < 6; i++ {
+		got = append(got, rr.Pick(candidates()).Unwrap())
+	}
+
+	want := []int32{1, 2, 3, 1, 2, 3}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestFewestKeys(t *testing.T) {
+	f := NewFewestKeys()
+
+	counts := make(map[int32]int)
+	for i := 0; i < 30; i++ {
+		counts[f.Pick(candidates()).Unwrap()]++
+	}
+
+	// a new server takes the new keys until it catches up
+	withNew := append(candidates(), models.PlacementCandidate{Number: 4})
+	for i := 0; i < 10; i++ {
+		if got := f.Pick(withNew).Unwrap(); got != 4 {
+			t.Fatalf("pick %d: got s#%d, want the new server", i, got)
+		}
+	}
+
+	if counts[1] != 10 || counts[2] != 10 || counts[3] != 10 {
+		t.Errorf("got %v, want 10 keys on each server", counts)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	w := NewWeighted(1)
+
+	counts := make(map[int32]int)
+	for i := 0; i < 4000; i++ {
+		counts[w.Pick(candidates()).Unwrap()]++
+	}
+
+	if counts[2] != 0 {
+		t.Errorf("s#2 with a negative weight got %d keys", counts[2])
+	}
+
+	// s#3 has the weight 3, s#1 has the default weight 1
+	if ratio := float64(counts[3]) / float64(counts[1]); ratio < 2.5 || ratio > 3.5 {
+		t.Errorf("got %v, want about 3 times more keys on s#3", counts)
+	}
+}
+
+func TestZones(t *testing.T) {
+	z := NewZones()
+
+	var got []int32
+	for i := 0; i < 4; i++ {
+		got = append(got, z.Pick(candidates()).Unwrap())
+	}
+
+	// the zones take turns, s#1 has more free RAM than s#2 in the zone "a"
+	want := []int32{1, 3, 1, 3}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestHint(t *testing.T) {
+	hint, err := ParseHint("zone=a, disk=ssd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var matched []int32
+	for _, c := range candidates() {
+		if Matches(c.Labels, hint) {
+			matched = append(matched, c.Number)
+		}
+	}
+
+	if len(matched) != 1 || matched[0] != 2 {
+		t.Errorf("got %v, want s#2", matched)
+	}
+
+	if hint, err := ParseHint(""); err != nil || !Matches(nil, hint) {
+		t.Errorf("empty hint: %v, %v", hint, err)
+	}
+
+	for _, bad := range []string{"zone", "=a", "zone=a,,"} {
+		if _, err := ParseHint(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range []string{"", config.MostFreeRAMStrategy, config.RoundRobinStrategy, config.FewestKeysStrategy, config.WeightedStrategy, config.LabelsStrategy} {
+		if _, err := New(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+
+	if _, err := New("random"); err == nil {
+		t.Error("unknown strategy: no error")
+	}
+}
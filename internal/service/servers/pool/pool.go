@@ -0,0 +1,191 @@
+// Package pool keeps several gRPC connections to a remote server.
+//
+// One connection multiplexes the requests over the streams of a single HTTP/2 connection,
+// so a server that limits the concurrent streams of a connection, or a connection busy with
+// large messages, holds back every request of the balancer. The requests are spread over the
+// connections of the pool instead.
+package pool
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/egorgasay/itisadb-go-sdk"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+	"itisadb/config"
+)
+
+const (
+	DefaultSize             = 4
+	DefaultKeepalive        = 30 * time.Second
+	DefaultKeepaliveTimeout = 10 * time.Second
+	DefaultMaxMessageSize   = 16 << 20
+
+	// MinKeepalive is the shortest ping interval gRPC allows, the servers accept the pings this often.
+	MinKeepalive = 10 * time.Second
+)
+
+// WithDefaults fills the fields the config does not set.
+func WithDefaults(cfg config.ConnectionsConfig) config.ConnectionsConfig {
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = DefaultSize
+	}
+	if cfg.Keepalive == 0 {
+		cfg.Keepalive = DefaultKeepalive
+	}
+	if cfg.KeepaliveTimeout <= 0 {
+		cfg.KeepaliveTimeout = DefaultKeepaliveTimeout
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = DefaultMaxMessageSize
+	}
+	if cfg.Login == "" {
+		cfg.Login, cfg.Password = itisadb.DefaultUser, itisadb.DefaultPassword
+	}
+
+	return cfg
+}
+
+// DialOptions returns the options of the connections to the servers.
+func DialOptions(cfg config.ConnectionsConfig) []grpc.DialOption {
+	cfg = WithDefaults(cfg)
+
+	callOpts := []grpc.CallOption{
+		grpc.MaxCallRecvMsgSize(cfg.MaxMessageSize),
+		grpc.MaxCallSendMsgSize(cfg.MaxMessageSize),
+	}
+	if cfg.Compression == config.GzipCompression {
+		callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(callOpts...),
+	}
+
+	if cfg.Keepalive > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                max(cfg.Keepalive, MinKeepalive),
+			Timeout:             cfg.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+
+	return opts
+}
+
+// ServerOptions returns the options of the gRPC server that let the balancer
+// connect to it with the same config.
+func ServerOptions(cfg config.ConnectionsConfig) []grpc.ServerOption {
+	cfg = WithDefaults(cfg)
+
+	return []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(cfg.MaxMessageSize),
+		grpc.MaxSendMsgSize(cfg.MaxMessageSize),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             MinKeepalive,
+			PermitWithoutStream: true,
+		}),
+	}
+}
+
+// Pool is a fixed set of connections to one address.
+type Pool struct {
+	conns []*grpc.ClientConn
+	next  atomic.Uint64
+
+	mu       sync.Mutex
+	inFlight int
+	retired  bool
+	closed   bool
+}
+
+// Dial creates the connections of the pool. They connect in the background, like grpc.Dial does.
+func Dial(address string, cfg config.ConnectionsConfig) (*Pool, error) {
+	cfg = WithDefaults(cfg)
+	opts := DialOptions(cfg)
+
+	p := &Pool{conns: make([]*grpc.ClientConn, 0, cfg.PoolSize)}
+
+	for i := 0; i < cfg.PoolSize; i++ {
+		conn, err := grpc.Dial(address, opts...)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+
+		p.conns = append(p.conns, conn)
+	}
+
+	return p, nil
+}
+
+// Acquire returns the next connection for a request, release must be called when the request is done.
+// The connections are taken in turn, so the requests are spread over them evenly.
+func (p *Pool) Acquire() (conn *grpc.ClientConn, release func()) {
+	p.mu.Lock()
+	p.inFlight++
+	p.mu.Unlock()
+
+	conn = p.conns[p.next.Add(1)%uint64(len(p.conns))]
+
+	var once sync.Once
+	return conn, func() { once.Do(p.release) }
+}
+
+func (p *Pool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.inFlight--
+	if p.retired && p.inFlight == 0 {
+		p.close()
+	}
+}
+
+// Retire closes the connections once the requests that acquired them are done.
+// A replaced pool is retired, so the requests in flight are not cut off.
+func (p *Pool) Retire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.retired = true
+	if p.inFlight == 0 {
+		p.close()
+	}
+}
+
+// Close closes the connections at once, the requests in flight fail.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.close()
+}
+
+func (p *Pool) close() {
+	if p.closed {
+		return
+	}
+	p.closed = true
+
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+}
+
+// Size returns the number of the connections.
+func (p *Pool) Size() int {
+	return len(p.conns)
+}
+
+// InFlight returns the number of the requests that hold a connection now.
+func (p *Pool) InFlight() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inFlight
+}
@@ -0,0 +1,160 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"itisadb/config"
+)
+
+// busyServer answers each check after delay, and at most streams checks of one connection at a time,
+// like a heavily loaded server does.
+type busyServer struct {
+	healthpb.UnimplementedHealthServer
+	delay time.Duration
+}
+
+func (s *busyServer) Check(context.Context, *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	time.Sleep(s.delay)
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func startBusyServer(t testing.TB, streams uint32, delay time.Duration) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer(append(ServerOptions(config.ConnectionsConfig{}), grpc.MaxConcurrentStreams(streams))...)
+	healthpb.RegisterHealthServer(srv, &busyServer{delay: delay})
+
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	return lis.Addr().String()
+}
+
+// load sends the checks from the workers for d and returns the number of the answered ones per second.
+func load(t testing.TB, p *Pool, workers int, d time.Duration) float64 {
+	var (
+		done atomic.Int64
+		wg   sync.WaitGroup
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				conn, release := p.Acquire()
+				_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+				release()
+
+				if err == nil {
+					done.Add(1)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return float64(done.Load()) / d.Seconds()
+}
+
+func dial(t testing.TB, address string, size int) *Pool {
+	p, err := Dial(address, config.ConnectionsConfig{PoolSize: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+func TestLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test")
+	}
+
+	address := startBusyServer(t, 4, 5*time.Millisecond)
+
+	single, pooled := dial(t, address, 1), dial(t, address, 8)
+
+	// the connections are dialed by the first requests
+	load(t, single, 64, 50*time.Millisecond)
+	load(t, pooled, 64, 50*time.Millisecond)
+
+	singleRate := load(t, single, 64, 500*time.Millisecond)
+	pooledRate := load(t, pooled, 64, 500*time.Millisecond)
+
+	t.Logf("1 connection: %.0f req/s, 8 connections: %.0f req/s", singleRate, pooledRate)
+
+	if pooledRate < 2*singleRate {
+		t.Errorf("the pool is not faster: %.0f req/s against %.0f req/s", pooledRate, singleRate)
+	}
+}
+
+func BenchmarkLoad(b *testing.B) {
+	address := startBusyServer(b, 4, 2*time.Millisecond)
+
+	for _, size := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("conns=%d", size), func(b *testing.B) {
+			p := dial(b, address, size)
+			load(b, p, 64, 50*time.Millisecond)
+
+			var rate float64
+			for i := 0; i < b.N; i++ {
+				rate = load(b, p, 64, 200*time.Millisecond)
+			}
+
+			b.ReportMetric(rate, "req/s")
+		})
+	}
+}
+
+func TestRetire(t *testing.T) {
+	address := startBusyServer(t, 100, 0)
+	p := dial(t, address, 2)
+
+	conn, release := p.Acquire()
+	if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Retire()
+
+	// the request in flight keeps its connection
+	if conn.GetState() == connectivity.Shutdown {
+		t.Fatal("the connection was closed under the request")
+	}
+
+	if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	release()
+	release() // a second release changes nothing
+
+	if p.InFlight() != 0 {
+		t.Errorf("%d requests in flight", p.InFlight())
+	}
+
+	for _, conn := range p.conns {
+		if conn.GetState() != connectivity.Shutdown {
+			t.Error("the retired pool is not closed")
+		}
+	}
+}
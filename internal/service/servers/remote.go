@@ -2,29 +2,47 @@ package servers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"sync/atomic"
+	"io"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/egorgasay/gost"
 	"github.com/egorgasay/itisadb-go-sdk"
+	api "github.com/egorgasay/itisadb-shared-proto/go"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"itisadb/config"
 	"itisadb/internal/constants"
+	"itisadb/internal/domains"
 	"itisadb/internal/models"
+	"itisadb/internal/service/servers/health"
+	"itisadb/internal/service/servers/pool"
+	"itisadb/pkg/api/cluster"
+	"itisadb/pkg/bloom"
+	"itisadb/pkg/merkle"
 )
 
 // =============== server ====================== //
 
-func NewRemoteServer(ctx context.Context, address string, number int32, logger *zap.Logger) (*RemoteServer, error) {
+func NewRemoteServer(ctx context.Context, address string, number int32, cfg config.HealthConfig, conns config.ConnectionsConfig, session domains.Session, logger *zap.Logger) (*RemoteServer, error) {
 	rs := &RemoteServer{
 		number:  number,
-		tries:   atomic.Uint32{},
 		ram:     gost.NewRwLock(models.RAM{}),
 		address: address,
+		session: session,
 		logger:  logger,
+		latency: health.NewLatency(),
+		connCfg: conns,
 	}
+	rs.breaker = health.NewBreaker(cfg, rs.stateChanged)
 
 	if err := rs.Reconnect(ctx).Error(); err != nil {
-		rs.tries.Store(constants.MaxServerTries)
+		rs.breaker.Trip()
 		return rs, err.ExtendMsg("failed to connect to remote server", address, err.Error())
 	}
 
@@ -32,42 +50,114 @@ func NewRemoteServer(ctx context.Context, address string, number int32, logger *
 }
 
 type RemoteServer struct {
-	tries   atomic.Uint32
+	breaker *health.Breaker
+	latency *health.Latency
 	ram     gost.RwLock[models.RAM]
 	number  int32
 	address string
 	logger  *zap.Logger
 
-	sdk *itisadb.Client
+	// session signs the callers forwarded to the server.
+	session domains.Session
+
+	// conns are the connections to the server, token authenticates the balancer on it.
+	connsMu sync.RWMutex
+	conns   *pool.Pool
+	token   string
+	connCfg config.ConnectionsConfig
+
+	// filter is the last pulled key filter of the server, nil until the first pull.
+	filterMu sync.RWMutex
+	filter   *bloom.Filter
 }
 
 func (s *RemoteServer) Number() int32 {
 	return s.number
 }
 
+// IsOffline reports whether the server gets no requests: its breaker is open or half-open.
 func (s *RemoteServer) IsOffline() bool {
-	return s.tries.Load() >= constants.MaxServerTries
+	return s.breaker.State() != health.Closed
+}
+
+// Health returns the breaker the health checker probes the server through.
+func (s *RemoteServer) Health() *health.Breaker {
+	return s.breaker
+}
+
+// Latency returns the durations of the recent requests to the server.
+func (s *RemoteServer) Latency() *health.Latency {
+	return s.latency
+}
+
+func (s *RemoteServer) stateChanged(from, to health.State) {
+	fields := []zap.Field{zap.Int32("server", s.number), zap.String("address", s.address), zap.Stringer("from", from), zap.Stringer("to", to)}
+
+	switch to {
+	case health.Open:
+		s.logger.Warn("server is offline", fields...)
+	case health.HalfOpen:
+		s.logger.Info("probing server", fields...)
+	case health.Closed:
+		s.logger.Info("server is back online", fields...)
+	}
 }
 
+// Reconnect dials a new pool of connections to the server and authenticates on it.
+// The replaced connections are closed once the requests on them are done.
 func (s *RemoteServer) Reconnect(ctx context.Context) (res gost.ResultN) {
+	p, err := pool.Dial(s.address, s.connCfg)
+	if err != nil {
+		return res.Err(gost.NewErrX(0, "grpc dial failed").ExtendMsg(err.Error()))
+	}
+
+	conn, release := p.Acquire()
+	resp, err := api.NewItisaDBClient(conn).Authenticate(ctx, &api.AuthRequest{Login: s.connCfg.Login, Password: s.connCfg.Password})
+	release()
 
-	switch r := itisadb.New(ctx, s.address); r.Switch() {
-	case gost.IsOk:
-		s.sdk = r.Unwrap()
-		s.resetTries()
-	case gost.IsErr:
-		return res.Err(r.Error())
+	if err != nil {
+		p.Close()
+		return res.Err(fromStatus(err))
+	}
+
+	s.connsMu.Lock()
+	old := s.conns
+	s.conns, s.token = p, resp.Token
+	s.connsMu.Unlock()
+
+	if old != nil {
+		old.Retire()
 	}
 
 	return res.Ok()
 }
 
-type resulterr interface {
+// Close closes the connections to the server once the requests on them are done.
+func (s *RemoteServer) Close() {
+	s.connsMu.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.connsMu.Unlock()
+
+	if conns != nil {
+		conns.Retire()
+	}
+}
+
+type resulterr[Re any] interface {
 	IsErr() bool
 	Error() *gost.ErrX
+	Err(err *gost.ErrX) Re
 }
 
-func after[Re resulterr, RePtr *Re](s *RemoteServer, res RePtr) {
+// after records the result and the duration of a request with ctx that started at start.
+// A request the server refused to the forwarded caller fails with constants.ErrForbidden,
+// like the same request to the local server does.
+// A request the balancer canceled, e.g. the slower one of a hedged read, is not a failure of the server,
+// while one that ran out of time is.
+func after[Re resulterr[Re]](ctx context.Context, s *RemoteServer, res *Re, start time.Time) {
+	s.latency.Record(time.Since(start))
+
 	if res == nil {
 		s.logger.Warn("res in server handler is nil", zap.Int32("server number", s.number))
 		return
@@ -75,42 +165,115 @@ func after[Re resulterr, RePtr *Re](s *RemoteServer, res RePtr) {
 
 	resUnwrapped := *res
 
-	if !resUnwrapped.IsErr() {
-		s.resetTries()
-		return
+	switch {
+	case !resUnwrapped.IsErr():
+		s.breaker.Success()
+	case resUnwrapped.Error().BaseCode() != 0:
+	case answered(resUnwrapped.Error()):
+		s.breaker.Success()
+
+		if denied(resUnwrapped.Error()) {
+			*res = resUnwrapped.Err(constants.ErrForbidden)
+		}
+	case canceled(resUnwrapped.Error()), errors.Is(ctx.Err(), context.Canceled):
+	default:
+		s.breaker.Failure()
 	}
+}
 
-	if resUnwrapped.Error().BaseCode() != 0 {
-		return
-	}
+// _answers are the errors the server returns itself, they don't tell it is down.
+var _answers = []*gost.ErrX{
+	itisadb.ErrNotFound,
+	itisadb.ErrObjectNotFound,
+	itisadb.ErrUniqueConstraint,
+	itisadb.ErrUnauthorized,
+	itisadb.ErrPermissionDenied,
+	// the Cluster service answers with the errors of the node itself
+	constants.ErrAlreadyExists,
+	constants.ErrForbidden,
+	constants.ErrTxConflict,
+}
+
+// answered compares the root messages, the codes of the SDK errors are all 0.
+func answered(err *gost.ErrX) bool {
+	root := err.Messages()[0]
+	return slices.ContainsFunc(_answers, func(answer *gost.ErrX) bool {
+		return root == answer.Message()
+	})
+}
 
-	s.incTries()
+// denied reports whether the server refused the caller the request.
+func denied(err *gost.ErrX) bool {
+	return err.Messages()[0] == itisadb.ErrPermissionDenied.Message()
 }
 
-func (s *RemoteServer) GetOne(ctx context.Context, _ gost.Option[models.UserClaims], key string, opt models.GetOptions) (res gost.Result[models.Value]) {
-	defer after(s, &res)
+// canceled reports whether the caller gave up on the request, it says nothing about the server.
+func canceled(err *gost.ErrX) bool {
+	return err.Messages()[0] == itisadb.ErrContextCanceled.Message()
+}
+
+func (s *RemoteServer) GetOne(ctx context.Context, claims gost.Option[models.UserClaims], key string, opt models.GetOptions) (res gost.Result[models.Value]) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
 
-	r := s.sdk.GetOne(ctx, key, opt.ToSDK())
-	if r.IsErr() {
-		return res.Err(r.Error())
+	r, err := c.api.Get(ctx, &api.GetRequest{Key: key, Options: &api.GetRequest_Options{}})
+	if err != nil {
+		return res.Err(fromStatus(err))
 	}
 
-	val := r.Unwrap()
 	return res.Ok(models.Value{
-		ReadOnly: val.ReadOnly,
-		Level:    models.Level(val.Level),
-		Value:    val.Value,
+		ReadOnly: r.ReadOnly,
+		Level:    models.Level(r.Level),
+		Value:    r.Value,
 	})
 }
 
-func (s *RemoteServer) DelOne(ctx context.Context, _ gost.Option[models.UserClaims], key string, opt models.DeleteOptions) (res gost.ResultN) {
-	defer after(s, &res)
-	return s.sdk.DelOne(ctx, key, opt.ToSDK())
+func (s *RemoteServer) DelOne(ctx context.Context, claims gost.Option[models.UserClaims], key string, opt models.DeleteOptions) (res gost.ResultN) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	if _, err := c.api.Delete(ctx, &api.DeleteRequest{Key: key, Options: &api.DeleteRequest_Options{}}); err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	return res.Ok()
 }
 
-func (s *RemoteServer) SetOne(ctx context.Context, _ gost.Option[models.UserClaims], key string, val string, opts models.SetOptions) (res gost.Result[int32]) {
-	defer after(s, &res)
-	return s.sdk.SetOne(ctx, key, val, opts.ToSDK())
+func (s *RemoteServer) SetOne(ctx context.Context, claims gost.Option[models.UserClaims], key string, val string, opts models.SetOptions) (res gost.Result[int32]) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	r, err := c.api.Set(ctx, &api.SetRequest{
+		Key:   key,
+		Value: val,
+		Options: &api.SetRequest_Options{
+			ReadOnly: opts.ReadOnly,
+			Level:    api.Level(opts.Level),
+			Unique:   opts.Unique,
+		},
+	})
+	if err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	s.addKeys(key)
+
+	return res.Ok(r.SavedTo)
 }
 
 func (s *RemoteServer) RAM() models.RAM {
@@ -119,162 +282,714 @@ func (s *RemoteServer) RAM() models.RAM {
 }
 
 func (s *RemoteServer) RefreshRAM(ctx context.Context) (res gost.ResultN) {
-	defer after(s, &res)
+	defer after(ctx, s, &res, time.Now())
 
-	r := itisadb.Internal.GetRAM(ctx, s.sdk)
-	if r.IsErr() {
-		return res.Err(r.Error())
+	c, ctx, errX := s.conn(ctx)
+	if errX != nil {
+		return res.Err(errX)
 	}
+	defer c.release()
 
-	s.ram.SetWithLock(models.RAM(r.Unwrap()))
-	return res.Ok()
-}
+	r, err := c.api.GetRam(ctx, &api.GetRamRequest{})
+	if err != nil {
+		return res.Err(fromStatus(err))
+	}
 
-func (s *RemoteServer) incTries() uint32 {
-	return s.tries.Add(1)
-}
+	ram := r.GetRam()
+	s.ram.SetWithLock(models.RAM{Total: ram.GetTotal(), Available: ram.GetAvailable()})
 
-func (s *RemoteServer) resetTries() {
-	s.tries.Store(0)
+	return res.Ok()
 }
 
-func (s *RemoteServer) NewObject(ctx context.Context, _ gost.Option[models.UserClaims], name string, opts models.ObjectOptions) (res gost.ResultN) {
-	defer after(s, &res)
+func (s *RemoteServer) NewObject(ctx context.Context, claims gost.Option[models.UserClaims], name string, opts models.ObjectOptions) (res gost.ResultN) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
 
-	r := s.sdk.Object(name).Create(ctx, opts.ToSDK())
-	if r.IsOk() {
-		return res.Ok()
+	_, err := c.api.Object(ctx, &api.ObjectRequest{
+		Name:    name,
+		Options: &api.ObjectRequest_Options{Level: api.Level(opts.Level)},
+	})
+	if err != nil {
+		return res.Err(fromStatus(err))
 	}
 
-	return res.Err(r.Error())
+	return res.Ok()
 }
 
-func (s *RemoteServer) GetFromObject(ctx context.Context, _ gost.Option[models.UserClaims], object string, key string, opts models.GetFromObjectOptions) (res gost.Result[string]) {
-	defer after(s, &res)
+func (s *RemoteServer) GetFromObject(ctx context.Context, claims gost.Option[models.UserClaims], object string, key string, opts models.GetFromObjectOptions) (res gost.Result[string]) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
 
-	gerRes := s.sdk.Object(object).Get(ctx, key, opts.ToSDK())
-	if gerRes.IsErr() {
-		return res.Err(gerRes.Error().ExtendMsg(fmt.Sprintf("error while GetFromObject [%s.%s]", object, key)))
+	r, err := c.api.GetFromObject(ctx, &api.GetFromObjectRequest{Object: object, Key: key, Options: &api.GetFromObjectRequest_Options{}})
+	if err != nil {
+		return res.Err(fromStatus(err).ExtendMsg(fmt.Sprintf("error while GetFromObject [%s.%s]", object, key)))
 	}
 
-	return res.Ok(gerRes.Unwrap())
+	return res.Ok(r.Value)
 }
 
-func (s *RemoteServer) SetToObject(ctx context.Context, _ gost.Option[models.UserClaims], object string, key string, value string, opts models.SetToObjectOptions) (res gost.ResultN) {
-	defer after(s, &res)
+func (s *RemoteServer) SetToObject(ctx context.Context, claims gost.Option[models.UserClaims], object string, key string, value string, opts models.SetToObjectOptions) (res gost.ResultN) {
+	defer after(ctx, s, &res, time.Now())
 
-	setResult := s.sdk.Object(object).Set(ctx, key, value, opts.ToSDK())
-	if setResult.IsErr() {
-		return res.Err(setResult.Error())
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	_, err := c.api.SetToObject(ctx, &api.SetToObjectRequest{
+		Object:  object,
+		Key:     key,
+		Value:   value,
+		Options: &api.SetToObjectRequest_Options{ReadOnly: opts.ReadOnly},
+	})
+	if err != nil {
+		return res.Err(fromStatus(err))
 	}
 
 	return res.Ok()
 }
 
-func (s *RemoteServer) ObjectToJSON(ctx context.Context, _ gost.Option[models.UserClaims], object string, opts models.ObjectToJSONOptions) (res gost.Result[string]) {
-	defer after(s, &res)
+func (s *RemoteServer) ObjectToJSON(ctx context.Context, claims gost.Option[models.UserClaims], object string, opts models.ObjectToJSONOptions) (res gost.Result[string]) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
 
-	rJSON := s.sdk.Object(object).JSON(ctx, opts.ToSDK())
-	if rJSON.IsErr() {
-		return res.Err(rJSON.Error())
+	r, err := c.api.ObjectToJSON(ctx, &api.ObjectToJSONRequest{Name: object, Options: &api.ObjectToJSONRequest_Options{}})
+	if err != nil {
+		return res.Err(fromStatus(err))
 	}
 
-	return res.Ok(rJSON.Unwrap())
+	return res.Ok(r.Object)
 }
 
-func (s *RemoteServer) ObjectSize(ctx context.Context, _ gost.Option[models.UserClaims], object string, opts models.SizeOptions) (res gost.Result[uint64]) {
-	defer after(s, &res)
+func (s *RemoteServer) ObjectSize(ctx context.Context, claims gost.Option[models.UserClaims], object string, opts models.SizeOptions) (res gost.Result[uint64]) {
+	defer after(ctx, s, &res, time.Now())
 
-	rSize := s.sdk.Object(object).Size(ctx, opts.ToSDK())
-	if rSize.IsErr() {
-		return res.Err(rSize.Error())
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
 	}
+	defer c.release()
 
-	return res.Ok(rSize.Unwrap())
+	r, err := c.api.Size(ctx, &api.ObjectSizeRequest{Name: object, Options: &api.ObjectSizeRequest_Options{}})
+	if err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	return res.Ok(r.Size)
 }
 
-func (s *RemoteServer) DeleteObject(ctx context.Context, _ gost.Option[models.UserClaims], object string, opts models.DeleteObjectOptions) (res gost.ResultN) {
-	defer after(s, &res)
+func (s *RemoteServer) DeleteObject(ctx context.Context, claims gost.Option[models.UserClaims], object string, opts models.DeleteObjectOptions) (res gost.ResultN) {
+	defer after(ctx, s, &res, time.Now())
 
-	rDelete := s.sdk.Object(object).DeleteObject(ctx, opts.ToSDK())
-	if rDelete.IsErr() {
-		return res.Err(rDelete.Error())
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	if _, err := c.api.DeleteObject(ctx, &api.DeleteObjectRequest{Object: object, Options: &api.DeleteObjectRequest_Options{}}); err != nil {
+		return res.Err(fromStatus(err))
 	}
 
 	return res.Ok()
 }
 
-func (s *RemoteServer) AttachToObject(ctx context.Context, _ gost.Option[models.UserClaims], dst, src string, opts models.AttachToObjectOptions) (res gost.ResultN) {
-	defer after(s, &res)
+func (s *RemoteServer) AttachToObject(ctx context.Context, claims gost.Option[models.UserClaims], dst, src string, opts models.AttachToObjectOptions) (res gost.ResultN) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
 
-	attachRes := s.sdk.Object(dst).Attach(ctx, src, opts.ToSDK())
-	if attachRes.IsErr() {
-		return res.Err(attachRes.Error())
+	if _, err := c.api.AttachToObject(ctx, &api.AttachToObjectRequest{Dst: dst, Src: src, Options: &api.AttachToObjectRequest_Options{}}); err != nil {
+		return res.Err(fromStatus(err))
 	}
 
 	return res.Ok()
 }
 
-func (s *RemoteServer) ObjectDeleteKey(ctx context.Context, _ gost.Option[models.UserClaims], object, key string, opts models.DeleteAttrOptions) (res gost.ResultN) {
-	defer after(s, &res)
+func (s *RemoteServer) ObjectDeleteKey(ctx context.Context, claims gost.Option[models.UserClaims], object, key string, opts models.DeleteAttrOptions) (res gost.ResultN) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
 
-	rDeleteK := s.sdk.Object(object).DeleteKey(ctx, key, opts.ToSDK())
-	if rDeleteK.IsErr() {
-		return res.Err(rDeleteK.Error())
+	if _, err := c.api.DeleteAttr(ctx, &api.DeleteAttrRequest{Object: object, Key: key, Options: &api.DeleteAttrRequest_Options{}}); err != nil {
+		return res.Err(fromStatus(err))
 	}
 
 	return res.Ok()
 }
 
-func (s *RemoteServer) IsObject(ctx context.Context, _ gost.Option[models.UserClaims], object string, opts models.IsObjectOptions) (res gost.Result[bool]) {
-	defer after(s, &res)
-	return s.sdk.Object(object).Is(ctx)
+func (s *RemoteServer) IsObject(ctx context.Context, claims gost.Option[models.UserClaims], object string, opts models.IsObjectOptions) (res gost.Result[bool]) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	r, err := c.api.IsObject(ctx, &api.IsObjectRequest{Name: object})
+	if err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	return res.Ok(r.Ok)
 }
 
-func (s *RemoteServer) NewUser(ctx context.Context, _ gost.Option[models.UserClaims], user models.User) (res gost.ResultN) {
-	defer after(s, &res)
-	return s.sdk.NewUser(ctx, user.Login, user.Password, itisadb.NewUserOptions{Level: user.Level.ToSDK()})
+// NewUser creates the user on the server, a user that exists already is left as it is.
+func (s *RemoteServer) NewUser(ctx context.Context, claims gost.Option[models.UserClaims], user models.User) (res gost.ResultN) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	_, err := c.api.NewUser(ctx, &api.NewUserRequest{
+		User: &api.User{Login: user.Login, Password: user.Password, Level: uint32(user.Level)},
+	})
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		return res.Err(fromStatus(err))
+	}
+
+	return res.Ok()
 }
 
-func (s *RemoteServer) DeleteUser(ctx context.Context, _ gost.Option[models.UserClaims], login string) (res gost.Result[bool]) {
-	defer after(s, &res)
-	return s.sdk.DeleteUser(ctx, login)
+// DeleteUser deletes the user from the server, false means there was no such user.
+func (s *RemoteServer) DeleteUser(ctx context.Context, claims gost.Option[models.UserClaims], login string) (res gost.Result[bool]) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	_, err := c.api.DeleteUser(ctx, &api.DeleteUserRequest{Login: login})
+	switch {
+	case err == nil:
+		return res.Ok(true)
+	case status.Code(err) == codes.NotFound:
+		return res.Ok(false)
+	default:
+		return res.Err(fromStatus(err))
+	}
 }
 
-func (s *RemoteServer) ChangePassword(ctx context.Context, _ gost.Option[models.UserClaims], login string, password string) (res gost.ResultN) {
-	defer after(s, &res)
-	return s.sdk.ChangePassword(ctx, login, password)
+func (s *RemoteServer) ChangePassword(ctx context.Context, claims gost.Option[models.UserClaims], login string, password string) (res gost.ResultN) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	if _, err := c.api.ChangePassword(ctx, &api.ChangePasswordRequest{Login: login, NewPassword: password}); err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	return res.Ok()
 }
 
-func (s *RemoteServer) ChangeLevel(ctx context.Context, _ gost.Option[models.UserClaims], login string, level models.Level) (res gost.ResultN) {
-	defer after(s, &res)
-	return s.sdk.ChangeLevel(ctx, login, level.ToSDK())
+func (s *RemoteServer) ChangeLevel(ctx context.Context, claims gost.Option[models.UserClaims], login string, level models.Level) (res gost.ResultN) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	if _, err := c.api.ChangeLevel(ctx, &api.ChangeLevelRequest{Login: login, Level: int32(level)}); err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	return res.Ok()
 }
 
 func (s *RemoteServer) GetLastUserChangeID(ctx context.Context) (r gost.Result[uint64]) {
-	defer after(s, &r)
-	return itisadb.Internal.GetLastUserChangeID(ctx, s.sdk)
+	defer after(ctx, s, &r, time.Now())
+
+	c, ctx, errX := s.conn(ctx)
+	if errX != nil {
+		return r.Err(errX)
+	}
+	defer c.release()
+
+	resp, err := c.api.GetLastUserChangeID(ctx, &api.GetLastUserChangeIDRequest{})
+	if err != nil {
+		return r.Err(fromStatus(err))
+	}
+
+	return r.Ok(resp.LastChangeID)
 }
 
 func (s *RemoteServer) Sync(ctx context.Context, syncID uint64, users []models.User) (r gost.ResultN) {
-	defer after(s, &r)
-	return itisadb.Internal.Sync(ctx, s.sdk, syncID, fromUsersToInternalUsersSDK(users))
+	defer after(ctx, s, &r, time.Now())
+
+	c, ctx, errX := s.conn(ctx)
+	if errX != nil {
+		return r.Err(errX)
+	}
+	defer c.release()
+
+	if _, err := c.api.Sync(ctx, &api.SyncData{Users: toAPIUsers(users), SyncID: syncID}); err != nil {
+		return r.Err(fromStatus(err))
+	}
+
+	return r.Ok()
 }
 
-func fromUsersToInternalUsersSDK(users []models.User) []itisadb.Internal_User {
-	var sdkUsers []itisadb.Internal_User
+func toAPIUsers(users []models.User) []*api.User {
+	apiUsers := make([]*api.User, 0, len(users))
 	for _, user := range users {
-		sdkUsers = append(sdkUsers, itisadb.Internal_User{
+		apiUsers = append(apiUsers, &api.User{
 			Login:    user.Login,
 			Password: user.Password,
-			Level:    user.Level.ToSDK(),
+			Level:    uint32(user.Level),
 			Active:   user.Active,
 		})
 	}
-	return sdkUsers
-
+	return apiUsers
 }
 
 func (s *RemoteServer) Address() string {
 	return s.address
 }
+
+// conn is a connection of the pool taken for one request, release gives it back.
+type conn struct {
+	api     api.ItisaDBClient
+	cluster cluster.ClusterClient
+	release func()
+}
+
+// conn takes a connection to the server and returns ctx with the token of the balancer,
+// which authenticates the request.
+func (s *RemoteServer) conn(ctx context.Context) (conn, context.Context, *gost.ErrX) {
+	s.connsMu.RLock()
+	defer s.connsMu.RUnlock()
+
+	if s.conns == nil {
+		return conn{}, nil, itisadb.ErrUnavailable
+	}
+
+	cc, release := s.conns.Acquire()
+	c := conn{api: api.NewItisaDBClient(cc), cluster: cluster.NewClusterClient(cc), release: release}
+
+	return c, metadata.AppendToOutgoingContext(ctx, "token", s.token), nil
+}
+
+// withCaller takes a connection like conn does and adds the signed caller, whose level the server checks.
+func (s *RemoteServer) withCaller(ctx context.Context, claims gost.Option[models.UserClaims]) (conn, context.Context, *gost.ErrX) {
+	c, ctx, errX := s.conn(ctx)
+	if errX != nil {
+		return conn{}, nil, errX
+	}
+
+	ctx, errX = s.forward(ctx, claims)
+	if errX != nil {
+		c.release()
+		return conn{}, nil, errX
+	}
+
+	return c, ctx, nil
+}
+
+// forward adds the signed caller to the metadata of ctx. The claims without an ID are the balancer
+// itself, e.g. the rebalancer, so nothing is forwarded and the request is made with the token of the balancer.
+func (s *RemoteServer) forward(ctx context.Context, claims gost.Option[models.UserClaims]) (context.Context, *gost.ErrX) {
+	if claims.IsSome() && claims.Unwrap().ID == "" {
+		return ctx, nil
+	}
+
+	caller, err := s.session.Forward(ctx, claims)
+	if err != nil {
+		return nil, gost.NewErrX(0, "can't forward the caller").ExtendMsg(err.Error())
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, constants.CallerKey, caller), nil
+}
+
+func (s *RemoteServer) Scan(ctx context.Context, f func(models.Entry) error) (res gost.ResultN) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.conn(ctx)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	stream, err := c.cluster.Scan(ctx, &cluster.ScanRequest{})
+	if err != nil {
+		return res.Err(gost.NewErrX(0, err.Error()))
+	}
+
+	for {
+		e, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return res.Ok()
+		}
+		if err != nil {
+			return res.Err(gost.NewErrX(0, err.Error()))
+		}
+
+		entry := models.Entry{
+			Kind:   models.EntryKind(e.Kind),
+			Object: e.Object,
+			Key:    e.Key,
+			Value:  models.Value{ReadOnly: e.ReadOnly, Level: models.Level(e.Level), Value: e.Value},
+		}
+
+		if err := f(entry); err != nil {
+			// the error comes from the caller, not from the server
+			return res.Err(gost.NewErrX(1, err.Error()))
+		}
+	}
+}
+
+// MerkleTree asks the server for the tree over its values in the ranges.
+func (s *RemoteServer) MerkleTree(ctx context.Context, ranges merkle.Ranges, depth int) (res gost.Result[*merkle.Tree]) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.conn(ctx)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	resp, err := c.cluster.MerkleTree(ctx, &cluster.MerkleTreeRequest{Ranges: clusterRanges(ranges), Depth: depth})
+	if err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	tree := new(merkle.Tree)
+	if err := tree.UnmarshalBinary(resp.Tree); err != nil {
+		return res.Err(gost.NewErrX(0, err.Error()))
+	}
+
+	return res.Ok(tree)
+}
+
+func (s *RemoteServer) MerkleLeaves(ctx context.Context, ranges merkle.Ranges, depth int, leaves []int) (res gost.Result[[]models.Entry]) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.conn(ctx)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	resp, err := c.cluster.MerkleLeaves(ctx, &cluster.MerkleLeavesRequest{Ranges: clusterRanges(ranges), Depth: depth, Leaves: leaves})
+	if err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	entries := make([]models.Entry, len(resp.Entries))
+	for i, e := range resp.Entries {
+		entries[i] = models.Entry{
+			Kind:  models.EntryKind(e.Kind),
+			Key:   e.Key,
+			Value: models.Value{ReadOnly: e.ReadOnly, Level: models.Level(e.Level), Value: e.Value},
+		}
+	}
+
+	return res.Ok(entries)
+}
+
+func clusterRanges(ranges merkle.Ranges) []cluster.Range {
+	res := make([]cluster.Range, len(ranges))
+	for i, r := range ranges {
+		res[i] = cluster.Range{From: r.From, To: r.To}
+	}
+
+	return res
+}
+
+// MGet reads the keys with one request to the Cluster service of the server.
+func (s *RemoteServer) MGet(ctx context.Context, claims gost.Option[models.UserClaims], keys []string, opt models.GetOptions) (res gost.Result[[]models.GetResult]) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	// the node serves the batch from its own storage, its balancer does not route it again
+	resp, err := c.cluster.MGet(ctx, &cluster.MGetRequest{Keys: keys, Server: constants.LocalServerNumber})
+	if err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	results := make([]models.GetResult, len(resp.Values))
+	for i, v := range resp.Values {
+		results[i] = models.GetResult{
+			Key:   v.Key,
+			Value: models.Value{ReadOnly: v.ReadOnly, Level: models.Level(v.Level), Value: v.Value},
+			Err:   fromMessages(v.Error),
+		}
+	}
+
+	return res.Ok(results)
+}
+
+// MSet writes the values with one request to the Cluster service of the server.
+func (s *RemoteServer) MSet(ctx context.Context, claims gost.Option[models.UserClaims], values map[string]string, opt models.SetOptions) (res gost.Result[[]models.SetResult]) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	resp, err := c.cluster.MSet(ctx, &cluster.MSetRequest{
+		Values:   values,
+		Server:   constants.LocalServerNumber,
+		Level:    uint8(opt.Level),
+		ReadOnly: opt.ReadOnly,
+		Unique:   opt.Unique,
+	})
+	if err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	results := make([]models.SetResult, len(resp.Values))
+	written := make([]string, 0, len(resp.Values))
+	for i, v := range resp.Values {
+		// the number the server gave itself means nothing to the balancer
+		results[i] = models.SetResult{Key: v.Key, Server: s.number, Err: fromMessages(v.Error)}
+		if results[i].Err == nil {
+			written = append(written, v.Key)
+		}
+	}
+	s.addKeys(written...)
+
+	return res.Ok(results)
+}
+
+// Prepare asks the server to get ready to commit its part of the transaction,
+// the keys it sets are added to the key filter before it is known whether tx commits.
+func (s *RemoteServer) Prepare(ctx context.Context, claims gost.Option[models.UserClaims], tx models.Transaction) (res gost.ResultN) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.withCaller(ctx, claims)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	req := &cluster.PrepareRequest{
+		ID:       tx.ID,
+		Ops:      make([]cluster.TxOp, len(tx.Ops)),
+		Level:    uint8(tx.Opts.Level),
+		ReadOnly: tx.Opts.ReadOnly,
+		Unique:   tx.Opts.Unique,
+	}
+
+	var written []string
+	for i, op := range tx.Ops {
+		req.Ops[i] = cluster.TxOp{Key: op.Key, Value: op.Value, Delete: op.Delete}
+		if !op.Delete {
+			written = append(written, op.Key)
+		}
+	}
+
+	resp, err := c.cluster.Prepare(ctx, req)
+	if err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	if len(resp.Error) != 0 {
+		// fromMessages makes an ErrX of the messages
+		return res.Err(fromMessages(resp.Error).(*gost.ErrX))
+	}
+
+	s.addKeys(written...)
+
+	return res.Ok()
+}
+
+func (s *RemoteServer) CommitPrepared(ctx context.Context, id string) (res gost.ResultN) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.conn(ctx)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	if _, err := c.cluster.CommitPrepared(ctx, &cluster.ResolveRequest{ID: id}); err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	return res.Ok()
+}
+
+func (s *RemoteServer) AbortPrepared(ctx context.Context, id string) (res gost.ResultN) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.conn(ctx)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	if _, err := c.cluster.AbortPrepared(ctx, &cluster.ResolveRequest{ID: id}); err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	return res.Ok()
+}
+
+func (s *RemoteServer) InDoubt(ctx context.Context) (res gost.Result[[]string]) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.conn(ctx)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	resp, err := c.cluster.InDoubt(ctx, &cluster.InDoubtRequest{})
+	if err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	return res.Ok(resp.IDs)
+}
+
+// fromStatus turns a gRPC error into the error the SDK would return for it,
+// so the callers see the same errors from the remote servers as from the SDK.
+func fromStatus(err error) *gost.ErrX {
+	st, ok := status.FromError(err)
+	if !ok {
+		return gost.NewErrX(0, "grpc dial failed").ExtendMsg(err.Error())
+	}
+
+	switch st.Code() {
+	case codes.NotFound:
+		return itisadb.ErrNotFound
+	case codes.Unavailable:
+		return itisadb.ErrUnavailable
+	case codes.ResourceExhausted:
+		return itisadb.ErrObjectNotFound
+	case codes.AlreadyExists:
+		return itisadb.ErrUniqueConstraint
+	case codes.Unauthenticated:
+		return itisadb.ErrUnauthorized
+	case codes.PermissionDenied:
+		return itisadb.ErrPermissionDenied
+	case codes.Canceled:
+		return itisadb.ErrContextCanceled
+	}
+
+	return gost.NewErrX(0, "unknown error").ExtendMsg(err.Error())
+}
+
+// fromMessages restores the error of a key of a batch from its messages, the root message comes first.
+func fromMessages(messages []string) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	err := gost.NewErrX(0, messages[0])
+	for _, msg := range messages[1:] {
+		err = err.ExtendMsg(msg)
+	}
+
+	return err
+}
+
+// NodeStats asks the Cluster service of the server about its storage.
+func (s *RemoteServer) NodeStats(ctx context.Context) (res gost.Result[models.NodeStats]) {
+	defer after(ctx, s, &res, time.Now())
+
+	c, ctx, errX := s.conn(ctx)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	resp, err := c.cluster.NodeStats(ctx, &cluster.NodeStatsRequest{})
+	if err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	return res.Ok(models.NodeStats{Keys: resp.Keys, Objects: resp.Objects, Role: resp.Role})
+}
+
+// MayHave asks the last pulled key filter of the server. Until the first pull every key may be there.
+func (s *RemoteServer) MayHave(key string) bool {
+	s.filterMu.RLock()
+	defer s.filterMu.RUnlock()
+
+	return s.filter == nil || s.filter.MayContain(key)
+}
+
+// RefreshKeyFilter pulls the key filter of the server. The breaker is left to RefreshRAM,
+// so a server without the KeyFilter method keeps answering MayHave with true.
+func (s *RemoteServer) RefreshKeyFilter(ctx context.Context) (res gost.ResultN) {
+	c, ctx, errX := s.conn(ctx)
+	if errX != nil {
+		return res.Err(errX)
+	}
+	defer c.release()
+
+	resp, err := c.cluster.KeyFilter(ctx, &cluster.KeyFilterRequest{})
+	if err != nil {
+		return res.Err(fromStatus(err))
+	}
+
+	f := new(bloom.Filter)
+	if err := f.UnmarshalBinary(resp.Filter); err != nil {
+		return res.Err(gost.NewErrX(0, err.Error()))
+	}
+
+	s.filterMu.Lock()
+	s.filter = f
+	s.filterMu.Unlock()
+
+	return res.Ok()
+}
+
+// addKeys adds the keys the balancer wrote to the server to the pulled filter,
+// so they are found before the next pull.
+func (s *RemoteServer) addKeys(keys ...string) {
+	s.filterMu.Lock()
+	defer s.filterMu.Unlock()
+
+	if s.filter == nil {
+		return
+	}
+
+	for _, key := range keys {
+		s.filter.Add(key)
+	}
+}
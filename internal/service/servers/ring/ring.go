@@ -0,0 +1,162 @@
+package ring
+
+import (
+	"hash/fnv"
+	"slices"
+	"strconv"
+)
+
+// DefaultVirtualNodes is the number of points a server has on the ring when the config does not set it.
+const DefaultVirtualNodes = 128
+
+type point struct {
+	hash   uint64
+	server int32
+}
+
+// Ring is a consistent-hash ring with virtual nodes.
+// Adding or removing a server moves only the keys of the ring segments it owns.
+// Ring is not safe for concurrent use.
+type Ring struct {
+	vnodes int
+	points []point
+}
+
+func New(vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+
+	return &Ring{vnodes: vnodes}
+}
+
+// Hash returns the point of key on the ring, the nodes digest their keys by it for the anti-entropy.
+func Hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	// fnv spreads short similar strings poorly, so the result is mixed once more (splitmix64 finalizer).
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
+
+// Add places the virtual nodes of server on the ring.
+func (r *Ring) Add(server int32) {
+	if r.Has(server) {
+		return
+	}
+
+	prefix := strconv.Itoa(int(server)) + "#"
+	for i := 0; i < r.vnodes; i++ {
+		r.points = append(r.points, point{hash: Hash(prefix + strconv.Itoa(i)), server: server})
+	}
+
+	slices.SortFunc(r.points, func(a, b point) int {
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		}
+		return int(a.server - b.server)
+	})
+}
+
+// Remove takes server off the ring.
+func (r *Ring) Remove(server int32) {
+	r.points = slices.DeleteFunc(r.points, func(p point) bool {
+		return p.server == server
+	})
+}
+
+func (r *Ring) Has(server int32) bool {
+	return slices.ContainsFunc(r.points, func(p point) bool {
+		return p.server == server
+	})
+}
+
+// Owners returns the distinct servers that follow key on the ring, the owner first.
+// Servers rejected by accept are skipped, at most n servers are returned.
+func (r *Ring) Owners(key string, n int, accept func(server int32) bool) []int32 {
+	if len(r.points) == 0 || n <= 0 {
+		return nil
+	}
+
+	h := Hash(key)
+	start, _ := slices.BinarySearchFunc(r.points, h, func(p point, h uint64) int {
+		switch {
+		case p.hash < h:
+			return -1
+		case p.hash > h:
+			return 1
+		}
+		return 0
+	})
+
+	return r.ownersFrom(start, n, accept)
+}
+
+func (r *Ring) ownersFrom(start, n int, accept func(server int32) bool) []int32 {
+	owners := make([]int32, 0, n)
+	for i := 0; i < len(r.points) && len(owners) < n; i++ {
+		server := r.points[(start+i)%len(r.points)].server
+		if slices.Contains(owners, server) || (accept != nil && !accept(server)) {
+			continue
+		}
+		owners = append(owners, server)
+	}
+
+	return owners
+}
+
+// Segment is a range of the points on the ring, From and To included, and the servers that keep its keys.
+type Segment struct {
+	From, To uint64
+	Servers  []int32
+}
+
+// Segments splits the ring at the points of the servers. The servers of a segment are
+// the ones Owners returns for its keys, the segments are sorted.
+// The keys past the last point belong to the first one, so its servers keep the last segment too.
+func (r *Ring) Segments(n int, accept func(server int32) bool) []Segment {
+	if len(r.points) == 0 || n <= 0 {
+		return nil
+	}
+
+	segments := make([]Segment, 0, len(r.points)+1)
+	for i, p := range r.points {
+		servers := r.ownersFrom(i, n, accept)
+
+		if i == 0 {
+			segments = append(segments, Segment{From: 0, To: p.hash, Servers: servers})
+			continue
+		}
+
+		// the points of two servers may share a hash
+		if prev := r.points[i-1].hash; prev != p.hash {
+			segments = append(segments, Segment{From: prev + 1, To: p.hash, Servers: servers})
+		}
+	}
+
+	if last := r.points[len(r.points)-1].hash; last != ^uint64(0) {
+		segments = append(segments, Segment{From: last + 1, To: ^uint64(0), Servers: segments[0].Servers})
+	}
+
+	return segments
+}
+
+// Owner returns the server that owns key.
+func (r *Ring) Owner(key string, accept func(server int32) bool) (int32, bool) {
+	owners := r.Owners(key, 1, accept)
+	if len(owners) == 0 {
+		return 0, false
+	}
+
+	return owners[0], true
+}
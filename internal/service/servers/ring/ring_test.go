@@ -0,0 +1,154 @@
+package ring
+
+import (
+	"fmt"
+	"testing"
+)
+
+func keys(n int) []string {
+	k := make([]string, n)
+	for i := range k {
+		k[i] = fmt.Sprintf("key%d", i)
+	}
+	return k
+}
+
+func owners(r *Ring, keys []string) map[string]int32 {
+	m := make(map[string]int32, len(keys))
+	for _, k := range keys {
+		s, ok := r.Owner(k, nil)
+		if !ok {
+			panic("empty ring")
+		}
+		m[k] = s
+	}
+	return m
+}
+
+func TestRing(t *testing.T) {
+	const n = 10_000
+
+	r := New(0)
+	if _, ok := r.Owner("key", nil); ok {
+		t.Fatal("empty ring has an owner")
+	}
+
+	for s := int32(1); s <= 4; s++ {
+		r.Add(s)
+	}
+	r.Add(1)
+
+	before := owners(r, keys(n))
+
+	t.Run("balance", func(t *testing.T) {
+		count := make(map[int32]int)
+		for _, s := range before {
+			count[s]++
+		}
+
+		for s := int32(1); s <= 4; s++ {
+			if c := count[s]; c < n/4/2 || c > n/4*2 {
+				t.Errorf("server %d owns %d of %d keys", s, c, n)
+			}
+		}
+	})
+
+	t.Run("stable", func(t *testing.T) {
+		other := New(DefaultVirtualNodes)
+		for s := int32(4); s >= 1; s-- {
+			other.Add(s)
+		}
+
+		for k, s := range owners(other, keys(n)) {
+			if before[k] != s {
+				t.Fatalf("%s: owner depends on the order servers were added: %d != %d", k, before[k], s)
+			}
+		}
+	})
+
+	t.Run("join", func(t *testing.T) {
+		r.Add(5)
+		defer r.Remove(5)
+
+		moved := 0
+		for k, s := range owners(r, keys(n)) {
+			if s == before[k] {
+				continue
+			}
+			if s != 5 {
+				t.Fatalf("%s moved from %d to %d, not to the new server", k, before[k], s)
+			}
+			moved++
+		}
+
+		if moved == 0 || moved > n/5*2 {
+			t.Errorf("%d of %d keys moved to the new server", moved, n)
+		}
+	})
+
+	t.Run("leave", func(t *testing.T) {
+		r.Remove(2)
+		defer r.Add(2)
+
+		for k, s := range owners(r, keys(n)) {
+			if before[k] != 2 && s != before[k] {
+				t.Fatalf("%s moved from %d to %d, but its owner stayed", k, before[k], s)
+			}
+			if s == 2 {
+				t.Fatalf("%s is owned by the removed server", k)
+			}
+		}
+	})
+
+	t.Run("owners", func(t *testing.T) {
+		got := r.Owners("key", 3, func(s int32) bool { return s != before["key"] })
+		if len(got) != 3 {
+			t.Fatalf("got %v, want 3 distinct servers", got)
+		}
+
+		seen := make(map[int32]bool)
+		for _, s := range got {
+			if s == before["key"] || seen[s] {
+				t.Fatalf("got %v", got)
+			}
+			seen[s] = true
+		}
+
+		if got := r.Owners("key", 10, nil); len(got) != 4 {
+			t.Errorf("got %v, want every server once", got)
+		}
+	})
+}
+
+func TestSegments(t *testing.T) {
+	r := New(16)
+	for s := int32(1); s <= 3; s++ {
+		r.Add(s)
+	}
+
+	segments := r.Segments(2, nil)
+
+	var next uint64
+	for i, seg := range segments {
+		if seg.From != next {
+			t.Fatalf("segment %d starts at %d, want %d", i, seg.From, next)
+		}
+		next = seg.To + 1
+	}
+	if segments[len(segments)-1].To != ^uint64(0) {
+		t.Fatal("the segments don't reach the end of the ring")
+	}
+
+	for _, key := range keys(1000) {
+		h := Hash(key)
+		for _, seg := range segments {
+			if h < seg.From || h > seg.To {
+				continue
+			}
+
+			if want := r.Owners(key, 2, nil); fmt.Sprint(seg.Servers) != fmt.Sprint(want) {
+				t.Errorf("segment of %s is kept by %v, want %v", key, seg.Servers, want)
+			}
+		}
+	}
+}
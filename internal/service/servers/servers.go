@@ -2,44 +2,117 @@ package servers
 
 import (
 	"bufio"
+	"cmp"
 	"context"
+	"expvar"
 	"fmt"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"itisadb/config"
 	"itisadb/internal/constants"
 	"itisadb/internal/domains"
 	"itisadb/internal/models"
+	"itisadb/internal/service/servers/health"
+	"itisadb/internal/service/servers/placement"
+	"itisadb/internal/service/servers/pool"
+	"itisadb/internal/service/servers/ring"
 
 	"github.com/egorgasay/gost"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
+var _current atomic.Pointer[Servers]
+
+// _deepSearch counts the searches of the keys the balancer doesn't know the server of,
+// the servers they queried or skipped by the key filters and the searches that asked the skipped ones after all.
+var _deepSearch = expvar.NewMap("deep_search")
+
+func init() {
+	expvar.Publish("servers_health", expvar.Func(func() any {
+		s := _current.Load()
+		if s == nil {
+			return nil
+		}
+
+		return s.healthStats()
+	}))
+}
+
 type Servers struct {
 	servers map[int32]domains.Server
 	poolCh  chan struct{}
 	freeID  int32
 	logger  *zap.Logger
+
+	// ring places keys by their hash, it is nil in the RAM placement mode.
+	ring *ring.Ring
+
+	// leaving servers get no new data while it is moved off them.
+	leaving map[int32]struct{}
+	// servers in maintenance get no new data, but keep serving what they hold.
+	maintenance map[int32]struct{}
+
+	strategy domains.PlacementStrategy
+	// options are the weights and labels of the servers by their addresses.
+	options map[string]config.ServerOptions
+
+	health config.HealthConfig
+	conns  config.ConnectionsConfig
+	// session signs the callers forwarded to the remote servers.
+	session domains.Session
+
+	// meta is shared with the other balancers, the servers and their numbers come from it when it is set.
+	meta gost.Option[domains.Metadata]
+
 	sync.RWMutex
 }
 
-func New(addresses []string, local gost.Option[domains.Server], logger *zap.Logger) (*Servers, error) {
-	f, err := os.OpenFile("servers", os.O_TRUNC|os.O_CREATE|os.O_RDWR, 0644)
+// _joinTimeout limits the addition of the servers from the config when the metadata is shared,
+// it waits for the balancers to elect a leader.
+const _joinTimeout = time.Minute
+
+func New(cfg config.BalancerConfig, local gost.Option[domains.Server], session domains.Session, meta gost.Option[domains.Metadata], logger *zap.Logger) (*Servers, error) {
+	var hashRing *ring.Ring
+	switch cfg.Placement {
+	case "", config.RAMPlacement:
+	case config.HashPlacement:
+		hashRing = ring.New(cfg.VirtualNodes)
+	default:
+		return nil, fmt.Errorf("unknown placement %q", cfg.Placement)
+	}
+
+	switch cfg.Connections.Compression {
+	case "", config.GzipCompression:
+	default:
+		return nil, fmt.Errorf("unknown compression %q", cfg.Connections.Compression)
+	}
+
+	strategy, err := placement.New(cfg.Strategy)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+
+	options := make(map[string]config.ServerOptions, len(cfg.ServerOptions))
+	for _, opts := range cfg.ServerOptions {
+		options[opts.Address] = opts
+	}
 
 	s := make(map[int32]domains.Server, 10)
 
 	if local.IsSome() {
 		serv := local.Unwrap()
 		s[serv.Number()] = serv
+
+		if hashRing != nil {
+			hashRing.Add(serv.Number())
+		}
 	}
 
 	maxProc := runtime.GOMAXPROCS(0) * 100 // TODO: make it configurable
@@ -49,11 +122,39 @@ func New(addresses []string, local gost.Option[domains.Server], logger *zap.Logg
 		freeID:  2,
 		poolCh:  make(chan struct{}, maxProc),
 		logger:  logger,
+		ring:    hashRing,
+		leaving: make(map[int32]struct{}),
+
+		maintenance: make(map[int32]struct{}),
+
+		strategy: strategy,
+		options:  options,
+
+		health:  health.WithDefaults(cfg.Health),
+		conns:   pool.WithDefaults(cfg.Connections),
+		session: session,
+		meta:    meta,
+	}
+
+	if meta.IsSome() {
+		meta.Unwrap().Watch(servers.follow)
+		go servers.join(cfg.Servers)
+
+		_current.Store(servers)
+		go servers.checkHealth()
+
+		return servers, nil
 	}
 
+	f, err := os.OpenFile("servers", os.O_TRUNC|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
 	ctx := context.Background()
 
-	for _, server := range addresses {
+	for _, server := range cfg.Servers {
 		logger.Info("Adding server", zap.String("server", server))
 
 		func() {
@@ -69,8 +170,9 @@ func New(addresses []string, local gost.Option[domains.Server], logger *zap.Logg
 		}()
 	}
 
+	_current.Store(servers)
 
-	go servers.updateRAM()
+	go servers.checkHealth()
 
 	scanner := bufio.NewScanner(f)
 	if !scanner.Scan() {
@@ -89,56 +191,194 @@ func New(addresses []string, local gost.Option[domains.Server], logger *zap.Logg
 	return servers, nil
 }
 
-const _updateRAMInterval = 5 * time.Second
+// healthStats returns the breaker state of each remote server by its number.
+func (s *Servers) healthStats() map[string]any {
+	s.RLock()
+	defer s.RUnlock()
 
-func (s *Servers) updateRAM() {
-	for { // Бесконечный цикл
-		for _, cl := range s.servers { // Цикл по всем доступным серверам
-			func() {
-				ctx, cancel := context.WithTimeout(context.Background(), constants.ServerConnectTimeout)
-				defer cancel()
+	stats := make(map[string]any, len(s.servers))
+	for num, cl := range s.servers {
+		p, ok := cl.(prober)
+		if !ok {
+			continue
+		}
 
-				 // Проверка последнего состояния сервера (ответил на запросы или нет)
-				if cl.IsOffline() {
-					// Попытка переподключиться к серверу
-					if r := cl.Reconnect(ctx); r.IsErr() {
-						s.logger.Warn("can't reconnect", zap.Int32("server", cl.Number()), zap.Error(r.Error()))
-						return
-					}
-				}
+		st := p.Health().Stats()
+		stats[strconv.Itoa(int(num))] = map[string]any{
+			"address":    cl.Address(),
+			"state":      st.State.String(),
+			"failures":   st.Failures,
+			"changes":    st.Changes,
+			"since":      st.Since,
+			"next_probe": st.NextProbe,
+		}
+	}
+
+	return stats
+}
 
-				// Обновление значений
-				if r := cl.RefreshRAM(ctx); r.IsErr() {
-					s.logger.Error("can't refresh RAM", zap.Int32("server", cl.Number()), zap.Error(r.Error()))
+// prober is a remote server, the health checker probes it while it is offline.
+type prober interface {
+	Health() *health.Breaker
+	Latency() *health.Latency
+}
+
+// checkHealth refreshes the RAM of the online servers every Interval and probes the offline ones
+// when their backoff is over. The key filters are pulled with the RAM every KeyFilterInterval.
+// The servers are checked in parallel, so a slow one doesn't delay the rest.
+func (s *Servers) checkHealth() {
+	tick := min(s.health.Interval, s.health.MinBackoff)
+	checked := make(map[int32]time.Time)
+	filtered := make(map[int32]time.Time)
+
+	for ; ; time.Sleep(tick) {
+		var due []domains.Server
+
+		s.RLock()
+		for num, cl := range s.servers {
+			p, ok := cl.(prober)
+			switch {
+			case ok && p.Health().State() != health.Closed:
+				if p.Health().ProbeDue() {
+					due = append(due, cl)
 				}
-			}()
+			case time.Since(checked[num]) >= s.health.Interval:
+				due = append(due, cl)
+			}
+		}
+		s.RUnlock()
+
+		var wg sync.WaitGroup
+		for _, cl := range due {
+			checked[cl.Number()] = time.Now()
+
+			filter := s.health.KeyFilterInterval > 0 && time.Since(filtered[cl.Number()]) >= s.health.KeyFilterInterval
+			if filter {
+				filtered[cl.Number()] = time.Now()
+			}
+
+			wg.Add(1)
+			go func(cl domains.Server, filter bool) {
+				defer wg.Done()
+				s.check(cl, filter)
+			}(cl, filter)
+		}
+		wg.Wait()
+	}
+}
+
+// check refreshes the RAM of the server and, if filter is set, its key filter.
+// An offline server is reconnected first. The result goes to the breaker of the server
+// like the result of any other request.
+func (s *Servers) check(cl domains.Server, filter bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.health.Timeout)
+	defer cancel()
 
+	if cl.IsOffline() {
+		if r := cl.Reconnect(ctx); r.IsErr() {
+			if p, ok := cl.(prober); ok {
+				p.Health().Failure()
+			}
+
+			s.logger.Debug("can't reconnect", zap.Int32("server", cl.Number()), zap.Error(r.Error()))
+			return
 		}
-		time.Sleep(_updateRAMInterval)
 	}
+
+	if r := cl.RefreshRAM(ctx); r.IsErr() {
+		s.logger.Warn("can't refresh RAM", zap.Int32("server", cl.Number()), zap.Error(r.Error()))
+		return
+	}
+
+	if !filter {
+		return
+	}
+
+	if r := cl.RefreshKeyFilter(ctx); r.IsErr() {
+		s.logger.Warn("can't refresh key filter", zap.Int32("server", cl.Number()), zap.Error(r.Error()))
+	}
+}
+
+// Place chooses a server for new data with the placement strategy.
+// The hint, e.g. "zone=b", leaves only the servers with these labels to choose from.
+func (s *Servers) Place(hint string) (res gost.Result[domains.Server]) {
+	r := s.PlaceN(hint, 1)
+	if r.IsErr() {
+		return res.Err(r.Error())
+	}
+
+	return res.Ok(r.Unwrap()[0])
 }
 
-func (s *Servers) getServer() (domains.Server, bool) {
+// PlaceN chooses up to n different servers for new data, like Place does it for one.
+// It returns fewer servers when there are not enough of them to choose from.
+func (s *Servers) PlaceN(hint string, n int) (res gost.Result[[]domains.Server]) {
+	labels, err := placement.ParseHint(hint)
+	if err != nil {
+		return res.Err(constants.ErrInvalidHint.ExtendMsg(err.Error()))
+	}
+
 	s.RLock()
 	defer s.RUnlock()
 
-	best := 0.0
-	var serverNumber int32 = 0
-
+	candidates := make([]models.PlacementCandidate, 0, len(s.servers))
 	for num, cl := range s.servers {
-		if cl.IsOffline() {
+		if !s.placeable(num) || cl.IsOffline() {
 			continue
 		}
 
-		r := cl.RAM()
-		if val := float64(r.Available) / float64(r.Total) * 100; val > best {
-			serverNumber = num
-			best = val
+		opts := s.serverOptions(cl)
+		if !placement.Matches(opts.Labels, labels) {
+			continue
+		}
+
+		candidates = append(candidates, models.PlacementCandidate{
+			Number: num,
+			RAM:    cl.RAM(),
+			Weight: opts.Weight,
+			Labels: opts.Labels,
+		})
+	}
+
+	var chosen []domains.Server
+	for len(chosen) < n && len(candidates) > 0 {
+		number := s.strategy.Pick(candidates)
+		if number.IsNone() {
+			break
+		}
+
+		chosen = append(chosen, s.servers[number.Unwrap()])
+		candidates = slices.DeleteFunc(candidates, func(c models.PlacementCandidate) bool {
+			return c.Number == number.Unwrap()
+		})
+	}
+
+	if len(chosen) == 0 {
+		if hint != "" {
+			return res.Err(constants.ErrNoPlacement.ExtendMsg(fmt.Sprintf("placement hint %q", hint)))
 		}
+
+		return res.Err(constants.ErrNoPlacement)
+	}
+
+	return res.Ok(chosen)
+}
+
+// serverOptions returns the weight and the labels of the server. The caller holds the lock.
+func (s *Servers) serverOptions(cl domains.Server) config.ServerOptions {
+	address := cl.Address()
+	if cl.Number() == constants.LocalServerNumber {
+		address = config.LocalServerAddress
 	}
 
-	cl, ok := s.servers[serverNumber]
-	return cl, ok
+	return s.options[address]
+}
+
+// placeable reports whether new data may be put on the server. The caller holds the lock.
+func (s *Servers) placeable(number int32) bool {
+	_, leaving := s.leaving[number]
+	_, maintenance := s.maintenance[number]
+	return !leaving && !maintenance
 }
 
 func (s *Servers) Len() int32 {
@@ -150,6 +390,15 @@ func (s *Servers) Len() int32 {
 var ErrInternal = errors.New("internal error")
 
 func (s *Servers) AddServer(ctx context.Context, address string, force bool) (int32, error) {
+	return s.addServer(ctx, address, force, true)
+}
+
+// addServer connects the server, with save its address is saved to the servers file.
+func (s *Servers) addServer(ctx context.Context, address string, force, save bool) (int32, error) {
+	if s.meta.IsSome() {
+		return s.addMember(ctx, address, force, save)
+	}
+
 	s.Lock()
 	defer s.Unlock()
 
@@ -158,14 +407,13 @@ func (s *Servers) AddServer(ctx context.Context, address string, force bool) (in
 
 	// add test connection
 
-	stClient, err := NewRemoteServer(ctx, address, server, s.logger)
+	// a server that can't be reached is added offline when forced, the health checker probes it
+	stClient, err := NewRemoteServer(ctx, address, server, s.health, s.conns, s.session, s.logger)
 	if err != nil {
 		s.logger.Error("can't add server", zap.Int32("server", server), zap.Error(err))
 		if !force {
 			return 0, err
-		} 
-
-		stClient.tries.Add(constants.MaxServerTries)
+		}
 	}
 
 	var addresses = make([]string, 0, len(s.servers))
@@ -195,45 +443,422 @@ func (s *Servers) AddServer(ctx context.Context, address string, force bool) (in
 		return 0, errors.Wrapf(ErrInternal, "can't save last id: %v", err.Error())
 	}
 
-	if err := config.UpdateServers(addresses); err != nil {
-		return 0, errors.Wrapf(ErrInternal, "can't update config: %v", err.Error())
+	if save {
+		if err := config.UpdateServers(addresses); err != nil {
+			return 0, errors.Wrapf(ErrInternal, "can't update config: %v", err.Error())
+		}
 	}
 
 	s.servers[server] = stClient
 
+	if s.ring != nil {
+		s.ring.Add(server)
+	}
+
 	return server, nil
 }
 
+// addMember adds the server to the metadata the balancers share, the number comes from there.
+// No lock is held while the change is replicated.
+func (s *Servers) addMember(ctx context.Context, address string, force, save bool) (int32, error) {
+	meta := s.meta.Unwrap()
+
+	for _, addr := range meta.Members() {
+		if addr == address {
+			return 0, constants.ErrAlreadyExists
+		}
+	}
+
+	r := meta.AddMember(ctx, address)
+	if r.IsErr() {
+		return 0, r.Error()
+	}
+	number := r.Unwrap()
+
+	if err := s.connect(ctx, number, address, force); err != nil {
+		if rm := meta.RemoveMember(ctx, number); rm.IsErr() {
+			s.logger.Error("can't remove the server that failed to connect", zap.Int32("server", number), zap.Error(rm.Error()))
+		}
+
+		return 0, err
+	}
+
+	if save {
+		if err := config.UpdateServers(s.addresses()); err != nil {
+			return 0, errors.Wrapf(ErrInternal, "can't update config: %v", err.Error())
+		}
+	}
+
+	return number, nil
+}
+
+// join adds the servers from the config that are not members yet.
+func (s *Servers) join(addresses []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), _joinTimeout)
+	defer cancel()
+
+	for _, address := range addresses {
+		r := s.meta.Unwrap().AddMember(ctx, address)
+		if r.IsErr() {
+			s.logger.Error("Failed to add server", zap.String("server", address), zap.Error(r.Error()))
+			continue
+		}
+
+		if err := s.connect(ctx, r.Unwrap(), address, true); err != nil {
+			s.logger.Error("Failed to add server", zap.String("server", address), zap.Error(err))
+		}
+	}
+}
+
+// follow connects the members the other balancers added and disconnects the removed ones.
+func (s *Servers) follow(members map[int32]string) {
+	s.RLock()
+	var gone []int32
+	for number := range s.servers {
+		if _, ok := members[number]; !ok && number != constants.LocalServerNumber {
+			gone = append(gone, number)
+		}
+	}
+	s.RUnlock()
+
+	for _, number := range gone {
+		s.logger.Info("Server removed by another balancer", zap.Int32("server", number))
+		s.disconnect(number)
+	}
+
+	var wg sync.WaitGroup
+	for number, address := range members {
+		wg.Add(1)
+		go func(number int32, address string) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), constants.ServerConnectTimeout)
+			defer cancel()
+
+			if err := s.connect(ctx, number, address, true); err != nil {
+				s.logger.Error("can't connect the member", zap.Int32("server", number), zap.Error(err))
+			}
+		}(number, address)
+	}
+	wg.Wait()
+}
+
+// connect connects the server with the number, unless it is connected already.
+// A server that can't be reached is added offline when forced, the health checker probes it.
+func (s *Servers) connect(ctx context.Context, number int32, address string, force bool) error {
+	if s.Exists(number) {
+		return nil
+	}
+
+	cl, err := NewRemoteServer(ctx, address, number, s.health, s.conns, s.session, s.logger)
+	if err != nil && !force {
+		cl.Close()
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	// the server could have been connected meanwhile, by the watcher or by AddServer
+	if _, ok := s.servers[number]; ok {
+		cl.Close()
+		return nil
+	}
+
+	s.servers[number] = cl
+
+	if s.ring != nil {
+		s.ring.Add(number)
+	}
+
+	s.logger.Info("Connected server", zap.Int32("server", number), zap.String("address", address))
+
+	return nil
+}
+
+// Discover follows the membership of the cluster: the servers that join it are connected,
+// the dead and the left ones are taken offline until the health checker reaches them.
+// A server keeps its number when it comes back, it is removed only by Disconnect.
+// The servers file is not changed for them.
+func (s *Servers) Discover(membership domains.Membership) {
+	membership.Watch(s.discover)
+}
+
+func (s *Servers) discover(members []models.Member) {
+	s.RLock()
+	known := make(map[string]domains.Server, len(s.servers))
+	for _, cl := range s.servers {
+		if cl.Number() != constants.LocalServerNumber {
+			known[cl.Address()] = cl
+		}
+	}
+	s.RUnlock()
+
+	for _, m := range members {
+		if m.Role != models.MemberServer {
+			continue
+		}
+
+		cl, ok := known[m.Address]
+
+		switch {
+		case m.State == models.MemberAlive && !ok:
+			ctx, cancel := context.WithTimeout(context.Background(), constants.ServerConnectTimeout)
+			number, err := s.addServer(ctx, m.Address, true, false)
+			cancel()
+
+			switch {
+			case errors.Is(err, constants.ErrAlreadyExists):
+			case err != nil:
+				s.logger.Error("can't add the discovered server", zap.String("server", m.Address), zap.Error(err))
+			default:
+				s.logger.Info("Discovered server", zap.String("server", m.Address), zap.Int32("number", number))
+			}
+		case (m.State == models.MemberDead || m.State == models.MemberLeft) && ok && !cl.IsOffline():
+			if p, ok := cl.(prober); ok {
+				s.logger.Warn("Server is gone from the cluster", zap.Int32("server", cl.Number()), zap.String("state", m.State))
+				p.Health().Trip()
+			}
+		}
+	}
+}
+
+// addresses returns the addresses of the remote servers.
+func (s *Servers) addresses() []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	addresses := make([]string, 0, len(s.servers))
+	for _, serv := range s.servers {
+		if addr := serv.Address(); addr != "" {
+			addresses = append(addresses, addr)
+		}
+	}
+
+	return addresses
+}
+
 func (s *Servers) Disconnect(number int32) {
+	if s.meta.IsSome() {
+		ctx, cancel := context.WithTimeout(context.Background(), constants.ServerConnectTimeout)
+		defer cancel()
+
+		if r := s.meta.Unwrap().RemoveMember(ctx, number); r.IsErr() {
+			s.logger.Error("can't remove the server from the metadata", zap.Int32("server", number), zap.Error(r.Error()))
+		}
+	}
+
+	s.disconnect(number)
+}
+
+func (s *Servers) disconnect(number int32) {
 	s.Lock()
 	defer s.Unlock()
 	delete(s.servers, number)
+	delete(s.leaving, number)
+	delete(s.maintenance, number)
+
+	if s.ring != nil {
+		s.ring.Remove(number)
+	}
+}
+
+// Leave stops placing new data on the server, it stays connected to serve what it holds.
+func (s *Servers) Leave(number int32) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.servers[number]; !ok {
+		return false
+	}
+
+	s.leaving[number] = struct{}{}
+
+	if s.ring != nil {
+		s.ring.Remove(number)
+	}
+
+	return true
+}
+
+// SetMaintenance excludes the server from the choice of a server for new data, or brings it back.
+// The keys and objects already routed to the server stay there.
+func (s *Servers) SetMaintenance(number int32, on bool) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.servers[number]; !ok {
+		return false
+	}
+
+	if on {
+		s.maintenance[number] = struct{}{}
+	} else {
+		delete(s.maintenance, number)
+	}
+
+	return true
 }
 
-func (s *Servers) GetServersInfo() []string {
+// Leaving returns the servers that were asked to leave and are still connected.
+func (s *Servers) Leaving() []int32 {
 	s.RLock()
 	defer s.RUnlock()
 
-	var servers = make([]string, 0, len(s.servers))
-	for _, cl := range s.servers {
-		var res string
-		if cl.IsOffline() {
-			res = fmt.Sprintf("s#%d Offline", cl.Number())
+	leaving := make([]int32, 0, len(s.leaving))
+	for number := range s.leaving {
+		leaving = append(leaving, number)
+	}
+
+	return leaving
+}
+
+// KeyReplicas returns up to n online servers that keep the copies of key, the owner first.
+// It returns nil in the RAM placement mode.
+func (s *Servers) KeyReplicas(key string, n int) []domains.Server {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.ring == nil {
+		return nil
+	}
+
+	numbers := s.ring.Owners(key, n, func(number int32) bool {
+		serv, ok := s.servers[number]
+		return ok && !serv.IsOffline()
+	})
+
+	replicas := make([]domains.Server, 0, len(numbers))
+	for _, number := range numbers {
+		replicas = append(replicas, s.servers[number])
+	}
+
+	return replicas
+}
+
+// Segments splits the hash ring between the online servers, each segment is kept by up to n of them.
+// It returns nil in the RAM placement mode.
+func (s *Servers) Segments(n int) []models.Segment {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.ring == nil {
+		return nil
+	}
+
+	ringSegments := s.ring.Segments(n, func(number int32) bool {
+		serv, ok := s.servers[number]
+		return ok && !serv.IsOffline()
+	})
+
+	segments := make([]models.Segment, 0, len(ringSegments))
+	for _, seg := range ringSegments {
+		segments = append(segments, models.Segment{From: seg.From, To: seg.To, Servers: seg.Servers})
+	}
+
+	return segments
+}
+
+// KeyOwner returns the server that owns key on the hash ring.
+// An offline owner is passed over for the next server on the ring.
+// It returns None in the RAM placement mode, where the owner can't be computed.
+func (s *Servers) KeyOwner(key string) (res gost.Option[domains.Server]) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.ring == nil {
+		return res.None()
+	}
+
+	number, ok := s.ring.Owner(key, func(number int32) bool {
+		serv, ok := s.servers[number]
+		return ok && !serv.IsOffline()
+	})
+	if !ok {
+		return res.None()
+	}
+
+	return res.Some(s.servers[number])
+}
+
+func (s *Servers) Info() []models.ServerInfo {
+	s.RLock()
+	defer s.RUnlock()
+
+	infos := make([]models.ServerInfo, 0, len(s.servers))
+	for num, cl := range s.servers {
+		info := models.ServerInfo{
+			Number:  num,
+			Address: cl.Address(),
+			State:   models.ServerOnline,
+			RAM:     cl.RAM(),
+		}
+
+		if p, ok := cl.(prober); ok {
+			st := p.Health().Stats()
+			info.Failures, info.LastSeen = st.Failures, st.LastSeen
+
+			ps := p.Latency().Percentiles(50, 90, 99)
+			info.Latency = models.Latency{P50: ps[0], P90: ps[1], P99: ps[2]}
 		} else {
-			r := cl.RAM()
-			res = fmt.Sprintf("s#%d Avaliable: %d MB, Total: %d MB", cl.Number(), r.Available, r.Total)
+			info.Local, info.LastSeen = true, time.Now()
+		}
+
+		if _, ok := s.leaving[num]; ok {
+			info.State = models.ServerDraining
+		} else if _, ok := s.maintenance[num]; ok {
+			info.State = models.ServerMaintenance
+		}
+
+		// an offline server is reported offline whatever else is going on with it
+		if cl.IsOffline() {
+			info.State = models.ServerOffline
 		}
 
-		servers = append(servers, res)
+		infos = append(infos, info)
 	}
 
-	return servers
+	slices.SortFunc(infos, func(a, b models.ServerInfo) int {
+		return cmp.Compare(a.Number, b.Number)
+	})
+
+	return infos
 }
 
 func (s *Servers) DeepSearch(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.GetOptions) (res gost.Result[gost.Pair[int32, models.Value]]) {
 	s.RLock()
 	defer s.RUnlock()
 
+	var candidates, skipped []domains.Server
+	for _, cl := range s.servers {
+		if cl.IsOffline() {
+			continue
+		}
+
+		if s.health.KeyFilterInterval > 0 && !cl.MayHave(key) {
+			skipped = append(skipped, cl)
+			continue
+		}
+
+		candidates = append(candidates, cl)
+	}
+
+	_deepSearch.Add("searches", 1)
+	_deepSearch.Add("skipped", int64(len(skipped)))
+
+	r := s.search(ctx, claims, key, opts, candidates)
+	if r.IsOk() || len(skipped) == 0 {
+		return r
+	}
+
+	// a filter is pulled every KeyFilterInterval, a key written since the last pull is missing from it
+	_deepSearch.Add("fallbacks", 1)
+
+	return s.search(ctx, claims, key, opts, skipped)
+}
+
+// search asks the servers for the key in parallel and returns the first value found.
+func (s *Servers) search(ctx context.Context, claims gost.Option[models.UserClaims], key string, opts models.GetOptions, servers []domains.Server) (res gost.Result[gost.Pair[int32, models.Value]]) {
 	ctxCancel, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -251,14 +876,10 @@ func (s *Servers) DeepSearch(ctx context.Context, claims gost.Option[models.User
 	}
 
 	var wg sync.WaitGroup
-	wg.Add(len(s.servers))
-
-	for _, cl := range s.servers {
-		if cl.IsOffline() {
-			wg.Done()
-			continue
-		}
+	wg.Add(len(servers))
 
+	for _, cl := range servers {
+		_deepSearch.Add("queried", 1)
 		c := cl
 
 		go gost.WithContextPool(ctx, func() error {
@@ -267,13 +888,13 @@ func (s *Servers) DeepSearch(ctx context.Context, claims gost.Option[models.User
 			r := c.GetOne(ctxCancel, claims, key, opts)
 			if r.IsErr() {
 				if !errors.Is(r.Error(), constants.ErrNotFound) {
-					s.logger.Error("can't DeepSearch", zap.Int32("server", cl.Number()), zap.Error(r.Error()))
+					s.logger.Error("can't DeepSearch", zap.Int32("server", c.Number()), zap.Error(r.Error()))
 				}
 
 				return nil
 			}
 
-			finished(r.Unwrap(), cl.Number())
+			finished(r.Unwrap(), c.Number())
 			return nil
 		}, s.poolCh)
 	}
@@ -296,7 +917,12 @@ func (s *Servers) DeepSearch(ctx context.Context, claims gost.Option[models.User
 
 func (s *Servers) GetServer(number int32) (domains.Server, bool) {
 	if number == constants.AutoServerNumber {
-		return s.getServer()
+		r := s.Place("")
+		if r.IsErr() {
+			return nil, false
+		}
+
+		return r.Unwrap(), true
 	}
 
 	s.RLock()
@@ -0,0 +1,2 @@
+// Package serverstest tests how the servers of a cluster are searched for a key.
+package serverstest
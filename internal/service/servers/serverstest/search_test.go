@@ -0,0 +1,74 @@
+package serverstest
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/clustertest"
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+	"itisadb/internal/service/servers"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+// inTempDir runs the test in a temporary directory, servers.New keeps the last used server number in the working one.
+func inTempDir(t *testing.T) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestDeepSearchFilters(t *testing.T) {
+	ctx := context.Background()
+	claims := gost.None[models.UserClaims]()
+
+	inTempDir(t)
+
+	local := clustertest.NewServer(constants.LocalServerNumber)
+
+	cfg := config.BalancerConfig{Health: config.HealthConfig{KeyFilterInterval: time.Minute}}
+	s, err := servers.New(cfg, gost.Some[domains.Server](local), nil, gost.None[domains.Metadata](), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local.Put("key", models.Value{Value: "value"})
+
+	// the filter was pulled before the key was written
+	local.SetKeyFilter(func(string) bool { return false })
+
+	r := s.DeepSearch(ctx, claims, "key", models.GetOptions{})
+	if r.IsErr() || r.Unwrap().Left != constants.LocalServerNumber || r.Unwrap().Right.Value != "value" {
+		t.Fatalf("search past a stale filter: %+v", r)
+	}
+
+	if r := s.DeepSearch(ctx, claims, "missing", models.GetOptions{}); r.IsOk() || r.Error() != constants.ErrNotFound {
+		t.Fatalf("search of a missing key: %+v", r)
+	}
+
+	// the filter that has the key skips no server
+	local.SetKeyFilter(func(key string) bool { return key == "key" })
+
+	reads := local.Calls("GetOne")
+	if r := s.DeepSearch(ctx, claims, "key", models.GetOptions{}); r.IsErr() {
+		t.Fatal(r.Error())
+	}
+
+	if n := local.Calls("GetOne") - reads; n != 1 {
+		t.Errorf("the search read the server %d times", n)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 
+	"github.com/egorgasay/gost"
 	"github.com/golang-jwt/jwt/v5"
 	"go.uber.org/zap"
 	"itisadb/config"
@@ -82,6 +83,34 @@ func (s Session) Create(ctx context.Context, userID string, level models.Level)
 	return token, nil
 }
 
+// Forward signs the caller with the key every node shares, an anonymous caller is constants.AnonymousCaller.
+func (s Session) Forward(ctx context.Context, claims gost.Option[models.UserClaims]) (string, error) {
+	if claims.IsNone() {
+		return constants.AnonymousCaller, nil
+	}
+
+	token, _, err := s.generator.AccessToken(ctx, claims.Unwrap(), s.key, constants.AccessTTL)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// AuthByForwarded checks the caller like AuthByToken checks a token, the user must exist on this node too.
+func (s Session) AuthByForwarded(ctx context.Context, caller string) (res gost.Option[models.UserClaims], err error) {
+	if caller == constants.AnonymousCaller {
+		return res.None(), nil
+	}
+
+	claims, err := s.AuthByToken(ctx, caller)
+	if err != nil {
+		return res, err
+	}
+
+	return res.Some(claims), nil
+}
+
 func (s Session) infoFromJWT(token string) (models.UserClaims, error) {
 	t, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
 		return s.key, nil
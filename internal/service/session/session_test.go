@@ -0,0 +1,102 @@
+package session
+
+import (
+	"context"
+	"testing"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+	"itisadb/internal/models"
+	"itisadb/internal/service/generator"
+	"itisadb/internal/service/logic"
+	"itisadb/internal/service/security"
+	"itisadb/internal/storage"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+func TestForward(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := storage.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := []models.User{
+		{Login: "default", Level: constants.DefaultLevel, Active: true},
+		{Login: "restricted", Level: constants.RestrictedLevel, Active: true},
+		{Login: "secret", Level: constants.SecretLevel, Active: true},
+	}
+	for _, u := range users {
+		if r := store.NewUser(u); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+	}
+
+	ses := New(config.Config{}, store, generator.New(zap.NewNop()), zap.NewNop())
+
+	// the remote node checks the levels against the forwarded caller
+	sec := security.NewSecurityService(config.SecurityConfig{MandatoryAuthorization: true}, config.EncryptionConfig{Key: "PLEASE CHANGE ME"})
+	l := logic.NewLogic(store, config.Config{}, nil, zap.NewNop(), sec)
+
+	admin := gost.Some(models.UserClaims{Level: constants.MaxLevel})
+	levels := []models.Level{constants.DefaultLevel, constants.RestrictedLevel, constants.SecretLevel}
+	for _, level := range levels {
+		if r := l.SetOne(ctx, admin, level.String(), "v", models.SetOptions{Level: level}); r.IsErr() {
+			t.Fatal(r.Error())
+		}
+	}
+
+	check := func(name string, caller gost.Option[models.UserClaims], level models.Level) {
+		t.Helper()
+
+		for _, key := range levels {
+			r := l.GetOne(ctx, caller, key.String(), models.GetOptions{})
+			if allowed := key <= level; allowed != r.IsOk() {
+				t.Errorf("%s reads the %s key: got %v, want allowed=%v", name, key, r.Error(), allowed)
+			}
+		}
+	}
+
+	var signed string
+	for _, u := range users {
+		token, err := ses.Forward(ctx, gost.Some(u.ExtractClaims()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		caller, err := ses.AuthByForwarded(ctx, token)
+		if err != nil {
+			t.Fatalf("%s: %v", u.Login, err)
+		}
+
+		if caller.IsNone() || caller.Unwrap() != u.ExtractClaims() {
+			t.Fatalf("%s: got %+v", u.Login, caller)
+		}
+
+		check(u.Login, caller, u.Level)
+		signed = token
+	}
+
+	token, err := ses.Forward(ctx, gost.None[models.UserClaims]())
+	if err != nil || token != constants.AnonymousCaller {
+		t.Fatalf("anonymous: got %q, %v", token, err)
+	}
+
+	anonymous, err := ses.AuthByForwarded(ctx, token)
+	if err != nil || anonymous.IsSome() {
+		t.Fatalf("anonymous: got %+v, %v", anonymous, err)
+	}
+
+	check("anonymous", anonymous, constants.DefaultLevel)
+
+	// a caller the node doesn't know and a token not signed by a node are refused
+	unknown, _ := ses.Forward(ctx, gost.Some(models.UserClaims{ID: "nobody", Level: constants.SecretLevel}))
+	for _, bad := range []string{unknown, signed + "x", "not a token"} {
+		if _, err := ses.AuthByForwarded(ctx, bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
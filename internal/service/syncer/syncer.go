@@ -10,6 +10,7 @@ import (
 
 	"itisadb/internal/domains"
 
+	"github.com/egorgasay/gost"
 	"go.uber.org/zap"
 )
 
@@ -18,15 +19,23 @@ type Syncer struct {
 	repo    domains.Storage
 	logger  *zap.Logger
 	f       *os.File
+
+	// meta keeps the synced change ID when the balancers share it, only the leader syncs then.
+	meta gost.Option[domains.Metadata]
 }
 
 var syncerIsRunning = false
 
-func NewSyncer(servers domains.Servers, logger *zap.Logger, repo domains.Storage) (domains.Syncer, error) {
+func NewSyncer(servers domains.Servers, logger *zap.Logger, repo domains.Storage, meta gost.Option[domains.Metadata]) (domains.Syncer, error) {
 	s := &Syncer{
 		servers: servers,
 		repo:    repo,
 		logger:  logger,
+		meta:    meta,
+	}
+
+	if meta.IsSome() {
+		return s, nil
 	}
 
 	f, err := os.OpenFile("sync", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
@@ -62,6 +71,11 @@ func (s Syncer) Start() {
 	defer func() { syncerIsRunning = false }()
 
 	for {
+		if s.meta.IsSome() && !s.meta.Unwrap().IsLeader() {
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
 		if err := s.servers.Iter(s.syncServer); err != nil {
 			s.logger.Error("can't iter over servers", zap.Error(err))
 		}
@@ -82,6 +96,12 @@ func (s Syncer) syncServer(server domains.Server) error {
 	syncID := r.Unwrap()
 
 	// Получение последнего идентификатора изменений
+	if s.meta.IsSome() {
+		// the change ID saved by the previous leader
+		if shared := s.meta.Unwrap().UserChangeID(); shared > s.repo.GetUserChangeID() {
+			s.repo.SetUserChangeID(shared)
+		}
+	}
 	currentSyncID := s.repo.GetUserChangeID()
 	if syncID == currentSyncID { 
 		return nil // Если нет изменений - алгоритм завершает работу
@@ -101,6 +121,14 @@ func (s Syncer) syncServer(server domains.Server) error {
 		return rSync.Error()
 	}
 
+	if s.meta.IsSome() {
+		if r := s.meta.Unwrap().SetUserChangeID(ctx, currentSyncID); r.IsErr() {
+			return r.Error()
+		}
+
+		return nil
+	}
+
 	_, err := s.f.WriteAt([]byte(fmt.Sprint(currentSyncID)), 0) // Сохраняем идентификатор в файл
 	if err != nil {
 		return fmt.Errorf("can't write sync id to file: %w", err)
@@ -0,0 +1,176 @@
+package transactionlogger
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+
+	"itisadb/config"
+	"itisadb/internal/constants"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultQueueSize    = 60_000
+	DefaultQueueTimeout = 5 * time.Second
+)
+
+// _errorsBuffer is the number of failures kept for the Err channel.
+const _errorsBuffer = 100
+
+// _current is the logger whose state is exported as the "transaction_logger" variable.
+var _current atomic.Pointer[TransactionLogger]
+
+func init() {
+	expvar.Publish("transaction_logger", expvar.Func(func() any {
+		if t := _current.Load(); t != nil {
+			return t.Metrics()
+		}
+		return nil
+	}))
+}
+
+type stats struct {
+	flushes        atomic.Uint64
+	flushedBytes   atomic.Uint64
+	lastFlushBytes atomic.Uint64
+	lastFlush      atomic.Int64
+	flushTime      atomic.Int64
+	rejected       atomic.Uint64
+}
+
+func (s *stats) flushed(n int, took time.Duration) {
+	s.flushes.Add(1)
+	s.flushedBytes.Add(uint64(n))
+	s.lastFlushBytes.Store(uint64(n))
+	s.lastFlush.Store(int64(took))
+	s.flushTime.Add(int64(took))
+}
+
+// Metrics returns the current state of the queue and the flushes.
+func (t *TransactionLogger) Metrics() map[string]any {
+	m := map[string]any{
+		"queue_depth":         len(t.events),
+		"queue_size":          cap(t.events),
+		"reserved":            t.reserved.Load(),
+		"rejected_writes":     t.stats.rejected.Load(),
+		"flushes":             t.stats.flushes.Load(),
+		"flushed_bytes":       t.stats.flushedBytes.Load(),
+		"last_flush_bytes":    t.stats.lastFlushBytes.Load(),
+		"last_flush_latency":  time.Duration(t.stats.lastFlush.Load()).String(),
+		"flush_latency_total": time.Duration(t.stats.flushTime.Load()).String(),
+		"read_only":           t.readOnly.Load(),
+		"healthy":             t.Healthy() == nil,
+	}
+
+	if err := t.Healthy(); err != nil {
+		m["error"] = err.Error()
+	}
+
+	return m
+}
+
+// enqueue passes e to the writer. The room for it is reserved in advance,
+// so it waits only behind the events written without a reservation.
+func (t *TransactionLogger) enqueue(e Event) (r gost.ResultN) {
+	t.events <- e
+	return r.Ok()
+}
+
+// Reserve takes room in the queue for n events, applying the queue full policy when there is none.
+// Callers reserve before they change the data and Release once the events are written,
+// so a rejected write leaves nothing behind and an accepted one is always logged.
+func (t *TransactionLogger) Reserve(n int) (r gost.ResultN) {
+	if t.Healthy() != nil {
+		return r.Err(constants.ErrLogFailed)
+	}
+
+	if t.readOnly.Load() {
+		return r.Err(constants.ErrLogReadOnly)
+	}
+
+	if t.tryReserve(n) {
+		return r.Ok()
+	}
+
+	switch t.cfg.QueueFullPolicy {
+	case config.RejectPolicy:
+	case config.ReadOnlyPolicy:
+		if !t.readOnly.Swap(true) {
+			t.logger.Warn("transaction log queue is full, node is read-only until it drains")
+		}
+		t.stats.rejected.Add(1)
+		return r.Err(constants.ErrLogReadOnly)
+	default:
+		timer := time.NewTimer(t.cfg.QueueTimeout)
+		defer timer.Stop()
+
+	wait:
+		for {
+			select {
+			case <-t.room:
+				if t.tryReserve(n) {
+					return r.Ok()
+				}
+			case <-timer.C:
+				break wait
+			}
+		}
+	}
+
+	t.stats.rejected.Add(1)
+
+	return r.Err(constants.ErrLogQueueFull)
+}
+
+// Release gives back the room taken by Reserve.
+func (t *TransactionLogger) Release(n int) {
+	t.reserved.Add(int64(-n))
+	t.freed()
+}
+
+func (t *TransactionLogger) tryReserve(n int) bool {
+	for {
+		reserved := t.reserved.Load()
+		if len(t.events)+int(reserved)+n > cap(t.events) {
+			return false
+		}
+
+		if t.reserved.CompareAndSwap(reserved, reserved+int64(n)) {
+			return true
+		}
+	}
+}
+
+// freed wakes up a Reserve waiting for room.
+func (t *TransactionLogger) freed() {
+	select {
+	case t.room <- struct{}{}:
+	default:
+	}
+}
+
+// Healthy returns the failure that made the log incomplete, if any.
+// The logger never recovers from it, the node has to be restarted.
+func (t *TransactionLogger) Healthy() error {
+	if err := t.failure.Load(); err != nil {
+		return *err
+	}
+
+	return nil
+}
+
+func (t *TransactionLogger) fail(err error) {
+	if t.failure.CompareAndSwap(nil, &err) {
+		t.logger.Error("transaction logger failed, the node is unhealthy", zap.Error(err))
+	} else {
+		t.logger.Error("transaction logger error", zap.Error(err))
+	}
+
+	select {
+	case t.errors <- err:
+	default:
+	}
+}
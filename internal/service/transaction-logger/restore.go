@@ -14,176 +14,235 @@ var ErrCorruptedConfigFile = fmt.Errorf("corrupted config file")
 
 func (t *TransactionLogger) handleEvents(r domains.Restorer, events <-chan Event, errs <-chan error) error {
 	e, ok := Event{}, true
-	var err error
 
-	for ok && err == nil {
+	for ok {
 		select {
-		case err, ok = <-errs:
-			if ok && err != nil {
+		case err, open := <-errs:
+			if !open {
+				errs = nil
+				continue
+			}
+
+			if err != nil {
 				return err
 			}
-			ok = true
 		case e, ok = <-events:
-			switch e.EventType {
-			case 0:
-				continue
-			case Set:
-				split := strings.Split(e.Metadata, constants.MetadataSeparator)
+			if !ok {
+				break
+			}
 
-				if len(split) < 2 {
-					return fmt.Errorf("%w\n invalid metadata %s, Name: %s", ErrCorruptedConfigFile, e.Metadata, e.Name)
-				}
+			if err := ApplyEvent(r, t.security, e); err != nil {
+				return err
+			}
+
+			if err := t.track(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
 
-				readOnly := split[0] == "1"
+// ApplyEvent applies a single decoded transaction-log event to r.
+// Encrypted values are decrypted with security before they are applied.
+func ApplyEvent(r domains.Restorer, security domains.SecurityService, e Event) (err error) {
+	switch e.EventType {
+	case 0:
+		return nil
+	case Set:
+		value, opts, err := decodeSet(security, e)
+		if err != nil {
+			return err
+		}
 
-				levelStr := split[1]
-				level, err := strconv.Atoi(levelStr)
+		r := r.Set(e.Name, value, opts)
+		if r.IsErr() {
+			return fmt.Errorf("can't set %s: %w", e.Name, r.Error())
+		}
+	case Delete:
+		r := r.Delete(e.Name)
+		if r.IsErr() {
+			return fmt.Errorf("can't delete %s: %w", e.Name, r.Error())
+		}
+	case SetToObject:
+		split := strings.Split(e.Name, constants.ObjectSeparator)
+		if len(split) < 2 {
+			return fmt.Errorf("%w\n invalid value %s, Name: %s", ErrCorruptedConfigFile, e.Value, e.Name)
+		}
+		key, value := split[len(split)-1], e.Value
+
+		if len(split) > 2 {
+			encrypt := split[2] == _enctyptedSign
+			if encrypt {
+				value, err = security.Decrypt(value)
 				if err != nil {
-					return fmt.Errorf("%w\n invalid level %s, Name: %s", ErrCorruptedConfigFile, levelStr, e.Name)
+					return fmt.Errorf("can't decrypt encrypted value %s: %w", e.Name, err)
 				}
+			}
+		}
 
-				if len(split) > 2 {
-					encrypt := split[2] == _enctyptedSign
-					if encrypt {
-						e.Value, err = t.security.Decrypt(e.Value)
-						if err != nil {
-							return fmt.Errorf("can't decrypt encrypted value %s: %w", e.Name, err)
-						}
-					}
-				}
+		r := r.SetToObject(strings.Join(split[:len(split)-1], constants.ObjectSeparator), key, value, models.SetToObjectOptions{
+			ReadOnly: e.Metadata == "1",
+		})
+		if r.IsErr() {
+			return fmt.Errorf("can't set to object %s, v: %s: %w", e.Name, e.Value, r.Error())
+		}
 
-				r := r.Set(e.Name, e.Value, models.SetOptions{
-					ReadOnly: readOnly,
-					Level:    models.Level(level),
-				})
-				if r.IsErr() {
-					return fmt.Errorf("can't set %s: %w", e.Name, r.Error())
-				}
-			case Delete:
-				r := r.Delete(e.Name)
-				if r.IsErr() {
-					return fmt.Errorf("can't delete %s: %w", e.Name, r.Error())
-				}
-			case SetToObject:
-				split := strings.Split(e.Name, constants.ObjectSeparator)
-				if len(split) < 2 {
-					return fmt.Errorf("%w\n invalid value %s, Name: %s", ErrCorruptedConfigFile, e.Value, e.Name)
-				}
-				key, value := split[len(split)-1], e.Value
-
-				if len(split) > 2 {
-					encrypt := split[2] == _enctyptedSign
-					if encrypt {
-						value, err = t.security.Decrypt(value)
-						if err != nil {
-							return fmt.Errorf("can't decrypt encrypted value %s: %w", e.Name, err)
-						}
-					}
-				}
+	case DeleteAttr:
+		i := strings.LastIndex(e.Name, constants.ObjectSeparator)
+		if i < 0 {
+			return fmt.Errorf("%w\n invalid attr, Name: %s", ErrCorruptedConfigFile, e.Name)
+		}
 
-				r := r.SetToObject(strings.Join(split[:len(split)-1], constants.ObjectSeparator), key, value, models.SetToObjectOptions{
-					ReadOnly: e.Metadata == "1",
-				})
-				if r.IsErr() {
-					return fmt.Errorf("can't set to object %s, v: %s: %w", e.Name, e.Value, r.Error())
-				}
+		r := r.DeleteAttr(e.Name[:i], e.Name[i+len(constants.ObjectSeparator):])
+		if r.IsErr() {
+			return fmt.Errorf("can't delete attr %s: %w", e.Name, r.Error())
+		}
+	case Attach:
+		r := r.AttachToObject(e.Name, e.Value)
+		if r.IsErr() {
+			return fmt.Errorf("can't attach %s, v: %s: %w", e.Name, e.Value, r.Error())
+		}
+	case DeleteObject:
+		rDel := r.DeleteObject(e.Name)
+		if rDel.IsErr() {
+			return fmt.Errorf("can't delete object %s: %w", e.Name, rDel.Error())
+		}
+		r.DeleteObjectInfo(e.Name)
+		// TODO: case Detach:
+	case CreateUser, UpdateUser, ChangePassword, ChangeLevel:
+		user, err := decodeUser(e)
+		if err != nil {
+			return err
+		}
 
-			case DeleteAttr:
-				r := r.DeleteAttr(e.Name, e.Value)
-				if r.IsErr() {
-					return fmt.Errorf("can't delete attr %s: %w", e.Name, r.Error())
-				}
-			case Attach:
-				r := r.AttachToObject(e.Name, e.Value)
-				if r.IsErr() {
-					return fmt.Errorf("can't attach %s, v: %s: %w", e.Name, e.Value, r.Error())
-				}
-			case DeleteObject:
-				rDel := r.DeleteObject(e.Name)
-				if rDel.IsErr() {
-					return fmt.Errorf("can't delete object %s: %w", e.Name, rDel.Error())
-				}
-				r.DeleteObjectInfo(e.Name)
-				// TODO: case Detach:
-			case CreateUser:
-				split := strings.Split(e.Metadata, constants.MetadataSeparator)
-				if len(split) < 2 {
-					return fmt.Errorf("[%w]\n NewUser invalid value %s, Name: %s", ErrCorruptedConfigFile, e.Value, e.Name)
-				}
+		if rUser := r.RestoreUser(user); rUser.IsErr() {
+			return fmt.Errorf("can't restore user %s: %w", e.Name, rUser.Error())
+		}
+	case DeleteUser:
+		// logs written before change IDs were recorded carry no metadata.
+		if e.Metadata == "" {
+			if rDelUser := r.DeleteUser(e.Name); rDelUser.IsErr() {
+				return fmt.Errorf("can't delete user %s: %w", e.Name, rDelUser.Error())
+			}
 
-				syncIDStr := split[0]
-				activeStr := split[1]
-				levelStr := split[2]
+			return nil
+		}
 
-				active, err := strconv.ParseBool(activeStr)
-				if err != nil {
-					return fmt.Errorf("[%w]\n invalid active value %s, Name: %s", ErrCorruptedConfigFile, e.Metadata, e.Name)
-				}
+		changeID, err := strconv.ParseUint(e.Metadata, 10, 64)
+		if err != nil {
+			return fmt.Errorf("[%w]\n invalid changeID value %s, Name: %s", ErrCorruptedConfigFile, e.Metadata, e.Name)
+		}
 
-				level, err := strconv.Atoi(levelStr)
-				if err != nil {
-					return fmt.Errorf("[%w]\n invalid level value %s, Name: %s", ErrCorruptedConfigFile, e.Metadata, e.Name)
-				}
+		if rDelUser := r.RestoreDeleteUser(e.Name, changeID); rDelUser.IsErr() {
+			return fmt.Errorf("can't delete user %s: %w", e.Name, rDelUser.Error())
+		}
+	case CreateObject:
+		split := strings.Split(e.Value, constants.MetadataSeparator)
+		if len(split) < 2 {
+			return fmt.Errorf("[%w]\n AddObjectInfo invalid value %s, Name: %s", ErrCorruptedConfigFile, e.Value, e.Name)
+		}
 
-				syncID, err := strconv.ParseUint(syncIDStr, 10, 64)
-				if err != nil {
-					return fmt.Errorf("[%w]\n invalid syncID value %s, Name: %s", ErrCorruptedConfigFile, e.Metadata, e.Name)
-				}
+		serverStr := split[0]
+		levelStr := split[1]
 
-				user := models.User{
-					Login:    e.Name,
-					Password: e.Value,
-					Level:    models.Level(level),
-					Active:   active,
-				}
+		server, err := strconv.Atoi(serverStr)
+		if err != nil {
+			return fmt.Errorf("[%w]\n invalid server value %s, Name: %s", ErrCorruptedConfigFile, e.Value, e.Name)
+		}
 
-				user.SetChangeID(syncID)
+		level, err := strconv.Atoi(levelStr)
+		if err != nil {
+			return fmt.Errorf("[%w]\n invalid level value %s, Name: %s", ErrCorruptedConfigFile, e.Value, e.Name)
+		}
 
-				rUser := r.NewUser(user)
-				if rUser.IsErr() {
-					return fmt.Errorf("can't create user %s, v: %s: %w", e.Name, e.Value, rUser.Error())
-				}
-			case DeleteUser:
-				rDelUser := r.DeleteUser(e.Name)
-				if rDelUser.IsErr() {
-					return fmt.Errorf("can't delete user %s: %w", e.Name, rDelUser.Error())
-				}
-			case CreateObject:
-				split := strings.Split(e.Value, constants.MetadataSeparator)
-				if len(split) < 2 {
-					return fmt.Errorf("[%w]\n AddObjectInfo invalid value %s, Name: %s", ErrCorruptedConfigFile, e.Value, e.Name)
-				}
+		objOpts := models.ObjectOptions{
+			Server: int32(server),
+			Level:  models.Level(level),
+		}
 
-				serverStr := split[0]
-				levelStr := split[1]
+		rObj := r.CreateObject(e.Name, objOpts)
+		if rObj.IsErr() {
+			return fmt.Errorf("can't create object %s: %w", e.Name, rObj.Error())
+		}
 
-				server, err := strconv.Atoi(serverStr)
-				if err != nil {
-					return fmt.Errorf("[%w]\n invalid server value %s, Name: %s", ErrCorruptedConfigFile, e.Value, e.Name)
-				}
+		r.AddObjectInfo(e.Name, models.ObjectInfo{Server: objOpts.Server, Level: objOpts.Level})
+	case Prepare, Abort:
+		// the writes of a transaction are applied by its commit
+		return nil
+	case Commit:
+		tx, err := DecodeTx(security, e)
+		if err != nil {
+			return err
+		}
 
-				level, err := strconv.Atoi(levelStr)
-				if err != nil {
-					return fmt.Errorf("[%w]\n invalid level value %s, Name: %s", ErrCorruptedConfigFile, e.Value, e.Name)
-				}
+		return applyTx(r, tx)
+	default:
+		return fmt.Errorf("[%w]\n unknown event type %v", ErrCorruptedConfigFile, e)
+	}
 
-				objOpts := models.ObjectOptions{
-					Server: int32(server),
-					Level:  models.Level(level),
-				}
+	return nil
+}
 
-				rObj := r.CreateObject(e.Name, objOpts)
-				if rObj.IsErr() {
-					return fmt.Errorf("can't create object %s: %w", e.Name, rObj.Error())
-				}
+// decodeSet returns the value and the options stored by SetEvent, the value is decrypted.
+func decodeSet(security domains.SecurityService, e Event) (value string, opts models.SetOptions, err error) {
+	split := strings.Split(e.Metadata, constants.MetadataSeparator)
 
-				r.AddObjectInfo(e.Name, models.ObjectInfo(objOpts))
-			default:
-				return fmt.Errorf("[%w]\n unknown event type %v", ErrCorruptedConfigFile, e)
-			}
+	if len(split) < 2 {
+		return "", opts, fmt.Errorf("%w\n invalid metadata %s, Name: %s", ErrCorruptedConfigFile, e.Metadata, e.Name)
+	}
+
+	opts.ReadOnly = split[0] == "1"
+
+	levelStr := split[1]
+	level, err := strconv.Atoi(levelStr)
+	if err != nil {
+		return "", opts, fmt.Errorf("%w\n invalid level %s, Name: %s", ErrCorruptedConfigFile, levelStr, e.Name)
+	}
+	opts.Level = models.Level(level)
+
+	value = e.Value
+	if len(split) > 2 && split[2] == _enctyptedSign {
+		value, err = security.Decrypt(value)
+		if err != nil {
+			return "", opts, fmt.Errorf("can't decrypt encrypted value %s: %w", e.Name, err)
 		}
 	}
-	return nil
+
+	return value, opts, nil
+}
+
+// decodeUser parses a user record written by writeUser.
+func decodeUser(e Event) (user models.User, err error) {
+	split := strings.Split(e.Metadata, constants.MetadataSeparator)
+	if len(split) < 3 {
+		return user, fmt.Errorf("[%w]\n %s invalid metadata %s, Name: %s", ErrCorruptedConfigFile, e.EventType, e.Metadata, e.Name)
+	}
+
+	changeID, err := strconv.ParseUint(split[0], 10, 64)
+	if err != nil {
+		return user, fmt.Errorf("[%w]\n invalid changeID value %s, Name: %s", ErrCorruptedConfigFile, e.Metadata, e.Name)
+	}
+
+	active, err := strconv.ParseBool(split[1])
+	if err != nil {
+		return user, fmt.Errorf("[%w]\n invalid active value %s, Name: %s", ErrCorruptedConfigFile, e.Metadata, e.Name)
+	}
+
+	level, err := strconv.Atoi(split[2])
+	if err != nil {
+		return user, fmt.Errorf("[%w]\n invalid level value %s, Name: %s", ErrCorruptedConfigFile, e.Metadata, e.Name)
+	}
+
+	user = models.User{
+		Login:    e.Name,
+		Password: e.Value,
+		Level:    models.Level(level),
+		Active:   active,
+	}
+	user.SetChangeID(changeID)
+
+	return user, nil
 }
 
 func (t *TransactionLogger) Restore(r domains.Restorer) error {
@@ -0,0 +1,63 @@
+package transactionlogger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var ErrNotEmpty = errors.New("transaction log directory is not empty")
+
+// Seed writes the events produced by fill as the first log file in dir,
+// so the next Restore starts from them. dir must not contain a log yet.
+// Nothing is left in dir when fill fails.
+func Seed(dir string, fill func(write func(Event) error) error) (err error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	files, err := Files(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(files) > 0 {
+		return fmt.Errorf("%w: %s", ErrNotEmpty, dir)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".seed-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	err = fill(func(e Event) error {
+		_, err := w.WriteString(EncodeEvent(e))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, "1"))
+}
@@ -5,9 +5,11 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"itisadb/config"
 	"itisadb/internal/domains"
+	"itisadb/internal/models"
 
 	"go.uber.org/zap"
 )
@@ -25,27 +27,99 @@ const (
 	DeleteObject
 	CreateUser
 	DeleteUser
+	UpdateUser
+	ChangePassword
+	ChangeLevel
+	Prepare
+	Commit
+	Abort
+
+	_lastEventType = Abort
 )
 
+func (e EventType) String() string {
+	switch e {
+	case Set:
+		return "Set"
+	case Delete:
+		return "Delete"
+	case SetToObject:
+		return "SetToObject"
+	case DeleteAttr:
+		return "DeleteAttr"
+	case CreateObject:
+		return "CreateObject"
+	case Attach:
+		return "Attach"
+	case DeleteObject:
+		return "DeleteObject"
+	case CreateUser:
+		return "CreateUser"
+	case DeleteUser:
+		return "DeleteUser"
+	case UpdateUser:
+		return "UpdateUser"
+	case ChangePassword:
+		return "ChangePassword"
+	case ChangeLevel:
+		return "ChangeLevel"
+	case Prepare:
+		return "Prepare"
+	case Commit:
+		return "Commit"
+	case Abort:
+		return "Abort"
+	}
+
+	return "Unknown"
+}
+
 type Event struct {
 	EventType EventType
 	Name      string
 	Value     string
 	Metadata  string
+
+	// flushed is closed when the event is written to the log file.
+	flushed chan struct{}
 }
 
 type TransactionLogger struct {
 	pathToFile string
 	file       *os.File
 
-	currentCOL  int32
+	// currentCOL counts the events in the current file, the watcher starts the next one at MaxCOL.
+	currentCOL  atomic.Int32
 	currentName int32
 
 	events chan Event
 	errors chan error
 
+	// reserved is the room in the queue taken by Reserve, room is signalled when some is freed.
+	reserved atomic.Int64
+	room     chan struct{}
+
 	sync.RWMutex
 
+	// pubMu guards the buffer, the sequence number and the subscribers.
+	pubMu       sync.Mutex
+	buf         *limitedBuffer
+	seq         uint64
+	subscribers map[*subscriber]struct{}
+
+	// readOnly is set when the queue overflows with the read-only policy.
+	readOnly atomic.Bool
+	// failure keeps the first error that made the log incomplete.
+	failure atomic.Pointer[error]
+	stats   stats
+
+	// starts keeps the sequence number of the event before the first one of each log file, see scanFrom.
+	startsMu sync.Mutex
+	starts   map[int32]uint64
+
+	// inDoubt keeps the transactions found prepared but not resolved by Restore.
+	inDoubt map[string]models.Transaction
+
 	logger *zap.Logger
 	cfg    config.TransactionLoggerConfig
 
@@ -57,6 +131,22 @@ func New(cfg config.TransactionLoggerConfig, logger *zap.Logger, security domain
 		cfg.BackupDirectory = DefaultPath
 	}
 
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+
+	if cfg.QueueTimeout <= 0 {
+		cfg.QueueTimeout = DefaultQueueTimeout
+	}
+
+	switch cfg.QueueFullPolicy {
+	case "":
+		cfg.QueueFullPolicy = config.BlockPolicy
+	case config.BlockPolicy, config.RejectPolicy, config.ReadOnlyPolicy:
+	default:
+		return nil, fmt.Errorf("unknown queue full policy %q", cfg.QueueFullPolicy)
+	}
+
 	if err := os.MkdirAll(cfg.BackupDirectory, 0755); err != nil {
 		return nil, err
 	}
@@ -89,12 +179,32 @@ func New(cfg config.TransactionLoggerConfig, logger *zap.Logger, security domain
 		return nil, err
 	}
 
-	return &TransactionLogger{
+	t := &TransactionLogger{
 		pathToFile:  filename,
 		file:        f,
 		currentName: int32(maxNumber),
 		cfg:         cfg,
 		logger:      logger,
 		security:    security,
-	}, nil
+		buf:         newLimitedBuffer(),
+		subscribers: make(map[*subscriber]struct{}),
+		inDoubt:     make(map[string]models.Transaction),
+		starts:      make(map[int32]uint64),
+		events:      make(chan Event, cfg.QueueSize),
+		room:        make(chan struct{}, 1),
+		errors:      make(chan error, _errorsBuffer),
+	}
+
+	if err := ScanDir(cfg.BackupDirectory, func(pos Position, _ Event) (bool, error) {
+		t.seq = pos.Seq
+		if pos.Line == 1 {
+			t.rememberStart(pos)
+		}
+		return true, nil
+	}); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return t, nil
 }
@@ -2,13 +2,18 @@ package transactionlogger
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"itisadb/internal/models"
+
 	"go.uber.org/zap"
 )
 
@@ -16,6 +21,17 @@ var DefaultPath = "transaction-logger"
 
 const MaxCOL = 100_000
 
+// _maxLineSize is the longest encoded event the readers accept.
+const _maxLineSize = 64 * 1024 * 1024
+
+// _subscriberBuffer is the number of live events a subscriber may lag behind before it is dropped.
+const _subscriberBuffer = 10_000
+
+var (
+	ErrSubscriberLagged = errors.New("subscriber is too slow, resubscribe from the last applied event")
+	ErrUnknownPosition  = errors.New("position is ahead of the transaction log")
+)
+
 type limitedBuffer struct {
 	sb       strings.Builder
 	lastSync time.Time
@@ -27,12 +43,14 @@ func newLimitedBuffer() *limitedBuffer {
 	}
 }
 
+type subscriber struct {
+	ch chan models.LogEvent
+}
+
 func (t *TransactionLogger) Run() {
-	events := make(chan Event, 60000)
-	errorsch := make(chan error, 60000)
+	events, errorsch := t.events, t.errors
 
-	t.errors = errorsch
-	t.events = events
+	_current.Store(t)
 
 	done := make(chan struct{})
 
@@ -42,41 +60,86 @@ func (t *TransactionLogger) Run() {
 		defer close(done)
 		defer close(errorsch)
 
-		op := newLimitedBuffer()
-
 		for e := range events {
-			data := []byte(
-				fmt.Sprintf(
-					"%d %s %s %s\n",
-					e.EventType,
-					b64.EncodeToString([]byte(e.Name)),
-					b64.EncodeToString([]byte(e.Value)),
-					b64.EncodeToString([]byte(e.Metadata)),
-				),
-			)
-
-			op.sb.Write(data)
-
-			t.currentCOL++
-
-			if time.Now().Sub(op.lastSync) >= t.cfg.SyncBufferTime {
-				t.logger.Debug("transaction logger syncing...")
-
-				t.RLock()
-				_, err := t.file.WriteString(op.sb.String())
-				//t.file.Sync() // TODO: ???
-				t.RUnlock()
-				if err != nil {
-					t.logger.Error("flash error", zap.Error(err))
-					t.errors <- err
-				}
-				op.sb.Reset()
-				op.lastSync = time.Now()
+			t.freed()
+			t.append(e)
+
+			if t.readOnly.Load() && len(events) <= cap(events)/2 {
+				t.readOnly.Store(false)
+				t.logger.Info("transaction log queue drained, node is writable again")
 			}
 		}
 	}()
 }
 
+// append writes e to the buffer, assigns it the next sequence number and
+// passes it to the subscribers.
+func (t *TransactionLogger) append(e Event) {
+	t.pubMu.Lock()
+	defer t.pubMu.Unlock()
+
+	t.buf.sb.WriteString(EncodeEvent(e))
+
+	t.currentCOL.Add(1)
+	t.seq++
+
+	t.publish(models.LogEvent{
+		Seq:      t.seq,
+		Time:     time.Now(),
+		Type:     uint8(e.EventType),
+		Name:     e.Name,
+		Value:    e.Value,
+		Metadata: e.Metadata,
+	})
+
+	if e.flushed != nil {
+		t.flush()
+		close(e.flushed)
+		return
+	}
+
+	if time.Now().Sub(t.buf.lastSync) >= t.cfg.SyncBufferTime {
+		t.flush()
+	}
+}
+
+// flush must be called with pubMu held.
+func (t *TransactionLogger) flush() {
+	if t.buf.sb.Len() == 0 {
+		return
+	}
+
+	t.logger.Debug("transaction logger syncing...")
+
+	start := time.Now()
+
+	t.RLock()
+	n, err := t.file.WriteString(t.buf.sb.String())
+	//t.file.Sync() // TODO: ???
+	t.RUnlock()
+	if err != nil {
+		t.fail(fmt.Errorf("flush error: %w", err))
+	}
+
+	t.stats.flushed(n, time.Since(start))
+
+	t.buf.sb.Reset()
+	t.buf.lastSync = time.Now()
+}
+
+// publish must be called with pubMu held.
+func (t *TransactionLogger) publish(e models.LogEvent) {
+	for sub := range t.subscribers {
+		select {
+		case sub.ch <- e:
+		default:
+			t.logger.Warn("dropping slow transaction log subscriber", zap.Uint64("seq", e.Seq))
+			delete(t.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
 func (t *TransactionLogger) countWatcher(done chan struct{}) {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
@@ -85,7 +148,7 @@ func (t *TransactionLogger) countWatcher(done chan struct{}) {
 		case <-done:
 			return
 		case <-ticker.C:
-			if t.currentCOL < MaxCOL {
+			if t.currentCOL.Load() < MaxCOL {
 				continue
 			}
 			t.currentName++
@@ -93,11 +156,12 @@ func (t *TransactionLogger) countWatcher(done chan struct{}) {
 			t.pathToFile = fmt.Sprintf("%s/%d", t.cfg.BackupDirectory, t.currentName)
 			f, err := os.OpenFile(t.pathToFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 			if err != nil {
-				t.errors <- err
+				t.fail(fmt.Errorf("can't open the next log file: %w", err))
+				continue
 			}
 
 			t.Lock()
-			t.currentCOL = 0
+			t.currentCOL.Store(0)
 			t.file.Close()
 			t.file = f
 			t.Unlock()
@@ -109,38 +173,65 @@ func (t *TransactionLogger) Err() <-chan error {
 	return t.errors
 }
 
-func (t *TransactionLogger) readEventsFrom(r io.Reader, outEvent chan<- Event, outError chan<- error) {
-	var event Event
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		action := scanner.Text()
+// EncodeEvent returns the line that represents e in the transaction log.
+func EncodeEvent(e Event) string {
+	return fmt.Sprintf(
+		"%d %s %s %s\n",
+		e.EventType,
+		b64.EncodeToString([]byte(e.Name)),
+		b64.EncodeToString([]byte(e.Value)),
+		b64.EncodeToString([]byte(e.Metadata)),
+	)
+}
 
-		args := strings.Split(action, " ")
-		for len(args) < 4 {
-			args = append(args, "")
-		}
+// DecodeEvent parses a single line of the transaction log.
+func DecodeEvent(line string) (Event, error) {
+	args := strings.Split(line, " ")
+	for len(args) < 4 {
+		args = append(args, "")
+	}
 
-		for idx := range args[1:] {
-			realIDX := idx + 1
-			decode, err := b64.DecodeString(args[realIDX])
-			if err != nil {
-				outError <- fmt.Errorf("transaction log read failure: %w", err)
-				return
-			}
-			args[realIDX] = string(decode)
+	for idx := range args[1:] {
+		realIDX := idx + 1
+		decode, err := b64.DecodeString(args[realIDX])
+		if err != nil {
+			return Event{}, fmt.Errorf("transaction log read failure: %w", err)
 		}
+		args[realIDX] = string(decode)
+	}
+
+	num, err := strconv.Atoi(args[0])
+	if err != nil {
+		return Event{}, fmt.Errorf("transaction log read failure: %w", err)
+	}
+
+	if num < 0 || num > int(_lastEventType) {
+		return Event{}, fmt.Errorf("transaction log read failure: unknown event type %d", num)
+	}
 
-		num, err := strconv.Atoi(args[0])
+	return Event{
+		EventType: EventType(num),
+		Name:      args[1],
+		Value:     args[2],
+		Metadata:  args[3],
+	}, nil
+}
+
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), _maxLineSize)
+	return scanner
+}
+
+func (t *TransactionLogger) readEventsFrom(r io.Reader, outEvent chan<- Event, outError chan<- error) {
+	scanner := newScanner(r)
+	for scanner.Scan() {
+		event, err := DecodeEvent(scanner.Text())
 		if err != nil {
-			outError <- fmt.Errorf("transaction log read failure: %w", err)
-			continue
+			outError <- err
+			return
 		}
 
-		event.EventType = EventType(num)
-		event.Name = args[1]
-		event.Value = strings.TrimSpace(args[2])
-		event.Metadata = args[3]
-
 		outEvent <- event
 	}
 
@@ -158,36 +249,253 @@ func (t *TransactionLogger) readEvents() (<-chan Event, <-chan error) {
 		defer close(outEvent)
 		defer close(outError)
 
-		d, err := os.ReadDir(t.cfg.BackupDirectory)
+		// the directory keeps other files next to the log, e.g. the position of a replica
+		files, err := Files(t.cfg.BackupDirectory)
 		if err != nil {
-			outError <- fmt.Errorf("transaction log read failure: %w", err)
+			outError <- err
 			return
 		}
 
-		i := 1
-		for _, f := range d {
-			if f.IsDir() {
-				continue
-			}
-
+		for _, n := range files {
 			func() {
-				file, err := os.Open(t.cfg.BackupDirectory + "/" + fmt.Sprintf("%d", i))
+				file, err := os.Open(fmt.Sprintf("%s/%d", t.cfg.BackupDirectory, n))
 				if err != nil {
 					outError <- fmt.Errorf("transaction log read failure: %w", err)
+					return
 				}
 				defer file.Close()
 
 				t.readEventsFrom(file, outEvent, outError)
-				i++
 			}()
-
 		}
-
 	}()
 
 	return outEvent, outError
 }
 
+// Position is the location of an event in the transaction log.
+type Position struct {
+	Seq  uint64
+	File int32
+	Line int
+	// Offset is the byte offset of the line in the file.
+	Offset int64
+}
+
+// CorruptionError is returned by ScanDir when a line can't be decoded.
+type CorruptionError struct {
+	Position Position
+	Err      error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corrupted event in file %d, line %d: %v", e.Position.File, e.Position.Line, e.Err)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
+
+// Files returns the numbers of the log files stored in dir, in order.
+func Files(dir string) ([]int32, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("transaction log read failure: %w", err)
+	}
+
+	var files []int32
+	for _, f := range entries {
+		if f.IsDir() {
+			continue
+		}
+
+		if n, err := strconv.Atoi(f.Name()); err == nil && n > 0 {
+			files = append(files, int32(n))
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i] < files[j] })
+
+	return files, nil
+}
+
+// ScanDir calls fn for every event stored in the log files in dir, in order.
+// It stops when fn returns false or an error.
+func ScanDir(dir string, fn func(pos Position, e Event) (next bool, err error)) error {
+	files, err := Files(dir)
+	if err != nil {
+		return err
+	}
+
+	return scanFiles(dir, files, 0, fn)
+}
+
+// scanFiles is ScanDir over the given files, seq is the sequence number of the event before the first of them.
+func scanFiles(dir string, files []int32, seq uint64, fn func(pos Position, e Event) (next bool, err error)) error {
+	for _, n := range files {
+		stop, err := func() (bool, error) {
+			file, err := os.Open(fmt.Sprintf("%s/%d", dir, n))
+			if err != nil {
+				return false, fmt.Errorf("transaction log read failure: %w", err)
+			}
+			defer file.Close()
+
+			pos := Position{File: n}
+
+			scanner := newScanner(file)
+			for scanner.Scan() {
+				line := scanner.Text()
+
+				pos.Line++
+				pos.Seq = seq + 1
+
+				e, err := DecodeEvent(line)
+				if err != nil {
+					return false, &CorruptionError{Position: pos, Err: err}
+				}
+
+				seq++
+				next, err := fn(pos, e)
+				if err != nil || !next {
+					return true, err
+				}
+
+				pos.Offset += int64(len(line)) + 1
+			}
+
+			if err := scanner.Err(); err != nil {
+				pos.Line++
+				return false, &CorruptionError{Position: pos, Err: err}
+			}
+
+			return false, nil
+		}()
+		if err != nil || stop {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Head returns the sequence number of the last event written to the log.
+func (t *TransactionLogger) Head() uint64 {
+	t.pubMu.Lock()
+	defer t.pubMu.Unlock()
+	return t.seq
+}
+
+// Stream sends every event after the from position to send: first the events
+// that are already stored in the log files, then the live ones. It returns when
+// ctx is done, send fails or the subscriber can't keep up with the writers.
+func (t *TransactionLogger) Stream(ctx context.Context, from uint64, send func(models.LogEvent) error) error {
+	sub, snapshot := t.subscribe()
+	defer t.unsubscribe(sub)
+
+	if from > snapshot {
+		return fmt.Errorf("%w: requested %d, head %d", ErrUnknownPosition, from, snapshot)
+	}
+
+	err := t.scanFrom(from, func(pos Position, e Event) (bool, error) {
+		seq := pos.Seq
+		if seq > snapshot {
+			return false, nil
+		}
+
+		if seq <= from {
+			return true, nil
+		}
+
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+
+		return true, send(models.LogEvent{
+			Seq:      seq,
+			Type:     uint8(e.EventType),
+			Name:     e.Name,
+			Value:    e.Value,
+			Metadata: e.Metadata,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-sub.ch:
+			if !ok {
+				return ErrSubscriberLagged
+			}
+
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// scanFrom is ScanDir that skips the files with the events up to from. Only the last file
+// grows, so the sequence numbers the files start at are remembered once a scan has seen them.
+func (t *TransactionLogger) scanFrom(from uint64, fn func(pos Position, e Event) (next bool, err error)) error {
+	files, err := Files(t.cfg.BackupDirectory)
+	if err != nil {
+		return err
+	}
+
+	first, seq := 0, uint64(0)
+
+	t.startsMu.Lock()
+	for i, n := range files {
+		if start, ok := t.starts[n]; ok && start <= from {
+			first, seq = i, start
+		}
+	}
+	t.startsMu.Unlock()
+
+	return scanFiles(t.cfg.BackupDirectory, files[first:], seq, func(pos Position, e Event) (bool, error) {
+		if pos.Line == 1 {
+			t.rememberStart(pos)
+		}
+
+		return fn(pos, e)
+	})
+}
+
+// rememberStart keeps the sequence number of the event before the first one of the file of pos.
+func (t *TransactionLogger) rememberStart(pos Position) {
+	t.startsMu.Lock()
+	t.starts[pos.File] = pos.Seq - 1
+	t.startsMu.Unlock()
+}
+
+// subscribe flushes the buffer, so that the log files contain every event up to
+// the returned sequence number, and registers a subscriber for the next ones.
+func (t *TransactionLogger) subscribe() (*subscriber, uint64) {
+	t.pubMu.Lock()
+	defer t.pubMu.Unlock()
+
+	t.flush()
+
+	sub := &subscriber{ch: make(chan models.LogEvent, _subscriberBuffer)}
+	t.subscribers[sub] = struct{}{}
+
+	return sub, t.seq
+}
+
+func (t *TransactionLogger) unsubscribe(sub *subscriber) {
+	t.pubMu.Lock()
+	defer t.pubMu.Unlock()
+
+	if _, ok := t.subscribers[sub]; ok {
+		delete(t.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
 func (t *TransactionLogger) Stop() error {
 	close(t.events)
 	return t.file.Close()
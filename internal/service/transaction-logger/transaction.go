@@ -0,0 +1,161 @@
+package transactionlogger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+
+	"github.com/egorgasay/gost"
+	"go.uber.org/zap"
+)
+
+// WritePrepare logs that the node is ready to commit tx. Unlike the other events
+// it is written to the file before it returns: the node must not forget a transaction it agreed to commit.
+func (t *TransactionLogger) WritePrepare(tx models.Transaction) (r gost.ResultN) {
+	e, err := TxEvent(t.security, Prepare, tx)
+	if err != nil {
+		t.logger.Error("failed to encrypt value", zap.Error(err))
+	}
+
+	e.flushed = make(chan struct{})
+	if rQ := t.enqueue(e); rQ.IsErr() {
+		return rQ
+	}
+
+	timer := time.NewTimer(t.cfg.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-e.flushed:
+	case <-timer.C:
+		return r.Err(constants.ErrLogQueueFull)
+	}
+
+	if t.Healthy() != nil {
+		return r.Err(constants.ErrLogFailed)
+	}
+
+	return r.Ok()
+}
+
+// WriteCommit logs the commit of tx together with its writes, so the restore applies them.
+func (t *TransactionLogger) WriteCommit(tx models.Transaction) (r gost.ResultN) {
+	e, err := TxEvent(t.security, Commit, tx)
+	if err != nil {
+		t.logger.Error("failed to encrypt value", zap.Error(err))
+	}
+
+	return t.enqueue(e)
+}
+
+func (t *TransactionLogger) WriteAbort(id string) (r gost.ResultN) {
+	return t.enqueue(Event{EventType: Abort, Name: id})
+}
+
+// InDoubt returns the transactions Restore found prepared but neither committed nor aborted, sorted by ID.
+func (t *TransactionLogger) InDoubt() []models.Transaction {
+	txs := make([]models.Transaction, 0, len(t.inDoubt))
+	for _, tx := range t.inDoubt {
+		txs = append(txs, tx)
+	}
+
+	sort.Slice(txs, func(i, j int) bool { return txs[i].ID < txs[j].ID })
+
+	return txs
+}
+
+// track keeps the in-doubt transactions while the log is restored.
+func (t *TransactionLogger) track(e Event) error {
+	switch e.EventType {
+	case Prepare:
+		tx, err := DecodeTx(t.security, e)
+		if err != nil {
+			return err
+		}
+
+		t.inDoubt[tx.ID] = tx
+	case Commit, Abort:
+		delete(t.inDoubt, e.Name)
+	}
+
+	return nil
+}
+
+// TxEvent returns the event of a phase of tx. The writes are kept in the value
+// as the lines of the Set and Delete events that do them.
+// When a value can't be encrypted, the event keeps it as is and the error is returned.
+func TxEvent(security domains.SecurityService, eventType EventType, tx models.Transaction) (e Event, err error) {
+	var sb strings.Builder
+	for _, op := range tx.Ops {
+		if op.Delete {
+			sb.WriteString(EncodeEvent(Event{EventType: Delete, Name: op.Key}))
+			continue
+		}
+
+		set, encErr := SetEvent(security, op.Key, op.Value, tx.Opts)
+		if encErr != nil {
+			err = encErr
+		}
+
+		sb.WriteString(EncodeEvent(set))
+	}
+
+	return Event{EventType: eventType, Name: tx.ID, Value: sb.String()}, err
+}
+
+// DecodeTx returns the transaction stored by TxEvent, the values are decrypted.
+func DecodeTx(security domains.SecurityService, e Event) (tx models.Transaction, err error) {
+	tx.ID = e.Name
+
+	for _, line := range strings.Split(e.Value, "\n") {
+		if line == "" {
+			continue
+		}
+
+		op, err := DecodeEvent(line)
+		if err != nil {
+			return tx, fmt.Errorf("transaction %s: %w", e.Name, err)
+		}
+
+		switch op.EventType {
+		case Delete:
+			tx.Ops = append(tx.Ops, models.TxOp{Key: op.Name, Delete: true})
+		case Set:
+			value, opts, err := decodeSet(security, op)
+			if err != nil {
+				return tx, err
+			}
+
+			tx.Opts = opts
+			tx.Ops = append(tx.Ops, models.TxOp{Key: op.Name, Value: value})
+		default:
+			return tx, fmt.Errorf("[%w]\n unknown write %v of transaction %s", ErrCorruptedConfigFile, op.EventType, e.Name)
+		}
+	}
+
+	return tx, nil
+}
+
+// applyTx applies the writes of a committed transaction, a key that is already gone is not deleted again.
+func applyTx(r domains.Restorer, tx models.Transaction) error {
+	for _, op := range tx.Ops {
+		if op.Delete {
+			if rDel := r.Delete(op.Key); rDel.IsErr() && rDel.Error().Messages()[0] != constants.ErrNotFound.Message() {
+				return fmt.Errorf("can't delete %s: %w", op.Key, rDel.Error())
+			}
+
+			continue
+		}
+
+		if rSet := r.Set(op.Key, op.Value, tx.Opts); rSet.IsErr() {
+			return fmt.Errorf("can't set %s: %w", op.Key, rSet.Error())
+		}
+	}
+
+	return nil
+}
@@ -3,16 +3,50 @@ package transactionlogger
 import (
 	"encoding/base64"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"itisadb/internal/constants"
+	"itisadb/internal/domains"
 	"itisadb/internal/models"
 
+	"github.com/egorgasay/gost"
 	"go.uber.org/zap"
 )
 
 const _enctyptedSign = "E"
 
-func (t *TransactionLogger) WriteSet(key, value string, opts models.SetOptions) {
+// Encrypted reports whether the value of e is stored encrypted.
+func (e Event) Encrypted() bool {
+	if e.EventType != Set {
+		return false
+	}
+
+	split := strings.Split(e.Metadata, constants.MetadataSeparator)
+	return len(split) > 2 && split[2] == _enctyptedSign
+}
+
+// Write logs e as it is, e.g. an event a replica got from the primary.
+func (t *TransactionLogger) Write(e Event) (r gost.ResultN) {
+	if t.Healthy() != nil {
+		return r.Err(constants.ErrLogFailed)
+	}
+
+	return t.enqueue(e)
+}
+
+func (t *TransactionLogger) WriteSet(key, value string, opts models.SetOptions) (r gost.ResultN) {
+	e, err := SetEvent(t.security, key, value, opts)
+	if err != nil {
+		t.logger.Error("failed to encrypt value", zap.Error(err))
+	}
+
+	return t.enqueue(e)
+}
+
+// SetEvent returns the event that stores value under key.
+// When the value can't be encrypted, the event keeps it as is and the error is returned.
+func SetEvent(security domains.SecurityService, key, value string, opts models.SetOptions) (e Event, err error) {
 	readOnly := 1
 	if !opts.ReadOnly {
 		readOnly = 0
@@ -20,23 +54,28 @@ func (t *TransactionLogger) WriteSet(key, value string, opts models.SetOptions)
 
 	metadata := fmt.Sprintf("%d%s%d", readOnly, constants.MetadataSeparator, opts.Level)
 	if opts.Encrypt {
-		encrypted, err := t.security.Encrypt(value)
-		if err != nil {
-			t.logger.Error("failed to encrypt value", zap.Error(err))
+		encrypted, encErr := security.Encrypt(value)
+		if encErr != nil {
+			err = encErr
 		} else {
 			metadata += constants.MetadataSeparator + _enctyptedSign
 			value = encrypted
 		}
 	}
 
-	t.events <- Event{EventType: Set, Name: key, Value: value, Metadata: metadata}
+	return Event{EventType: Set, Name: key, Value: value, Metadata: metadata}, err
+}
+
+func (t *TransactionLogger) WriteDelete(key string) (r gost.ResultN) {
+	return t.enqueue(Event{EventType: Delete, Name: key})
 }
 
-func (t *TransactionLogger) WriteDelete(key string) {
-	t.events <- Event{EventType: Delete, Name: key}
+func (t *TransactionLogger) WriteSetToObject(name string, key string, val string, opts models.SetToObjectOptions) (r gost.ResultN) {
+	return t.enqueue(SetToObjectEvent(name, key, val, opts))
 }
 
-func (t *TransactionLogger) WriteSetToObject(name string, key string, val string, opts models.SetToObjectOptions) {
+// SetToObjectEvent returns the event that stores val under key of the object name.
+func SetToObjectEvent(name string, key string, val string, opts models.SetToObjectOptions) Event {
 	readOnly := 1
 	if !opts.ReadOnly {
 		readOnly = 0
@@ -47,40 +86,68 @@ func (t *TransactionLogger) WriteSetToObject(name string, key string, val string
 		metadata += constants.MetadataSeparator + _enctyptedSign
 	}
 
-	t.events <- Event{EventType: SetToObject, Name: name + constants.ObjectSeparator + key, Value: val, Metadata: metadata}
+	return Event{EventType: SetToObject, Name: name + constants.ObjectSeparator + key, Value: val, Metadata: metadata}
+}
+
+func (t *TransactionLogger) WriteCreateObject(name string, info models.ObjectInfo) (r gost.ResultN) {
+	return t.enqueue(CreateObjectEvent(name, info))
 }
 
-func (t *TransactionLogger) WriteCreateObject(name string, info models.ObjectInfo) {
+// CreateObjectEvent returns the event that creates the object name.
+func CreateObjectEvent(name string, info models.ObjectInfo) Event {
 	value := fmt.Sprintf("%d%s%d", info.Server, constants.MetadataSeparator, info.Level)
-	t.events <- Event{EventType: CreateObject, Name: name, Value: value}
+	return Event{EventType: CreateObject, Name: name, Value: value}
 }
 
-func (t *TransactionLogger) WriteDeleteObject(name string) {
-	t.events <- Event{EventType: DeleteObject, Name: name}
+func (t *TransactionLogger) WriteDeleteObject(name string) (r gost.ResultN) {
+	return t.enqueue(Event{EventType: DeleteObject, Name: name})
 }
 
-func (t *TransactionLogger) WriteAttach(dst string, src string) {
-	t.events <- Event{EventType: Attach, Name: dst, Value: src}
+func (t *TransactionLogger) WriteAttach(dst string, src string) (r gost.ResultN) {
+	return t.enqueue(Event{EventType: Attach, Name: dst, Value: src})
 }
 
-func (t *TransactionLogger) WriteDeleteAttr(object string, key string) {
-	t.events <- Event{EventType: DeleteAttr, Name: object + constants.ObjectSeparator + key}
+func (t *TransactionLogger) WriteDeleteAttr(object string, key string) (r gost.ResultN) {
+	return t.enqueue(Event{EventType: DeleteAttr, Name: object + constants.ObjectSeparator + key})
 }
 
 var b64 = base64.StdEncoding
 
-func (t *TransactionLogger) WriteNewUser(user models.User) {
-	meta := fmt.Sprintf("%d%s%t%s%d", 
-		user.GetChangeID(), constants.MetadataSeparator, 
-		user.Active, constants.MetadataSeparator, 
+func (t *TransactionLogger) WriteNewUser(user models.User) (r gost.ResultN) {
+	return t.writeUser(CreateUser, user)
+}
+
+// WriteUpdateUser logs a user record received from another node as is.
+func (t *TransactionLogger) WriteUpdateUser(user models.User) (r gost.ResultN) {
+	return t.writeUser(UpdateUser, user)
+}
+
+func (t *TransactionLogger) WriteChangePassword(user models.User) (r gost.ResultN) {
+	return t.writeUser(ChangePassword, user)
+}
+
+func (t *TransactionLogger) WriteChangeLevel(user models.User) (r gost.ResultN) {
+	return t.writeUser(ChangeLevel, user)
+}
+
+func (t *TransactionLogger) writeUser(eventType EventType, user models.User) (r gost.ResultN) {
+	return t.enqueue(UserEvent(eventType, user))
+}
+
+// UserEvent returns the event that keeps the whole user record together with
+// the change ID the storage assigned to it, so restore can reproduce it exactly.
+func UserEvent(eventType EventType, user models.User) Event {
+	meta := fmt.Sprintf("%d%s%t%s%d",
+		user.GetChangeID(), constants.MetadataSeparator,
+		user.Active, constants.MetadataSeparator,
 		user.Level,
 	)
 
-	t.events <- Event{EventType: CreateUser, Name: user.Login, Value: user.Password, Metadata: meta}
+	return Event{EventType: eventType, Name: user.Login, Value: user.Password, Metadata: meta}
 }
 
-func (t *TransactionLogger) WriteDeleteUser(login string) {
-	t.events <- Event{EventType: DeleteUser, Name: login}
+func (t *TransactionLogger) WriteDeleteUser(login string, changeID uint64) (r gost.ResultN) {
+	return t.enqueue(Event{EventType: DeleteUser, Name: login, Metadata: strconv.FormatUint(changeID, 10)})
 }
 
 //func (t *TransactionLogger) WriteDeleteObjectInfo(name string) {
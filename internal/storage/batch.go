@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"itisadb/internal/models"
+
+	"github.com/egorgasay/gost"
+)
+
+// GetMany returns the values of the keys in their order, reading them under one lock.
+func (s *Storage) GetMany(keys []string) []gost.Option[models.Value] {
+	s.ramStorage.RLock()
+	defer s.ramStorage.RUnlock()
+
+	values := make([]gost.Option[models.Value], len(keys))
+	for i, key := range keys {
+		if val, ok := s.ramStorage.Get(key); ok {
+			values[i] = gost.Some(val)
+		}
+	}
+
+	return values
+}
+
+// SetMany writes the values under one lock. A key is written only when check
+// lets it, the errors of check are returned by the key.
+func (s *Storage) SetMany(values map[string]string, opts models.SetOptions, check func(key string, old gost.Option[models.Value]) error) map[string]error {
+	s.ramStorage.Lock()
+	defer s.ramStorage.Unlock()
+
+	errs := make(map[string]error)
+	for key, val := range values {
+		var old gost.Option[models.Value]
+		if v, ok := s.ramStorage.Get(key); ok {
+			old = gost.Some(v)
+		}
+
+		if err := check(key, old); err != nil {
+			errs[key] = err
+			continue
+		}
+
+		s.ramStorage.Put(key, models.Value{ReadOnly: opts.ReadOnly, Level: opts.Level, Value: val})
+		s.addKey(key)
+	}
+
+	return errs
+}
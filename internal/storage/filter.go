@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"itisadb/internal/models"
+	"itisadb/pkg/bloom"
+)
+
+const (
+	// FilterRate is the false positive rate of the key filter.
+	FilterRate = 0.01
+
+	_filterMinKeys = 1 << 16
+)
+
+// keyFilter is the Bloom filter over the keys, it is guarded by the lock of the values.
+type keyFilter struct {
+	*bloom.Filter
+	capacity uint64
+}
+
+func newKeyFilter(capacity uint64) keyFilter {
+	return keyFilter{Filter: bloom.New(capacity, FilterRate), capacity: capacity}
+}
+
+// addKey adds the key to the filter, the caller holds the write lock of the values.
+// Once the filter got more keys than it was built for, it is built again twice as large
+// from the current keys, which also forgets the deleted ones.
+func (s *Storage) addKey(key string) {
+	if s.filter.Count() >= s.filter.capacity {
+		f := newKeyFilter(max(uint64(s.ramStorage.Count())*2, _filterMinKeys))
+		s.ramStorage.Iter(func(k string, _ models.Value) bool {
+			f.Add(k)
+			return false
+		})
+		s.filter = f
+	}
+
+	s.filter.Add(key)
+}
+
+// KeyFilter returns a copy of the filter over the keys.
+func (s *Storage) KeyFilter() *bloom.Filter {
+	s.ramStorage.RLock()
+	defer s.ramStorage.RUnlock()
+
+	return s.filter.Clone()
+}
+
+// MayHave reports false only when the key is not stored.
+func (s *Storage) MayHave(key string) bool {
+	s.ramStorage.RLock()
+	defer s.ramStorage.RUnlock()
+
+	return s.filter.MayContain(key)
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"itisadb/internal/constants"
+	"itisadb/internal/domains"
+	"itisadb/internal/models"
+)
+
+// Snapshot passes the whole content of the storage to v.
+// Every part is read under its own lock, so the result is consistent
+// only when nothing writes to the storage meanwhile.
+// Attached objects are visited as regular nested objects.
+func (s *Storage) Snapshot(v domains.SnapshotVisitor) (err error) {
+	if err = s.snapshotUsers(v); err != nil {
+		return err
+	}
+
+	if err = s.snapshotValues(v); err != nil {
+		return err
+	}
+
+	return s.snapshotObjects(v)
+}
+
+func (s *Storage) snapshotUsers(v domains.SnapshotVisitor) (err error) {
+	s.users.RLock()
+	defer s.users.RUnlock()
+
+	s.users.Iter(func(_ string, user models.User) (stop bool) {
+		err = v.User(user)
+		return err != nil
+	})
+
+	return err
+}
+
+func (s *Storage) snapshotValues(v domains.SnapshotVisitor) (err error) {
+	s.ramStorage.RLock()
+	defer s.ramStorage.RUnlock()
+
+	s.ramStorage.Iter(func(key string, val models.Value) (stop bool) {
+		err = v.Value(key, val)
+		return err != nil
+	})
+
+	return err
+}
+
+func (s *Storage) snapshotObjects(v domains.SnapshotVisitor) (err error) {
+	s.objects.RLock()
+	defer s.objects.RUnlock()
+
+	s.objects.Iter(func(name string, some Something) (stop bool) {
+		if o := some.Object(); o.IsSome() {
+			err = s.snapshotObject(v, name, o.Unwrap())
+		}
+		return err != nil
+	})
+
+	return err
+}
+
+func (s *Storage) snapshotObject(v domains.SnapshotVisitor, name string, obj *object) (err error) {
+	info := s.GetObjectInfo(name).UnwrapOrElse(func() models.ObjectInfo {
+		return models.ObjectInfo{Level: obj.Level()}
+	})
+	if err = v.Object(name, info); err != nil {
+		return err
+	}
+
+	obj.Iter(func(key string, some Something) (stop bool) {
+		switch {
+		case some == nil:
+		case some.IsObject():
+			err = s.snapshotObject(v, name+constants.ObjectSeparator+key, some.Object().Unwrap())
+		default:
+			val := some.Value().Unwrap()
+			err = v.ObjectValue(name, key, models.OValue{ReadOnly: val.readOnly, Value: val.value})
+		}
+		return err != nil
+	})
+
+	return err
+}
@@ -18,6 +18,7 @@ type Storage struct {
 	objects     objects
 	users       users
 	objectsInfo objectsInfo
+	filter      keyFilter
 }
 
 type ramStorage struct {
@@ -55,6 +56,7 @@ func New() (*Storage, error) {
 		ramStorage:  ramStorage{Map: swiss.NewMap[string, models.Value](10_000_000), RWMutex: &sync.RWMutex{}},
 		objects:     objects{Map: swiss.NewMap[string, Something](100_000), RWMutex: &sync.RWMutex{}},
 		users:       users{Map: swiss.NewMap[string, models.User](100), RWMutex: &sync.RWMutex{}},
+		filter:      newKeyFilter(_filterMinKeys),
 	}
 
 	return st, nil
@@ -65,6 +67,7 @@ func (s *Storage) Set(key, val string, opts models.SetOptions) (r gost.ResultN)
 	defer s.ramStorage.Unlock()
 
 	s.ramStorage.Put(key, models.Value{ReadOnly: opts.ReadOnly, Level: opts.Level, Value: val})
+	s.addKey(key)
 
 	return r.Ok()
 }
@@ -81,6 +84,19 @@ func (s *Storage) Get(key string) (r gost.Option[models.Value]) {
 	return r.Some(val)
 }
 
+// Count returns the number of keys and the number of objects, nested objects included.
+func (s *Storage) Count() (keys, objects int) {
+	s.ramStorage.RLock()
+	keys = s.ramStorage.Count()
+	s.ramStorage.RUnlock()
+
+	s.objectsInfo.RLock()
+	objects = s.objectsInfo.Count()
+	s.objectsInfo.RUnlock()
+
+	return keys, objects
+}
+
 func (s *Storage) GetFromObject(name, key string) (r gost.Option[string]) {
 	s.objects.RLock()
 	defer s.objects.RUnlock()
@@ -59,8 +59,8 @@ func (s *Storage) SaveUser(user models.User) (r gost.ResultN) {
 
 	s.users.changeID++
 
-	s.users.Put(user.Login, user)
 	user.SetChangeID(s.users.changeID)
+	s.users.Put(user.Login, user)
 
 
 	return r.Ok()
@@ -84,3 +84,39 @@ func (s *Storage) SetUserChangeID(id uint64) {
 
 	s.users.changeID = id
 }
+
+// RestoreUser saves the user as it was recorded, keeping its change ID.
+// A user without a change ID gets the next one, as NewUser would do.
+func (s *Storage) RestoreUser(user models.User) (r gost.ResultN) {
+	s.users.Lock()
+	defer s.users.Unlock()
+
+	if user.GetChangeID() == 0 {
+		s.users.changeID++
+		user.SetChangeID(s.users.changeID)
+	}
+
+	s.users.changeID = max(s.users.changeID, user.GetChangeID())
+	s.users.Put(user.Login, user)
+
+	return r.Ok()
+}
+
+// RestoreDeleteUser marks the user as deleted with the recorded change ID.
+func (s *Storage) RestoreDeleteUser(login string, changeID uint64) (r gost.ResultN) {
+	s.users.Lock()
+	defer s.users.Unlock()
+
+	val, ok := s.users.Get(login)
+	if !ok {
+		return r.Ok()
+	}
+
+	val.Active = false
+	val.SetChangeID(changeID)
+	s.users.Put(login, val)
+
+	s.users.changeID = max(s.users.changeID, changeID)
+
+	return r.Ok()
+}
@@ -0,0 +1,231 @@
+// Package cluster contains the internal gRPC API that itisadb nodes use to talk
+// to each other. It is served next to the public api.ItisaDB service.
+package cluster
+
+type ReplicateRequest struct {
+	// From is the sequence number of the last event the replica has applied.
+	From uint64 `json:"from"`
+}
+
+type ReplicationEvent struct {
+	Seq  uint64 `json:"seq"`
+	Head uint64 `json:"head"`
+	// Time is the unix time in nanoseconds when the event was written on the primary.
+	// It is zero for the events read from the log files.
+	Time     int64  `json:"time,omitempty"`
+	Type     uint8  `json:"type"`
+	Name     []byte `json:"name"`
+	Value    []byte `json:"value"`
+	Metadata []byte `json:"metadata"`
+}
+
+type ReplicationStatusRequest struct{}
+
+type ReplicationStatusResponse struct {
+	Role      string  `json:"role"`
+	Primary   string  `json:"primary,omitempty"`
+	Connected bool    `json:"connected"`
+	Applied   uint64  `json:"applied"`
+	Head      uint64  `json:"head"`
+	Replicas  int     `json:"replicas"`
+	LagEvents uint64  `json:"lag_events"`
+	LagSecs   float64 `json:"lag_seconds"`
+}
+
+type BackupRequest struct{}
+
+// BackupChunk is the next part of the backup archive.
+type BackupChunk struct {
+	Data []byte `json:"data"`
+}
+
+type ScanRequest struct{}
+
+// ScanEntry is a value, an object or a value of an object stored on the node.
+type ScanEntry struct {
+	Kind     uint8  `json:"kind"`
+	Object   string `json:"object,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Level    uint8  `json:"level"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+}
+
+type RebalanceStatusRequest struct{}
+
+type RebalanceStatusResponse struct {
+	Running bool   `json:"running"`
+	Reason  string `json:"reason,omitempty"`
+	// StartedAt and FinishedAt are unix times in nanoseconds, zero when unknown.
+	StartedAt  int64   `json:"started_at,omitempty"`
+	FinishedAt int64   `json:"finished_at,omitempty"`
+	Scanned    uint64  `json:"scanned"`
+	Moved      uint64  `json:"moved"`
+	Failed     uint64  `json:"failed"`
+	Leaving    []int32 `json:"leaving,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type DrainRequest struct {
+	Server int32 `json:"server"`
+}
+
+type DrainResponse struct{}
+
+type MaintenanceRequest struct {
+	Server int32 `json:"server"`
+	On     bool  `json:"on"`
+}
+
+type MaintenanceResponse struct{}
+
+// BatchValue is one key of MGet or MSet with its value, or the error the key got.
+type BatchValue struct {
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	Level    uint8  `json:"level,omitempty"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+	Server   int32  `json:"server,omitempty"`
+	// Error holds the messages of the error from the root one, it is empty on success.
+	Error []string `json:"error,omitempty"`
+}
+
+type MGetRequest struct {
+	Keys   []string `json:"keys"`
+	Server int32    `json:"server,omitempty"`
+}
+
+type MGetResponse struct {
+	Values []BatchValue `json:"values"`
+}
+
+type MSetRequest struct {
+	Values   map[string]string `json:"values"`
+	Server   int32             `json:"server,omitempty"`
+	Level    uint8             `json:"level,omitempty"`
+	ReadOnly bool              `json:"read_only,omitempty"`
+	Unique   bool              `json:"unique,omitempty"`
+}
+
+type MSetResponse struct {
+	Values []BatchValue `json:"values"`
+}
+
+type NodeStatsRequest struct{}
+
+// NodeStatsResponse describes the storage of the node that answers.
+type NodeStatsResponse struct {
+	Keys    uint64 `json:"keys"`
+	Objects uint64 `json:"objects"`
+	Role    string `json:"role"`
+}
+
+type ClusterInfoRequest struct{}
+
+// ServerInfo describes a server of the balancer that answers.
+type ServerInfo struct {
+	Number  int32  `json:"number"`
+	Address string `json:"address,omitempty"`
+	Local   bool   `json:"local,omitempty"`
+	// State is "online", "offline", "draining" or "maintenance".
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	// LastSeen is the unix time in nanoseconds when the server answered last, zero if it never did.
+	LastSeen     int64  `json:"last_seen,omitempty"`
+	RAMTotal     uint64 `json:"ram_total_mb"`
+	RAMAvailable uint64 `json:"ram_available_mb"`
+	// The latency percentiles of the recent requests, in nanoseconds.
+	LatencyP50 int64  `json:"latency_p50"`
+	LatencyP90 int64  `json:"latency_p90"`
+	LatencyP99 int64  `json:"latency_p99"`
+	Keys       uint64 `json:"keys"`
+	Objects    uint64 `json:"objects"`
+	Role       string `json:"role,omitempty"`
+	StatsError string `json:"stats_error,omitempty"`
+}
+
+type ClusterInfoResponse struct {
+	Servers []ServerInfo `json:"servers"`
+}
+
+type KeyFilterRequest struct{}
+
+// KeyFilterResponse holds the Bloom filter over the keys of the node in the binary form of pkg/bloom.
+type KeyFilterResponse struct {
+	Filter []byte `json:"filter"`
+}
+
+// TxOp is one write of a transaction, it sets the key to the value or deletes it.
+type TxOp struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Delete bool   `json:"delete,omitempty"`
+}
+
+// PrepareRequest asks the node to get ready to commit its part of the transaction.
+type PrepareRequest struct {
+	ID       string `json:"id"`
+	Ops      []TxOp `json:"ops"`
+	Level    uint8  `json:"level,omitempty"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+	Unique   bool   `json:"unique,omitempty"`
+}
+
+type PrepareResponse struct {
+	// Error holds the messages of the error the node refused the transaction with, from the root one.
+	Error []string `json:"error,omitempty"`
+}
+
+// ResolveRequest commits or aborts the prepared transaction.
+type ResolveRequest struct {
+	ID string `json:"id"`
+}
+
+type ResolveResponse struct{}
+
+type InDoubtRequest struct{}
+
+// InDoubtResponse holds the IDs of the transactions the node prepared and that are not resolved yet.
+type InDoubtResponse struct {
+	IDs []string `json:"ids"`
+}
+
+// TransactRequest writes the keys all at once, they may live on different servers.
+type TransactRequest struct {
+	Ops      []TxOp `json:"ops"`
+	Level    uint8  `json:"level,omitempty"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+	Unique   bool   `json:"unique,omitempty"`
+}
+
+type TransactResponse struct {
+	ID string `json:"id"`
+}
+
+// Range is a range of the points on the hash ring, From and To included.
+type Range struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// MerkleTreeRequest asks the node for the Merkle tree over its values in the ranges.
+type MerkleTreeRequest struct {
+	Ranges []Range `json:"ranges"`
+	Depth  int     `json:"depth"`
+}
+
+// MerkleTreeResponse holds the tree in the binary form of pkg/merkle.
+type MerkleTreeResponse struct {
+	Tree []byte `json:"tree"`
+}
+
+// MerkleLeavesRequest asks the node for the values under the leaves of the tree of MerkleTreeRequest.
+type MerkleLeavesRequest struct {
+	Ranges []Range `json:"ranges"`
+	Depth  int     `json:"depth"`
+	Leaves []int   `json:"leaves"`
+}
+
+type MerkleLeavesResponse struct {
+	Entries []ScanEntry `json:"entries"`
+}
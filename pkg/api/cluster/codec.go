@@ -0,0 +1,29 @@
+package cluster
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// CodecName is the content-subtype used by the cluster service.
+// The messages are plain Go structs, so they are encoded as JSON instead of protobuf.
+const CodecName = "json"
+
+type codec struct{}
+
+func (codec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (codec) Name() string {
+	return CodecName
+}
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
@@ -0,0 +1,29 @@
+package cluster
+
+// The Gossip service spreads the membership of the cluster between its nodes.
+
+type GossipMember struct {
+	Address     string `json:"address"`
+	Role        string `json:"role"`
+	State       string `json:"state"`
+	Incarnation uint64 `json:"incarnation"`
+}
+
+// PingRequest carries the members the sender knows, the response carries the ones the receiver knows.
+type PingRequest struct {
+	From    string         `json:"from"`
+	Members []GossipMember `json:"members"`
+}
+
+type PingResponse struct {
+	Members []GossipMember `json:"members"`
+}
+
+// IndirectPingRequest asks a node to ping the target that didn't answer the sender.
+type IndirectPingRequest struct {
+	Target string `json:"target"`
+}
+
+type IndirectPingResponse struct {
+	Ack bool `json:"ack"`
+}
@@ -0,0 +1,61 @@
+package cluster
+
+// The Raft service replicates the metadata of the balancer instances.
+
+type RaftEntry struct {
+	Index   uint64 `json:"index"`
+	Term    uint64 `json:"term"`
+	Command []byte `json:"command"`
+}
+
+type VoteRequest struct {
+	Term         uint64 `json:"term"`
+	Candidate    string `json:"candidate"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+type AppendRequest struct {
+	Term         uint64      `json:"term"`
+	Leader       string      `json:"leader"`
+	PrevLogIndex uint64      `json:"prev_log_index"`
+	PrevLogTerm  uint64      `json:"prev_log_term"`
+	Entries      []RaftEntry `json:"entries,omitempty"`
+	LeaderCommit uint64      `json:"leader_commit"`
+}
+
+type AppendResponse struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+	// LastIndex is the last entry of the follower, the leader goes back to it at once on a mismatch.
+	LastIndex uint64 `json:"last_index"`
+}
+
+type SnapshotRequest struct {
+	Term      uint64 `json:"term"`
+	Leader    string `json:"leader"`
+	LastIndex uint64 `json:"last_index"`
+	LastTerm  uint64 `json:"last_term"`
+	Data      []byte `json:"data"`
+}
+
+type SnapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+type ProposeRequest struct {
+	Command []byte `json:"command"`
+}
+
+type ProposeResponse struct {
+	// Index is the entry of the command, the follower that forwarded it waits until it applies it too.
+	Index  uint64 `json:"index"`
+	Result []byte `json:"result,omitempty"`
+	// Error holds the messages of the error of the command, the root message comes first.
+	Error []string `json:"error,omitempty"`
+}
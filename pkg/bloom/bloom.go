@@ -0,0 +1,120 @@
+// Package bloom implements the Bloom filter the nodes report their keys with.
+//
+// The hashes are the same in every process, so a filter built on a node
+// answers on the balancer that pulled it.
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"slices"
+)
+
+// Filter answers whether a key may have been added. It never forgets a key,
+// so a removed key stays a false positive until the filter is built again.
+// A Filter is not safe for concurrent use.
+type Filter struct {
+	bits  []uint64
+	k     uint32
+	count uint64
+}
+
+// New returns a filter for n keys with the false positive rate p.
+func New(n uint64, p float64) *Filter {
+	n = max(n, 1)
+	p = min(max(p, 1e-9), 0.5)
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+
+	return &Filter{bits: make([]uint64, (m+63)/64), k: max(k, 1)}
+}
+
+func (f *Filter) Add(key string) {
+	h1, h2 := hashes(key)
+	m := uint64(len(f.bits)) * 64
+
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+
+	f.count++
+}
+
+// MayContain reports false only for the keys that were never added.
+func (f *Filter) MayContain(key string) bool {
+	h1, h2 := hashes(key)
+	m := uint64(len(f.bits)) * 64
+
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Count returns the number of Add calls, a key added twice is counted twice.
+func (f *Filter) Count() uint64 { return f.count }
+
+// Clone returns a copy that does not share the bitmap.
+func (f *Filter) Clone() *Filter {
+	return &Filter{bits: slices.Clone(f.bits), k: f.k, count: f.count}
+}
+
+const _header = 4 + 8
+
+// MarshalBinary encodes the filter as the number of hashes, the count and the bitmap, little-endian.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	data := make([]byte, _header+8*len(f.bits))
+
+	binary.LittleEndian.PutUint32(data, f.k)
+	binary.LittleEndian.PutUint64(data[4:], f.count)
+	for i, word := range f.bits {
+		binary.LittleEndian.PutUint64(data[_header+8*i:], word)
+	}
+
+	return data, nil
+}
+
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < _header+8 || (len(data)-_header)%8 != 0 {
+		return fmt.Errorf("invalid filter of %d bytes", len(data))
+	}
+
+	k := binary.LittleEndian.Uint32(data)
+	if k == 0 {
+		return errors.New("invalid filter without hashes")
+	}
+
+	f.k, f.count = k, binary.LittleEndian.Uint64(data[4:])
+	f.bits = make([]uint64, (len(data)-_header)/8)
+	for i := range f.bits {
+		f.bits[i] = binary.LittleEndian.Uint64(data[_header+8*i:])
+	}
+
+	return nil
+}
+
+// hashes returns the two hashes of the double hashing scheme, the second one is odd
+// so the probes don't repeat too soon.
+func hashes(key string) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(key))
+
+	sum := h.Sum(nil)
+
+	var h1, h2 uint64
+	for i := 0; i < 8; i++ {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[8+i])
+	}
+
+	return h1, h2 | 1
+}
@@ -0,0 +1,69 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	const n = 10_000
+
+	f := New(n, 0.01)
+	for i := 0; i < n; i++ {
+		f.Add(fmt.Sprintf("key%d", i))
+	}
+
+	for i := 0; i < n; i++ {
+		if key := fmt.Sprintf("key%d", i); !f.MayContain(key) {
+			t.Fatalf("%s was added, but is not in the filter", key)
+		}
+	}
+
+	positives := 0
+	for i := 0; i < n; i++ {
+		if f.MayContain(fmt.Sprintf("other%d", i)) {
+			positives++
+		}
+	}
+
+	if rate := float64(positives) / n; rate > 0.02 {
+		t.Errorf("false positive rate %.3f, want about 0.01", rate)
+	}
+
+	if f.Count() != n {
+		t.Errorf("count %d, want %d", f.Count(), n)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	f := New(100, 0.01)
+	f.Add("a")
+	f.Add("b")
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Filter
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.MayContain("a") || !got.MayContain("b") || got.Count() != 2 {
+		t.Errorf("restored filter lost the keys: %+v", got)
+	}
+
+	// the clone is not changed with the original
+	clone := f.Clone()
+	f.Add("c")
+	if clone.Count() != 2 {
+		t.Errorf("clone count %d, want 2", clone.Count())
+	}
+
+	for _, bad := range [][]byte{nil, data[:len(data)-1], make([]byte, len(data))} {
+		if err := new(Filter).UnmarshalBinary(bad); err == nil {
+			t.Errorf("%d bytes: no error", len(bad))
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Package flight coalesces identical calls that are in flight at the same time:
+// the first caller runs the call, the others wait for its result.
+package flight
+
+import (
+	"context"
+	"sync"
+)
+
+type call[T any] struct {
+	done chan struct{}
+	val  T
+	err  error
+}
+
+// Group runs at most one call per key at a time. The zero Group is ready to use.
+type Group[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+}
+
+// Do runs f, unless a call with the key is in flight already, then it waits for that one.
+// shared reports whether the result came from the call of another caller.
+//
+// f runs on its own, so a caller that gives up doesn't cancel the call for the others:
+// f must not use the context of the caller and must bound itself.
+// Each caller waits until its ctx is done at the longest.
+func (g *Group[T]) Do(ctx context.Context, key string, f func() (T, error)) (v T, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+
+	c, ok := g.calls[key]
+	if !ok {
+		c = &call[T]{done: make(chan struct{})}
+		g.calls[key] = c
+
+		go g.run(key, c, f)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, ok, c.err
+	case <-ctx.Done():
+		return v, ok, ctx.Err()
+	}
+}
+
+func (g *Group[T]) run(key string, c *call[T], f func() (T, error)) {
+	c.val, c.err = f()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	close(c.done)
+}
+
+// InFlight returns the number of the calls running now.
+func (g *Group[T]) InFlight() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.calls)
+}
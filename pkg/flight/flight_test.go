@@ -0,0 +1,92 @@
+package flight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	const callers = 100
+
+	var (
+		g       Group[string]
+		calls   atomic.Int32
+		release = make(chan struct{})
+		wg      sync.WaitGroup
+		shared  atomic.Int32
+	)
+
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			v, ok, err := g.Do(context.Background(), "key", func() (string, error) {
+				calls.Add(1)
+				<-release
+				return "value", nil
+			})
+			if err != nil || v != "value" {
+				t.Errorf("got %q, %v", v, err)
+			}
+
+			if ok {
+				shared.Add(1)
+			}
+		}()
+	}
+
+	// the callers join the call while it is in flight
+	deadline := time.Now().Add(5 * time.Second)
+	for calls.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the call")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != callers-shared.Load() {
+		t.Errorf("%d calls for %d callers, %d shared", n, callers, shared.Load())
+	}
+
+	if shared.Load() == 0 {
+		t.Error("no caller shared the call")
+	}
+
+	if g.InFlight() != 0 {
+		t.Errorf("%d calls are left in flight", g.InFlight())
+	}
+
+	// a finished call is not reused
+	v, ok, err := g.Do(context.Background(), "key", func() (string, error) { return "next", errors.New("failed") })
+	if v != "next" || ok || err == nil {
+		t.Errorf("got %q, %v, %v", v, ok, err)
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	var g Group[int]
+
+	release := make(chan struct{})
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the caller gives up, the call keeps going for the others
+	if _, _, err := g.Do(ctx, "key", func() (int, error) { <-release; return 1, nil }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+
+	if g.InFlight() != 1 {
+		t.Errorf("the call was dropped with the caller")
+	}
+}
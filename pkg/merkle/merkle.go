@@ -0,0 +1,216 @@
+// Package merkle implements the Merkle trees the replicas compare their keys with.
+//
+// A tree covers the keys whose hashes fall in a set of ranges. The leaves split
+// the hash space evenly, so two trees of the same depth built on different nodes
+// differ exactly under the leaves where the nodes keep different values.
+package merkle
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"slices"
+)
+
+const (
+	// DefaultDepth gives the trees 1024 leaves.
+	DefaultDepth = 10
+	// MaxDepth bounds the size of a tree, it has 2^(MaxDepth+1)-1 nodes.
+	MaxDepth = 16
+)
+
+// Range is the range of the key hashes from From to To, both included.
+type Range struct {
+	From, To uint64
+}
+
+// Ranges are sorted ranges that don't overlap.
+type Ranges []Range
+
+// Normalize sorts the ranges and merges the ones that overlap or touch.
+func Normalize(ranges []Range) Ranges {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b Range) int {
+		switch {
+		case a.From < b.From:
+			return -1
+		case a.From > b.From:
+			return 1
+		}
+		return 0
+	})
+
+	var merged Ranges
+	for _, r := range sorted {
+		if r.From > r.To {
+			continue
+		}
+
+		if n := len(merged); n > 0 && (merged[n-1].To == ^uint64(0) || r.From <= merged[n-1].To+1) {
+			merged[n-1].To = max(merged[n-1].To, r.To)
+			continue
+		}
+
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// Contains reports whether h falls in one of the ranges.
+func (r Ranges) Contains(h uint64) bool {
+	i, _ := slices.BinarySearchFunc(r, h, func(r Range, h uint64) int {
+		switch {
+		case r.To < h:
+			return -1
+		case r.From > h:
+			return 1
+		}
+		return 0
+	})
+
+	return i < len(r) && r[i].From <= h && h <= r[i].To
+}
+
+// Tree is a complete binary tree over the hash space, the node i has the children 2i+1 and 2i+2.
+// A leaf is the XOR of the digests of its keys and values, so the order of Add doesn't matter.
+// A Tree is not safe for concurrent use.
+type Tree struct {
+	depth int
+	nodes []uint64
+}
+
+// New returns an empty tree with 2^depth leaves, depth is cut to MaxDepth.
+func New(depth int) *Tree {
+	depth = min(max(depth, 0), MaxDepth)
+
+	t := &Tree{depth: depth, nodes: make([]uint64, 1<<(depth+1)-1)}
+	for i := len(t.nodes) - 1; i >= 0; i-- {
+		t.update(i)
+	}
+
+	return t
+}
+
+func (t *Tree) Depth() int { return t.depth }
+
+func (t *Tree) Root() uint64 { return t.nodes[0] }
+
+// Leaf returns the leaf the key with the hash h falls under.
+func (t *Tree) Leaf(h uint64) int {
+	if t.depth == 0 {
+		return 0
+	}
+
+	return int(h >> (64 - t.depth))
+}
+
+// Add adds the key with the hash h and its value to the tree.
+func (t *Tree) Add(h uint64, key, value string) {
+	i := t.leaves() + t.Leaf(h)
+	t.nodes[i] ^= digest(key, value)
+
+	for i > 0 {
+		i = (i - 1) / 2
+		t.update(i)
+	}
+}
+
+// Diff returns the leaves where the trees differ, in order.
+// Only the subtrees whose roots differ are walked down.
+func (t *Tree) Diff(other *Tree) ([]int, error) {
+	if t.depth != other.depth {
+		return nil, fmt.Errorf("trees of depth %d and %d can't be compared", t.depth, other.depth)
+	}
+
+	var leaves []int
+
+	var walk func(i int)
+	walk = func(i int) {
+		if t.nodes[i] == other.nodes[i] {
+			return
+		}
+
+		if i >= t.leaves() {
+			leaves = append(leaves, i-t.leaves())
+			return
+		}
+
+		walk(2*i + 1)
+		walk(2*i + 2)
+	}
+	walk(0)
+
+	return leaves, nil
+}
+
+// leaves returns the index of the first leaf.
+func (t *Tree) leaves() int {
+	return 1<<t.depth - 1
+}
+
+// update hashes the children of the inner node i into it, the leaves are left as they are.
+func (t *Tree) update(i int) {
+	left := 2*i + 1
+	if left >= len(t.nodes) {
+		return
+	}
+
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[:], t.nodes[left])
+	binary.LittleEndian.PutUint64(buf[8:], t.nodes[left+1])
+
+	h := fnv.New64a()
+	h.Write(buf[:])
+	t.nodes[i] = mix(h.Sum64())
+}
+
+// MarshalBinary encodes the tree as its depth and the nodes, little-endian.
+func (t *Tree) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 1+8*len(t.nodes))
+
+	data[0] = byte(t.depth)
+	for i, node := range t.nodes {
+		binary.LittleEndian.PutUint64(data[1+8*i:], node)
+	}
+
+	return data, nil
+}
+
+func (t *Tree) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || int(data[0]) > MaxDepth {
+		return fmt.Errorf("invalid tree of %d bytes", len(data))
+	}
+
+	depth := int(data[0])
+	if len(data) != 1+8*(1<<(depth+1)-1) {
+		return fmt.Errorf("invalid tree of depth %d and %d bytes", depth, len(data))
+	}
+
+	t.depth, t.nodes = depth, make([]uint64, 1<<(depth+1)-1)
+	for i := range t.nodes {
+		t.nodes[i] = binary.LittleEndian.Uint64(data[1+8*i:])
+	}
+
+	return nil
+}
+
+func digest(key, value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+
+	return mix(h.Sum64())
+}
+
+// mix is the splitmix64 finalizer, fnv alone spreads similar inputs poorly.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
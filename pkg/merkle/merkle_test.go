@@ -0,0 +1,90 @@
+package merkle
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"testing"
+)
+
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return mix(h.Sum64())
+}
+
+func TestDiff(t *testing.T) {
+	a, b := New(DefaultDepth), New(DefaultDepth)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		a.Add(hash(key), key, "v")
+	}
+
+	// the same keys in the other order
+	for i := 999; i >= 0; i-- {
+		key := fmt.Sprintf("key%d", i)
+		b.Add(hash(key), key, "v")
+	}
+
+	if a.Root() != b.Root() {
+		t.Fatal("the trees of the same values differ")
+	}
+
+	b.Add(hash("key7"), "key7", "v") // removes it
+	b.Add(hash("key7"), "key7", "changed")
+	b.Add(hash("new"), "new", "v")
+
+	leaves, err := a.Diff(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{a.Leaf(hash("key7")), a.Leaf(hash("new"))}
+	slices.Sort(want)
+	want = slices.Compact(want)
+
+	if !slices.Equal(leaves, want) {
+		t.Errorf("diff %v, want %v", leaves, want)
+	}
+
+	if _, err := a.Diff(New(3)); err == nil {
+		t.Error("the trees of different depths were compared")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	a := New(4)
+	a.Add(hash("a"), "a", "1")
+
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := new(Tree)
+	if err := b.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if leaves, err := a.Diff(b); err != nil || len(leaves) != 0 {
+		t.Errorf("decoded tree differs at %v: %v", leaves, err)
+	}
+
+	if err := b.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("truncated tree was decoded")
+	}
+}
+
+func TestRanges(t *testing.T) {
+	r := Normalize([]Range{{From: 20, To: 30}, {From: 0, To: 9}, {From: 10, To: 15}, {From: 50, To: 40}})
+
+	if want := (Ranges{{From: 0, To: 15}, {From: 20, To: 30}}); !slices.Equal(r, want) {
+		t.Fatalf("normalized %v, want %v", r, want)
+	}
+
+	for h, want := range map[uint64]bool{0: true, 15: true, 16: false, 20: true, 30: true, 31: false, 45: false} {
+		if got := r.Contains(h); got != want {
+			t.Errorf("Contains(%d) = %v, want %v", h, got, want)
+		}
+	}
+}